@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -9,9 +11,28 @@ import (
 	"time"
 
 	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/accesslog"
+	"github.com/fekuna/omnipos-gateway/internal/audit"
+	"github.com/fekuna/omnipos-gateway/internal/backend"
+	"github.com/fekuna/omnipos-gateway/internal/debug"
+	"github.com/fekuna/omnipos-gateway/internal/events"
+	"github.com/fekuna/omnipos-gateway/internal/graphql"
+	"github.com/fekuna/omnipos-gateway/internal/grpcproxy"
+	"github.com/fekuna/omnipos-gateway/internal/health"
+	"github.com/fekuna/omnipos-gateway/internal/i18n"
+	"github.com/fekuna/omnipos-gateway/internal/logging"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
 	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	"github.com/fekuna/omnipos-gateway/internal/probe"
+	"github.com/fekuna/omnipos-gateway/internal/receipt"
+	"github.com/fekuna/omnipos-gateway/internal/reporting"
 	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-gateway/internal/security"
+	"github.com/fekuna/omnipos-gateway/internal/static"
+	"github.com/fekuna/omnipos-gateway/internal/stats"
 	"github.com/fekuna/omnipos-gateway/internal/swagger"
+	"github.com/fekuna/omnipos-gateway/internal/usage"
+	"github.com/fekuna/omnipos-gateway/internal/webhook"
 	"github.com/fekuna/omnipos-pkg/cache"
 	"github.com/fekuna/omnipos-pkg/logger"
 	auditv1 "github.com/fekuna/omnipos-proto/gen/go/omnipos/audit/v1"
@@ -23,13 +44,16 @@ import (
 	userv1 "github.com/fekuna/omnipos-proto/gen/go/omnipos/user/v1"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/joho/godotenv"
+	"github.com/quic-go/quic-go/http3"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	channelzservice "google.golang.org/grpc/channelz/service"
 	"google.golang.org/grpc/metadata"
 )
 
 func main() {
+	startedAt := time.Now()
+
 	// Load environment variables from .env file (will not override existing env vars)
 	if err := godotenv.Load(); err != nil {
 		// handle error if .env file is missing, which is fine for docker
@@ -50,7 +74,9 @@ func main() {
 		DisableStacktrace: cfg.Logger.DisableStacktrace,
 	}
 
-	log := logger.NewZapLogger(&loggerCfg)
+	// The level can be changed at runtime (admin API, SIGUSR1/SIGUSR2)
+	levelLogger := logging.NewLevelLogger(loggerCfg)
+	var log logger.ZapLogger = levelLogger
 	defer log.Sync()
 
 	log.Info("Logger initialized")
@@ -60,6 +86,11 @@ func main() {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// Believe the forwarding headers of the proxies in front of the gateway only
+	if err := middleware.ConfigureTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		log.Fatal("invalid trusted proxies", zap.Error(err))
+	}
+
 	// Initialize JWT helper
 	jwtHelper := middleware.NewJWTHelper(cfg.JWT.SecretKey)
 	log.Info("JWT helper initialized")
@@ -74,207 +105,612 @@ func main() {
 		log.Debug("public endpoint", zap.String("method", endpoint))
 	}
 
+	// Discover HTTP routes and per-method rate limits from proto definitions
+	routes, err := middleware.DiscoverRoutes()
+	if err != nil {
+		log.Fatal("failed to discover routes", zap.Error(err))
+	}
+	log.Info("Discovered routes from proto definitions", zap.Int("count", len(routes.Routes())))
+
+	methodRateLimits, err := middleware.DiscoverMethodRateLimits()
+	if err != nil {
+		log.Fatal("failed to discover method rate limits", zap.Error(err))
+	}
+	for method, limit := range methodRateLimits {
+		log.Debug("method rate limit", zap.String("method", method), zap.Int("rps", limit.RPS), zap.Int("burst", limit.Burst), zap.Duration("period", limit.Period))
+	}
+
+	idempotentMethods, err := middleware.DiscoverIdempotentMethods()
+	if err != nil {
+		log.Fatal("failed to discover idempotent methods", zap.Error(err))
+	}
+	log.Info("Discovered idempotent methods from proto definitions", zap.Int("count", len(idempotentMethods)))
+
+	deprecatedMethods, err := middleware.DiscoverDeprecatedMethods()
+	if err != nil {
+		log.Fatal("failed to discover deprecated methods", zap.Error(err))
+	}
+	log.Info("Discovered deprecated methods from proto definitions", zap.Int("count", len(deprecatedMethods)))
+
 	// Initialize auth interceptor with proto-based public endpoints
 	authInterceptor := middleware.NewAuthInterceptor(jwtHelper, log, publicEndpoints)
 	log.Info("Auth interceptor initialized")
 
+	// Initialize cookie session mode (access token kept in an httpOnly cookie)
+	sessionCookie := middleware.NewSessionCookie(cfg.Session, jwtHelper, log)
+	if cfg.Session.CookieEnabled {
+		log.Info("Cookie session mode enabled", zap.String("cookie", cfg.Session.CookieName))
+	}
+
+	// Initialize double-submit CSRF protection (only active in cookie session mode)
+	csrfProtection := middleware.NewCSRFProtection(cfg.CSRF, cfg.Session, log)
+
+	// Metadata forwarded to the backends, shared by grpc-gateway and the gRPC proxies
+	annotate := func(ctx context.Context, req *http.Request) metadata.MD {
+		// Get standard metadata from our custom annotator (lang, timezone)
+		md := middleware.MetadataAnnotator(ctx, req)
+
+		// Explicitly forward Authorization header
+		if auth := req.Header.Get("Authorization"); auth != "" {
+			md.Set("authorization", auth)
+		}
+
+		// Forward the canary header so the backend router can match it
+		if cfg.Canary.Enabled && cfg.Canary.Header != "" {
+			if value := req.Header.Get(cfg.Canary.Header); value != "" {
+				md.Set(cfg.Canary.Header, value)
+			}
+		}
+		return md
+	}
+
+	// Initialize Redis client
+	redisClient, err := cache.NewRedisClient(&cfg.Redis)
+	if err != nil {
+		log.Fatal("failed to initialize redis client", zap.Error(err))
+	}
+	defer redisClient.Close()
+	log.Info("Redis client initialized")
+
+	// Serve order/payment events to long-polling clients
+	eventHub := events.NewHub(redisClient, jwtHelper, cfg.Events, log)
+	var eventPublisher webhook.Publisher
+	if cfg.Events.Enabled {
+		eventPublisher = eventHub
+	}
+
+	// Deliver order/payment events to the webhook endpoints registered by merchants (and to polling clients)
+	webhookDispatcher := webhook.NewDispatcher(redisClient, jwtHelper, eventPublisher, cfg.Outbound, log)
+	dispatchEvents := cfg.Outbound.Enabled || cfg.Events.Enabled
+
+	// Translate well-known backend errors into the language of the client
+	var translator *i18n.Translator
+	if cfg.I18n.Enabled {
+		if translator, err = i18n.NewTranslator(cfg.I18n); err != nil {
+			log.Fatal("failed to load the message catalogs", zap.Error(err))
+		}
+	}
+
 	// Initialize grpc-gateway mux with custom header matcher and metadata annotator
-	mux := runtime.NewServeMux(
+	muxOpts := []runtime.ServeMuxOption{
 		runtime.WithIncomingHeaderMatcher(middleware.HTTPHeaderMatcher),
+		runtime.WithOutgoingHeaderMatcher(middleware.OutgoingHeaderMatcher),
 		runtime.WithMarshalerOption(runtime.MIMEWildcard, customRuntime.NewCustomMarshaler()),
-		runtime.WithMetadata(func(ctx context.Context, req *http.Request) metadata.MD {
-			// Get standard metadata from our custom annotator (lang, timezone)
-			md := middleware.MetadataAnnotator(ctx, req)
+		// Binary protobuf clients skip JSON and the envelope
+		runtime.WithMarshalerOption(customRuntime.ProtoContentType, customRuntime.NewProtoMarshaler(customRuntime.ProtoContentType)),
+		runtime.WithMarshalerOption("application/protobuf", customRuntime.NewProtoMarshaler("application/protobuf")),
+		// Report downloads: list responses as CSV rows
+		runtime.WithMarshalerOption(customRuntime.CSVContentType, customRuntime.NewCSVMarshaler()),
+		// Legacy integrations (ERP systems): the envelope as XML
+		runtime.WithMarshalerOption(customRuntime.XMLContentType, customRuntime.NewXMLMarshaler()),
+		// POS terminals on constrained links: the envelope as MessagePack
+		runtime.WithMarshalerOption(customRuntime.MsgpackContentType, customRuntime.NewMsgpackMarshaler()),
+		// Server streams and large lists line by line, each stream message flushed as it arrives
+		runtime.WithMarshalerOption(customRuntime.NDJSONContentType, customRuntime.NewNDJSONMarshaler()),
+		runtime.WithMetadata(annotate),
+		runtime.WithForwardResponseOption(sessionCookie.ForwardResponse),
+		runtime.WithRoutingErrorHandler(middleware.RoutingErrorHandler(routes)),
+		runtime.WithErrorHandler(middleware.ErrorHandler(cfg.Errors)),
+		// Add the request ID to every envelope, and leave it out of raw responses
+		runtime.WithForwardResponseRewriter(customRuntime.NewResponseRewriter(cfg.Envelope.RawMethods, translator)),
+	}
+	if dispatchEvents {
+		muxOpts = append(muxOpts, runtime.WithForwardResponseOption(webhookDispatcher.ForwardResponse))
+	}
+	mux := runtime.NewServeMux(muxOpts...)
+
+	// Resolve logical backend addresses (consul:///, etcd:///, kubernetes:///) through the service registries
+	backend.RegisterDiscovery(cfg.Discovery, log)
+	// Balance across backend hosts, ejecting the ones whose error rate stands out
+	backend.RegisterOutlierDetection(cfg.Outlier, log)
+
+	// Connect to the backends: one shared connection per address, authenticated and routed by the interceptors
+	// (GRPC_INTERCEPTORS / <SVC>_GRPC_INTERCEPTORS), which are registered below
+	interceptors := backend.NewInterceptors()
+	connManager := backend.NewManager(cfg.GRPCServices, interceptors, log)
+	defer connManager.Close()
+
+	// Route calls pinned to another service generation (API version routing) or picked for a canary to their backend,
+	// over connections configured like the service's own, and mirror sampled reads to shadow backends
+	backendRouter := middleware.NewBackendRouter(cfg.Canary, cfg.Shadow, idempotentMethods, connManager, log)
+	versionRouting := middleware.NewVersionRouting(cfg.GRPCServices, log)
+
+	// Bound backend calls by per-service and per-method timeouts, capping the deadlines clients ask for
+	timeouts := middleware.NewTimeouts(cfg.Timeouts, log)
+	// Retry idempotent calls through transient backend failures
+	retryInterceptor := middleware.NewRetryInterceptor(idempotentMethods, cfg.Retry, log)
+	// Fast-fail calls to backends that are down
+	circuitBreaker := middleware.NewCircuitBreaker(cfg.Breaker, log)
+	// Hedge hot reads so a backend GC pause doesn't show up in their tail latency
+	hedging := middleware.NewHedging(cfg.Hedging, log)
+
+	// Write auth failures, rate-limit hits, IP bans and signature failures to the security log
+	if err := security.Configure(cfg.SecurityLog, log); err != nil {
+		log.Fatal("failed to initialize security log", zap.Error(err))
+	}
+	go security.Run(ctx)
 
-			// Explicitly forward Authorization header
-			if auth := req.Header.Get("Authorization"); auth != "" {
-				md.Set("authorization", auth)
-			}
-			return md
-		}),
-	)
+	// Report panics, 5xx responses and backend INTERNAL errors to Sentry
+	errorReporter, err := reporting.NewReporter(cfg.Reporting, log)
+	if err != nil {
+		log.Fatal("failed to initialize error reporting", zap.Error(err))
+	}
+	go errorReporter.Run(ctx)
+	errorCapture := reporting.NewCapture(errorReporter, jwtHelper)
+	recovery := middleware.NewRecovery(log)
+
+	// Interceptors composed per backend by GRPC_INTERCEPTORS / <SVC>_GRPC_INTERCEPTORS
+	interceptors.Register("recovery", backend.Interceptor{Unary: recovery.Unary(), Stream: recovery.Stream()})
+	interceptors.Register("timeout", backend.Interceptor{Unary: timeouts.Unary(), Stream: timeouts.Stream()})
+	interceptors.Register("auth", backend.Interceptor{Unary: authInterceptor.Unary(), Stream: authInterceptor.Stream()})
+	interceptors.Register("error_reporting", backend.Interceptor{Unary: errorCapture.Unary(), Stream: errorCapture.Stream()})
+	interceptors.Register("circuit_breaker", backend.Interceptor{Unary: circuitBreaker.Unary(), Stream: circuitBreaker.Stream()})
+	interceptors.Register("retry", backend.Interceptor{Unary: retryInterceptor.Unary()})
+	interceptors.Register("hedging", backend.Interceptor{Unary: hedging.Unary()})
+	interceptors.Register("backend_router", backend.Interceptor{Unary: backendRouter.Unary(), Stream: backendRouter.Stream()})
+
+	// Register the service handlers (auto-generated from proto annotations!)
+	services := []struct {
+		name     string
+		addr     string
+		register func(context.Context, *runtime.ServeMux, *grpc.ClientConn) error
+	}{
+		{"user.v1.MerchantService", cfg.GRPCServices.MerchantServiceAddr, userv1.RegisterMerchantServiceHandler},
+		// RoleService and UserService are hosted in User Service (MerchantServiceAddr)
+		{"user.v1.RoleService", cfg.GRPCServices.MerchantServiceAddr, userv1.RegisterRoleServiceHandler},
+		{"user.v1.UserService", cfg.GRPCServices.MerchantServiceAddr, userv1.RegisterUserServiceHandler},
+		{"product.v1.ProductService", cfg.GRPCServices.ProductServiceAddr, productv1.RegisterProductServiceHandler},
+		{"product.v1.CategoryService", cfg.GRPCServices.ProductServiceAddr, productv1.RegisterCategoryServiceHandler},
+		{"product.v1.InventoryService", cfg.GRPCServices.ProductServiceAddr, productv1.RegisterInventoryServiceHandler},
+		{"product.v1.ProductVariantService", cfg.GRPCServices.ProductServiceAddr, productv1.RegisterProductVariantServiceHandler},
+		{"order.v1.OrderService", cfg.GRPCServices.OrderServiceAddr, orderv1.RegisterOrderServiceHandler},
+		{"customer.v1.CustomerService", cfg.GRPCServices.CustomerServiceAddr, customerv1.RegisterCustomerServiceHandler},
+		{"payment.v1.PaymentService", cfg.GRPCServices.PaymentServiceAddr, paymentv1.RegisterPaymentServiceHandler},
+		{"store.v1.StoreService", cfg.GRPCServices.StoreServiceAddr, storev1.RegisterStoreServiceHandler},
+		{"audit.v1.AuditService", cfg.GRPCServices.AuditServiceAddr, auditv1.RegisterAuditServiceHandler},
+	}
+	// Every chain must authenticate its calls, and route them where a canary or shadow is configured
+	var routedAddrs []string
+	for _, svc := range services {
+		if (cfg.Canary.Enabled && cfg.Canary.Backends[svc.name] != "") || (cfg.Shadow.Enabled && cfg.Shadow.Backends[svc.name] != "") {
+			routedAddrs = append(routedAddrs, svc.addr)
+		}
+	}
+	if err := interceptors.Validate(cfg.GRPCServices, routedAddrs); err != nil {
+		log.Fatal("invalid backend interceptor chain", zap.Error(err))
+	}
+	// Connections are lazy, so the gateway starts with unreachable backends: their routes answer 503 and
+	// /readyz reports them degraded until the connection recovers. Only a malformed address leaves a service unregistered.
+	for _, svc := range services {
+		conn, err := connManager.Conn(svc.addr)
+		if err != nil {
+			log.Error("failed to connect to backend, its routes are unavailable", zap.String("service", svc.name), zap.Error(err))
+			continue
+		}
+		if err := svc.register(ctx, mux, conn); err != nil {
+			log.Error("failed to register service handler", zap.String("service", svc.name), zap.Error(err))
+			continue
+		}
+		log.Info("Service handler registered", zap.String("service", svc.name), zap.String("addr", svc.addr))
+	}
 
-	// gRPC dial options with authentication interceptor
-	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithUnaryInterceptor(authInterceptor.Unary()),
+	// Initialize the raw gRPC proxy (gRPC-Web, GraphQL, native gRPC listener), routing by proto package to the same backends
+	proxyConns := make(map[string]*grpc.ClientConn)
+	for pkg, addr := range map[string]string{
+		"user.v1":     cfg.GRPCServices.MerchantServiceAddr,
+		"product.v1":  cfg.GRPCServices.ProductServiceAddr,
+		"order.v1":    cfg.GRPCServices.OrderServiceAddr,
+		"customer.v1": cfg.GRPCServices.CustomerServiceAddr,
+		"payment.v1":  cfg.GRPCServices.PaymentServiceAddr,
+		"store.v1":    cfg.GRPCServices.StoreServiceAddr,
+		"audit.v1":    cfg.GRPCServices.AuditServiceAddr,
+	} {
+		conn, err := connManager.Conn(addr)
+		if err != nil {
+			// Already logged by the service registration
+			continue
+		}
+		proxyConns[pkg] = conn
 	}
+	grpcProxy := grpcproxy.NewProxy(proxyConns, annotate, log)
 
-	// Register user service handler (auto-generated from proto annotations!)
-	log.Info("Connecting to merchant service", zap.String("addr", cfg.GRPCServices.MerchantServiceAddr))
-	err = userv1.RegisterMerchantServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.MerchantServiceAddr,
-		opts,
-	)
-	if err != nil {
-		log.Fatal("failed to register user service handler", zap.Error(err))
+	// Create HTTP handler using grpc-gateway mux
+	httpMux := http.NewServeMux()
+
+	// Register gRPC-Gateway routes
+	httpMux.Handle("/", mux)
+
+	// Register liveness, readiness and backend health probes
+	healthChecker := health.NewChecker([]health.Backend{
+		{Name: "merchant", Addr: cfg.GRPCServices.MerchantServiceAddr},
+		{Name: "product", Addr: cfg.GRPCServices.ProductServiceAddr},
+		{Name: "order", Addr: cfg.GRPCServices.OrderServiceAddr},
+		{Name: "customer", Addr: cfg.GRPCServices.CustomerServiceAddr},
+		{Name: "payment", Addr: cfg.GRPCServices.PaymentServiceAddr},
+		{Name: "store", Addr: cfg.GRPCServices.StoreServiceAddr},
+		{Name: "audit", Addr: cfg.GRPCServices.AuditServiceAddr},
+	}, connManager, cfg.Health, log)
+	healthChecker.RegisterRoutes(httpMux)
+	if cfg.Health.WarmUp {
+		healthChecker.WarmUp(ctx)
 	}
 
-	// Register RoleService
-	err = userv1.RegisterRoleServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.MerchantServiceAddr, // RoleService is hosted in User Service (MerchantServiceAddr)
-		opts,
-	)
+	// Initialize and register Swagger UI
+	swaggerHandler := swagger.NewHandler(log)
+	swaggerHandler.RegisterRoutes(httpMux)
+
+	// Register session routes (logout) for cookie session mode
+	sessionCookie.RegisterRoutes(httpMux)
+	csrfProtection.RegisterRoutes(httpMux)
+
+	// Proxy plain HTTP upstreams under their path prefixes
+	upstreams, err := middleware.NewUpstreams(jwtHelper, cfg.Upstream, []string{cfg.Session.CookieName, cfg.CSRF.CookieName}, log)
 	if err != nil {
-		log.Fatal("failed to register role service handler", zap.Error(err))
+		log.Fatal("failed to initialize http upstreams", zap.Error(err))
+	}
+	upstreams.RegisterRoutes(httpMux)
+
+	// Initialize the GraphQL facade over the read methods of the services
+	if cfg.GraphQL.Enabled {
+		graphqlHandler, err := graphql.NewHandler(grpcProxy, cfg.GraphQL, log)
+		if err != nil {
+			log.Error("failed to initialize graphql, endpoint disabled", zap.Error(err))
+		} else {
+			graphqlHandler.RegisterRoutes(httpMux)
+			log.Info("GraphQL endpoint enabled", zap.String("path", cfg.GraphQL.Path))
+		}
 	}
 
-	// Register UserService
-	err = userv1.RegisterUserServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.MerchantServiceAddr, // UserService is hosted in User Service (MerchantServiceAddr)
-		opts,
-	)
-	if err != nil {
-		log.Fatal("failed to register user service handler (staff)", zap.Error(err))
+	// Serve static assets (receipt logos, web POS bundle)
+	if cfg.Static.Enabled {
+		staticHandler, err := static.NewHandler(cfg.Static, log)
+		if err != nil {
+			log.Fatal("failed to initialize static assets", zap.Error(err))
+		}
+		staticHandler.RegisterRoutes(httpMux)
 	}
 
-	// Register other service handlers here
-	// Product Service
-	log.Info("Connecting to product service", zap.String("addr", cfg.GRPCServices.ProductServiceAddr))
+	// Issue and resolve signed receipt links
+	if cfg.Receipt.Enabled {
+		if cfg.Receipt.SigningKey == "" {
+			log.Fatal("receipt links require RECEIPT_SIGNING_KEY")
+		}
+		receipt.NewHandler(grpcProxy, jwtHelper, cfg.Receipt, log).RegisterRoutes(httpMux)
+	}
 
-	// Register ProductService
-	err = productv1.RegisterProductServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.ProductServiceAddr,
-		opts,
-	)
-	if err != nil {
-		log.Fatal("failed to register product service handler", zap.Error(err))
+	// Expose Prometheus metrics, and push them to an OpenTelemetry collector with or without the scrape path
+	if cfg.Metrics.OTLPEndpoint != "" {
+		hostname, _ := os.Hostname()
+		go metrics.NewOTLPExporter(cfg.Metrics, map[string]string{
+			"service.name":           cfg.Server.AppName,
+			"deployment.environment": cfg.Server.AppEnv,
+			"service.instance.id":    hostname,
+		}, log).Run(ctx)
+	}
+	if cfg.Metrics.Enabled {
+		httpMux.Handle(cfg.Metrics.Path, metrics.Handler(cfg.Metrics.Exemplars))
 	}
 
-	// Register CategoryService
-	err = productv1.RegisterCategoryServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.ProductServiceAddr,
-		opts,
-	)
-	if err != nil {
-		log.Fatal("failed to register category service handler", zap.Error(err))
+	// Receive payment-provider webhooks, rate limited by the webhook limiter on the same prefix
+	if cfg.Webhooks.Enabled {
+		webhook.NewHandler(grpcProxy, redisClient, cfg.Webhooks, cfg.WebhookLimit.PathPrefix, log).RegisterRoutes(httpMux)
+	}
+	if cfg.Outbound.Enabled {
+		webhookDispatcher.RegisterRoutes(httpMux)
+		log.Info("Outbound webhooks enabled", zap.Int("events", len(cfg.Outbound.Events)))
+	}
+	if cfg.Events.Enabled {
+		eventHub.RegisterRoutes(httpMux)
+		go eventHub.Run(ctx)
+		log.Info("Event long polling enabled", zap.String("path", cfg.Events.PollPath))
+	}
+	if dispatchEvents {
+		go webhookDispatcher.Run(ctx)
 	}
 
-	// Register InventoryService
-	err = productv1.RegisterInventoryServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.ProductServiceAddr,
-		opts,
-	)
+	// Initialize Rate Limiter
+	rateLimiter := middleware.NewRateLimiter(redisClient, jwtHelper, cfg.RateLimit, routes, methodRateLimits, log)
+	go rateLimiter.Run(ctx)
+
+	// Initialize IP allow/deny lists (static CIDRs plus runtime bans stored in Redis)
+	ipFilter, err := middleware.NewIPFilter(redisClient, cfg.IPFilter, log)
 	if err != nil {
-		log.Fatal("failed to register inventory service handler", zap.Error(err))
+		log.Fatal("failed to initialize ip filter", zap.Error(err))
+	}
+	go ipFilter.Run(ctx)
+
+	// Register admin routes (protected by the admin token)
+	adminAuth := middleware.AdminAuth(cfg.Admin, log)
+	ipFilter.RegisterAdminRoutes(httpMux, adminAuth)
+	rateLimiter.RegisterAdminRoutes(httpMux, adminAuth)
+	levelLogger.RegisterAdminRoutes(httpMux, adminAuth)
+	go levelLogger.HandleSignals(ctx)
+
+	// Serve profiling and debug endpoints, preferably on an internal listener
+	var adminServer *http.Server
+	if cfg.Admin.Debug {
+		if cfg.Admin.Port == "" {
+			debug.RegisterRoutes(httpMux, adminAuth)
+		} else {
+			adminMux := http.NewServeMux()
+			debug.RegisterRoutes(adminMux, adminAuth)
+			adminServer = &http.Server{Addr: cfg.Admin.Port, Handler: adminMux, ReadHeaderTimeout: 10 * time.Second}
+			go func() {
+				log.Info("admin debug server started", zap.String("port", cfg.Admin.Port))
+				if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Fatal("failed to start admin debug server", zap.Error(err))
+				}
+			}()
+		}
 	}
 
-	// Register ProductVariantService
-	err = productv1.RegisterProductVariantServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.ProductServiceAddr,
-		opts,
-	)
-	if err != nil {
-		log.Fatal("failed to register product variant service handler", zap.Error(err))
+	// Initialize global and per-service maintenance switches
+	maintenance := middleware.NewMaintenance(redisClient, routes, cfg.Maintenance, log)
+	maintenance.RegisterAdminRoutes(httpMux, adminAuth)
+	go maintenance.Run(ctx)
+
+	// Answer OPTIONS and HEAD from the route table and apply method overrides
+	methodHandling := middleware.NewMethodHandling(routes, cfg.HTTP, log)
+
+	// Initialize runtime backend address changes (migrations without a restart)
+	backendReroutes := backend.NewReroutes(redisClient, connManager, cfg.GRPCServices, log)
+	backendReroutes.RegisterAdminRoutes(httpMux, adminAuth)
+	go backendReroutes.Run(ctx)
+
+	// Fail backends over to their fallback address while their primary is down
+	if len(cfg.Failover.Backends) > 0 {
+		go backend.NewFailover(connManager, cfg.Failover, log).Run(ctx)
 	}
 
-	log.Info("Product service handlers registered")
+	// Initialize the per-route kill switch
+	killSwitch := middleware.NewKillSwitch(redisClient, routes, cfg.KillSwitch, log)
+	killSwitch.RegisterAdminRoutes(httpMux, adminAuth)
+	go killSwitch.Run(ctx)
 
-	// Register OrderService
-	log.Info("Connecting to order service", zap.String("addr", cfg.GRPCServices.OrderServiceAddr))
-	err = orderv1.RegisterOrderServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.OrderServiceAddr,
-		opts,
-	)
-	if err != nil {
-		log.Fatal("failed to register order service handler", zap.Error(err))
+	// Initialize host-based tenancy for white-label deployments ("{merchant}.omnipos.app")
+	tenantResolver := middleware.NewTenantResolver(redisClient, jwtHelper, cfg.Tenant, log)
+	if cfg.Tenant.Enabled {
+		go tenantResolver.Run(ctx)
+		tenantResolver.RegisterAdminRoutes(httpMux, adminAuth)
 	}
-	log.Info("Order service handler registered")
 
-	// Register CustomerService
-	log.Info("Connecting to customer service", zap.String("addr", cfg.GRPCServices.CustomerServiceAddr))
-	err = customerv1.RegisterCustomerServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.CustomerServiceAddr,
-		opts,
-	)
+	// Initialize cluster-wide rate caps (overall and per backend service)
+	globalRateLimiter := middleware.NewGlobalRateLimiter(redisClient, cfg.GlobalLimit, routes, log)
+
+	// Initialize rate limit exemptions (health checks, trusted callers, internal networks)
+	rateLimitExemptions, err := middleware.NewRateLimitExemptions(cfg.RateLimit)
 	if err != nil {
-		log.Fatal("failed to register customer service handler", zap.Error(err))
+		log.Fatal("failed to initialize rate limit exemptions", zap.Error(err))
 	}
-	log.Info("Customer service handler registered")
 
-	// Register PaymentService
-	log.Info("Connecting to payment service", zap.String("addr", cfg.GRPCServices.PaymentServiceAddr))
-	err = paymentv1.RegisterPaymentServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.PaymentServiceAddr,
-		opts,
-	)
+	// Initialize the leaky-bucket limiter of inbound payment webhooks, separate from user traffic
+	webhookRateLimiter := middleware.NewWebhookRateLimiter(redisClient, cfg.WebhookLimit, log)
+
+	// Initialize in-flight request limits (per merchant and global)
+	concurrencyLimiter := middleware.NewConcurrencyLimiter(jwtHelper, cfg.Concurrency, log)
+
+	// Serve a JSON snapshot of the gateway for dashboards and diagnostics without Prometheus
+	stats.NewHandler(connManager, concurrencyLimiter, startedAt).RegisterAdminRoutes(httpMux, adminAuth)
+
+	// Initialize path rewrites (ingress prefixes, legacy client paths)
+	pathRewrite, err := middleware.NewPathRewrite(cfg.Rewrite, log)
 	if err != nil {
-		log.Fatal("failed to register payment service handler", zap.Error(err))
+		log.Fatal("failed to initialize path rewrite", zap.Error(err))
 	}
-	log.Info("Payment service handler registered")
 
-	// Register StoreService
-	log.Info("Connecting to store service", zap.String("addr", cfg.GRPCServices.StoreServiceAddr))
-	err = storev1.RegisterStoreServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.StoreServiceAddr,
-		opts,
-	)
+	// Initialize request body size limits
+	bodyLimiter := middleware.NewBodyLimiter(cfg.BodyLimit, routes, log)
+
+	// Initialize monthly per-merchant quotas
+	quotaManager := middleware.NewQuotaManager(redisClient, jwtHelper, cfg.Quota, log)
+	quotaManager.RegisterRoutes(httpMux)
+
+	// Initialize opt-in body logging, redacting sensitive fields
+	redactedFields, err := middleware.DiscoverRedactedFields()
 	if err != nil {
-		log.Fatal("failed to register store service handler", zap.Error(err))
+		log.Fatal("failed to discover redacted fields", zap.Error(err))
+	}
+	bodyLogger := middleware.NewBodyLogger(cfg.BodyLog, redactedFields, log)
+	if cfg.BodyLog.Enabled {
+		log.Warn("request/response body logging enabled", zap.Strings("paths", cfg.BodyLog.Paths), zap.Int("max_bytes", cfg.BodyLog.MaxBytes))
 	}
-	log.Info("Store service handler registered")
 
-	// Register AuditService
-	log.Info("Connecting to audit service", zap.String("addr", cfg.GRPCServices.AuditServiceAddr))
-	err = auditv1.RegisterAuditServiceHandlerFromEndpoint(
-		ctx,
-		mux,
-		cfg.GRPCServices.AuditServiceAddr,
-		opts,
-	)
-	if err != nil {
-		log.Fatal("failed to register audit service handler", zap.Error(err))
+	// Forward an audit record of every successful mutating request to the audit service
+	var auditForwarder *audit.Forwarder
+	if cfg.Audit.Enabled {
+		if conn, err := connManager.Conn(cfg.GRPCServices.AuditServiceAddr); err != nil {
+			log.Error("failed to connect to the audit service, audit forwarding disabled", zap.Error(err))
+		} else if auditForwarder, err = audit.NewForwarder(conn, jwtHelper, routes, cfg.Audit, log); err != nil {
+			log.Error("failed to initialize audit forwarding, audit forwarding disabled", zap.Error(err))
+		} else {
+			go auditForwarder.Run(ctx)
+		}
 	}
-	log.Info("Audit service handler registered")
 
-	log.Info("User service handler registered")
+	// Meter requests and bytes per merchant and API key per day for billing
+	var usageMeter *usage.Meter
+	if cfg.Usage.Enabled {
+		forwarder := auditForwarder
+		if forwarder == nil && cfg.Usage.Export == "audit" {
+			// The usage export only needs the queue to the audit service, not the per-request records
+			conn, err := connManager.Conn(cfg.GRPCServices.AuditServiceAddr)
+			if err != nil {
+				log.Fatal("failed to connect to the audit service for the usage export", zap.Error(err))
+			}
+			if forwarder, err = audit.NewForwarder(conn, jwtHelper, routes, cfg.Audit, log); err != nil {
+				log.Fatal("failed to initialize the audit usage export", zap.Error(err))
+			}
+			go forwarder.Run(ctx)
+		}
+		if usageMeter, err = usage.NewMeter(redisClient, jwtHelper, forwarder, cfg.Usage, log); err != nil {
+			log.Fatal("failed to initialize usage metering", zap.Error(err))
+		}
+		usageMeter.RegisterRoutes(httpMux)
+		go usageMeter.Run(ctx)
+	}
 
-	// Create HTTP handler using grpc-gateway mux
-	httpMux := http.NewServeMux()
+	// Log requests over the slow request threshold with their route, merchant and backend
+	serviceBackends := make(map[string]string, len(services))
+	for _, svc := range services {
+		serviceBackends[svc.name] = svc.addr
+	}
+	slowLog := middleware.NewSlowLog(jwtHelper, routes, serviceBackends, cfg.SlowLog, log)
 
-	// Register gRPC-Gateway routes
-	httpMux.Handle("/", mux)
+	// Count requests by route, status and (the busiest) merchants, and observe their latency
+	metrics.ConfigureMerchantLabels(cfg.Metrics)
+	go metrics.RunMerchantLabels(ctx)
+	requestMetrics := middleware.NewRequestMetrics(jwtHelper, routes)
 
-	// Initialize and register Swagger UI
-	swaggerHandler := swagger.NewHandler(log)
-	swaggerHandler.RegisterRoutes(httpMux)
+	// Flag the responses of deprecated methods and record their callers
+	deprecations, err := middleware.NewDeprecations(jwtHelper, routes, deprecatedMethods, cfg.Deprecation, log)
+	if err != nil {
+		log.Fatal("failed to initialize deprecations", zap.Error(err))
+	}
 
-	// Initialize Redis client
-	redisClient, err := cache.NewRedisClient(&cfg.Redis)
+	// Trace requests at the sample rate of their route
+	traceSampler, err := middleware.NewTraceSampler(routes, cfg.Tracing)
 	if err != nil {
-		log.Fatal("failed to initialize redis client", zap.Error(err))
+		log.Fatal("failed to initialize trace sampling", zap.Error(err))
 	}
-	defer redisClient.Close()
-	log.Info("Redis client initialized")
 
-	// Initialize Rate Limiter
-	rateLimiter := middleware.NewRateLimiter(redisClient, cfg.RateLimit, log)
+	// Exercise the configured read endpoints end-to-end through the gateway
+	go probe.NewProber(cfg.Probe, log).Run(ctx)
 
-	// Apply CORS middleware and Rate Limiter
-	// Order: CORS -> RateLimit -> Mux
-	handler := middleware.CORS(rateLimiter.Limit(middleware.RequestIDMiddleware(httpMux)))
+	// Export an access log entry per request, apart from the application logs
+	var accessLog *accesslog.Exporter
+	if cfg.AccessLog.Enabled {
+		if accessLog, err = accesslog.NewExporter(jwtHelper, routes, cfg.AccessLog, log); err != nil {
+			log.Fatal("failed to initialize the access log", zap.Error(err))
+		}
+		go accessLog.Run(ctx)
+	}
+
+	// Compress the responses of clients accepting it
+	var compression *middleware.Compression
+	if cfg.Compression.Enabled {
+		if compression, err = middleware.NewCompression(cfg.Compression); err != nil {
+			log.Fatal("failed to initialize response compression", zap.Error(err))
+		}
+	}
+
+	// Apply middlewares, outermost first
+	middlewares := []func(http.Handler) http.Handler{
+		// Outermost, so every response (including rejections) and log line carries the request ID
+		middleware.RequestIDMiddleware,
+		// Before everything reading the caller's claims, so the token is validated once per request
+		jwtHelper.CacheClaims,
+		recovery.Handle,
+		errorCapture.Handle,
+	}
+	if cfg.HTTP.ServerTiming {
+		middlewares = append(middlewares, middleware.ServerTiming)
+	}
+	if compression != nil {
+		// Outside of the body logging and rewriting middlewares, which need the uncompressed response
+		middlewares = append(middlewares, compression.Compress)
+	}
+	if accessLog != nil {
+		// Before anything that rewrites or rejects requests, so every request is logged as received
+		middlewares = append(middlewares, accessLog.Log)
+	}
+	middlewares = append(middlewares,
+		middleware.CORS,
+		pathRewrite.Rewrite,
+		versionRouting.Route,
+		traceSampler.Sample,
+		slowLog.Log,
+		requestMetrics.Count,
+		methodHandling.Handle,
+		timeouts.Deadline,
+		ipFilter.Filter,
+		maintenance.Check,
+		killSwitch.Check,
+		csrfProtection.Protect,
+		sessionCookie.Authenticate,
+		deprecations.Flag,
+		tenantResolver.Resolve,
+		rateLimitExemptions.Mark,
+		webhookRateLimiter.Limit,
+		rateLimiter.Limit,
+		globalRateLimiter.Limit,
+		concurrencyLimiter.Limit,
+		quotaManager.Enforce,
+		bodyLimiter.Limit,
+		bodyLogger.Log,
+		customRuntime.CSVAttachment,
+	)
+	if cfg.Envelope.RawHeader {
+		middlewares = append(middlewares, customRuntime.RawResponses)
+	}
+	middlewares = append(middlewares, customRuntime.FieldCasing, customRuntime.FieldSelection)
+	if cfg.Envelope.Pretty {
+		middlewares = append(middlewares, customRuntime.PrettyPrint)
+	}
+	if translator != nil {
+		middlewares = append(middlewares, translator.Negotiate)
+	}
+	if usageMeter != nil {
+		// After the limiters, so only the requests let through are billed
+		middlewares = append(middlewares, usageMeter.Track)
+	}
+	if auditForwarder != nil {
+		middlewares = append(middlewares, auditForwarder.Record)
+	}
+	if cfg.GRPCWeb.Enabled {
+		// gRPC-Web requests are negotiated by Content-Type and bypass the JSON mux
+		middlewares = append(middlewares, grpcProxy.GRPCWeb(cfg.GRPCWeb))
+	}
+	if cfg.Upload.Enabled {
+		// Multipart uploads to designated routes are translated into gRPC calls
+		uploadBridge := grpcproxy.NewUploadBridge(grpcProxy, routes, cfg.Upload, log)
+		middlewares = append(middlewares, uploadBridge.Handle)
+	}
+	handler := middleware.Chain(httpMux, middlewares...)
+
+	// Initialize the HTTP/3 (QUIC) server, advertised to clients of the TCP listener with Alt-Svc
+	var h3Server *http3.Server
+	if cfg.HTTP.HTTP3 {
+		if cfg.HTTP.TLSCertFile == "" || cfg.HTTP.TLSKeyFile == "" {
+			log.Fatal("HTTP/3 requires HTTP_TLS_CERT_FILE and HTTP_TLS_KEY_FILE")
+		}
+
+		h3Server = &http3.Server{
+			Addr:        cfg.HTTP.HTTP3Port,
+			Handler:     handler,
+			IdleTimeout: 60 * time.Second,
+		}
+
+		next := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h3Server.SetQUICHeaders(w.Header())
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	// Serve HTTP/1.1, plus cleartext HTTP/2 (h2c) when enabled
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(cfg.HTTP.H2C)
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -283,6 +719,7 @@ func main() {
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		Protocols:    &protocols,
 	}
 
 	// Start server in a goroutine
@@ -293,6 +730,53 @@ func main() {
 		}
 	}()
 
+	if h3Server != nil {
+		go func() {
+			log.Info("http/3 server started", zap.String("port", cfg.HTTP.HTTP3Port))
+			if err := h3Server.ListenAndServeTLS(cfg.HTTP.TLSCertFile, cfg.HTTP.TLSKeyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal("failed to start http/3 server", zap.Error(err))
+			}
+		}()
+	}
+
+	// Start the native gRPC passthrough listener
+	var grpcServer *grpc.Server
+	if cfg.GRPCProxy.Enabled {
+		lis, err := net.Listen("tcp", cfg.GRPCProxy.Port)
+		if err != nil {
+			log.Fatal("failed to listen for grpc proxy", zap.Error(err))
+		}
+
+		grpcServer = grpcProxy.NewServer()
+		if cfg.GRPCProxy.Reflection {
+			grpcProxy.RegisterReflection(grpcServer)
+		}
+		go func() {
+			log.Info("grpc proxy server started", zap.String("port", cfg.GRPCProxy.Port))
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatal("failed to start grpc proxy server", zap.Error(err))
+			}
+		}()
+	}
+
+	// Start the internal channelz listener; keep it off public networks
+	var channelzServer *grpc.Server
+	if cfg.Metrics.ChannelzPort != "" {
+		lis, err := net.Listen("tcp", cfg.Metrics.ChannelzPort)
+		if err != nil {
+			log.Fatal("failed to listen for channelz", zap.Error(err))
+		}
+
+		channelzServer = grpc.NewServer()
+		channelzservice.RegisterChannelzServiceToServer(channelzServer)
+		go func() {
+			log.Info("channelz server started", zap.String("port", cfg.Metrics.ChannelzPort))
+			if err := channelzServer.Serve(lis); err != nil {
+				log.Fatal("failed to start channelz server", zap.Error(err))
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
@@ -300,12 +784,30 @@ func main() {
 	<-sigCh
 	log.Info("shutting down grpc-gateway server")
 
+	// Fail readiness first so the load balancer stops sending new requests before connections are drained
+	healthChecker.SetReady(false)
+	time.Sleep(cfg.Health.ShutdownDelay)
+
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
 
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		log.Error("server shutdown failed", zap.Error(err))
 	}
+	if h3Server != nil {
+		if err := h3Server.Shutdown(shutdownCtx); err != nil {
+			log.Error("http/3 server shutdown failed", zap.Error(err))
+		}
+	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	if channelzServer != nil {
+		channelzServer.Stop()
+	}
+	if adminServer != nil {
+		adminServer.Close()
+	}
 
 	log.Info("server shutdown complete")
 }
@@ -1,6 +1,12 @@
 package config
 
 import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/fekuna/omnipos-pkg/cache"
 )
 
@@ -12,6 +18,54 @@ type Config struct {
 	JWT          JWTConfig
 	Redis        cache.Config
 	RateLimit    RateLimitConfig
+	Session      SessionConfig
+	CSRF         CSRFConfig
+	Quota        QuotaConfig
+	Concurrency  ConcurrencyConfig
+	Admin        AdminConfig
+	IPFilter     IPFilterConfig
+	BodyLimit    BodyLimitConfig
+	GlobalLimit  GlobalRateLimitConfig
+	Metrics      MetricsConfig
+	Tracing      TracingConfig
+	Deprecation  DeprecationConfig
+	Errors       ErrorConfig
+	BodyLog      BodyLogConfig
+	Audit        AuditConfig
+	Reporting    ErrorReportingConfig
+	SlowLog      SlowLogConfig
+	SecurityLog  SecurityLogConfig
+	AccessLog    AccessLogConfig
+	Probe        ProbeConfig
+	Usage        UsageConfig
+	WebhookLimit WebhookRateLimitConfig
+	GRPCWeb      GRPCWebConfig
+	GraphQL      GraphQLConfig
+	GRPCProxy    GRPCProxyConfig
+	Upload       UploadConfig
+	Canary       CanaryConfig
+	Shadow       ShadowConfig
+	Rewrite      RewriteConfig
+	Health       HealthConfig
+	Upstream     UpstreamConfig
+	Maintenance  MaintenanceConfig
+	Receipt      ReceiptConfig
+	Static       StaticConfig
+	Webhooks     PaymentWebhookConfig
+	Outbound     OutboundWebhookConfig
+	Tenant       TenantConfig
+	KillSwitch   KillSwitchConfig
+	Events       EventsConfig
+	Timeouts     TimeoutConfig
+	Retry        RetryConfig
+	Hedging      HedgingConfig
+	Breaker      CircuitBreakerConfig
+	Discovery    DiscoveryConfig
+	Failover     FailoverConfig
+	Outlier      OutlierConfig
+	Compression  CompressionConfig
+	Envelope     EnvelopeConfig
+	I18n         I18nConfig
 }
 
 type ServerConfig struct {
@@ -21,7 +75,23 @@ type ServerConfig struct {
 }
 
 type HTTPConfig struct {
-	Port string
+	Port           string
+	H2C            bool   // also accept HTTP/2 without TLS on Port, for in-cluster callers behind a TLS-terminating LB
+	HTTP3          bool   // serve HTTP/3 (QUIC) on HTTP3Port and advertise it with Alt-Svc
+	HTTP3Port      string // UDP address
+	TLSCertFile    string // certificate and key of the HTTP/3 listener, which always uses TLS
+	TLSKeyFile     string
+	ServerTiming   bool     // add a Server-Timing header breaking latency down into auth, backend and marshaling
+	MethodOverride bool     // honor X-HTTP-Method-Override on POST requests (PUT, PATCH and DELETE only)
+	TrustedProxies []string // CIDRs of the proxies whose X-Forwarded-For and X-Real-IP are believed
+}
+
+type CompressionConfig struct {
+	Enabled      bool
+	Encodings    []string // supported Content-Encodings (gzip, deflate), preferred first when the client accepts several
+	Level        int      // compression level of gzip and deflate alike, 1 (fastest) to 9 (smallest)
+	MinBytes     int      // smaller responses are sent uncompressed
+	ContentTypes []string // compressed content types, by prefix (e.g. "text/")
 }
 
 type GRPCServicesConfig struct {
@@ -32,6 +102,76 @@ type GRPCServicesConfig struct {
 	PaymentServiceAddr  string
 	StoreServiceAddr    string
 	AuditServiceAddr    string
+	// VersionRoutes maps URL version prefixes to the address of another service generation,
+	// e.g. "/v2/orders" -> "order-service-v2:8083"
+	VersionRoutes map[string]string
+	Subchannels   int // HTTP/2 connections per backend address, balanced round robin
+	// TLS secures the connections to the backends by address; version routes and canaries use the
+	// settings of the service they stand in for, shadows those of their own address
+
+	TLS        map[string]BackendTLSConfig
+	DefaultTLS BackendTLSConfig
+	// Keepalive pings keep idle connections alive through NAT and load balancer idle timeouts; by
+	// backend address, other addresses use DefaultKeepalive
+	Keepalive              map[string]BackendKeepaliveConfig
+	DefaultKeepalive       BackendKeepaliveConfig
+	RerouteRefreshInterval time.Duration // how often runtime address changes (admin API) are picked up
+	// MessageSize raises the gRPC message size limits by backend address (e.g. for catalog exports),
+	// other addresses use DefaultMessageSize
+	MessageSize        map[string]BackendMessageSizeConfig
+	DefaultMessageSize BackendMessageSizeConfig
+	// Compression gzips the calls to chatty, large-payload backends by address, other addresses use
+	// DefaultCompression. Services sharing an address must configure it alike, as for Interceptors.
+	Compression        map[string]BackendCompressionConfig
+	DefaultCompression BackendCompressionConfig
+	// Interceptors is the client interceptor chain by backend address, outermost first (e.g. "recovery", "timeout",
+	// "auth", "error_reporting", "circuit_breaker", "retry", "hedging", "backend_router"), other addresses use DefaultInterceptors.
+	// backend_router must come last, as it sends calls to other connections. Every chain must include auth,
+	// and backend_router where the service has a canary or shadow, or version routes are configured.
+	Interceptors        map[string][]string
+	DefaultInterceptors []string
+	// Credentials are static credentials required by backends operated by partner teams, by address;
+	// there is no default, so they are never sent to other backends, and services sharing an address
+	// must configure the same ones
+	Credentials map[string]BackendCredentialsConfig
+	// Names are the services hosted at each address ("order", "merchant,store"), labelling backend
+	// connection metrics and logs
+	Names map[string]string
+}
+
+type BackendCredentialsConfig struct {
+	Header   string // metadata key carrying Token or the basic auth credentials
+	Token    string // sent as "Bearer <token>"
+	Username string // basic auth, when there is no token
+	Password string
+	Metadata map[string]string // additional static metadata, e.g. a partner API key
+}
+
+type BackendCompressionConfig struct {
+	Enabled  bool
+	MinBytes int // unary requests smaller than this are sent uncompressed
+}
+
+type BackendMessageSizeConfig struct {
+	MaxRecvBytes int // largest response accepted from the backend, 0 keeps gRPC's 4 MiB
+	MaxSendBytes int // largest request sent to the backend, 0 keeps gRPC's default (no practical limit)
+}
+
+// BackendKeepaliveConfig configures the keepalive pings of a backend connection; backends must permit
+// them (grpc-go servers reject pings more frequent than every 5 minutes by default)
+type BackendKeepaliveConfig struct {
+	Time                time.Duration // ping after this long without activity, 0 disables pings
+	Timeout             time.Duration // close the connection when a ping isn't acknowledged in time
+	PermitWithoutStream bool          // also ping idle connections without calls in flight
+}
+
+// BackendTLSConfig secures the connection to a backend service; plaintext unless enabled
+type BackendTLSConfig struct {
+	Enabled    bool
+	CAFile     string // PEM CA bundle verifying the backend, system roots when empty
+	CertFile   string // client certificate and key for mTLS, optional
+	KeyFile    string
+	ServerName string // overrides the name verified in the backend certificate
 }
 
 type LoggerConfig struct {
@@ -46,11 +186,465 @@ type JWTConfig struct {
 }
 
 type RateLimitConfig struct {
+	Enabled         bool
+	PublicRPS       int
+	PublicBurst     int
+	AuthRPS         int
+	AuthBurst       int
+	Algorithm       string         // "gcra", "sliding_window" or "fixed_window"
+	Period          time.Duration  // period of the public/auth rates
+	FailurePolicy   string         // "open" (default), "closed" or "local" (in-memory fallback) when Redis is unavailable
+	FallbackPercent int            // percentage of the configured rates applied by the local fallback limiter
+	RouteCosts      map[string]int // gRPC method -> tokens deducted per request (default 1)
+
+	// Separate read (GET/HEAD) buckets per principal, so reads don't consume the write budget.
+	// The public/auth rates above then apply to writes only.
+	SplitReadWrite  bool
+	ReadPublicRPS   int
+	ReadPublicBurst int
+	ReadAuthRPS     int
+	ReadAuthBurst   int
+
+	OverrideRefreshInterval time.Duration // how often overrides and rates set via the admin API are reloaded from Redis
+
+	// Key authenticated buckets on the X-Device-Id header when it matches the token's device_id claim,
+	// so each POS terminal sharing an account gets its own bucket
+	PerDevice bool
+
+	// Requests over the limit are delayed until the bucket allows them instead of rejected,
+	// as long as the wait stays within ShapingMaxWait
+	ShapingEnabled bool
+	ShapingMaxWait time.Duration
+
+	// Principals throttled PenaltyThreshold times within PenaltyWindow are rejected for PenaltyDuration
+	PenaltyEnabled   bool
+	PenaltyThreshold int
+	PenaltyWindow    time.Duration
+	PenaltyDuration  time.Duration
+
+	ExemptPaths  []string // path prefixes never throttled or counted (health checks, probes)
+	ExemptCIDRs  []string // internal networks never throttled or counted
+	ExemptTokens []string // bearer tokens or X-API-Key values of trusted callers
+}
+
+type SessionConfig struct {
+	CookieEnabled  bool
+	CookieName     string
+	CookieDomain   string
+	CookiePath     string
+	CookieSecure   bool
+	CookieSameSite string
+	CookieMaxAge   time.Duration
+	LoginMethods   []string
+	TokenField     string
+	LogoutPath     string
+}
+
+type CSRFConfig struct {
 	Enabled     bool
-	PublicRPS   int
-	PublicBurst int
-	AuthRPS     int
-	AuthBurst   int
+	CookieName  string
+	HeaderName  string
+	TokenPath   string
+	ExemptPaths []string
+}
+
+type QuotaConfig struct {
+	Enabled     bool
+	DefaultPlan string
+	Plans       map[string]int // plan name -> monthly request quota (0 = unlimited)
+	UsagePath   string
+}
+
+// UsageConfig meters requests and bytes per merchant or API key per day, for billing
+type UsageConfig struct {
+	Enabled        bool
+	Path           string        // usage endpoint of the authenticated caller
+	FlushInterval  time.Duration // how often the counters buffered in memory are added up in Redis
+	Retention      time.Duration // how long daily counters are kept in Redis
+	Export         string        // where completed days are exported: "" (nowhere), "file" or "audit"
+	ExportFile     string        // JSON lines appended by the file export
+	ExportInterval time.Duration // how often completed days are looked for
+}
+
+type ConcurrencyConfig struct {
+	Enabled              bool
+	MaxInFlight          int // across all tenants (0 = unlimited)
+	MaxInFlightPerTenant int // per merchant (0 = unlimited)
+	// ExemptPaths are long-lived requests that would hold a slot while idle, by path prefix; the event
+	// poll path is always exempt
+	ExemptPaths []string
+}
+
+type AdminConfig struct {
+	Token string // static token required in the X-Admin-Token header; admin endpoints are disabled when empty
+	Debug bool   // serve pprof, expvar and goroutine dumps under /debug/
+	Port  string // internal listener of the debug endpoints, e.g. ":6060"; they're served on the HTTP port (whose write timeout cuts long profiles) when empty
+}
+
+type IPFilterConfig struct {
+	Enabled         bool
+	Allowlist       []string // IPs/CIDRs; when set, only these are allowed
+	Denylist        []string // IPs/CIDRs always rejected
+	DynamicDeny     bool     // also reject IPs banned at runtime (stored in Redis)
+	RefreshInterval time.Duration
+}
+
+type BodyLimitConfig struct {
+	Enabled        bool
+	MaxBytes       int64
+	UploadMaxBytes int64
+	UploadPaths    []string // path prefixes of upload routes (e.g. product images)
+	UploadMethods  []string // gRPC methods of upload routes
+}
+
+type GlobalRateLimitConfig struct {
+	Enabled    bool
+	RPS        int // total requests per second across all gateway replicas (0 = unlimited)
+	Burst      int
+	ServiceRPS map[string]int // gRPC service (e.g. "payment.v1.PaymentService") -> total requests per second
+}
+
+type WebhookRateLimitConfig struct {
+	Enabled         bool
+	PathPrefix      string   // inbound webhook routes, "<prefix><provider>"; also where the webhook endpoints are served
+	RPS             int      // drain rate of each provider/identity bucket
+	Capacity        int      // requests queued in a bucket before rejecting
+	IdentityHeaders []string // signature headers identifying the provider account, first present wins
+}
+
+type GRPCWebConfig struct {
+	Enabled         bool
+	MaxMessageBytes int // largest request message, checked before it's read; gRPC servers accept 4 MiB by default
+}
+
+type GRPCProxyConfig struct {
+	Enabled    bool
+	Port       string // listener of native gRPC clients, e.g. ":9090"
+	Reflection bool   // serve the reflection services of all backends on the listener
+}
+
+type UploadConfig struct {
+	Enabled      bool
+	Methods      []string // gRPC methods accepting multipart/form-data uploads; also list them in BODY_LIMIT_UPLOAD_METHODS
+	AllowedTypes []string
+	MaxFileBytes int64
+	ChunkSize    int // bytes per message of client-streaming upload methods
+}
+
+type CanaryConfig struct {
+	Enabled     bool
+	Backends    map[string]string // gRPC service (e.g. "order.v1.OrderService") -> canary address
+	Percent     map[string]int    // gRPC service -> share of calls sent to the canary, 0-100
+	Header      string            // requests with Header: HeaderValue always go to the canary
+	HeaderValue string
+}
+
+type ShadowConfig struct {
+	Enabled     bool
+	Backends    map[string]string // gRPC service (e.g. "order.v1.OrderService") -> shadow address
+	Percent     map[string]int    // gRPC service -> share of idempotent unary calls mirrored to the shadow, 0-100
+	Timeout     time.Duration
+	MaxInFlight int // mirrored calls in flight at once; calls over the limit are not mirrored
+	// Metadata is sent with mirrored calls in place of the caller's credentials and identity, e.g. a
+	// staging token
+	Metadata map[string]string
+}
+
+type RewriteConfig struct {
+	StripPrefixes []string          // deployment prefixes removed from request paths, e.g. "/api" added by the ingress
+	Rules         []string          // "<regexp>=<replacement>" applied in order after stripping, first match wins
+	Aliases       map[string]string // exact vanity paths, e.g. "/login" -> "/v1/merchants/login"
+}
+
+type GraphQLConfig struct {
+	Enabled  bool
+	Path     string
+	Services []string // gRPC services whose GET-bound methods are exposed as Query fields
+	// MaxFields caps the top-level fields (aliases included) of a query, each a backend call; 0 = unlimited
+	MaxFields int
+	MaxDepth  int // deepest selection nesting of a query, 0 = unlimited
+	// Concurrency is the number of top-level fields of a query resolved at the same time
+	Concurrency int
+}
+
+type UpstreamConfig struct {
+	Routes         map[string]string // path prefix -> plain HTTP upstream, e.g. "/reports" -> "http://reporting:8090"
+	PublicPrefixes []string          // upstream prefixes served without authentication, e.g. the image CDN origin
+	StripPrefix    bool              // remove the prefix from the path sent upstream
+	Timeout        time.Duration     // time to wait for upstream response headers
+	// ForwardCredentials lists the upstream prefixes that receive the client's Authorization header and
+	// the gateway session and CSRF cookies; every other upstream gets them stripped
+	ForwardCredentials []string
+}
+
+type MaintenanceConfig struct {
+	Enabled         bool     // put the whole gateway in maintenance
+	Services        []string // gRPC services in maintenance, e.g. "order.v1.OrderService"
+	Message         string
+	RetryAfter      time.Duration
+	ExemptPaths     []string // still served during maintenance
+	RefreshInterval time.Duration
+}
+
+type KillSwitchConfig struct {
+	DisabledMethods []string // gRPC methods answered with 503, e.g. "/report.v1.ReportService/GetSalesReport"
+	GoneMethods     []string // gRPC methods answered with 410
+	RefreshInterval time.Duration
+}
+
+type ReceiptConfig struct {
+	Enabled             bool
+	SigningKey          string
+	IssuePath           string // authenticated route issuing links
+	BaseURL             string // public origin of the links, e.g. "https://omnipos.example.com"
+	PageURL             string // receipt web page browsers are redirected to, with ?token=
+	TTL                 time.Duration
+	MaxTTL              time.Duration
+	OrderMethod         string // unary gRPC method resolving the order of a receipt
+	OrderIDField        string // request field of OrderMethod set to the order ID
+	PaymentMethod       string // optional unary gRPC method resolving the payment of the order
+	PaymentOrderIDField string
+}
+
+type StaticConfig struct {
+	Enabled      bool
+	Path         string        // route prefix, e.g. "/static/"
+	Dir          string        // directory to serve; the embedded public directory when empty
+	MaxAge       time.Duration // Cache-Control max-age of files without a content hash in their name
+	GzipMinBytes int           // smallest file gzipped on the fly
+}
+
+type PaymentWebhookConfig struct {
+	Enabled             bool
+	Method              string // PaymentService RPC receiving the normalized notifications
+	MidtransServerKey   string // providers are enabled by setting their credentials
+	XenditCallbackToken string
+	StripeSigningSecret string
+	StripeTolerance     time.Duration // accepted age of Stripe signature timestamps
+	ReplayWindow        time.Duration // how long processed event IDs are remembered
+	MaxBodyBytes        int64
+}
+
+type OutboundWebhookConfig struct {
+	Enabled              bool
+	Events               map[string]string // gRPC method -> event type emitted when it succeeds, e.g. "order.created"
+	EndpointsPath        string            // merchant endpoint registration route
+	DeliveriesPath       string            // merchant delivery log route
+	MaxAttempts          int
+	InitialBackoff       time.Duration // doubled after each failed attempt
+	MaxBackoff           time.Duration
+	Timeout              time.Duration // per delivery attempt
+	Workers              int           // delivery attempts made at the same time, per replica
+	DeliveryLogSize      int           // attempts kept per merchant
+	StreamMaxLen         int           // approximate cap of queued events
+	AllowPrivateTargets  bool          // allow deliveries to private/loopback addresses (local development)
+	AllowInsecureTargets bool          // allow http:// endpoint URLs
+}
+
+type EventsConfig struct {
+	Enabled     bool
+	PollPath    string
+	DefaultWait time.Duration // how long polls wait for an event by default
+	MaxWait     time.Duration
+	BacklogSize int           // events kept per merchant for polls to catch up on
+	BacklogTTL  time.Duration // backlog of inactive merchants expires
+}
+
+type TimeoutConfig struct {
+	Default  time.Duration            // backend calls without a more specific timeout
+	Services map[string]time.Duration // by service, e.g. "report.v1.ReportService" -> 30s
+	Methods  map[string]time.Duration // by method, e.g. "/report.v1.ReportService/GetSalesReport" -> 60s
+	Max      time.Duration            // cap on timeouts requested by clients (grpc-timeout, X-Request-Timeout)
+}
+
+type RetryConfig struct {
+	Enabled           bool
+	MaxAttempts       int // including the first attempt
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	PerAttemptTimeout time.Duration // bounds each attempt so a hung backend is retried; 0 leaves only the call timeout
+	Methods           []string      // also retried, for idempotent methods without a GET rule or idempotency_level
+}
+
+type HedgingConfig struct {
+	Methods     []string      // hot read methods to hedge, e.g. "/product.v1.ProductService/GetProduct"; none by default
+	Delay       time.Duration // wait before firing the next attempt
+	MaxAttempts int           // including the first attempt
+}
+
+type CircuitBreakerConfig struct {
+	Enabled             bool
+	ConsecutiveFailures int           // trips after this many failures in a row
+	FailureRatio        float64       // or once this share of the calls in Window failed
+	MinRequests         int           // calls in Window before FailureRatio applies
+	Window              time.Duration // failure ratio window
+	OpenTimeout         time.Duration // how long calls fast-fail before probing the backend again
+	HalfOpenProbes      int           // concurrent probe calls while half-open
+}
+
+type DiscoveryConfig struct {
+	ConsulAddr          string // Consul HTTP API, enables consul:/// addresses
+	ConsulToken         string
+	EtcdAddr            string        // etcd v3 JSON gateway, enables etcd:/// addresses
+	KubernetesNamespace string        // default namespace of kubernetes:/// addresses
+	RefreshInterval     time.Duration // poll interval of etcd and Kubernetes, retry interval of Consul
+}
+
+type FailoverConfig struct {
+	Backends          map[string]string // backend address -> fallback address, e.g. in the DR region
+	CheckInterval     time.Duration
+	CheckTimeout      time.Duration
+	FailureThreshold  int // consecutive failed health checks of the primary before failing over
+	RecoveryThreshold int // consecutive passed health checks of the primary before switching back
+}
+
+type OutlierConfig struct {
+	Enabled            bool
+	Interval           time.Duration // error rates are evaluated per host over this interval
+	MinRequests        int           // calls in an interval before a host can be ejected
+	FailureRatio       float64       // share of failed calls that ejects a host
+	BaseEjectionTime   time.Duration // multiplied by the number of consecutive ejections
+	MaxEjectionPercent int           // of a backend's hosts ejected at once
+}
+
+type TenantConfig struct {
+	Enabled            bool
+	BaseDomains        []string          // e.g. "omnipos.app", so "acme.omnipos.app" is tenant "acme"
+	Subdomains         map[string]string // subdomain -> merchant ID; unmapped subdomains are merchant IDs
+	ReservedSubdomains []string          // subdomains that aren't tenants, e.g. "www", "api"
+	TrustForwardedHost bool              // use X-Forwarded-Host from a trusted proxy
+	RefreshInterval    time.Duration
+}
+
+type HealthConfig struct {
+	CheckTimeout  time.Duration // per-backend timeout of /healthz/backends checks
+	ShutdownDelay time.Duration // time between failing /readyz and draining connections on shutdown
+	WarmUp        bool          // connect to and health check every backend before passing /readyz at startup
+	WarmUpTimeout time.Duration // readiness passes after this long even if backends are still unreachable
+}
+
+type BodyLogConfig struct {
+	Enabled      bool     // log request and response bodies, for support investigations only
+	Paths        []string // path prefixes whose bodies are logged, all when empty
+	MaxBytes     int      // bodies over this size are logged by size only
+	RedactFields []string // JSON fields masked at any depth, in addition to the debug_redact proto fields
+}
+
+type AuditConfig struct {
+	Enabled   bool
+	Method    string            // audit service method receiving the records
+	Fields    map[string]string // record attribute (actor_id, merchant_id, action, resource, summary, result, time) -> request field
+	QueueSize int               // records waiting to be sent; further records are dropped
+	Workers   int
+	Timeout   time.Duration
+}
+
+type ErrorReportingConfig struct {
+	DSN         string // Sentry DSN; errors aren't reported when empty
+	Environment string
+	Release     string
+	SampleRate  float64 // share of errors reported, 0-1
+	QueueSize   int     // errors waiting to be sent; further errors are dropped
+	Timeout     time.Duration
+}
+
+// SecurityLogConfig configures the security event stream (auth failures, rate-limit hits, IP bans,
+// signature failures), written apart from the application logs so a SIEM can ingest it as is
+type SecurityLogConfig struct {
+	Enabled    bool
+	Output     string // "stdout", "stderr" or a file the events are appended to as JSON lines
+	KafkaREST  string // Kafka REST proxy URL; events are also produced to KafkaTopic when set
+	KafkaTopic string
+	QueueSize  int // events waiting to be produced; further events are dropped
+	BatchSize  int
+	Timeout    time.Duration
+}
+
+// AccessLogConfig exports an entry per request to a sink, apart from the application logs, for request retention
+type AccessLogConfig struct {
+	Enabled       bool
+	Sink          string // "file", "kafka" or "http"
+	FilePath      string
+	MaxSize       int64         // bytes after which the file is rotated
+	MaxAge        time.Duration // rotated files older than this are deleted, 0 keeps them
+	MaxBackups    int           // rotated files kept, 0 keeps them all
+	KafkaREST     string        // Kafka REST proxy URL
+	KafkaTopic    string
+	HTTPURL       string            // collector receiving batches as NDJSON
+	HTTPHeaders   map[string]string // e.g. the collector's API key
+	QueueSize     int               // entries waiting to be exported; further entries are dropped
+	BatchSize     int
+	FlushInterval time.Duration
+	Timeout       time.Duration
+}
+
+// ProbeConfig sends synthetic requests to read endpoints through the gateway's own listener
+type ProbeConfig struct {
+	Enabled   bool
+	BaseURL   string            // gateway URL the probes are sent to
+	Endpoints map[string]string // probe name -> path, e.g. "product_list" -> "/v1/products?page_size=1"
+	Headers   map[string]string // e.g. the Authorization of a synthetic merchant
+	Interval  time.Duration
+	Timeout   time.Duration
+}
+
+type SlowLogConfig struct {
+	Threshold time.Duration // requests taking longer are logged and counted, 0 disables
+}
+
+type ErrorConfig struct {
+	RetryAfter time.Duration // Retry-After of UNAVAILABLE errors without a delay of their own
+}
+
+// EnvelopeConfig selects responses written without the {status, message, data} envelope, for
+// integrations requiring an exact payload shape (payment provider callbacks, export formats)
+type EnvelopeConfig struct {
+	RawMethods []string // full gRPC method names, e.g. "/omnipos.payment.v1.PaymentService/MidtransCallback"
+	RawHeader  bool     // let clients ask with X-Raw-Response: true
+	Pretty     bool     // honor ?pretty=true with indented JSON, for curl debugging
+}
+
+type I18nConfig struct {
+	Enabled         bool
+	DefaultLanguage string // language of clients asking for none with a catalog
+	CatalogDir      string // "<language>.json" catalogs merged over the embedded ones
+}
+
+type MetricsConfig struct {
+	Enabled      bool
+	Path         string
+	ChannelzPort string // internal gRPC listener serving channelz (backend connection internals), e.g. ":9091"; off when empty
+	// MerchantLabels labels request and quota metrics by merchant, limited to MerchantAllowlist and the
+	// MerchantTopN busiest merchants of each MerchantWindow; the rest are "other"
+	MerchantLabels    bool
+	MerchantAllowlist []string
+	MerchantTopN      int
+	MerchantWindow    time.Duration
+	// Exemplars serves OpenMetrics, where latency histograms carry the trace ID (from traceparent) of a
+	// sample request per bucket
+	Exemplars bool
+	// OTLPEndpoint pushes the metrics every OTLPInterval to an OpenTelemetry collector over OTLP/HTTP
+	// (JSON), e.g. "http://otel-collector:4318/v1/metrics", with or without the scrape path; off when empty
+	OTLPEndpoint string
+	OTLPHeaders  map[string]string // e.g. an authorization header of the collector
+	OTLPInterval time.Duration
+	OTLPTimeout  time.Duration
+}
+
+// DeprecationConfig describes the deprecated methods (marked deprecated in the proto definitions, or
+// listed here) to their callers with Deprecation, Sunset and Link headers
+type DeprecationConfig struct {
+	Dates   map[string]string // deprecation date (YYYY-MM-DD) by gRPC method; also deprecates methods not marked in the protos
+	Sunsets map[string]string // removal date (YYYY-MM-DD) by gRPC method
+	Link    string            // migration guide, linked with rel="deprecation"
+}
+
+// TracingConfig sets the share of requests traced. The gateway starts the trace of requests without a
+// traceparent header and passes it on to the backends; requests with one keep the caller's decision.
+type TracingConfig struct {
+	Enabled          bool
+	SampleRate       float64            // share of requests traced, 0-1
+	RouteSampleRates map[string]float64 // by gRPC method, e.g. "/payment.v1.PaymentService/ConfirmPayment" -> 1
 }
 
 func Load() (Config, error) {
@@ -61,16 +655,36 @@ func Load() (Config, error) {
 			PrivateKey: getEnvRequired("PRIVATE_KEY"),
 		},
 		HTTP: HTTPConfig{
-			Port: getEnv("HTTP_PORT", ":8081"),
+			Port:           getEnv("HTTP_PORT", ":8081"),
+			H2C:            getBoolEnv("HTTP_H2C_ENABLED", false),
+			HTTP3:          getBoolEnv("HTTP3_ENABLED", false),
+			HTTP3Port:      getEnv("HTTP3_PORT", ":8443"),
+			TLSCertFile:    getEnv("HTTP_TLS_CERT_FILE", ""),
+			TLSKeyFile:     getEnv("HTTP_TLS_KEY_FILE", ""),
+			ServerTiming:   getBoolEnv("HTTP_SERVER_TIMING_ENABLED", false),
+			MethodOverride: getBoolEnv("HTTP_METHOD_OVERRIDE_ENABLED", true),
+			TrustedProxies: getEnvList("TRUSTED_PROXIES", nil),
+		},
+		Compression: CompressionConfig{
+			Enabled:   getBoolEnv("COMPRESSION_ENABLED", false),
+			Encodings: getEnvList("COMPRESSION_ENCODINGS", []string{"gzip", "deflate"}),
+			Level:     getEnvInt("COMPRESSION_LEVEL", 5),
+			MinBytes:  getEnvInt("COMPRESSION_MIN_BYTES", 1024),
+			ContentTypes: getEnvList("COMPRESSION_CONTENT_TYPES", []string{
+				"application/json", "application/xml", "application/msgpack", "text/",
+			}),
 		},
 		GRPCServices: GRPCServicesConfig{
-			MerchantServiceAddr: getEnv("MERCHANT_GRPC_ADDR", "localhost:8080"),
-			ProductServiceAddr:  getEnv("PRODUCT_GRPC_ADDR", "localhost:8082"),
-			OrderServiceAddr:    getEnv("ORDER_GRPC_ADDR", "localhost:8083"),
-			CustomerServiceAddr: getEnv("CUSTOMER_GRPC_ADDR", "localhost:8084"),
-			PaymentServiceAddr:  getEnv("PAYMENT_GRPC_ADDR", "localhost:50054"),
-			StoreServiceAddr:    getEnv("STORE_GRPC_ADDR", "localhost:50055"),
-			AuditServiceAddr:    getEnv("AUDIT_GRPC_ADDR", "localhost:8086"),
+			MerchantServiceAddr:    getEnv("MERCHANT_GRPC_ADDR", "localhost:8080"),
+			ProductServiceAddr:     getEnv("PRODUCT_GRPC_ADDR", "localhost:8082"),
+			OrderServiceAddr:       getEnv("ORDER_GRPC_ADDR", "localhost:8083"),
+			CustomerServiceAddr:    getEnv("CUSTOMER_GRPC_ADDR", "localhost:8084"),
+			PaymentServiceAddr:     getEnv("PAYMENT_GRPC_ADDR", "localhost:50054"),
+			StoreServiceAddr:       getEnv("STORE_GRPC_ADDR", "localhost:50055"),
+			AuditServiceAddr:       getEnv("AUDIT_GRPC_ADDR", "localhost:8086"),
+			VersionRoutes:          getEnvMap("GRPC_VERSION_ROUTES", nil),
+			Subchannels:            getEnvInt("GRPC_SUBCHANNELS", 1),
+			RerouteRefreshInterval: getEnvDuration("GRPC_REROUTE_REFRESH_INTERVAL", 10*time.Second),
 		},
 		Logger: LoggerConfig{
 			Level:             getEnv("LOG_LEVEL", "info"),
@@ -87,12 +701,484 @@ func Load() (Config, error) {
 			DB:       getEnvInt("REDIS_DB", 0),
 		},
 		RateLimit: RateLimitConfig{
-			Enabled:     getBoolEnv("RATE_LIMIT_ENABLED", true),
-			PublicRPS:   getEnvInt("RATE_LIMIT_PUBLIC_RPS", 10),
-			PublicBurst: getEnvInt("RATE_LIMIT_PUBLIC_BURST", 20),
-			AuthRPS:     getEnvInt("RATE_LIMIT_AUTH_RPS", 100),
-			AuthBurst:   getEnvInt("RATE_LIMIT_AUTH_BURST", 200),
+			Enabled:         getBoolEnv("RATE_LIMIT_ENABLED", true),
+			PublicRPS:       getEnvInt("RATE_LIMIT_PUBLIC_RPS", 10),
+			PublicBurst:     getEnvInt("RATE_LIMIT_PUBLIC_BURST", 20),
+			AuthRPS:         getEnvInt("RATE_LIMIT_AUTH_RPS", 100),
+			AuthBurst:       getEnvInt("RATE_LIMIT_AUTH_BURST", 200),
+			Algorithm:       getEnv("RATE_LIMIT_ALGORITHM", "gcra"),
+			Period:          getEnvDuration("RATE_LIMIT_PERIOD", time.Second),
+			FailurePolicy:   getEnv("RATE_LIMIT_FAILURE_POLICY", "open"),
+			FallbackPercent: getEnvInt("RATE_LIMIT_FALLBACK_PERCENT", 50),
+			RouteCosts:      getEnvIntMap("RATE_LIMIT_ROUTE_COSTS", nil),
+
+			SplitReadWrite:  getBoolEnv("RATE_LIMIT_SPLIT_READ_WRITE", false),
+			ReadPublicRPS:   getEnvInt("RATE_LIMIT_READ_PUBLIC_RPS", 20),
+			ReadPublicBurst: getEnvInt("RATE_LIMIT_READ_PUBLIC_BURST", 40),
+			ReadAuthRPS:     getEnvInt("RATE_LIMIT_READ_AUTH_RPS", 200),
+			ReadAuthBurst:   getEnvInt("RATE_LIMIT_READ_AUTH_BURST", 400),
+
+			OverrideRefreshInterval: getEnvDuration("RATE_LIMIT_OVERRIDE_REFRESH_INTERVAL", 5*time.Second),
+
+			PerDevice: getBoolEnv("RATE_LIMIT_PER_DEVICE", false),
+
+			ShapingEnabled: getBoolEnv("RATE_LIMIT_SHAPING_ENABLED", false),
+			ShapingMaxWait: getEnvDuration("RATE_LIMIT_SHAPING_MAX_WAIT", 500*time.Millisecond),
+
+			PenaltyEnabled:   getBoolEnv("RATE_LIMIT_PENALTY_ENABLED", false),
+			PenaltyThreshold: getEnvInt("RATE_LIMIT_PENALTY_THRESHOLD", 20),
+			PenaltyWindow:    getEnvDuration("RATE_LIMIT_PENALTY_WINDOW", time.Minute),
+			PenaltyDuration:  getEnvDuration("RATE_LIMIT_PENALTY_DURATION", 15*time.Minute),
+
+			ExemptPaths:  getEnvList("RATE_LIMIT_EXEMPT_PATHS", []string{"/healthz", "/readyz", "/metrics"}),
+			ExemptCIDRs:  getEnvList("RATE_LIMIT_EXEMPT_CIDRS", nil),
+			ExemptTokens: getEnvList("RATE_LIMIT_EXEMPT_TOKENS", nil),
+		},
+		Session: SessionConfig{
+			CookieEnabled:  getBoolEnv("SESSION_COOKIE_ENABLED", false),
+			CookieName:     getEnv("SESSION_COOKIE_NAME", "omnipos_session"),
+			CookieDomain:   getEnv("SESSION_COOKIE_DOMAIN", ""),
+			CookiePath:     getEnv("SESSION_COOKIE_PATH", "/"),
+			CookieSecure:   getBoolEnv("SESSION_COOKIE_SECURE", true),
+			CookieSameSite: getEnv("SESSION_COOKIE_SAMESITE", "strict"),
+			CookieMaxAge:   getEnvDuration("SESSION_COOKIE_MAX_AGE", 24*time.Hour),
+			LoginMethods:   getEnvList("SESSION_LOGIN_METHODS", []string{"/user.v1.MerchantService/LoginMerchant"}),
+			TokenField:     getEnv("SESSION_TOKEN_FIELD", "access_token"),
+			LogoutPath:     getEnv("SESSION_LOGOUT_PATH", "/v1/session/logout"),
+		},
+		CSRF: CSRFConfig{
+			Enabled:     getBoolEnv("CSRF_ENABLED", true),
+			CookieName:  getEnv("CSRF_COOKIE_NAME", "omnipos_csrf"),
+			HeaderName:  getEnv("CSRF_HEADER_NAME", "X-CSRF-Token"),
+			TokenPath:   getEnv("CSRF_TOKEN_PATH", "/v1/session/csrf"),
+			ExemptPaths: getEnvList("CSRF_EXEMPT_PATHS", nil),
+		},
+		Quota: QuotaConfig{
+			Enabled:     getBoolEnv("QUOTA_ENABLED", false),
+			DefaultPlan: getEnv("QUOTA_DEFAULT_PLAN", "free"),
+			Plans:       getEnvIntMap("QUOTA_PLANS", map[string]int{"free": 10000, "pro": 100000, "enterprise": 0}),
+			UsagePath:   getEnv("QUOTA_USAGE_PATH", "/v1/quota/usage"),
+		},
+		Concurrency: ConcurrencyConfig{
+			Enabled:              getBoolEnv("CONCURRENCY_LIMIT_ENABLED", true),
+			MaxInFlight:          getEnvInt("CONCURRENCY_MAX_IN_FLIGHT", 1000),
+			MaxInFlightPerTenant: getEnvInt("CONCURRENCY_MAX_IN_FLIGHT_PER_TENANT", 50),
+			ExemptPaths:          getEnvList("CONCURRENCY_EXEMPT_PATHS", nil),
+		},
+		Admin: AdminConfig{
+			Token: getEnv("ADMIN_TOKEN", ""),
+			Debug: getBoolEnv("ADMIN_DEBUG_ENABLED", false),
+			Port:  getEnv("ADMIN_PORT", ""),
+		},
+		IPFilter: IPFilterConfig{
+			Enabled:         getBoolEnv("IP_FILTER_ENABLED", false),
+			Allowlist:       getEnvList("IP_FILTER_ALLOWLIST", nil),
+			Denylist:        getEnvList("IP_FILTER_DENYLIST", nil),
+			DynamicDeny:     getBoolEnv("IP_FILTER_DYNAMIC_DENY", true),
+			RefreshInterval: getEnvDuration("IP_FILTER_REFRESH_INTERVAL", 5*time.Second),
+		},
+		BodyLimit: BodyLimitConfig{
+			Enabled:        getBoolEnv("BODY_LIMIT_ENABLED", true),
+			MaxBytes:       int64(getEnvInt("BODY_LIMIT_MAX_BYTES", 1<<20)),
+			UploadMaxBytes: int64(getEnvInt("BODY_LIMIT_UPLOAD_MAX_BYTES", 10<<20)),
+			UploadPaths:    getEnvList("BODY_LIMIT_UPLOAD_PATHS", nil),
+			UploadMethods:  getEnvList("BODY_LIMIT_UPLOAD_METHODS", nil),
+		},
+		GlobalLimit: GlobalRateLimitConfig{
+			Enabled:    getBoolEnv("GLOBAL_RATE_LIMIT_ENABLED", false),
+			RPS:        getEnvInt("GLOBAL_RATE_LIMIT_RPS", 0),
+			Burst:      getEnvInt("GLOBAL_RATE_LIMIT_BURST", 0),
+			ServiceRPS: getEnvIntMap("GLOBAL_RATE_LIMIT_SERVICE_RPS", nil),
+		},
+		WebhookLimit: WebhookRateLimitConfig{
+			Enabled:         getBoolEnv("WEBHOOK_RATE_LIMIT_ENABLED", true),
+			PathPrefix:      getEnv("WEBHOOK_RATE_LIMIT_PATH_PREFIX", "/webhooks/"),
+			RPS:             getEnvInt("WEBHOOK_RATE_LIMIT_RPS", 50),
+			Capacity:        getEnvInt("WEBHOOK_RATE_LIMIT_CAPACITY", 200),
+			IdentityHeaders: getEnvList("WEBHOOK_RATE_LIMIT_IDENTITY_HEADERS", []string{"X-Callback-Token", "Stripe-Signature", "X-Signature"}),
+		},
+		GRPCWeb: GRPCWebConfig{
+			Enabled:         getBoolEnv("GRPC_WEB_ENABLED", false),
+			MaxMessageBytes: getEnvInt("GRPC_WEB_MAX_MESSAGE_BYTES", 4<<20),
+		},
+		GRPCProxy: GRPCProxyConfig{
+			Enabled:    getBoolEnv("GRPC_PROXY_ENABLED", false),
+			Port:       getEnv("GRPC_PROXY_PORT", ":9090"),
+			Reflection: getBoolEnv("GRPC_PROXY_REFLECTION_ENABLED", true),
+		},
+		Upload: UploadConfig{
+			Enabled:      getBoolEnv("UPLOAD_ENABLED", false),
+			Methods:      getEnvList("UPLOAD_METHODS", nil),
+			AllowedTypes: getEnvList("UPLOAD_ALLOWED_TYPES", []string{"image/jpeg", "image/png", "image/webp", "text/csv"}),
+			MaxFileBytes: int64(getEnvInt("UPLOAD_MAX_FILE_BYTES", 10<<20)),
+			ChunkSize:    getEnvInt("UPLOAD_CHUNK_SIZE", 64<<10),
+		},
+		Canary: CanaryConfig{
+			Enabled:     getBoolEnv("CANARY_ENABLED", false),
+			Backends:    getEnvMap("CANARY_BACKENDS", nil),
+			Percent:     getEnvIntMap("CANARY_PERCENT", nil),
+			Header:      getEnv("CANARY_HEADER", "X-Canary"),
+			HeaderValue: getEnv("CANARY_HEADER_VALUE", "always"),
+		},
+		Shadow: ShadowConfig{
+			Enabled:     getBoolEnv("SHADOW_ENABLED", false),
+			Backends:    getEnvMap("SHADOW_BACKENDS", nil),
+			Percent:     getEnvIntMap("SHADOW_PERCENT", nil),
+			Timeout:     getEnvDuration("SHADOW_TIMEOUT", 5*time.Second),
+			MaxInFlight: getEnvInt("SHADOW_MAX_IN_FLIGHT", 100),
+			Metadata:    getEnvMap("SHADOW_METADATA", nil),
+		},
+		Rewrite: RewriteConfig{
+			StripPrefixes: getEnvList("REWRITE_STRIP_PREFIXES", nil),
+			Rules:         getEnvList("REWRITE_RULES", nil),
+			Aliases:       getEnvMap("REWRITE_ALIASES", nil),
+		},
+		Upstream: UpstreamConfig{
+			Routes:             getEnvMap("UPSTREAM_ROUTES", nil),
+			PublicPrefixes:     getEnvList("UPSTREAM_PUBLIC_PREFIXES", nil),
+			StripPrefix:        getBoolEnv("UPSTREAM_STRIP_PREFIX", true),
+			Timeout:            getEnvDuration("UPSTREAM_TIMEOUT", 30*time.Second),
+			ForwardCredentials: getEnvList("UPSTREAM_FORWARD_CREDENTIALS", nil),
+		},
+		Maintenance: MaintenanceConfig{
+			Enabled:         getBoolEnv("MAINTENANCE_ENABLED", false),
+			Services:        getEnvList("MAINTENANCE_SERVICES", nil),
+			Message:         getEnv("MAINTENANCE_MESSAGE", "the service is under maintenance, please try again later"),
+			RetryAfter:      getEnvDuration("MAINTENANCE_RETRY_AFTER", 5*time.Minute),
+			ExemptPaths:     getEnvList("MAINTENANCE_EXEMPT_PATHS", []string{"/healthz", "/readyz", "/metrics", "/swagger-ui", "/openapi", "/admin"}),
+			RefreshInterval: getEnvDuration("MAINTENANCE_REFRESH_INTERVAL", 10*time.Second),
+		},
+		KillSwitch: KillSwitchConfig{
+			DisabledMethods: getEnvList("KILL_SWITCH_DISABLED_METHODS", nil),
+			GoneMethods:     getEnvList("KILL_SWITCH_GONE_METHODS", nil),
+			RefreshInterval: getEnvDuration("KILL_SWITCH_REFRESH_INTERVAL", 5*time.Second),
+		},
+		Receipt: ReceiptConfig{
+			Enabled:             getBoolEnv("RECEIPT_LINKS_ENABLED", false),
+			SigningKey:          getEnv("RECEIPT_SIGNING_KEY", ""),
+			IssuePath:           getEnv("RECEIPT_ISSUE_PATH", "/v1/receipt-links"),
+			BaseURL:             getEnv("RECEIPT_BASE_URL", ""),
+			PageURL:             getEnv("RECEIPT_PAGE_URL", ""),
+			TTL:                 getEnvDuration("RECEIPT_LINK_TTL", 72*time.Hour),
+			MaxTTL:              getEnvDuration("RECEIPT_LINK_MAX_TTL", 30*24*time.Hour),
+			OrderMethod:         getEnv("RECEIPT_ORDER_METHOD", "/order.v1.OrderService/GetOrder"),
+			OrderIDField:        getEnv("RECEIPT_ORDER_ID_FIELD", "id"),
+			PaymentMethod:       getEnv("RECEIPT_PAYMENT_METHOD", ""),
+			PaymentOrderIDField: getEnv("RECEIPT_PAYMENT_ORDER_ID_FIELD", "order_id"),
+		},
+		Static: StaticConfig{
+			Enabled:      getBoolEnv("STATIC_ENABLED", false),
+			Path:         getEnv("STATIC_PATH", "/static/"),
+			Dir:          getEnv("STATIC_DIR", ""),
+			MaxAge:       getEnvDuration("STATIC_MAX_AGE", time.Hour),
+			GzipMinBytes: getEnvInt("STATIC_GZIP_MIN_BYTES", 1024),
+		},
+		Webhooks: PaymentWebhookConfig{
+			Enabled:             getBoolEnv("PAYMENT_WEBHOOKS_ENABLED", false),
+			Method:              getEnv("PAYMENT_WEBHOOK_METHOD", "/payment.v1.PaymentService/HandleProviderWebhook"),
+			MidtransServerKey:   getEnv("MIDTRANS_SERVER_KEY", ""),
+			XenditCallbackToken: getEnv("XENDIT_CALLBACK_TOKEN", ""),
+			StripeSigningSecret: getEnv("STRIPE_WEBHOOK_SECRET", ""),
+			StripeTolerance:     getEnvDuration("STRIPE_WEBHOOK_TOLERANCE", 5*time.Minute),
+			ReplayWindow:        getEnvDuration("PAYMENT_WEBHOOK_REPLAY_WINDOW", 72*time.Hour),
+			MaxBodyBytes:        int64(getEnvInt("PAYMENT_WEBHOOK_MAX_BODY_BYTES", 1<<20)),
+		},
+		Outbound: OutboundWebhookConfig{
+			Enabled: getBoolEnv("OUTBOUND_WEBHOOKS_ENABLED", false),
+			Events: getEnvMap("OUTBOUND_WEBHOOK_EVENTS", map[string]string{
+				"/order.v1.OrderService/CreateOrder":       "order.created",
+				"/order.v1.OrderService/UpdateOrder":       "order.updated",
+				"/order.v1.OrderService/CancelOrder":       "order.cancelled",
+				"/payment.v1.PaymentService/CreatePayment": "payment.created",
+				"/payment.v1.PaymentService/RefundPayment": "payment.refunded",
+			}),
+			EndpointsPath:        getEnv("OUTBOUND_WEBHOOK_ENDPOINTS_PATH", "/v1/webhook-endpoints"),
+			DeliveriesPath:       getEnv("OUTBOUND_WEBHOOK_DELIVERIES_PATH", "/v1/webhook-deliveries"),
+			MaxAttempts:          getEnvInt("OUTBOUND_WEBHOOK_MAX_ATTEMPTS", 8),
+			InitialBackoff:       getEnvDuration("OUTBOUND_WEBHOOK_INITIAL_BACKOFF", 10*time.Second),
+			MaxBackoff:           getEnvDuration("OUTBOUND_WEBHOOK_MAX_BACKOFF", time.Hour),
+			Timeout:              getEnvDuration("OUTBOUND_WEBHOOK_TIMEOUT", 10*time.Second),
+			Workers:              getEnvInt("OUTBOUND_WEBHOOK_WORKERS", 16),
+			DeliveryLogSize:      getEnvInt("OUTBOUND_WEBHOOK_DELIVERY_LOG_SIZE", 500),
+			StreamMaxLen:         getEnvInt("OUTBOUND_WEBHOOK_STREAM_MAX_LEN", 100000),
+			AllowPrivateTargets:  getBoolEnv("OUTBOUND_WEBHOOK_ALLOW_PRIVATE_TARGETS", false),
+			AllowInsecureTargets: getBoolEnv("OUTBOUND_WEBHOOK_ALLOW_INSECURE_TARGETS", false),
+		},
+		Events: EventsConfig{
+			Enabled:     getBoolEnv("EVENTS_POLL_ENABLED", false),
+			PollPath:    getEnv("EVENTS_POLL_PATH", "/v1/events/poll"),
+			DefaultWait: getEnvDuration("EVENTS_POLL_DEFAULT_WAIT", 25*time.Second),
+			MaxWait:     getEnvDuration("EVENTS_POLL_MAX_WAIT", 55*time.Second),
+			BacklogSize: getEnvInt("EVENTS_BACKLOG_SIZE", 100),
+			BacklogTTL:  getEnvDuration("EVENTS_BACKLOG_TTL", time.Hour),
+		},
+		Timeouts: TimeoutConfig{
+			Default:  getEnvDuration("GRPC_TIMEOUT_DEFAULT", 10*time.Second),
+			Services: getEnvDurationMap("GRPC_TIMEOUT_SERVICES", nil),
+			Methods:  getEnvDurationMap("GRPC_TIMEOUT_METHODS", nil),
+			Max:      getEnvDuration("GRPC_TIMEOUT_MAX", 14*time.Second),
+		},
+		Retry: RetryConfig{
+			Enabled:           getBoolEnv("GRPC_RETRY_ENABLED", true),
+			MaxAttempts:       getEnvInt("GRPC_RETRY_MAX_ATTEMPTS", 3),
+			InitialBackoff:    getEnvDuration("GRPC_RETRY_INITIAL_BACKOFF", 50*time.Millisecond),
+			MaxBackoff:        getEnvDuration("GRPC_RETRY_MAX_BACKOFF", time.Second),
+			PerAttemptTimeout: getEnvDuration("GRPC_RETRY_PER_ATTEMPT_TIMEOUT", 0),
+			Methods:           getEnvList("GRPC_RETRY_METHODS", nil),
+		},
+		Hedging: HedgingConfig{
+			Methods:     getEnvList("GRPC_HEDGING_METHODS", nil),
+			Delay:       getEnvDuration("GRPC_HEDGING_DELAY", 50*time.Millisecond),
+			MaxAttempts: getEnvInt("GRPC_HEDGING_MAX_ATTEMPTS", 2),
+		},
+		Breaker: CircuitBreakerConfig{
+			Enabled:             getBoolEnv("CIRCUIT_BREAKER_ENABLED", true),
+			ConsecutiveFailures: getEnvInt("CIRCUIT_BREAKER_CONSECUTIVE_FAILURES", 5),
+			FailureRatio:        getEnvFloat("CIRCUIT_BREAKER_FAILURE_RATIO", 0.5),
+			MinRequests:         getEnvInt("CIRCUIT_BREAKER_MIN_REQUESTS", 20),
+			Window:              getEnvDuration("CIRCUIT_BREAKER_WINDOW", 10*time.Second),
+			OpenTimeout:         getEnvDuration("CIRCUIT_BREAKER_OPEN_TIMEOUT", 10*time.Second),
+			HalfOpenProbes:      getEnvInt("CIRCUIT_BREAKER_HALF_OPEN_PROBES", 1),
+		},
+		Failover: FailoverConfig{
+			Backends:          getEnvMap("GRPC_FAILOVER_BACKENDS", nil),
+			CheckInterval:     getEnvDuration("GRPC_FAILOVER_CHECK_INTERVAL", 5*time.Second),
+			CheckTimeout:      getEnvDuration("GRPC_FAILOVER_CHECK_TIMEOUT", 2*time.Second),
+			FailureThreshold:  getEnvInt("GRPC_FAILOVER_FAILURE_THRESHOLD", 3),
+			RecoveryThreshold: getEnvInt("GRPC_FAILOVER_RECOVERY_THRESHOLD", 3),
+		},
+		Outlier: OutlierConfig{
+			Enabled:            getBoolEnv("OUTLIER_DETECTION_ENABLED", true),
+			Interval:           getEnvDuration("OUTLIER_DETECTION_INTERVAL", 10*time.Second),
+			MinRequests:        getEnvInt("OUTLIER_DETECTION_MIN_REQUESTS", 20),
+			FailureRatio:       getEnvFloat("OUTLIER_DETECTION_FAILURE_RATIO", 0.5),
+			BaseEjectionTime:   getEnvDuration("OUTLIER_DETECTION_BASE_EJECTION_TIME", 30*time.Second),
+			MaxEjectionPercent: getEnvInt("OUTLIER_DETECTION_MAX_EJECTION_PERCENT", 50),
+		},
+		Discovery: DiscoveryConfig{
+			ConsulAddr:          getEnv("DISCOVERY_CONSUL_ADDR", ""),
+			ConsulToken:         getEnv("DISCOVERY_CONSUL_TOKEN", ""),
+			EtcdAddr:            getEnv("DISCOVERY_ETCD_ADDR", ""),
+			KubernetesNamespace: getEnv("DISCOVERY_KUBERNETES_NAMESPACE", "default"),
+			RefreshInterval:     getEnvDuration("DISCOVERY_REFRESH_INTERVAL", 10*time.Second),
+		},
+		Tenant: TenantConfig{
+			Enabled:            getBoolEnv("TENANT_ENABLED", false),
+			BaseDomains:        getEnvList("TENANT_BASE_DOMAINS", []string{"omnipos.app"}),
+			Subdomains:         getEnvMap("TENANT_SUBDOMAINS", nil),
+			ReservedSubdomains: getEnvList("TENANT_RESERVED_SUBDOMAINS", []string{"www", "api", "app", "admin"}),
+			TrustForwardedHost: getBoolEnv("TENANT_TRUST_FORWARDED_HOST", false),
+			RefreshInterval:    getEnvDuration("TENANT_REFRESH_INTERVAL", 30*time.Second),
+		},
+		Health: HealthConfig{
+			CheckTimeout:  getEnvDuration("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+			ShutdownDelay: getEnvDuration("HEALTH_SHUTDOWN_DELAY", 0),
+			WarmUp:        getBoolEnv("HEALTH_WARM_UP", false),
+			WarmUpTimeout: getEnvDuration("HEALTH_WARM_UP_TIMEOUT", 30*time.Second),
+		},
+		GraphQL: GraphQLConfig{
+			Enabled:     getBoolEnv("GRAPHQL_ENABLED", false),
+			Path:        getEnv("GRAPHQL_PATH", "/graphql"),
+			MaxFields:   getEnvInt("GRAPHQL_MAX_FIELDS", 20),
+			MaxDepth:    getEnvInt("GRAPHQL_MAX_DEPTH", 10),
+			Concurrency: getEnvInt("GRAPHQL_CONCURRENCY", 4),
+			Services: getEnvList("GRAPHQL_SERVICES", []string{
+				"product.v1.ProductService",
+				"product.v1.CategoryService",
+				"product.v1.InventoryService",
+				"order.v1.OrderService",
+				"customer.v1.CustomerService",
+			}),
+		},
+		BodyLog: BodyLogConfig{
+			Enabled:  getBoolEnv("BODY_LOG_ENABLED", false),
+			Paths:    getEnvList("BODY_LOG_PATHS", nil),
+			MaxBytes: getEnvInt("BODY_LOG_MAX_BYTES", 4096),
+			RedactFields: getEnvList("BODY_LOG_REDACT_FIELDS", []string{
+				"password", "new_password", "old_password", "pin",
+				"card_number", "cvv", "cvc", "expiry",
+				"token", "access_token", "refresh_token", "api_key", "secret", "authorization",
+			}),
+		},
+		Audit: AuditConfig{
+			Enabled: getBoolEnv("AUDIT_FORWARD_ENABLED", false),
+			Method:  getEnv("AUDIT_FORWARD_METHOD", "/audit.v1.AuditService/CreateAuditLog"),
+			Fields: getEnvMap("AUDIT_FORWARD_FIELDS", map[string]string{
+				"actor_id":    "actor_id",
+				"merchant_id": "merchant_id",
+				"action":      "action",
+				"resource":    "resource",
+				"summary":     "summary",
+				"result":      "result",
+			}),
+			QueueSize: getEnvInt("AUDIT_FORWARD_QUEUE_SIZE", 1000),
+			Workers:   getEnvInt("AUDIT_FORWARD_WORKERS", 2),
+			Timeout:   getEnvDuration("AUDIT_FORWARD_TIMEOUT", 5*time.Second),
+		},
+		Reporting: ErrorReportingConfig{
+			DSN:         getEnv("SENTRY_DSN", ""),
+			Environment: getEnv("SENTRY_ENVIRONMENT", getEnv("APP_ENV", "dev")),
+			Release:     getEnv("SENTRY_RELEASE", ""),
+			SampleRate:  getEnvFloat("SENTRY_SAMPLE_RATE", 1),
+			QueueSize:   getEnvInt("SENTRY_QUEUE_SIZE", 100),
+			Timeout:     getEnvDuration("SENTRY_TIMEOUT", 5*time.Second),
+		},
+		SecurityLog: SecurityLogConfig{
+			Enabled:    getBoolEnv("SECURITY_LOG_ENABLED", false),
+			Output:     getEnv("SECURITY_LOG_OUTPUT", "stdout"),
+			KafkaREST:  getEnv("SECURITY_LOG_KAFKA_REST_URL", ""),
+			KafkaTopic: getEnv("SECURITY_LOG_KAFKA_TOPIC", "omnipos.gateway.security"),
+			QueueSize:  getEnvInt("SECURITY_LOG_QUEUE_SIZE", 1000),
+			BatchSize:  getEnvInt("SECURITY_LOG_BATCH_SIZE", 100),
+			Timeout:    getEnvDuration("SECURITY_LOG_TIMEOUT", 5*time.Second),
+		},
+		AccessLog: AccessLogConfig{
+			Enabled:       getBoolEnv("ACCESS_LOG_ENABLED", false),
+			Sink:          getEnv("ACCESS_LOG_SINK", "file"),
+			FilePath:      getEnv("ACCESS_LOG_FILE", "/var/log/omnipos-gateway/access.log"),
+			MaxSize:       int64(getEnvInt("ACCESS_LOG_MAX_SIZE_MB", 100)) << 20,
+			MaxAge:        getEnvDuration("ACCESS_LOG_MAX_AGE", 30*24*time.Hour),
+			MaxBackups:    getEnvInt("ACCESS_LOG_MAX_BACKUPS", 30),
+			KafkaREST:     getEnv("ACCESS_LOG_KAFKA_REST_URL", ""),
+			KafkaTopic:    getEnv("ACCESS_LOG_KAFKA_TOPIC", "omnipos.gateway.access"),
+			HTTPURL:       getEnv("ACCESS_LOG_HTTP_URL", ""),
+			HTTPHeaders:   getEnvMap("ACCESS_LOG_HTTP_HEADERS", nil),
+			QueueSize:     getEnvInt("ACCESS_LOG_QUEUE_SIZE", 10000),
+			BatchSize:     getEnvInt("ACCESS_LOG_BATCH_SIZE", 500),
+			FlushInterval: getEnvDuration("ACCESS_LOG_FLUSH_INTERVAL", time.Second),
+			Timeout:       getEnvDuration("ACCESS_LOG_TIMEOUT", 5*time.Second),
+		},
+		Usage: UsageConfig{
+			Enabled:        getBoolEnv("USAGE_ENABLED", false),
+			Path:           getEnv("USAGE_PATH", "/v1/usage"),
+			FlushInterval:  getEnvDuration("USAGE_FLUSH_INTERVAL", 10*time.Second),
+			Retention:      getEnvDuration("USAGE_RETENTION", 90*24*time.Hour),
+			Export:         getEnv("USAGE_EXPORT", ""),
+			ExportFile:     getEnv("USAGE_EXPORT_FILE", "/var/lib/omnipos-gateway/usage.jsonl"),
+			ExportInterval: getEnvDuration("USAGE_EXPORT_INTERVAL", time.Hour),
+		},
+		Probe: ProbeConfig{
+			Enabled:   getBoolEnv("PROBE_ENABLED", false),
+			Endpoints: getEnvMap("PROBE_ENDPOINTS", nil),
+			Headers:   getEnvMap("PROBE_HEADERS", nil),
+			Interval:  getEnvDuration("PROBE_INTERVAL", 30*time.Second),
+			Timeout:   getEnvDuration("PROBE_TIMEOUT", 5*time.Second),
+		},
+		SlowLog: SlowLogConfig{
+			Threshold: getEnvDuration("SLOW_REQUEST_THRESHOLD", 2*time.Second),
+		},
+		Errors: ErrorConfig{
+			RetryAfter: getEnvDuration("ERROR_RETRY_AFTER", 5*time.Second),
+		},
+		Envelope: EnvelopeConfig{
+			RawMethods: getEnvList("ENVELOPE_RAW_METHODS", nil),
+			RawHeader:  getBoolEnv("ENVELOPE_RAW_HEADER_ENABLED", true),
+			Pretty:     getBoolEnv("ENVELOPE_PRETTY_ENABLED", getEnv("APP_ENV", "dev") == "dev"),
+		},
+		I18n: I18nConfig{
+			Enabled:         getBoolEnv("I18N_ENABLED", true),
+			DefaultLanguage: getEnv("I18N_DEFAULT_LANGUAGE", "en"),
+			CatalogDir:      getEnv("I18N_CATALOG_DIR", ""),
+		},
+		Metrics: MetricsConfig{
+			Enabled:           getBoolEnv("METRICS_ENABLED", true),
+			Path:              getEnv("METRICS_PATH", "/metrics"),
+			ChannelzPort:      getEnv("CHANNELZ_PORT", ""),
+			MerchantLabels:    getBoolEnv("METRICS_MERCHANT_LABELS", false),
+			MerchantAllowlist: getEnvList("METRICS_MERCHANT_ALLOWLIST", nil),
+			MerchantTopN:      getEnvInt("METRICS_MERCHANT_TOP_N", 20),
+			MerchantWindow:    getEnvDuration("METRICS_MERCHANT_WINDOW", 10*time.Minute),
+			Exemplars:         getBoolEnv("METRICS_EXEMPLARS", true),
+			OTLPEndpoint:      getEnv("METRICS_OTLP_ENDPOINT", ""),
+			OTLPHeaders:       getEnvMap("METRICS_OTLP_HEADERS", nil),
+			OTLPInterval:      getEnvDuration("METRICS_OTLP_INTERVAL", 30*time.Second),
+			OTLPTimeout:       getEnvDuration("METRICS_OTLP_TIMEOUT", 10*time.Second),
+		},
+		Deprecation: DeprecationConfig{
+			Dates:   getEnvMap("DEPRECATION_DATES", nil),
+			Sunsets: getEnvMap("DEPRECATION_SUNSETS", nil),
+			Link:    getEnv("DEPRECATION_LINK", ""),
+		},
+		Tracing: TracingConfig{
+			Enabled:          getBoolEnv("TRACING_ENABLED", false),
+			SampleRate:       getEnvFloat("TRACING_SAMPLE_RATE", 0.1),
+			RouteSampleRates: getEnvFloatMap("TRACING_ROUTE_SAMPLE_RATES", nil),
 		},
 	}
+
+	// Probes go through the gateway's own listener unless pointed elsewhere (e.g. the public load balancer)
+	cfg.Probe.BaseURL = getEnv("PROBE_BASE_URL", "http://localhost"+cfg.HTTP.Port)
+
+	// Backend TLS, keepalive, message sizes, compression and interceptors per service (e.g. ORDER_GRPC_TLS_CA_FILE,
+	// PAYMENT_GRPC_KEEPALIVE_TIME, PRODUCT_GRPC_MAX_RECV_MSG_SIZE, PRODUCT_GRPC_GZIP_ENABLED,
+	// PAYMENT_GRPC_INTERCEPTORS), falling back to GRPC_TLS_*, GRPC_KEEPALIVE_*, GRPC_MAX_*_MSG_SIZE,
+	// GRPC_GZIP_* and GRPC_INTERCEPTORS. Credentials are per service only (e.g. PAYMENT_GRPC_CREDENTIALS_TOKEN).
+	services := &cfg.GRPCServices
+	services.DefaultTLS = getBackendTLS("GRPC_TLS", BackendTLSConfig{})
+	services.DefaultKeepalive = getBackendKeepalive("GRPC_KEEPALIVE", BackendKeepaliveConfig{Timeout: 20 * time.Second})
+	services.DefaultMessageSize = getBackendMessageSize("GRPC", BackendMessageSizeConfig{})
+	services.DefaultCompression = getBackendCompression("GRPC_GZIP", BackendCompressionConfig{MinBytes: 1024})
+	services.DefaultInterceptors = getEnvList("GRPC_INTERCEPTORS", []string{"recovery", "timeout", "auth", "error_reporting", "circuit_breaker", "retry", "hedging", "backend_router"})
+	services.TLS = make(map[string]BackendTLSConfig)
+	services.Keepalive = make(map[string]BackendKeepaliveConfig)
+	services.MessageSize = make(map[string]BackendMessageSizeConfig)
+	services.Compression = make(map[string]BackendCompressionConfig)
+	services.Interceptors = make(map[string][]string)
+	services.Credentials = make(map[string]BackendCredentialsConfig)
+	services.Names = make(map[string]string)
+	for _, service := range []struct{ prefix, addr string }{
+		{"MERCHANT_GRPC", services.MerchantServiceAddr},
+		{"PRODUCT_GRPC", services.ProductServiceAddr},
+		{"ORDER_GRPC", services.OrderServiceAddr},
+		{"CUSTOMER_GRPC", services.CustomerServiceAddr},
+		{"PAYMENT_GRPC", services.PaymentServiceAddr},
+		{"STORE_GRPC", services.StoreServiceAddr},
+		{"AUDIT_GRPC", services.AuditServiceAddr},
+	} {
+		compression := getBackendCompression(service.prefix+"_GZIP", services.DefaultCompression)
+		interceptors := getEnvList(service.prefix+"_INTERCEPTORS", services.DefaultInterceptors)
+		credentials := getBackendCredentials(service.prefix + "_CREDENTIALS")
+
+		name := strings.ToLower(strings.TrimSuffix(service.prefix, "_GRPC"))
+		if names, ok := services.Names[service.addr]; ok {
+			// Services at the same address share its connection, so they can't configure it differently
+			if compression != services.Compression[service.addr] || !slices.Equal(interceptors, services.Interceptors[service.addr]) {
+				return cfg, fmt.Errorf("%s_GZIP and %s_INTERCEPTORS must match those of %s, which shares %s", service.prefix, service.prefix, names, service.addr)
+			}
+			// Otherwise the credentials of one service would be sent to the other
+			if !sameCredentials(credentials, services.Credentials[service.addr]) {
+				return cfg, fmt.Errorf("%s_CREDENTIALS must match those of %s, which shares %s", service.prefix, names, service.addr)
+			}
+			name = names + "," + name
+		}
+		services.Names[service.addr] = name
+
+		services.TLS[service.addr] = getBackendTLS(service.prefix+"_TLS", services.DefaultTLS)
+		services.Keepalive[service.addr] = getBackendKeepalive(service.prefix+"_KEEPALIVE", services.DefaultKeepalive)
+		services.MessageSize[service.addr] = getBackendMessageSize(service.prefix, services.DefaultMessageSize)
+		services.Compression[service.addr] = compression
+		services.Interceptors[service.addr] = interceptors
+		services.Credentials[service.addr] = credentials
+	}
+
+	// Polls wait for events up to EVENTS_POLL_MAX_WAIT, a merchant's polling terminals would use up its slots
+	if cfg.Events.Enabled {
+		cfg.Concurrency.ExemptPaths = append(cfg.Concurrency.ExemptPaths, cfg.Events.PollPath)
+	}
+
+	// The windowed rate limit algorithms count in whole milliseconds
+	if cfg.RateLimit.Period > 0 && cfg.RateLimit.Period < time.Millisecond {
+		return cfg, fmt.Errorf("RATE_LIMIT_PERIOD must be at least 1ms, got %s", cfg.RateLimit.Period)
+	}
+	if cfg.Compression.Enabled && (cfg.Compression.Level < 1 || cfg.Compression.Level > 9) {
+		return cfg, fmt.Errorf("COMPRESSION_LEVEL must be between 1 and 9, got %d", cfg.Compression.Level)
+	}
+
 	return cfg, nil
 }
+
+// sameCredentials compares backend credentials, which hold a map and can't be compared with ==
+func sameCredentials(a, b BackendCredentialsConfig) bool {
+	return a.Header == b.Header && a.Token == b.Token && a.Username == b.Username && a.Password == b.Password &&
+		maps.Equal(a.Metadata, b.Metadata)
+}
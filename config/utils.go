@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -52,6 +53,20 @@ func getEnvInt(key string, def int) int {
 	return val
 }
 
+func getEnvFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	val, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		panic(fmt.Sprintf("invalid %s: must be number", key))
+	}
+
+	return val
+}
+
 func getEnvDuration(key string, def time.Duration) time.Duration {
 	v := os.Getenv(key)
 	if v == "" {
@@ -65,3 +80,147 @@ func getEnvDuration(key string, def time.Duration) time.Duration {
 
 	return val
 }
+
+func getEnvList(key string, def []string) []string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
+// getEnvIntMap parses a "key:value,key:value" list of integers
+func getEnvIntMap(key string, def map[string]int) map[string]int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	m := make(map[string]int)
+	for _, item := range getEnvList(key, nil) {
+		name, raw, ok := strings.Cut(item, ":")
+		if !ok {
+			panic(fmt.Sprintf("invalid %s: must be a list of key:integer pairs", key))
+		}
+
+		val, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil {
+			panic(fmt.Sprintf("invalid %s: must be a list of key:integer pairs", key))
+		}
+		m[strings.TrimSpace(name)] = val
+	}
+
+	return m
+}
+
+// getEnvMap parses a "key=value,key=value" list
+func getEnvMap(key string, def map[string]string) map[string]string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	m := make(map[string]string)
+	for _, item := range getEnvList(key, nil) {
+		name, val, ok := strings.Cut(item, "=")
+		if !ok {
+			panic(fmt.Sprintf("invalid %s: must be a list of key=value pairs", key))
+		}
+		m[strings.TrimSpace(name)] = strings.TrimSpace(val)
+	}
+
+	return m
+}
+
+// getBackendTLS reads <prefix>_ENABLED, _CA_FILE, _CERT_FILE, _KEY_FILE and _SERVER_NAME, defaulting to def
+func getBackendTLS(prefix string, def BackendTLSConfig) BackendTLSConfig {
+	return BackendTLSConfig{
+		Enabled:    getBoolEnv(prefix+"_ENABLED", def.Enabled),
+		CAFile:     getEnv(prefix+"_CA_FILE", def.CAFile),
+		CertFile:   getEnv(prefix+"_CERT_FILE", def.CertFile),
+		KeyFile:    getEnv(prefix+"_KEY_FILE", def.KeyFile),
+		ServerName: getEnv(prefix+"_SERVER_NAME", def.ServerName),
+	}
+}
+
+// getEnvDurationMap parses a "key=duration,key=duration" list
+func getEnvDurationMap(key string, def map[string]time.Duration) map[string]time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	m := make(map[string]time.Duration)
+	for name, raw := range getEnvMap(key, nil) {
+		val, err := time.ParseDuration(raw)
+		if err != nil {
+			panic(fmt.Sprintf("invalid %s: must be a list of key=duration pairs", key))
+		}
+		m[name] = val
+	}
+
+	return m
+}
+
+// getEnvFloatMap parses a "key=number,key=number" list
+func getEnvFloatMap(key string, def map[string]float64) map[string]float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	m := make(map[string]float64)
+	for name, raw := range getEnvMap(key, nil) {
+		val, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			panic(fmt.Sprintf("invalid %s: must be a list of key=number pairs", key))
+		}
+		m[name] = val
+	}
+
+	return m
+}
+
+// getBackendKeepalive reads <prefix>_TIME, _TIMEOUT and _PERMIT_WITHOUT_STREAM, defaulting to def
+func getBackendKeepalive(prefix string, def BackendKeepaliveConfig) BackendKeepaliveConfig {
+	return BackendKeepaliveConfig{
+		Time:                getEnvDuration(prefix+"_TIME", def.Time),
+		Timeout:             getEnvDuration(prefix+"_TIMEOUT", def.Timeout),
+		PermitWithoutStream: getBoolEnv(prefix+"_PERMIT_WITHOUT_STREAM", def.PermitWithoutStream),
+	}
+}
+
+// getBackendMessageSize reads <prefix>_MAX_RECV_MSG_SIZE and _MAX_SEND_MSG_SIZE in bytes, defaulting to def
+func getBackendMessageSize(prefix string, def BackendMessageSizeConfig) BackendMessageSizeConfig {
+	return BackendMessageSizeConfig{
+		MaxRecvBytes: getEnvInt(prefix+"_MAX_RECV_MSG_SIZE", def.MaxRecvBytes),
+		MaxSendBytes: getEnvInt(prefix+"_MAX_SEND_MSG_SIZE", def.MaxSendBytes),
+	}
+}
+
+// getBackendCompression reads <prefix>_ENABLED and _MIN_SIZE in bytes, defaulting to def
+func getBackendCompression(prefix string, def BackendCompressionConfig) BackendCompressionConfig {
+	return BackendCompressionConfig{
+		Enabled:  getBoolEnv(prefix+"_ENABLED", def.Enabled),
+		MinBytes: getEnvInt(prefix+"_MIN_SIZE", def.MinBytes),
+	}
+}
+
+// getBackendCredentials reads <prefix>_HEADER, _TOKEN, _USERNAME, _PASSWORD and _METADATA ("key=value" list)
+func getBackendCredentials(prefix string) BackendCredentialsConfig {
+	return BackendCredentialsConfig{
+		Header:   strings.ToLower(getEnv(prefix+"_HEADER", "x-backend-authorization")),
+		Token:    getEnv(prefix+"_TOKEN", ""),
+		Username: getEnv(prefix+"_USERNAME", ""),
+		Password: getEnv(prefix+"_PASSWORD", ""),
+		Metadata: getEnvMap(prefix+"_METADATA", nil),
+	}
+}
@@ -0,0 +1,176 @@
+// Package accesslog exports an access log entry per request to an external sink (rotating files, a
+// Kafka topic or an HTTP collector), apart from the application logs, for request retention
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	"github.com/fekuna/omnipos-pkg/logger"
+	pkgMiddleware "github.com/fekuna/omnipos-pkg/middleware"
+	"go.uber.org/zap"
+)
+
+// Entry is the access log entry of a request
+type Entry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Route      string    `json:"route"` // gRPC method, or "unmatched"
+	Protocol   string    `json:"protocol"`
+	Status     int       `json:"status"`
+	BytesIn    int64     `json:"bytes_in"`
+	BytesOut   int64     `json:"bytes_out"`
+	DurationMS float64   `json:"duration_ms"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	MerchantID string    `json:"merchant_id,omitempty"`
+	UserID     string    `json:"user_id,omitempty"`
+}
+
+// sink writes batches of entries
+type sink interface {
+	write(ctx context.Context, entries []Entry) error
+	close() error
+}
+
+// Exporter queues an entry per request and writes them to the sink in batches in the background. The
+// queue is bounded: entries are dropped (and counted) rather than slowing requests down when the sink
+// falls behind.
+type Exporter struct {
+	sink      sink
+	jwtHelper *middleware.JWTHelper
+	routes    *middleware.RouteTable
+	cfg       config.AccessLogConfig
+	logger    logger.ZapLogger
+	queue     chan Entry
+}
+
+// NewExporter creates an exporter writing to cfg.Sink
+func NewExporter(jwtHelper *middleware.JWTHelper, routes *middleware.RouteTable, cfg config.AccessLogConfig, log logger.ZapLogger) (*Exporter, error) {
+	var s sink
+	switch cfg.Sink {
+	case "file":
+		f, err := newRotatingFile(cfg)
+		if err != nil {
+			return nil, err
+		}
+		s = f
+	case "kafka":
+		if cfg.KafkaREST == "" {
+			return nil, fmt.Errorf("the kafka access log sink needs a Kafka REST proxy URL")
+		}
+		s = newKafkaSink(cfg)
+	case "http":
+		if cfg.HTTPURL == "" {
+			return nil, fmt.Errorf("the http access log sink needs a collector URL")
+		}
+		s = newHTTPSink(cfg)
+	default:
+		return nil, fmt.Errorf("unknown access log sink %q", cfg.Sink)
+	}
+
+	return &Exporter{
+		sink:      s,
+		jwtHelper: jwtHelper,
+		routes:    routes,
+		cfg:       cfg,
+		logger:    log,
+		queue:     make(chan Entry, cfg.QueueSize),
+	}, nil
+}
+
+// Log queues the entry of every request once it's answered
+func (e *Exporter) Log(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := middleware.NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		entry := Entry{
+			Time:       start.UTC(),
+			RequestID:  pkgMiddleware.GetRequestID(r.Context()),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Route:      "unmatched",
+			Protocol:   r.Proto,
+			Status:     rec.Status,
+			BytesIn:    max(r.ContentLength, 0),
+			BytesOut:   rec.Bytes,
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			IP:         middleware.ClientIP(r),
+			UserAgent:  r.UserAgent(),
+		}
+		if route, ok := e.routes.MatchRequest(r); ok {
+			entry.Route = route.Method
+		}
+		if claims, err := e.jwtHelper.RequestClaims(r); err == nil {
+			entry.MerchantID = claims.MerchantID
+			entry.UserID = claims.Subject
+		}
+
+		select {
+		case e.queue <- entry:
+		default:
+			metrics.AccessLogEntries.WithLabelValues("dropped").Inc()
+		}
+	})
+}
+
+// Run writes the queued entries every cfg.FlushInterval, or as soon as a batch is full, until ctx is
+// cancelled; the entries still queued then are written before the sink is closed
+func (e *Exporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	batchSize := max(e.cfg.BatchSize, 1)
+	batch := make([]Entry, 0, batchSize)
+	for {
+		select {
+		case <-ctx.Done():
+		drain:
+			for {
+				select {
+				case entry := <-e.queue:
+					batch = append(batch, entry)
+				default:
+					break drain
+				}
+			}
+			flushCtx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+			e.flush(flushCtx, batch)
+			cancel()
+			if err := e.sink.close(); err != nil {
+				e.logger.Warn("failed to close access log sink", zap.Error(err))
+			}
+			return
+		case entry := <-e.queue:
+			batch = append(batch, entry)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		e.flush(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+func (e *Exporter) flush(ctx context.Context, batch []Entry) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := e.sink.write(ctx, batch); err != nil {
+		metrics.AccessLogEntries.WithLabelValues("failed").Add(float64(len(batch)))
+		e.logger.Warn("failed to export access log entries", zap.String("sink", e.cfg.Sink), zap.Int("entries", len(batch)), zap.Error(err))
+		return
+	}
+	metrics.AccessLogEntries.WithLabelValues("sent").Add(float64(len(batch)))
+}
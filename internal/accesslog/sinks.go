@@ -0,0 +1,179 @@
+package accesslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/kafka"
+)
+
+// rotatingFile appends entries as JSON lines to a file, rotated to "<name>-<time><ext>" once it grows
+// over cfg.MaxSize; rotated files are deleted past cfg.MaxBackups or cfg.MaxAge
+type rotatingFile struct {
+	cfg  config.AccessLogConfig
+	file *os.File
+	size int64
+}
+
+func newRotatingFile(cfg config.AccessLogConfig) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.FilePath), 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create the access log directory: %w", err)
+	}
+	f := &rotatingFile{cfg: cfg}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.cfg.FilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open the access log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) write(_ context.Context, entries []Entry) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	if f.cfg.MaxSize > 0 && f.size > 0 && f.size+int64(buf.Len()) > f.cfg.MaxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.Write(buf.Bytes())
+	f.size += int64(n)
+	return err
+}
+
+// rotate renames the current file and opens a new one, then prunes the rotated files
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(f.cfg.FilePath)
+	base := strings.TrimSuffix(f.cfg.FilePath, ext)
+	rotated := fmt.Sprintf("%s-%s%s", base, time.Now().UTC().Format("20060102T150405.000"), ext)
+	if err := os.Rename(f.cfg.FilePath, rotated); err != nil {
+		return fmt.Errorf("failed to rotate the access log: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	return f.prune(base + "-*" + ext)
+}
+
+// prune deletes the rotated files over cfg.MaxBackups or older than cfg.MaxAge; their names sort by time
+func (f *rotatingFile) prune(pattern string) error {
+	rotated, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(rotated)))
+
+	for i, name := range rotated {
+		expired := f.cfg.MaxBackups > 0 && i >= f.cfg.MaxBackups
+		if !expired && f.cfg.MaxAge > 0 {
+			if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > f.cfg.MaxAge {
+				expired = true
+			}
+		}
+		if expired {
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *rotatingFile) close() error {
+	return f.file.Close()
+}
+
+// kafkaSink produces entries to a topic, keyed by request ID
+type kafkaSink struct {
+	producer *kafka.Producer
+}
+
+func newKafkaSink(cfg config.AccessLogConfig) *kafkaSink {
+	return &kafkaSink{producer: kafka.NewProducer(cfg.KafkaREST, cfg.KafkaTopic, cfg.Timeout)}
+}
+
+func (s *kafkaSink) write(ctx context.Context, entries []Entry) error {
+	records := make([]kafka.Record, len(entries))
+	for i, entry := range entries {
+		records[i] = kafka.Record{Key: entry.RequestID, Value: entry}
+	}
+	return s.producer.Produce(ctx, records)
+}
+
+func (s *kafkaSink) close() error {
+	return nil
+}
+
+// httpSink posts batches of entries to a collector as NDJSON
+type httpSink struct {
+	cfg    config.AccessLogConfig
+	client *http.Client
+}
+
+func newHTTPSink(cfg config.AccessLogConfig) *httpSink {
+	return &httpSink{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+}
+
+func (s *httpSink) write(ctx context.Context, entries []Entry) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, entry := range entries {
+		if err := enc.Encode(entry); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.HTTPURL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for key, value := range s.cfg.HTTPHeaders {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("access log collector responded %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *httpSink) close() error {
+	return nil
+}
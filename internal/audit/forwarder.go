@@ -0,0 +1,174 @@
+// Package audit forwards an audit record of every successful mutating request to the audit service,
+// so backends don't each capture their own
+package audit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Record is the audit record of a request
+type Record struct {
+	ActorID    string // subject of the caller's token
+	MerchantID string
+	Action     string // gRPC method, or HTTP method and path for routes outside the gateway mux
+	Resource   string // request path
+	Summary    string
+	Result     string // HTTP status
+	Time       time.Time
+}
+
+// Forwarder queues the records of successful non-GET requests and sends them to the audit service in
+// the background. The queue is bounded: records are dropped (and counted) rather than slowing requests
+// down when the audit service falls behind.
+type Forwarder struct {
+	conn      *grpc.ClientConn
+	method    protoreflect.MethodDescriptor
+	jwtHelper *middleware.JWTHelper
+	routes    *middleware.RouteTable
+	cfg       config.AuditConfig
+	logger    logger.ZapLogger
+	queue     chan Record
+}
+
+// NewForwarder creates a forwarder calling cfg.Method over conn. The request message is built from the
+// method's registered descriptor, filling the string fields named in cfg.Fields.
+func NewForwarder(conn *grpc.ClientConn, jwtHelper *middleware.JWTHelper, routes *middleware.RouteTable, cfg config.AuditConfig, log logger.ZapLogger) (*Forwarder, error) {
+	name := protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(cfg.Method, "/"), "/", "."))
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("audit method %s not found: %w", cfg.Method, err)
+	}
+	method, ok := desc.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a gRPC method", cfg.Method)
+	}
+
+	for attr, field := range cfg.Fields {
+		if fd := method.Input().Fields().ByName(protoreflect.Name(field)); fd == nil || fd.Kind() != protoreflect.StringKind {
+			log.Warn("audit request field not found or not a string, it's left empty", zap.String("attribute", attr), zap.String("field", field))
+		}
+	}
+
+	return &Forwarder{
+		conn:      conn,
+		method:    method,
+		jwtHelper: jwtHelper,
+		routes:    routes,
+		cfg:       cfg,
+		logger:    log,
+		queue:     make(chan Record, cfg.QueueSize),
+	}, nil
+}
+
+// Record queues the audit record of successful mutating requests
+func (f *Forwarder) Record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := middleware.NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+		if rec.Status < 200 || rec.Status >= 300 {
+			return
+		}
+
+		record := Record{
+			Action:   r.Method + " " + r.URL.Path,
+			Resource: r.URL.Path,
+			Summary:  fmt.Sprintf("%s %s (%d bytes)", r.Method, r.URL.Path, max(r.ContentLength, 0)),
+			Result:   fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)),
+			Time:     time.Now().UTC(),
+		}
+		if route, ok := f.routes.MatchRequest(r); ok {
+			record.Action = route.Method
+			record.Summary = fmt.Sprintf("%s %s (%d bytes)", r.Method, route.Pattern, max(r.ContentLength, 0))
+		}
+		if claims, err := f.jwtHelper.RequestClaims(r); err == nil {
+			record.ActorID = claims.Subject
+			record.MerchantID = claims.MerchantID
+		}
+
+		f.Forward(record)
+	})
+}
+
+// Forward queues a record built elsewhere in the gateway, e.g. a usage export
+func (f *Forwarder) Forward(record Record) {
+	select {
+	case f.queue <- record:
+	default:
+		metrics.AuditRecords.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run sends queued records with cfg.Workers workers until ctx is cancelled
+func (f *Forwarder) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < max(f.cfg.Workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case record := <-f.queue:
+					f.send(ctx, record)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (f *Forwarder) send(ctx context.Context, record Record) {
+	if record.MerchantID != "" {
+		ctx = middleware.WithMerchant(ctx, record.MerchantID)
+	} else {
+		ctx = middleware.WithGatewayCaller(ctx, "audit")
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.cfg.Timeout)
+	defer cancel()
+
+	req := dynamicpb.NewMessage(f.method.Input())
+	for attr, value := range map[string]string{
+		"actor_id":    record.ActorID,
+		"merchant_id": record.MerchantID,
+		"action":      record.Action,
+		"resource":    record.Resource,
+		"summary":     record.Summary,
+		"result":      record.Result,
+		"time":        record.Time.Format(time.RFC3339),
+	} {
+		fd := req.Descriptor().Fields().ByName(protoreflect.Name(f.cfg.Fields[attr]))
+		if fd != nil && fd.Kind() == protoreflect.StringKind && value != "" {
+			req.Set(fd, protoreflect.ValueOfString(value))
+		}
+	}
+
+	fullMethod := fmt.Sprintf("/%s/%s", f.method.Parent().FullName(), f.method.Name())
+	if err := f.conn.Invoke(ctx, fullMethod, req, dynamicpb.NewMessage(f.method.Output())); err != nil {
+		metrics.AuditRecords.WithLabelValues("failed").Inc()
+		f.logger.Warn("failed to forward audit record", zap.String("action", record.Action), zap.Error(err))
+		return
+	}
+	metrics.AuditRecords.WithLabelValues("sent").Inc()
+}
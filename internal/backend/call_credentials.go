@@ -0,0 +1,51 @@
+package backend
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"google.golang.org/grpc"
+)
+
+// staticCredentials attaches the fixed metadata of a backend to every call, in addition to the user's
+// token and the gateway assertion
+type staticCredentials struct {
+	md map[string]string
+}
+
+func newStaticCredentials(cfg config.BackendCredentialsConfig) *staticCredentials {
+	md := make(map[string]string, len(cfg.Metadata)+1)
+	for key, value := range cfg.Metadata {
+		md[key] = value
+	}
+	switch {
+	case cfg.Token != "":
+		md[cfg.Header] = "Bearer " + cfg.Token
+	case cfg.Username != "":
+		md[cfg.Header] = "Basic " + base64.StdEncoding.EncodeToString([]byte(cfg.Username+":"+cfg.Password))
+	}
+	if len(md) == 0 {
+		return nil
+	}
+	return &staticCredentials{md: md}
+}
+
+func (c *staticCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return c.md, nil
+}
+
+// RequireTransportSecurity doesn't require TLS, as in-cluster backends are commonly dialed in plaintext;
+// enable backend TLS for partner backends outside the cluster
+func (c *staticCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// callCredentials returns the static credentials of addr, if any
+func (m *Manager) callCredentials(addr string) grpc.DialOption {
+	creds := newStaticCredentials(m.cfg.Credentials[addr])
+	if creds == nil {
+		return grpc.EmptyDialOption{}
+	}
+	return grpc.WithPerRPCCredentials(creds)
+}
@@ -0,0 +1,26 @@
+package backend
+
+import (
+	"context"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/protobuf/proto"
+)
+
+// compressionUnary gzips requests of at least cfg.MinBytes; smaller ones aren't worth the CPU. Backends
+// answer gzipped requests with gzipped responses.
+func compressionUnary(cfg config.BackendCompressionConfig) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if msg, ok := req.(proto.Message); !ok || proto.Size(msg) >= cfg.MinBytes {
+			opts = append(opts, grpc.UseCompressor(gzip.Name))
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// compressionStream gzips every message of streams, whose sizes aren't known upfront
+func compressionStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(ctx, desc, cc, method, append(opts, grpc.UseCompressor(gzip.Name))...)
+}
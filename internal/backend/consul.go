@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// consulWait is how long a Consul blocking query waits for the service to change
+const consulWait = 5 * time.Minute
+
+// consulDiscoverer watches the healthy instances of Consul services with blocking queries, so changes
+// are picked up as soon as Consul sees them
+type consulDiscoverer struct {
+	cfg    config.DiscoveryConfig
+	client *http.Client
+	logger logger.ZapLogger
+}
+
+func newConsulDiscoverer(cfg config.DiscoveryConfig, log logger.ZapLogger) *consulDiscoverer {
+	return &consulDiscoverer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: consulWait + 30*time.Second},
+		logger: log,
+	}
+}
+
+func (d *consulDiscoverer) Watch(ctx context.Context, service string, update func(addrs []string)) {
+	var index uint64
+	var last []string
+	updated := false
+
+	for ctx.Err() == nil {
+		addrs, next, err := d.lookup(ctx, service, index)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.logger.Warn("consul lookup failed", zap.String("service", service), zap.Error(err))
+			}
+			index = 0
+			select {
+			case <-ctx.Done():
+			case <-time.After(d.cfg.RefreshInterval):
+			}
+			continue
+		}
+
+		// Consul indexes can go backwards (e.g. after a snapshot restore); start over when they do
+		if next < index {
+			next = 0
+		}
+		index = next
+
+		slices.Sort(addrs)
+		if !updated || !slices.Equal(addrs, last) {
+			last, updated = addrs, true
+			update(addrs)
+		}
+	}
+}
+
+// lookup returns the addresses of the passing instances of service once they differ from index
+func (d *consulDiscoverer) lookup(ctx context.Context, service string, index uint64) ([]string, uint64, error) {
+	query := url.Values{"passing": {"true"}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", consulWait.String())
+	}
+	endpoint := strings.TrimSuffix(d.cfg.ConsulAddr, "/") + "/v1/health/service/" + url.PathEscape(service) + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if d.cfg.ConsulToken != "" {
+		req.Header.Set("X-Consul-Token", d.cfg.ConsulToken)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("consul returned %s", resp.Status)
+	}
+
+	var entries []struct {
+		Node struct {
+			Address string
+		}
+		Service struct {
+			Address string
+			Port    int
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, 0, err
+	}
+
+	addrs := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		host := entry.Service.Address
+		if host == "" {
+			host = entry.Node.Address
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)))
+	}
+
+	next, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return addrs, next, nil
+}
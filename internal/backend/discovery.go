@@ -0,0 +1,118 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/resolver"
+)
+
+// Discoverer keeps track of the instances of logical services in a service registry
+type Discoverer interface {
+	// Watch calls update with the addresses ("host:port") of service whenever they change, until ctx
+	// is cancelled. Registry errors are retried; the last known addresses stay in use meanwhile.
+	Watch(ctx context.Context, service string, update func(addrs []string))
+}
+
+// RegisterDiscovery registers gRPC resolvers for the configured service registries, so backend addresses
+// can be logical names kept up to date without restarts:
+//
+//	consul:///order-service                  healthy instances of a Consul service
+//	etcd:///services/order-service/          addresses stored under an etcd key prefix
+//	kubernetes:///order-service.pos:grpc     ready endpoints of a Kubernetes service (namespace and port optional)
+//
+// It must be called before the first connection is dialed.
+func RegisterDiscovery(cfg config.DiscoveryConfig, log logger.ZapLogger) {
+	if cfg.ConsulAddr != "" {
+		resolver.Register(&discoveryBuilder{scheme: "consul", discoverer: newConsulDiscoverer(cfg, log), logger: log})
+	}
+	if cfg.EtcdAddr != "" {
+		resolver.Register(&discoveryBuilder{scheme: "etcd", discoverer: newEtcdDiscoverer(cfg, log), logger: log})
+	}
+	resolver.Register(&discoveryBuilder{scheme: "kubernetes", discoverer: newKubernetesDiscoverer(cfg, log), logger: log})
+}
+
+// isDiscoveryTarget reports whether addr is resolved by a resolver rather than being a "host:port"
+func isDiscoveryTarget(addr string) bool {
+	return strings.Contains(addr, ":///")
+}
+
+type discoveryBuilder struct {
+	scheme     string
+	discoverer Discoverer
+	logger     logger.ZapLogger
+}
+
+func (b *discoveryBuilder) Scheme() string {
+	return b.scheme
+}
+
+func (b *discoveryBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	service := target.Endpoint()
+	if service == "" {
+		return nil, fmt.Errorf("%s target %q has no service name", b.scheme, target.String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go b.discoverer.Watch(ctx, service, func(addrs []string) {
+		if len(addrs) == 0 {
+			cc.ReportError(fmt.Errorf("no instances of %s in %s", service, b.scheme))
+			return
+		}
+
+		b.logger.Info("backend instances updated", zap.String("target", target.String()), zap.Strings("addrs", addrs))
+		state := resolver.State{Addresses: make([]resolver.Address, len(addrs))}
+		for i, addr := range addrs {
+			state.Addresses[i] = resolver.Address{Addr: addr}
+		}
+		if err := cc.UpdateState(state); err != nil {
+			b.logger.Warn("failed to apply backend instances", zap.String("target", target.String()), zap.Error(err))
+		}
+	})
+
+	return discoveryResolver(cancel), nil
+}
+
+// discoveryResolver stops its watch when closed; watches push updates, so ResolveNow has nothing to do
+type discoveryResolver context.CancelFunc
+
+func (r discoveryResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r discoveryResolver) Close() {
+	r()
+}
+
+// poll calls lookup every interval and update when the addresses changed, for registries without watches
+func poll(ctx context.Context, interval time.Duration, log logger.ZapLogger, service string,
+	lookup func(ctx context.Context) ([]string, error), update func(addrs []string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []string
+	updated := false
+	for {
+		addrs, err := lookup(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Warn("service discovery lookup failed", zap.String("service", service), zap.Error(err))
+		}
+		if err == nil {
+			slices.Sort(addrs)
+			if !updated || !slices.Equal(addrs, last) {
+				last, updated = addrs, true
+				update(addrs)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+)
+
+// etcdDiscoverer polls the addresses stored under an etcd key prefix through the etcd v3 JSON gateway.
+// Values are either "host:port" or the JSON endpoints of etcd's naming package ({"Addr": "host:port"}).
+type etcdDiscoverer struct {
+	cfg    config.DiscoveryConfig
+	client *http.Client
+	logger logger.ZapLogger
+}
+
+func newEtcdDiscoverer(cfg config.DiscoveryConfig, log logger.ZapLogger) *etcdDiscoverer {
+	return &etcdDiscoverer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: log,
+	}
+}
+
+func (d *etcdDiscoverer) Watch(ctx context.Context, prefix string, update func(addrs []string)) {
+	poll(ctx, d.cfg.RefreshInterval, d.logger, prefix, func(ctx context.Context) ([]string, error) {
+		return d.lookup(ctx, prefix)
+	}, update)
+}
+
+func (d *etcdDiscoverer) lookup(ctx context.Context, prefix string) ([]string, error) {
+	body, _ := json.Marshal(map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(prefixEnd([]byte(prefix))),
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(d.cfg.EtcdAddr, "/")+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("etcd returned %s", resp.Status)
+	}
+
+	var result struct {
+		Kvs []struct {
+			Value []byte `json:"value"` // base64 in JSON
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(result.Kvs))
+	for _, kv := range result.Kvs {
+		var endpoint struct {
+			Addr string
+		}
+		if json.Unmarshal(kv.Value, &endpoint) == nil && endpoint.Addr != "" {
+			addrs = append(addrs, endpoint.Addr)
+		} else if addr := strings.TrimSpace(string(kv.Value)); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, nil
+}
+
+// prefixEnd returns the range end covering every key with prefix
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// The prefix is all 0xff: range to the end of the keyspace
+	return []byte{0}
+}
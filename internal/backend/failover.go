@@ -0,0 +1,104 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Failover switches the connection of a backend to its fallback address (e.g. a DR region) when the
+// primary fails its health checks repeatedly, and back once it passes them again. The primary is probed
+// over a connection of its own, since the shared one points at the fallback while failed over.
+type Failover struct {
+	conns  *Manager
+	cfg    config.FailoverConfig
+	logger logger.ZapLogger
+}
+
+// NewFailover creates the failover of the backends of conns configured with a fallback
+func NewFailover(conns *Manager, cfg config.FailoverConfig, log logger.ZapLogger) *Failover {
+	return &Failover{
+		conns:  conns,
+		cfg:    cfg,
+		logger: log,
+	}
+}
+
+// Run probes the primaries until ctx is cancelled
+func (f *Failover) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for addr, fallback := range f.cfg.Backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.watch(ctx, addr, fallback)
+		}()
+	}
+	wg.Wait()
+}
+
+func (f *Failover) watch(ctx context.Context, addr, fallback string) {
+	probe, err := grpc.NewClient(addr, grpc.WithTransportCredentials(f.conns.credentials(addr)))
+	if err != nil {
+		f.logger.Error("failed to dial failover probe", zap.String("addr", addr), zap.Error(err))
+		return
+	}
+	defer probe.Close()
+	client := healthpb.NewHealthClient(probe)
+
+	ticker := time.NewTicker(f.cfg.CheckInterval)
+	defer ticker.Stop()
+
+	failedOver := false
+	failures, successes := 0, 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if f.healthy(ctx, client) {
+			failures, successes = 0, successes+1
+		} else {
+			failures, successes = failures+1, 0
+		}
+
+		switch {
+		case !failedOver && failures >= f.cfg.FailureThreshold:
+			f.logger.Error("backend failed over", zap.String("addr", addr), zap.String("fallback", fallback), zap.Int("failed_checks", failures))
+			failedOver = f.apply(addr, fallback)
+		case failedOver && successes >= f.cfg.RecoveryThreshold:
+			f.logger.Info("backend recovered, switching back", zap.String("addr", addr), zap.String("fallback", fallback))
+			failedOver = !f.apply(addr, "")
+		}
+	}
+}
+
+// healthy probes the primary; backends without the health service count as healthy when they answer
+func (f *Failover) healthy(ctx context.Context, client healthpb.HealthClient) bool {
+	ctx, cancel := context.WithTimeout(ctx, f.cfg.CheckTimeout)
+	defer cancel()
+
+	resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return true
+	}
+	return err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+}
+
+func (f *Failover) apply(addr, target string) bool {
+	if err := f.conns.FailOver(addr, target); err != nil {
+		f.logger.Error("failed to switch backend", zap.String("addr", addr), zap.Error(err))
+		return false
+	}
+	return true
+}
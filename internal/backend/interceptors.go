@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"google.golang.org/grpc"
+)
+
+// Interceptor is a client interceptor that can be composed into the chain of a backend; Stream is nil for
+// unary-only interceptors
+type Interceptor struct {
+	Unary  grpc.UnaryClientInterceptor
+	Stream grpc.StreamClientInterceptor
+}
+
+// Interceptors is the registry of named interceptors. The chain of each backend is configured as a list
+// of names (GRPCServicesConfig.Interceptors), so e.g. the payment service can run stricter policies than
+// product reads.
+type Interceptors struct {
+	registered map[string]Interceptor
+}
+
+// NewInterceptors creates an empty registry
+func NewInterceptors() *Interceptors {
+	return &Interceptors{registered: make(map[string]Interceptor)}
+}
+
+// Register adds an interceptor under name, replacing any interceptor registered under it
+func (i *Interceptors) Register(name string, interceptor Interceptor) {
+	i.registered[name] = interceptor
+}
+
+// Validate checks that every configured chain only names registered interceptors and authenticates its
+// calls ("auth"). Chains of the routed addresses, whose services have a canary or shadow, and all chains
+// when version routes are configured, must also route calls ("backend_router"), or these calls would
+// silently stay on the service's own backend.
+func (i *Interceptors) Validate(cfg config.GRPCServicesConfig, routed []string) error {
+	// Addresses without a chain of their own use the default one
+	defaultRouted := len(cfg.VersionRoutes) > 0
+	for _, addr := range routed {
+		if _, ok := cfg.Interceptors[addr]; !ok {
+			defaultRouted = true
+		}
+	}
+	if err := i.validate(cfg.DefaultInterceptors, defaultRouted); err != nil {
+		return err
+	}
+	for addr, chain := range cfg.Interceptors {
+		if err := i.validate(chain, len(cfg.VersionRoutes) > 0 || slices.Contains(routed, addr)); err != nil {
+			return fmt.Errorf("%s: %w", addr, err)
+		}
+	}
+	return nil
+}
+
+func (i *Interceptors) validate(chain []string, routed bool) error {
+	if _, err := i.DialOptions(chain); err != nil {
+		return err
+	}
+	if !slices.Contains(chain, "auth") {
+		return fmt.Errorf("backend interceptor chain %v lacks \"auth\"", chain)
+	}
+	if routed && !slices.Contains(chain, "backend_router") {
+		return fmt.Errorf("backend interceptor chain %v lacks \"backend_router\", required by canary, shadow and version routes", chain)
+	}
+	return nil
+}
+
+// DialOptions chains the named interceptors, outermost first
+func (i *Interceptors) DialOptions(chain []string) ([]grpc.DialOption, error) {
+	var unary []grpc.UnaryClientInterceptor
+	var stream []grpc.StreamClientInterceptor
+	for _, name := range chain {
+		interceptor, ok := i.registered[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown backend interceptor %q", name)
+		}
+		if interceptor.Unary != nil {
+			unary = append(unary, interceptor.Unary)
+		}
+		if interceptor.Stream != nil {
+			stream = append(stream, interceptor.Stream)
+		}
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(unary...),
+		grpc.WithChainStreamInterceptor(stream...),
+	}, nil
+}
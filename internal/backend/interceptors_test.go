@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fekuna/omnipos-gateway/config"
+)
+
+func TestInterceptors_Validate(t *testing.T) {
+	interceptors := NewInterceptors()
+	for _, name := range []string{"recovery", "auth", "retry", "backend_router"} {
+		interceptors.Register(name, Interceptor{})
+	}
+
+	full := []string{"recovery", "auth", "retry", "backend_router"}
+	tests := []struct {
+		name    string
+		cfg     config.GRPCServicesConfig
+		routed  []string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			cfg:  config.GRPCServicesConfig{DefaultInterceptors: full, Interceptors: map[string][]string{"order:8083": full}},
+		},
+		{
+			name:    "unknown interceptor",
+			cfg:     config.GRPCServicesConfig{DefaultInterceptors: []string{"auth", "cache"}},
+			wantErr: `unknown backend interceptor "cache"`,
+		},
+		{
+			name: "service chain without auth",
+			cfg: config.GRPCServicesConfig{
+				DefaultInterceptors: full,
+				Interceptors:        map[string][]string{"order:8083": {"recovery", "retry"}},
+			},
+			wantErr: `order:8083: backend interceptor chain [recovery retry] lacks "auth"`,
+		},
+		{
+			name:    "default chain without auth",
+			cfg:     config.GRPCServicesConfig{DefaultInterceptors: []string{"recovery"}},
+			wantErr: `lacks "auth"`,
+		},
+		{
+			name: "unrouted chain without backend_router",
+			cfg: config.GRPCServicesConfig{
+				DefaultInterceptors: full,
+				Interceptors:        map[string][]string{"order:8083": full, "product:8082": {"auth"}},
+			},
+			routed: []string{"order:8083"},
+		},
+		{
+			name: "canary service without backend_router",
+			cfg: config.GRPCServicesConfig{
+				DefaultInterceptors: full,
+				Interceptors:        map[string][]string{"order:8083": {"auth", "retry"}},
+			},
+			routed:  []string{"order:8083"},
+			wantErr: `order:8083: backend interceptor chain [auth retry] lacks "backend_router"`,
+		},
+		{
+			name: "version routes without backend_router",
+			cfg: config.GRPCServicesConfig{
+				DefaultInterceptors: full,
+				Interceptors:        map[string][]string{"order:8083": {"auth"}},
+				VersionRoutes:       map[string]string{"/v2/orders": "order-v2:8083"},
+			},
+			wantErr: `lacks "backend_router"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := interceptors.Validate(tt.cfg, tt.routed)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected the chains to be valid, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
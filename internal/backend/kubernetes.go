@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// In-cluster credentials of the gateway's service account
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount/"
+
+// kubernetesDiscoverer polls the ready endpoints of Kubernetes services through the API server, with the
+// gateway's service account (which needs get on endpoints). Pods are dialed directly, so connections are
+// balanced across them instead of pinned to one through the service's virtual IP.
+type kubernetesDiscoverer struct {
+	cfg    config.DiscoveryConfig
+	logger logger.ZapLogger
+}
+
+func newKubernetesDiscoverer(cfg config.DiscoveryConfig, log logger.ZapLogger) *kubernetesDiscoverer {
+	return &kubernetesDiscoverer{
+		cfg:    cfg,
+		logger: log,
+	}
+}
+
+// Watch resolves "<service>[.<namespace>][:<port name or number>]"; without a port, the first port of
+// the service is used
+func (d *kubernetesDiscoverer) Watch(ctx context.Context, target string, update func(addrs []string)) {
+	name, port, _ := strings.Cut(target, ":")
+	name, namespace, _ := strings.Cut(name, ".")
+	if namespace == "" {
+		namespace = d.cfg.KubernetesNamespace
+	}
+
+	client, apiServer, err := d.client()
+	if err != nil {
+		d.logger.Error("kubernetes service discovery unavailable", zap.String("target", target), zap.Error(err))
+		update(nil)
+		return
+	}
+
+	endpoint := apiServer + "/api/v1/namespaces/" + url.PathEscape(namespace) + "/endpoints/" + url.PathEscape(name)
+	poll(ctx, d.cfg.RefreshInterval, d.logger, target, func(ctx context.Context) ([]string, error) {
+		return d.lookup(ctx, client, endpoint, port)
+	}, update)
+}
+
+func (d *kubernetesDiscoverer) lookup(ctx context.Context, client *http.Client, endpoint, port string) ([]string, error) {
+	// Projected service account tokens rotate, so the token is read for every request
+	token, err := os.ReadFile(serviceAccountDir + "token")
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubernetes API returned %s", resp.Status)
+	}
+
+	var endpoints struct {
+		Subsets []struct {
+			Addresses []struct {
+				IP string `json:"ip"`
+			} `json:"addresses"`
+			Ports []struct {
+				Name string `json:"name"`
+				Port int    `json:"port"`
+			} `json:"ports"`
+		} `json:"subsets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&endpoints); err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, subset := range endpoints.Subsets {
+		number := 0
+		for _, p := range subset.Ports {
+			if port == "" || p.Name == port || strconv.Itoa(p.Port) == port {
+				number = p.Port
+				break
+			}
+		}
+		if number == 0 {
+			continue
+		}
+		for _, address := range subset.Addresses {
+			addrs = append(addrs, net.JoinHostPort(address.IP, strconv.Itoa(number)))
+		}
+	}
+	return addrs, nil
+}
+
+// client returns an HTTP client trusting the cluster CA, and the API server URL
+func (d *kubernetesDiscoverer) client() (*http.Client, string, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, "", errors.New("not running in a Kubernetes cluster")
+	}
+
+	ca, err := os.ReadFile(serviceAccountDir + "ca.crt")
+	if err != nil {
+		return nil, "", err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, "", errors.New("no certificates found in the cluster CA bundle")
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+	}
+	return client, "https://" + net.JoinHostPort(host, port), nil
+}
@@ -0,0 +1,354 @@
+// Package backend manages the gateway's connections to the backend gRPC services
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	subchannelSeparator = "#"
+	roundRobin          = `{"loadBalancingConfig": [{"` + outlierBalancerName + `": {}}]}`
+)
+
+// Manager owns the connections to the backend services: one per address, shared by every service
+// hosted there and by all gateway components (grpc-gateway, the gRPC proxies, health checks), so
+// they are dialed, configured and instrumented in one place
+type Manager struct {
+	cfg          config.GRPCServicesConfig
+	interceptors *Interceptors
+	logger       logger.ZapLogger
+
+	mu        sync.Mutex
+	conns     map[string]*grpc.ClientConn
+	resolvers map[string]*manual.Resolver // by configured address, except discovery targets
+	reroutes  map[string]string           // runtime address changes
+	failovers map[string]string           // active failovers, overridden by reroutes
+	targets   map[string]string           // address each connection currently dials, when not its own
+	routed    map[string]*grpc.ClientConn // see RoutedConn, by configured and routed address
+
+	dialErrors sync.Map // last dial error by address, while its dials fail
+}
+
+// NewManager creates a connection manager dialing with the interceptor chain and TLS settings of each address
+func NewManager(cfg config.GRPCServicesConfig, interceptors *Interceptors, log logger.ZapLogger) *Manager {
+	return &Manager{
+		cfg:          cfg,
+		interceptors: interceptors,
+		logger:       log,
+		conns:        make(map[string]*grpc.ClientConn),
+		resolvers:    make(map[string]*manual.Resolver),
+		reroutes:     make(map[string]string),
+		failovers:    make(map[string]string),
+		targets:      make(map[string]string),
+		routed:       make(map[string]*grpc.ClientConn),
+	}
+}
+
+// Conn returns the connection to addr, dialing it on first use. Connections are lazy: they connect
+// on the first call and reconnect on their own.
+func (m *Manager) Conn(addr string) (*grpc.ClientConn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if conn, ok := m.conns[addr]; ok {
+		return conn, nil
+	}
+
+	conn, r, err := m.dial(addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+	if r != nil {
+		m.resolvers[addr] = r
+	}
+	m.logger.Info("dialed backend", zap.String("addr", addr), zap.Int("subchannels", max(m.cfg.Subchannels, 1)))
+	m.conns[addr] = conn
+	go m.watchState(addr, conn)
+
+	// Start connecting right away so unreachable backends show up as degraded before the first call
+	conn.Connect()
+	return conn, nil
+}
+
+// Reroute points the connection of the configured address addr at target ("host:port"), or back at addr
+// when target is empty, without re-registering anything: calls go to target once it's connected, and
+// the old connections are closed after the calls in flight on them complete. The TLS settings of addr
+// still apply.
+func (m *Manager) Reroute(addr, target string) error {
+	return m.setTarget(m.reroutes, addr, target)
+}
+
+// FailOver points the connection of addr at its fallback target, or back at addr when target is empty.
+// Reroutes take precedence over failovers.
+func (m *Manager) FailOver(addr, target string) error {
+	return m.setTarget(m.failovers, addr, target)
+}
+
+func (m *Manager) setTarget(targets map[string]string, addr, target string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.resolvers[addr]
+	if !ok {
+		return fmt.Errorf("no reroutable connection to %s", addr)
+	}
+	if target == "" || target == addr {
+		delete(targets, addr)
+	} else {
+		targets[addr] = target
+	}
+
+	target = addr
+	if failover, ok := m.failovers[addr]; ok {
+		target = failover
+	}
+	if reroute, ok := m.reroutes[addr]; ok {
+		target = reroute
+	}
+
+	current, ok := m.targets[addr]
+	if !ok {
+		current = addr
+	}
+	if current == target {
+		return nil
+	}
+
+	r.UpdateState(m.resolverState(target))
+	m.resolverUpdated(addr, nil)
+	if target == addr {
+		delete(m.targets, addr)
+	} else {
+		m.targets[addr] = target
+	}
+	m.logger.Warn("backend rerouted", zap.String("services", m.names(addr)), zap.String("addr", addr), zap.String("from", current), zap.String("target", target))
+	return nil
+}
+
+// Target returns the address the connection of addr currently dials
+func (m *Manager) Target(addr string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if target, ok := m.targets[addr]; ok {
+		return target
+	}
+	return addr
+}
+
+// Unavailable returns the addresses whose connection is failing (TRANSIENT_FAILURE); gRPC keeps
+// reconnecting to them in the background
+func (m *Manager) Unavailable() []string {
+	var addrs []string
+	for addr, conn := range m.Conns() {
+		if conn.GetState() == connectivity.TransientFailure {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// Connection is the state of the connection to a backend address
+type Connection struct {
+	Addr      string `json:"addr"`
+	Services  string `json:"services"`
+	Target    string `json:"target"` // address currently dialed, when rerouted or failed over
+	State     string `json:"state"`
+	LastError string `json:"last_error,omitempty"` // while its dials fail
+}
+
+// Connections returns the state of the connections dialed so far, sorted by address
+func (m *Manager) Connections() []Connection {
+	conns := m.Conns()
+	connections := make([]Connection, 0, len(conns))
+	for addr, conn := range conns {
+		c := Connection{Addr: addr, Services: m.names(addr), Target: m.Target(addr), State: conn.GetState().String()}
+		if err, ok := m.dialErrors.Load(addr); ok {
+			c.LastError = err.(error).Error()
+		}
+		connections = append(connections, c)
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].Addr < connections[j].Addr })
+	return connections
+}
+
+// errUnavailable fast-fails calls to backends that can't be reached, with a message fit for clients
+// rather than the connection error; it maps to a 503 envelope
+var errUnavailable = status.Error(codes.Unavailable, "service temporarily unavailable, please retry later")
+
+func unavailableUnary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if cc.GetState() == connectivity.TransientFailure {
+		return errUnavailable
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func unavailableStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if cc.GetState() == connectivity.TransientFailure {
+		return nil, errUnavailable
+	}
+	return streamer(ctx, desc, cc, method, opts...)
+}
+
+// Conns returns the connections dialed so far by address
+func (m *Manager) Conns() map[string]*grpc.ClientConn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conns := make(map[string]*grpc.ClientConn, len(m.conns))
+	for addr, conn := range m.conns {
+		conns[addr] = conn
+	}
+	return conns
+}
+
+// Close closes all connections
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for addr, conn := range m.conns {
+		conn.Close()
+		delete(m.conns, addr)
+	}
+	for key, conn := range m.routed {
+		conn.Close()
+		delete(m.routed, key)
+	}
+	return nil
+}
+
+// dial connects to addr over cfg.Subchannels HTTP/2 connections, balanced round robin, so busy
+// backends aren't limited by the concurrent stream limit of a single connection. The addresses are
+// fed by a manual resolver, which Reroute updates.
+func (m *Manager) dial(addr string) (*grpc.ClientConn, *manual.Resolver, error) {
+	chain, ok := m.cfg.Interceptors[addr]
+	if !ok {
+		chain = m.cfg.DefaultInterceptors
+	}
+	interceptors, err := m.interceptors.DialOptions(chain)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := append([]grpc.DialOption{
+		grpc.WithTransportCredentials(m.credentials(addr)),
+		m.keepalive(addr),
+		m.messageSize(addr),
+		m.callCredentials(addr),
+		grpc.WithChainUnaryInterceptor(unavailableUnary),
+		grpc.WithChainStreamInterceptor(unavailableStream),
+		grpc.WithContextDialer(m.subchannelDialer(addr)),
+		grpc.WithStatsHandler(rpcStats{addr: addr}),
+	}, append(m.compression(addr), interceptors...)...)
+	opts = append(opts, grpc.WithChainUnaryInterceptor(m.latencyUnary(addr)))
+
+	if isDiscoveryTarget(addr) {
+		// Discovered instances are balanced like subchannels
+		opts = append([]grpc.DialOption{grpc.WithDefaultServiceConfig(roundRobin), m.observedResolver(addr)}, opts...)
+		conn, err := grpc.NewClient(addr, opts...)
+		return conn, nil, err
+	}
+
+	r := manual.NewBuilderWithScheme("backend")
+	r.InitialState(m.resolverState(addr))
+
+	opts = append([]grpc.DialOption{
+		grpc.WithResolvers(r),
+		grpc.WithDefaultServiceConfig(roundRobin),
+	}, opts...)
+	conn, err := grpc.NewClient(r.Scheme()+":///"+addr, opts...)
+	return conn, r, err
+}
+
+// credentials returns the transport credentials of addr
+func (m *Manager) credentials(addr string) credentials.TransportCredentials {
+	tlsConfig, ok := m.cfg.TLS[addr]
+	if !ok {
+		tlsConfig = m.cfg.DefaultTLS
+	}
+	creds, err := TransportCredentials(tlsConfig)
+	if err != nil {
+		m.logger.Error("failed to load backend TLS credentials, retrying on connect", zap.String("addr", addr), zap.Error(err))
+		return &reloadingCredentials{cfg: tlsConfig}
+	}
+	return creds
+}
+
+// keepalive returns the keepalive parameters of addr; pings stay off unless configured
+func (m *Manager) keepalive(addr string) grpc.DialOption {
+	cfg, ok := m.cfg.Keepalive[addr]
+	if !ok {
+		cfg = m.cfg.DefaultKeepalive
+	}
+	if cfg.Time <= 0 {
+		return grpc.EmptyDialOption{}
+	}
+	return grpc.WithKeepaliveParams(keepalive.ClientParameters{
+		Time:                cfg.Time,
+		Timeout:             cfg.Timeout,
+		PermitWithoutStream: cfg.PermitWithoutStream,
+	})
+}
+
+// messageSize returns the message size limits of addr
+func (m *Manager) messageSize(addr string) grpc.DialOption {
+	cfg, ok := m.cfg.MessageSize[addr]
+	if !ok {
+		cfg = m.cfg.DefaultMessageSize
+	}
+
+	var callOpts []grpc.CallOption
+	if cfg.MaxRecvBytes > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(cfg.MaxRecvBytes))
+	}
+	if cfg.MaxSendBytes > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(cfg.MaxSendBytes))
+	}
+	if len(callOpts) == 0 {
+		return grpc.EmptyDialOption{}
+	}
+	return grpc.WithDefaultCallOptions(callOpts...)
+}
+
+// compression returns the interceptors gzipping the calls to addr, when enabled
+func (m *Manager) compression(addr string) []grpc.DialOption {
+	cfg, ok := m.cfg.Compression[addr]
+	if !ok {
+		cfg = m.cfg.DefaultCompression
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(compressionUnary(cfg)),
+		grpc.WithChainStreamInterceptor(compressionStream),
+	}
+}
+
+// resolverState returns the subchannel addresses of target. Balancers key subchannels by address, so
+// each copy gets a "#<n>" suffix the dialer strips; the authority stays target's.
+func (m *Manager) resolverState(target string) resolver.State {
+	addrs := make([]resolver.Address, max(m.cfg.Subchannels, 1))
+	for i := range addrs {
+		addrs[i] = resolver.Address{Addr: target + subchannelSeparator + strconv.Itoa(i), ServerName: target}
+	}
+	return resolver.State{Addresses: addrs}
+}
@@ -0,0 +1,177 @@
+package backend
+
+import (
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// outlierBalancerName is the round robin balancer of the backend connections, which skips ejected hosts
+const outlierBalancerName = "omnipos_outlier_round_robin"
+
+// RegisterOutlierDetection registers the balancer of the backend connections: round robin across the
+// ready subchannels, temporarily ejecting hosts (e.g. one bad order-service pod) whose error rate stands
+// out so they don't poison a share of all traffic. It must be called before the first connection is dialed.
+func RegisterOutlierDetection(cfg config.OutlierConfig, log logger.ZapLogger) {
+	tracker := &outlierTracker{cfg: cfg, logger: log, hosts: make(map[string]*hostStats)}
+	balancer.Register(base.NewBalancerBuilder(outlierBalancerName, &outlierPickerBuilder{tracker: tracker}, base.Config{}))
+}
+
+// outlierTracker keeps the error rate of every host across connections
+type outlierTracker struct {
+	cfg    config.OutlierConfig
+	logger logger.ZapLogger
+
+	mu    sync.Mutex
+	hosts map[string]*hostStats
+}
+
+type hostStats struct {
+	requests     int
+	failures     int
+	windowStart  time.Time
+	ejections    int // consecutive ejections, lengthening the next one
+	ejectedUntil time.Time
+}
+
+// record counts the outcome of a call to host, ejecting it at the end of an interval with too many failures
+func (t *outlierTracker) record(host string, err error) {
+	if !t.cfg.Enabled {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	stats, ok := t.hosts[host]
+	if !ok {
+		stats = &hostStats{windowStart: now}
+		t.hosts[host] = stats
+	}
+
+	stats.requests++
+	if isServerFailure(err) {
+		stats.failures++
+	}
+	if now.Sub(stats.windowStart) < t.cfg.Interval {
+		return
+	}
+
+	if stats.requests >= t.cfg.MinRequests && float64(stats.failures)/float64(stats.requests) >= t.cfg.FailureRatio {
+		stats.ejections++
+		ejection := t.cfg.BaseEjectionTime * time.Duration(min(stats.ejections, 10))
+		stats.ejectedUntil = now.Add(ejection)
+		t.logger.Warn("backend host ejected", zap.String("host", host), zap.Int("requests", stats.requests),
+			zap.Int("failures", stats.failures), zap.Duration("duration", ejection))
+		metrics.OutlierEjections.WithLabelValues(host).Inc()
+	} else if stats.ejections > 0 && now.After(stats.ejectedUntil) {
+		stats.ejections--
+	}
+	stats.requests, stats.failures, stats.windowStart = 0, 0, now
+}
+
+// ejected returns the ejected hosts among hosts, at most maxEjected of them
+func (t *outlierTracker) ejected(hosts []string, maxEjected int) map[string]bool {
+	if !t.cfg.Enabled || maxEjected <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var ejected map[string]bool
+	for _, host := range hosts {
+		if stats, ok := t.hosts[host]; ok && now.Before(stats.ejectedUntil) {
+			if ejected == nil {
+				ejected = make(map[string]bool)
+			}
+			ejected[host] = true
+			if len(ejected) == maxEjected {
+				break
+			}
+		}
+	}
+	return ejected
+}
+
+// isServerFailure reports whether a call failed because of the backend rather than the request
+func isServerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.Unknown, codes.DeadlineExceeded, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+type outlierPickerBuilder struct {
+	tracker *outlierTracker
+}
+
+func (b *outlierPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	p := &outlierPicker{tracker: b.tracker}
+	seen := make(map[string]bool)
+	for subConn, scInfo := range info.ReadySCs {
+		// Subchannel copies of an address are the same host
+		host, _, _ := strings.Cut(scInfo.Address.Addr, subchannelSeparator)
+		p.subConns = append(p.subConns, subConn)
+		p.hosts = append(p.hosts, host)
+		if !seen[host] {
+			seen[host] = true
+			p.distinct = append(p.distinct, host)
+		}
+	}
+	sort.Strings(p.distinct)
+	p.maxEjected = len(p.distinct) * b.tracker.cfg.MaxEjectionPercent / 100
+	p.next.Store(rand.Uint32())
+	return p
+}
+
+type outlierPicker struct {
+	tracker    *outlierTracker
+	subConns   []balancer.SubConn
+	hosts      []string // host of each subchannel
+	distinct   []string
+	maxEjected int
+	next       atomic.Uint32
+}
+
+func (p *outlierPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	n := uint32(len(p.subConns))
+	start := p.next.Add(1)
+	pick := start % n
+
+	if ejected := p.tracker.ejected(p.distinct, p.maxEjected); len(ejected) > 0 {
+		for i := uint32(0); i < n; i++ {
+			if candidate := (start + i) % n; !ejected[p.hosts[candidate]] {
+				pick = candidate
+				break
+			}
+		}
+	}
+
+	host := p.hosts[pick]
+	return balancer.PickResult{
+		SubConn: p.subConns[pick],
+		Done: func(info balancer.DoneInfo) {
+			p.tracker.record(host, info.Err)
+		},
+	}, nil
+}
@@ -0,0 +1,150 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// reroutesKey is a Redis hash of configured backend address -> address it's rerouted to at runtime
+const reroutesKey = "backends:reroutes"
+
+// Reroutes moves backends to new addresses at runtime, e.g. during a migration, without bouncing the
+// gateway. Reroutes are stored in Redis and applied by every instance on its next refresh.
+type Reroutes struct {
+	redisClient *cache.RedisClient
+	conns       *Manager
+	cfg         config.GRPCServicesConfig
+	logger      logger.ZapLogger
+}
+
+// NewReroutes creates the runtime backend reroutes of the connections of conns
+func NewReroutes(redisClient *cache.RedisClient, conns *Manager, cfg config.GRPCServicesConfig, log logger.ZapLogger) *Reroutes {
+	return &Reroutes{
+		redisClient: redisClient,
+		conns:       conns,
+		cfg:         cfg,
+		logger:      log,
+	}
+}
+
+// Run applies the reroutes stored in Redis until ctx is cancelled
+func (r *Reroutes) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.RerouteRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.refresh(ctx); err != nil {
+			r.logger.Error("failed to refresh backend reroutes", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh points every connection at its reroute, or back at its configured address
+func (r *Reroutes) refresh(ctx context.Context) error {
+	values, err := r.redisClient.Client.HGetAll(ctx, reroutesKey).Result()
+	if err != nil {
+		return err
+	}
+
+	for addr := range r.conns.Conns() {
+		if isDiscoveryTarget(addr) {
+			continue
+		}
+		if err := r.conns.Reroute(addr, values[addr]); err != nil {
+			r.logger.Warn("failed to reroute backend", zap.String("addr", addr), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// RegisterAdminRoutes registers the backend reroute management route
+func (r *Reroutes) RegisterAdminRoutes(mux *http.ServeMux, adminAuth func(http.Handler) http.Handler) {
+	mux.Handle("/admin/backends", adminAuth(http.HandlerFunc(r.serveBackends)))
+}
+
+// serveBackends lists the backends (GET), reroutes one (POST {"addr", "target"}) and restores its
+// configured address (DELETE ?addr=)
+func (r *Reroutes) serveBackends(w http.ResponseWriter, req *http.Request) {
+	ctx := req.Context()
+
+	switch req.Method {
+	case http.MethodGet:
+		type backendInfo struct {
+			Addr   string `json:"addr"`
+			Target string `json:"target"`
+			State  string `json:"state"`
+		}
+		var backends []backendInfo
+		for addr, conn := range r.conns.Conns() {
+			backends = append(backends, backendInfo{Addr: addr, Target: r.conns.Target(addr), State: conn.GetState().String()})
+		}
+		sort.Slice(backends, func(i, j int) bool { return backends[i].Addr < backends[j].Addr })
+		customRuntime.WriteResponse(w, http.StatusOK, "success", backends)
+
+	case http.MethodPost:
+		var body struct {
+			Addr   string `json:"addr"`   // configured address, e.g. "order-service:8083"
+			Target string `json:"target"` // new address, e.g. "order-service-v2.pos.svc:8083"
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid request body", nil)
+			return
+		}
+
+		if _, ok := r.conns.Conns()[body.Addr]; !ok || isDiscoveryTarget(body.Addr) {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "addr must be a configured backend address", nil)
+			return
+		}
+		if _, _, err := net.SplitHostPort(body.Target); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "target must be a host:port address", nil)
+			return
+		}
+
+		if err := r.redisClient.Client.HSet(ctx, reroutesKey, body.Addr, body.Target).Err(); err != nil {
+			r.logger.Error("failed to reroute backend", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to reroute backend", nil)
+			return
+		}
+
+		r.refreshAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+
+	case http.MethodDelete:
+		addr := req.URL.Query().Get("addr")
+		if err := r.redisClient.Client.HDel(ctx, reroutesKey, addr).Err(); err != nil {
+			r.logger.Error("failed to restore backend address", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to restore backend address", nil)
+			return
+		}
+
+		r.refreshAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+// refreshAfterChange applies a change on this instance immediately; others pick it up on their next refresh
+func (r *Reroutes) refreshAfterChange(ctx context.Context) {
+	if err := r.refresh(ctx); err != nil {
+		r.logger.Error("failed to refresh backend reroutes", zap.Error(err))
+	}
+}
@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// RoutedConn returns the connection to addr for the calls the backend router takes away from the
+// connection from (canaries, shadows, other generations of the service), dialing it on first use
+// with the TLS, keepalive, message size and credentials of the address from was dialed for, or of addr
+// itself when from is nil (shadows). The calls already went through the interceptors and compression
+// of from, so they don't run again.
+func (m *Manager) RoutedConn(from *grpc.ClientConn, addr string) (*grpc.ClientConn, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Unknown and nil connections get the settings of addr itself
+	configured, ok := m.configuredAddr(from)
+	if !ok {
+		configured = addr
+	}
+
+	key := configured + " " + addr
+	if conn, ok := m.routed[key]; ok {
+		return conn, nil
+	}
+
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(m.credentials(configured)),
+		m.keepalive(configured),
+		m.messageSize(configured),
+		m.callCredentials(configured),
+		grpc.WithStatsHandler(rpcStats{addr: addr}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+	m.logger.Info("dialed routed backend", zap.String("addr", addr), zap.String("services", m.names(configured)))
+	m.routed[key] = conn
+	return conn, nil
+}
+
+// ConfiguredAddr returns the address conn was dialed for by Conn, whatever it's rerouted to, so calls
+// routed to that address can stay on conn
+func (m *Manager) ConfiguredAddr(conn *grpc.ClientConn) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.configuredAddr(conn)
+}
+
+// configuredAddr is ConfiguredAddr with m.mu held
+func (m *Manager) configuredAddr(conn *grpc.ClientConn) (string, bool) {
+	if conn == nil {
+		return "", false
+	}
+	for addr, c := range m.conns {
+		if c == conn {
+			return addr, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,54 @@
+package backend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestManager_RoutedCalls(t *testing.T) {
+	log := logger.NewZapLogger(&logger.ZapLoggerConfig{})
+	RegisterOutlierDetection(config.OutlierConfig{}, log)
+	m := NewManager(config.GRPCServicesConfig{}, NewInterceptors(), log)
+	defer m.Close()
+
+	const addr = "127.0.0.1:18083"
+	cc, err := m.Conn(addr)
+	if err != nil {
+		t.Fatalf("Conn: %v", err)
+	}
+	if configured, ok := m.ConfiguredAddr(cc); !ok || configured != addr {
+		t.Fatalf("expected the connection to be dialed for %s, got %q", addr, configured)
+	}
+
+	interceptor := middleware.NewBackendRouter(config.CanaryConfig{}, config.ShadowConfig{}, nil, m, log).Unary()
+	invoked := false
+	invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		invoked = true
+		return nil
+	}
+	const method = "/order.v1.OrderService/GetOrder"
+
+	// A call pinned to the service's own address stays on its connection
+	ctx := middleware.WithBackend(context.Background(), addr)
+	if err := interceptor(ctx, method, &emptypb.Empty{}, &emptypb.Empty{}, cc, invoker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !invoked || len(m.routed) != 0 {
+		t.Errorf("expected the call to stay on the connection of %s, got %d routed connections", addr, len(m.routed))
+	}
+
+	// A call pinned to another generation gets its own connection
+	invoked = false
+	ctx, cancel := context.WithCancel(middleware.WithBackend(context.Background(), "127.0.0.1:18084"))
+	cancel()
+	_ = interceptor(ctx, method, &emptypb.Empty{}, &emptypb.Empty{}, cc, invoker)
+	if invoked || len(m.routed) != 1 {
+		t.Errorf("expected the call to be routed to 127.0.0.1:18084, got %d routed connections", len(m.routed))
+	}
+}
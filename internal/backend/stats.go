@@ -0,0 +1,185 @@
+package backend
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+var connectivityStates = []connectivity.State{
+	connectivity.Idle,
+	connectivity.Connecting,
+	connectivity.Ready,
+	connectivity.TransientFailure,
+	connectivity.Shutdown,
+}
+
+// watchState reports the state of the connection to addr until it's closed, warning when it starts
+// failing and when it recovers, so an unreachable backend stands out from a gateway problem
+func (m *Manager) watchState(addr string, conn *grpc.ClientConn) {
+	names := m.names(addr)
+	var failingSince time.Time
+
+	for previous := connectivity.State(-1); ; {
+		state := conn.GetState()
+		for _, s := range connectivityStates {
+			value := 0.0
+			if s == state {
+				value = 1
+			}
+			metrics.BackendConnectionState.WithLabelValues(addr, s.String()).Set(value)
+		}
+
+		if state != previous {
+			metrics.BackendStateTransitions.WithLabelValues(addr, names, state.String()).Inc()
+			switch {
+			case state == connectivity.TransientFailure && failingSince.IsZero():
+				failingSince = time.Now()
+				metrics.BackendFailingSince.WithLabelValues(addr, names).Set(float64(failingSince.Unix()))
+				fields := []zap.Field{zap.String("services", names), zap.String("addr", addr), zap.String("target", m.Target(addr))}
+				if err, ok := m.dialErrors.Load(addr); ok {
+					fields = append(fields, zap.Error(err.(error)))
+				}
+				m.logger.Warn("backend connection failing", fields...)
+			case state == connectivity.Ready && !failingSince.IsZero():
+				duration := time.Since(failingSince)
+				failingSince = time.Time{}
+				metrics.BackendFailingSince.WithLabelValues(addr, names).Set(0)
+				metrics.BackendFailureDuration.WithLabelValues(addr, names).Observe(duration.Seconds())
+				m.logger.Info("backend connection recovered", zap.String("services", names), zap.String("addr", addr), zap.Duration("failed_for", duration))
+			}
+			previous = state
+		}
+
+		if state == connectivity.Shutdown || !conn.WaitForStateChange(context.Background(), state) {
+			metrics.BackendFailingSince.WithLabelValues(addr, names).Set(0)
+			return
+		}
+	}
+}
+
+// subchannelDialer dials the subchannels of addr, counting the attempts and warning on the first
+// failure of a streak. Subchannel addresses carry a "#<n>" suffix the dialer strips.
+func (m *Manager) subchannelDialer(addr string) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, target string) (net.Conn, error) {
+		target, _, _ = strings.Cut(target, subchannelSeparator)
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", target)
+
+		if err != nil {
+			metrics.BackendDialAttempts.WithLabelValues(addr, "error").Inc()
+			if _, failing := m.dialErrors.Swap(addr, err); !failing {
+				m.logger.Warn("backend dial failed", zap.String("services", m.names(addr)), zap.String("addr", addr), zap.String("host", target), zap.Error(err))
+			}
+			return nil, err
+		}
+		metrics.BackendDialAttempts.WithLabelValues(addr, "success").Inc()
+		m.dialErrors.Delete(addr)
+		return conn, nil
+	}
+}
+
+// names returns the services hosted at addr, or addr itself for addresses that aren't a service's own
+// (version routes, canaries, shadows)
+func (m *Manager) names(addr string) string {
+	if names, ok := m.cfg.Names[addr]; ok {
+		return names
+	}
+	return addr
+}
+
+// resolverUpdated counts an address update of the connection to addr, warning on resolver errors
+// (e.g. a service without instances in the registry)
+func (m *Manager) resolverUpdated(addr string, err error) {
+	names := m.names(addr)
+	if err != nil {
+		metrics.BackendResolverUpdates.WithLabelValues(addr, names, "error").Inc()
+		m.logger.Warn("backend resolver error", zap.String("services", names), zap.String("addr", addr), zap.Error(err))
+		return
+	}
+	metrics.BackendResolverUpdates.WithLabelValues(addr, names, "update").Inc()
+}
+
+// observedResolver wraps the resolver registered for the scheme of the discovery target addr so its
+// updates are counted per connection
+func (m *Manager) observedResolver(addr string) grpc.DialOption {
+	scheme, _, _ := strings.Cut(addr, ":///")
+	builder := resolver.Get(scheme)
+	if builder == nil {
+		return grpc.EmptyDialOption{}
+	}
+	return grpc.WithResolvers(observedBuilder{Builder: builder, manager: m, addr: addr})
+}
+
+type observedBuilder struct {
+	resolver.Builder
+	manager *Manager
+	addr    string
+}
+
+func (b observedBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	return b.Builder.Build(target, observedClientConn{ClientConn: cc, manager: b.manager, addr: b.addr}, opts)
+}
+
+type observedClientConn struct {
+	resolver.ClientConn
+	manager *Manager
+	addr    string
+}
+
+func (c observedClientConn) UpdateState(state resolver.State) error {
+	err := c.ClientConn.UpdateState(state)
+	c.manager.resolverUpdated(c.addr, err)
+	return err
+}
+
+func (c observedClientConn) ReportError(err error) {
+	c.manager.resolverUpdated(c.addr, err)
+	c.ClientConn.ReportError(err)
+}
+
+// latencyUnary observes the latency of the calls to addr, and adds it to the Server-Timing of the request. It's the innermost interceptor, so each
+// retry or hedge attempt is observed on its own, without the time spent in the gateway's interceptors.
+// Streams are long-lived and aren't observed.
+func (m *Manager) latencyUnary(addr string) grpc.UnaryClientInterceptor {
+	names := m.names(addr)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		elapsed := time.Since(start)
+		metrics.Observe(ctx, metrics.BackendRequestDuration.WithLabelValues(names, method), elapsed.Seconds())
+		middleware.RecordServerTiming(ctx, middleware.TimingBackend, elapsed)
+		return err
+	}
+}
+
+// rpcStats counts the calls that reached the backend at addr by status code
+type rpcStats struct {
+	addr string
+}
+
+func (s rpcStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (s rpcStats) HandleRPC(_ context.Context, rs stats.RPCStats) {
+	if end, ok := rs.(*stats.End); ok {
+		metrics.BackendRequests.WithLabelValues(s.addr, status.Code(end.Error).String()).Inc()
+	}
+}
+
+func (s rpcStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (s rpcStats) HandleConn(context.Context, stats.ConnStats) {}
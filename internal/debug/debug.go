@@ -0,0 +1,29 @@
+// Package debug serves runtime profiling and debug endpoints (pprof, expvar, goroutine dumps) for
+// investigating production issues without rebuilding the binary
+package debug
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+)
+
+// RegisterRoutes registers the debug endpoints behind adminAuth:
+//   - /debug/pprof/ profiles (heap, allocs, goroutine, profile?seconds=30, trace, ...)
+//   - /debug/vars expvar (memstats, cmdline)
+//   - /debug/goroutines a full dump of all goroutine stacks
+func RegisterRoutes(mux *http.ServeMux, adminAuth func(http.Handler) http.Handler) {
+	mux.Handle("/debug/pprof/", adminAuth(http.HandlerFunc(pprof.Index)))
+	mux.Handle("/debug/pprof/cmdline", adminAuth(http.HandlerFunc(pprof.Cmdline)))
+	mux.Handle("/debug/pprof/profile", adminAuth(http.HandlerFunc(pprof.Profile)))
+	mux.Handle("/debug/pprof/symbol", adminAuth(http.HandlerFunc(pprof.Symbol)))
+	mux.Handle("/debug/pprof/trace", adminAuth(http.HandlerFunc(pprof.Trace)))
+	mux.Handle("/debug/vars", adminAuth(expvar.Handler()))
+	mux.Handle("/debug/goroutines", adminAuth(http.HandlerFunc(serveGoroutines)))
+}
+
+func serveGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_ = runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+}
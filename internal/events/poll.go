@@ -0,0 +1,248 @@
+// Package events delivers order and payment events to clients that can't keep a WebSocket or SSE
+// connection open (e.g. behind restrictive proxies) through long polling
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-gateway/internal/security"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Events of a merchant are appended to a capped Redis stream "events:backlog:<merchant>" (fields "type"
+// and "event") and announced on the pub/sub channel "events:live:<merchant>". Backends can publish
+// events the same way.
+const (
+	backlogPrefix = "events:backlog:"
+	livePrefix    = "events:live:"
+)
+
+// cursorPattern matches the Redis stream IDs used as cursors, e.g. "1718000000000-0"
+var cursorPattern = regexp.MustCompile(`^[0-9]+(-[0-9]+)?$`)
+
+// Hub publishes events and holds poll requests open until an event of their merchant is announced.
+// Each gateway instance keeps a single pattern subscription and wakes its own waiting polls.
+type Hub struct {
+	redisClient *cache.RedisClient
+	jwtHelper   *middleware.JWTHelper
+	cfg         config.EventsConfig
+	logger      logger.ZapLogger
+
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+}
+
+// NewHub creates a new event hub
+func NewHub(redisClient *cache.RedisClient, jwtHelper *middleware.JWTHelper, cfg config.EventsConfig, log logger.ZapLogger) *Hub {
+	return &Hub{
+		redisClient: redisClient,
+		jwtHelper:   jwtHelper,
+		cfg:         cfg,
+		logger:      log,
+		waiters:     make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// Publish appends an event to the merchant's backlog and wakes the polls waiting for it
+func (h *Hub) Publish(ctx context.Context, merchantID, eventType string, event []byte) error {
+	key := backlogPrefix + merchantID
+
+	pipe := h.redisClient.Client.TxPipeline()
+	id := pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: int64(h.cfg.BacklogSize),
+		Approx: true,
+		Values: map[string]interface{}{"type": eventType, "event": event},
+	})
+	pipe.Expire(ctx, key, h.cfg.BacklogTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	return h.redisClient.Client.Publish(ctx, livePrefix+merchantID, id.Val()).Err()
+}
+
+// Run wakes the local polls of the merchants events are announced for, until ctx is cancelled
+func (h *Hub) Run(ctx context.Context) {
+	sub := h.redisClient.Client.PSubscribe(ctx, livePrefix+"*")
+	// Closing the subscription closes its channel, which ends the loop
+	go func() {
+		<-ctx.Done()
+		sub.Close()
+	}()
+
+	for msg := range sub.Channel() {
+		h.notify(strings.TrimPrefix(msg.Channel, livePrefix))
+	}
+}
+
+func (h *Hub) notify(merchantID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.waiters[merchantID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (h *Hub) wait(merchantID string) (chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	if h.waiters[merchantID] == nil {
+		h.waiters[merchantID] = make(map[chan struct{}]struct{})
+	}
+	h.waiters[merchantID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.waiters[merchantID], ch)
+		if len(h.waiters[merchantID]) == 0 {
+			delete(h.waiters, merchantID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// RegisterRoutes registers the poll route, for authenticated merchants
+func (h *Hub) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc(h.cfg.PollPath, h.servePoll)
+}
+
+// servePoll returns the events after ?cursor= (a previous response's cursor), waiting up to ?timeout=
+// (e.g. "25s") for one when there are none yet. ?types= filters by comma-separated event types.
+// Without a cursor, only events published after the request are returned.
+func (h *Hub) servePoll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	claims, err := h.jwtHelper.RequestClaims(r)
+	if err != nil {
+		middleware.RecordSecurityEvent(r, security.AuthFailure, "events", "missing or invalid authorization header", "")
+		customRuntime.WriteResponse(w, http.StatusUnauthorized, "missing or invalid authorization header", nil)
+		return
+	}
+	merchantID := claims.MerchantID
+
+	query := r.URL.Query()
+	cursor := query.Get("cursor")
+	if cursor != "" && !cursorPattern.MatchString(cursor) {
+		customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid cursor", nil)
+		return
+	}
+
+	timeout := h.cfg.DefaultWait
+	if value := query.Get("timeout"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid timeout", nil)
+			return
+		}
+	}
+	timeout = min(timeout, h.cfg.MaxWait)
+
+	// Polls outlive the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second)); err != nil {
+		h.logger.Debug("failed to extend poll write deadline", zap.Error(err))
+	}
+
+	var types map[string]bool
+	if value := query.Get("types"); value != "" {
+		types = make(map[string]bool)
+		for _, t := range strings.Split(value, ",") {
+			types[strings.TrimSpace(t)] = true
+		}
+	}
+
+	// Register before reading the backlog so an event published in between isn't missed
+	wake, done := h.wait(merchantID)
+	defer done()
+
+	ctx := r.Context()
+	key := backlogPrefix + merchantID
+	if cursor == "" {
+		cursor = h.latestID(ctx, key)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		events, next, err := h.read(ctx, key, cursor, types)
+		if err != nil {
+			h.logger.Error("failed to read events", zap.String("merchant_id", merchantID), zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to read events", nil)
+			return
+		}
+		cursor = next
+		if len(events) > 0 {
+			h.writeEvents(w, events, cursor)
+			return
+		}
+
+		select {
+		case <-wake:
+		case <-deadline.C:
+			h.writeEvents(w, events, cursor)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// read returns the events after cursor and the cursor to continue from
+func (h *Hub) read(ctx context.Context, key, cursor string, types map[string]bool) ([]json.RawMessage, string, error) {
+	messages, err := h.redisClient.Client.XRangeN(ctx, key, "("+cursor, "+", int64(h.cfg.BacklogSize)).Result()
+	if err != nil {
+		return nil, cursor, err
+	}
+
+	events := make([]json.RawMessage, 0, len(messages))
+	for _, msg := range messages {
+		cursor = msg.ID
+		if eventType, _ := msg.Values["type"].(string); types != nil && !types[eventType] {
+			continue
+		}
+		if event, ok := msg.Values["event"].(string); ok && json.Valid([]byte(event)) {
+			events = append(events, json.RawMessage(event))
+		}
+	}
+	return events, cursor, nil
+}
+
+// latestID returns the ID of the merchant's latest event, or "0-0" for an empty backlog
+func (h *Hub) latestID(ctx context.Context, key string) string {
+	messages, err := h.redisClient.Client.XRevRangeN(ctx, key, "+", "-", 1).Result()
+	if err != nil || len(messages) == 0 {
+		return "0-0"
+	}
+	return messages[0].ID
+}
+
+func (h *Hub) writeEvents(w http.ResponseWriter, events []json.RawMessage, cursor string) {
+	w.Header().Set("Cache-Control", "no-store")
+	customRuntime.WriteResponse(w, http.StatusOK, "success", map[string]interface{}{
+		"events": events,
+		"cursor": cursor,
+	})
+}
@@ -0,0 +1,379 @@
+// Package graphql exposes the read methods of the gRPC services as a GraphQL API, so clients can
+// fetch the data of a whole screen in one round trip. Top-level fields are resolved concurrently by a
+// bounded number of workers, and each backend call after the first is charged to the rate limiter and
+// quota that admitted the request.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/grpcproxy"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/validator"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Handler serves GraphQL queries on the configured path and the generated schema on <path>/schema
+type Handler struct {
+	proxy  *grpcproxy.Proxy
+	cfg    config.GraphQLConfig
+	schema *ast.Schema
+	sdl    string
+	fields map[string]queryField
+	logger logger.ZapLogger
+}
+
+type request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type response struct {
+	Data   interface{}   `json:"data,omitempty"`
+	Errors gqlerror.List `json:"errors,omitempty"`
+}
+
+// NewHandler generates the schema from the configured services
+func NewHandler(proxy *grpcproxy.Proxy, cfg config.GraphQLConfig, log logger.ZapLogger) (*Handler, error) {
+	builder := newSchemaBuilder()
+	for _, service := range cfg.Services {
+		if err := builder.addService(service); err != nil {
+			log.Warn("skipping graphql service", zap.String("service", service), zap.Error(err))
+		}
+	}
+	if len(builder.fields) == 0 {
+		return nil, errors.New("no queryable methods found in the configured services")
+	}
+
+	sdl := builder.sdl()
+	schema, err := gqlparser.LoadSchema(&ast.Source{Name: "omnipos.graphql", Input: sdl})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Handler{
+		proxy:  proxy,
+		cfg:    cfg,
+		schema: schema,
+		sdl:    sdl,
+		fields: builder.fields,
+		logger: log,
+	}, nil
+}
+
+// RegisterRoutes registers the GraphQL endpoint and the schema route
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc(h.cfg.Path, h.serveQuery)
+	mux.HandleFunc(h.cfg.Path+"/schema", h.serveSchema)
+}
+
+func (h *Handler) serveSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(h.sdl))
+}
+
+func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request) {
+	var req request
+
+	switch r.Method {
+	case http.MethodGet:
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid variables", nil)
+				return
+			}
+		}
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid request body", nil)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	doc, errs := gqlparser.LoadQueryWithRules(h.schema, req.Query, nil)
+	if len(errs) > 0 {
+		writeJSON(w, http.StatusBadRequest, response{Errors: errs})
+		return
+	}
+
+	op := doc.Operations.ForName(req.OperationName)
+	if op == nil {
+		writeJSON(w, http.StatusBadRequest, response{Errors: gqlerror.List{gqlerror.Errorf("operation %q not found", req.OperationName)}})
+		return
+	}
+
+	vars, err := validator.VariableValues(h.schema, op, req.Variables)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, response{Errors: gqlerror.List{gqlerror.WrapIfUnwrapped(err)}})
+		return
+	}
+
+	fields := collectFields(doc, op.SelectionSet, vars)
+	if h.cfg.MaxFields > 0 && len(fields) > h.cfg.MaxFields {
+		writeJSON(w, http.StatusBadRequest, response{Errors: gqlerror.List{gqlerror.Errorf("query selects %d top-level fields, more than the maximum of %d", len(fields), h.cfg.MaxFields)}})
+		return
+	}
+	if h.cfg.MaxDepth > 0 && selectionDepth(doc, op.SelectionSet, vars, h.cfg.MaxDepth) > h.cfg.MaxDepth {
+		writeJSON(w, http.StatusBadRequest, response{Errors: gqlerror.List{gqlerror.Errorf("query is nested deeper than the maximum of %d", h.cfg.MaxDepth)}})
+		return
+	}
+
+	ctx := h.proxy.OutgoingContext(r.Context(), r)
+	data, errs := h.execute(ctx, doc, fields, vars)
+	writeJSON(w, http.StatusOK, response{Data: data, Errors: errs})
+}
+
+// execute resolves the top-level fields, one backend call each, with up to cfg.Concurrency at a time.
+// The request paid for the first call; the others are charged as they're made, and fail with
+// RESOURCE_EXHAUSTED once the caller's rate limit or quota runs out.
+func (h *Handler) execute(ctx context.Context, doc *ast.QueryDocument, fields []*ast.Field, vars map[string]interface{}) (*orderedMap, gqlerror.List) {
+	values := make([]interface{}, len(fields))
+	fieldErrs := make([]*gqlerror.Error, len(fields))
+
+	type call struct {
+		i      int
+		charge bool
+	}
+	calls := make(chan call)
+	var wg sync.WaitGroup
+	for range min(max(h.cfg.Concurrency, 1), len(fields)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range calls {
+				field := fields[c.i]
+				if c.charge {
+					if err := middleware.ChargeCall(ctx); err != nil {
+						fieldErrs[c.i] = fieldError(field, status.Error(codes.ResourceExhausted, err.Error()))
+						continue
+					}
+				}
+				value, err := h.resolve(ctx, field, vars)
+				if err != nil {
+					fieldErrs[c.i] = fieldError(field, err)
+					continue
+				}
+				values[c.i] = project(doc, value, field.SelectionSet, vars)
+			}
+		}()
+	}
+
+	charge := false
+	for i, field := range fields {
+		if field.Name == "__typename" {
+			values[i] = "Query"
+			continue
+		}
+		calls <- call{i: i, charge: charge}
+		charge = true
+	}
+	close(calls)
+	wg.Wait()
+
+	data := &orderedMap{}
+	var errs gqlerror.List
+	for i, field := range fields {
+		data.set(field.Alias, values[i])
+		if fieldErrs[i] != nil {
+			errs = append(errs, fieldErrs[i])
+		}
+	}
+	return data, errs
+}
+
+// resolve calls the field's gRPC method and returns the response in its JSON form
+func (h *Handler) resolve(ctx context.Context, field *ast.Field, vars map[string]interface{}) (interface{}, error) {
+	qf := h.fields[field.Name]
+
+	args, err := json.Marshal(field.ArgumentMap(vars))
+	if err != nil {
+		return nil, err
+	}
+
+	req := dynamicpb.NewMessage(qf.input)
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(args, req); err != nil {
+		return nil, err
+	}
+
+	resp := dynamicpb.NewMessage(qf.output)
+	if err := h.proxy.Invoke(ctx, qf.method, req, resp); err != nil {
+		return nil, err
+	}
+
+	body, err := protojson.MarshalOptions{EmitUnpopulated: true, UseProtoNames: true}.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func fieldError(field *ast.Field, err error) *gqlerror.Error {
+	gqlErr := gqlerror.ErrorPathf(ast.Path{ast.PathName(field.Alias)}, "%s", err.Error())
+	if st, ok := status.FromError(err); ok {
+		gqlErr.Message = st.Message()
+		gqlErr.Extensions = map[string]interface{}{"code": st.Code().String()}
+	}
+	return gqlErr
+}
+
+// project keeps the selected fields of a JSON value, in selection order
+func project(doc *ast.QueryDocument, value interface{}, selection ast.SelectionSet, vars map[string]interface{}) interface{} {
+	if len(selection) == 0 || value == nil {
+		return value
+	}
+
+	switch v := value.(type) {
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = project(doc, item, selection, vars)
+		}
+		return items
+
+	case map[string]interface{}:
+		result := &orderedMap{}
+		for _, field := range collectFields(doc, selection, vars) {
+			if field.Name == "__typename" {
+				result.set(field.Alias, field.ObjectDefinition.Name)
+				continue
+			}
+			result.set(field.Alias, project(doc, v[field.Name], field.SelectionSet, vars))
+		}
+		return result
+
+	default:
+		return value
+	}
+}
+
+// collectFields flattens fragments and applies @skip/@include
+func collectFields(doc *ast.QueryDocument, selection ast.SelectionSet, vars map[string]interface{}) []*ast.Field {
+	var fields []*ast.Field
+	seen := make(map[string]bool)
+
+	var collect func(ast.SelectionSet)
+	collect = func(selection ast.SelectionSet) {
+		for _, sel := range selection {
+			switch s := sel.(type) {
+			case *ast.Field:
+				if !included(s.Directives, vars) || seen[s.Alias] {
+					continue
+				}
+				seen[s.Alias] = true
+				fields = append(fields, s)
+			case *ast.InlineFragment:
+				if included(s.Directives, vars) {
+					collect(s.SelectionSet)
+				}
+			case *ast.FragmentSpread:
+				if fragment := doc.Fragments.ForName(s.Name); fragment != nil && included(s.Directives, vars) {
+					collect(fragment.SelectionSet)
+				}
+			}
+		}
+	}
+	collect(selection)
+	return fields
+}
+
+// selectionDepth returns how deeply the fields of selection nest, descending no further than
+// past limit levels
+func selectionDepth(doc *ast.QueryDocument, selection ast.SelectionSet, vars map[string]interface{}, limit int) int {
+	depth := 0
+	for _, field := range collectFields(doc, selection, vars) {
+		d := 1
+		if len(field.SelectionSet) > 0 {
+			if limit <= 1 {
+				return 2
+			}
+			d += selectionDepth(doc, field.SelectionSet, vars, limit-1)
+		}
+		depth = max(depth, d)
+	}
+	return depth
+}
+
+func included(directives ast.DirectiveList, vars map[string]interface{}) bool {
+	if d := directives.ForName("skip"); d != nil {
+		if skip, _ := d.ArgumentMap(vars)["if"].(bool); skip {
+			return false
+		}
+	}
+	if d := directives.ForName("include"); d != nil {
+		if include, _ := d.ArgumentMap(vars)["if"].(bool); !include {
+			return false
+		}
+	}
+	return true
+}
+
+// orderedMap is a JSON object that keeps insertion order, as GraphQL responses follow the query order
+type orderedMap struct {
+	keys   []string
+	values []interface{}
+}
+
+func (m *orderedMap) set(key string, value interface{}) {
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, value)
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+
+		value, err := json.Marshal(m.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
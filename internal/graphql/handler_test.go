@@ -0,0 +1,43 @@
+package graphql
+
+import (
+	"testing"
+
+	"github.com/vektah/gqlparser/v2"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+func TestSelectionDepth(t *testing.T) {
+	schema := gqlparser.MustLoadSchema(&ast.Source{Input: `
+		type Query { order(id: ID): Order }
+		type Order { id: ID customer: Customer items: [Item] }
+		type Customer { id: ID name: String }
+		type Item { sku: String customer: Customer }
+	`})
+
+	tests := []struct {
+		name  string
+		query string
+		limit int
+		want  int
+	}{
+		{"scalar", `{ __typename }`, 10, 1},
+		{"nested", `{ order(id: 1) { id customer { name } } }`, 10, 3},
+		{"deepest branch", `{ order { id items { customer { name } } } a: order { id } }`, 10, 4},
+		{"fragment", `{ order { ...o } } fragment o on Order { items { sku } }`, 10, 3},
+		{"skipped", `{ order { items @skip(if: true) { customer { name } } id } }`, 10, 2},
+		{"over the limit", `{ order { items { customer { name } } } }`, 2, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, errs := gqlparser.LoadQuery(schema, tt.query)
+			if len(errs) > 0 {
+				t.Fatalf("invalid query: %v", errs)
+			}
+			op := doc.Operations[0]
+			if got := selectionDepth(doc, op.SelectionSet, nil, tt.limit); got != tt.want {
+				t.Errorf("expected depth %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
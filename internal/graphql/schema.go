@@ -0,0 +1,231 @@
+package graphql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// queryField is a Query field resolved by a unary gRPC method
+type queryField struct {
+	method string // full gRPC method name, e.g. "/product.v1.ProductService/ListProducts"
+	input  protoreflect.MessageDescriptor
+	output protoreflect.MessageDescriptor
+}
+
+// schemaBuilder generates GraphQL SDL from proto descriptors. Every unary method bound to an HTTP GET
+// becomes a Query field, its request fields become arguments and its response becomes an object type.
+// Field names are the proto names, same as the JSON API.
+type schemaBuilder struct {
+	fields map[string]queryField
+	types  map[protoreflect.FullName]string // message -> GraphQL type name
+	names  map[string]protoreflect.FullName // GraphQL type name -> message
+	order  []protoreflect.MessageDescriptor
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{
+		fields: make(map[string]queryField),
+		types:  make(map[protoreflect.FullName]string),
+		names:  make(map[string]protoreflect.FullName),
+	}
+}
+
+// addService adds the read methods of a service, e.g. "product.v1.ProductService"
+func (b *schemaBuilder) addService(name string) error {
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return fmt.Errorf("service %s not found: %w", name, err)
+	}
+
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a service", name)
+	}
+
+	methods := service.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		if method.IsStreamingClient() || method.IsStreamingServer() || !isGetMethod(method) {
+			continue
+		}
+
+		fieldName := lowerFirst(string(method.Name()))
+		if _, taken := b.fields[fieldName]; taken {
+			fieldName = lowerFirst(string(service.Name())) + string(method.Name())
+		}
+
+		b.fields[fieldName] = queryField{
+			method: fmt.Sprintf("/%s/%s", service.FullName(), method.Name()),
+			input:  method.Input(),
+			output: method.Output(),
+		}
+		b.objectType(method.Output())
+	}
+	return nil
+}
+
+// sdl renders the schema
+func (b *schemaBuilder) sdl() string {
+	var sb strings.Builder
+	sb.WriteString("scalar JSON\n\n")
+
+	names := make([]string, 0, len(b.fields))
+	for name := range b.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sb.WriteString("type Query {\n")
+	for _, name := range names {
+		field := b.fields[name]
+		fmt.Fprintf(&sb, "  %s%s: %s\n", name, b.arguments(field.input), b.objectType(field.output))
+	}
+	sb.WriteString("}\n")
+
+	// objectType may register more types while rendering, so iterate by index
+	for i := 0; i < len(b.order); i++ {
+		msg := b.order[i]
+		fmt.Fprintf(&sb, "\ntype %s {\n", b.types[msg.FullName()])
+
+		fields := msg.Fields()
+		for j := 0; j < fields.Len(); j++ {
+			field := fields.Get(j)
+			fmt.Fprintf(&sb, "  %s: %s\n", field.Name(), b.outputType(field))
+		}
+		sb.WriteString("}\n")
+	}
+
+	return sb.String()
+}
+
+func (b *schemaBuilder) arguments(msg protoreflect.MessageDescriptor) string {
+	fields := msg.Fields()
+	if fields.Len() == 0 {
+		return ""
+	}
+
+	args := make([]string, 0, fields.Len())
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		args = append(args, fmt.Sprintf("%s: %s", field.Name(), inputType(field)))
+	}
+	return "(" + strings.Join(args, ", ") + ")"
+}
+
+func (b *schemaBuilder) outputType(field protoreflect.FieldDescriptor) string {
+	if field.IsMap() {
+		return "JSON"
+	}
+
+	typ := scalarType(field)
+	if field.Kind() == protoreflect.MessageKind || field.Kind() == protoreflect.GroupKind {
+		typ = b.objectType(field.Message())
+	}
+
+	if field.IsList() {
+		return "[" + typ + "]"
+	}
+	return typ
+}
+
+// objectType returns the GraphQL type of a message, registering it on first use
+func (b *schemaBuilder) objectType(msg protoreflect.MessageDescriptor) string {
+	if typ, ok := wellKnownType(msg); ok {
+		return typ
+	}
+	if msg.Fields().Len() == 0 {
+		// GraphQL object types need at least one field
+		return "JSON"
+	}
+	if name, ok := b.types[msg.FullName()]; ok {
+		return name
+	}
+
+	name := string(msg.Name())
+	if _, taken := b.names[name]; taken {
+		name = strings.ReplaceAll(string(msg.FullName()), ".", "_")
+	}
+
+	b.types[msg.FullName()] = name
+	b.names[name] = msg.FullName()
+	b.order = append(b.order, msg)
+	return name
+}
+
+func inputType(field protoreflect.FieldDescriptor) string {
+	if field.IsMap() || field.Kind() == protoreflect.MessageKind || field.Kind() == protoreflect.GroupKind {
+		if typ, ok := wellKnownType(field.Message()); ok && !field.IsMap() {
+			return listOf(field, typ)
+		}
+		// Nested inputs are passed as their JSON form
+		return "JSON"
+	}
+	return listOf(field, scalarType(field))
+}
+
+func listOf(field protoreflect.FieldDescriptor, typ string) string {
+	if field.IsList() {
+		return "[" + typ + "]"
+	}
+	return typ
+}
+
+// scalarType maps a proto scalar to the GraphQL scalar matching its protojson form;
+// 64-bit integers are strings in protojson
+func scalarType(field protoreflect.FieldDescriptor) string {
+	switch field.Kind() {
+	case protoreflect.BoolKind:
+		return "Boolean"
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return "Int"
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return "Float"
+	default:
+		// strings, bytes (base64), enums (value names) and 64-bit integers
+		return "String"
+	}
+}
+
+// wellKnownType maps the well-known types with a non-object JSON form
+func wellKnownType(msg protoreflect.MessageDescriptor) (string, bool) {
+	switch msg.FullName() {
+	case "google.protobuf.Timestamp", "google.protobuf.Duration", "google.protobuf.FieldMask",
+		"google.protobuf.StringValue", "google.protobuf.BytesValue",
+		"google.protobuf.Int64Value", "google.protobuf.UInt64Value":
+		return "String", true
+	case "google.protobuf.BoolValue":
+		return "Boolean", true
+	case "google.protobuf.Int32Value", "google.protobuf.UInt32Value":
+		return "Int", true
+	case "google.protobuf.FloatValue", "google.protobuf.DoubleValue":
+		return "Float", true
+	case "google.protobuf.Struct", "google.protobuf.Value", "google.protobuf.ListValue",
+		"google.protobuf.Any", "google.protobuf.Empty":
+		return "JSON", true
+	}
+	return "", false
+}
+
+func isGetMethod(method protoreflect.MethodDescriptor) bool {
+	opts := method.Options()
+	if opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
+		return false
+	}
+
+	rule, ok := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+	return ok && rule != nil && rule.GetGet() != ""
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToLower(s[:1]) + s[1:]
+}
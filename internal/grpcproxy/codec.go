@@ -0,0 +1,47 @@
+package grpcproxy
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+)
+
+// frame is a serialized message passed through the proxy without being decoded
+type frame struct {
+	payload []byte
+}
+
+// rawCodec passes frames through untouched and marshals other messages as protobuf.
+// It keeps the "proto" name so backends see the usual application/grpc+proto content type.
+type rawCodec struct{}
+
+var _ encoding.Codec = rawCodec{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case *frame:
+		return m.payload, nil
+	case proto.Message:
+		return proto.Marshal(m)
+	default:
+		return nil, fmt.Errorf("grpcproxy: unexpected message type %T", v)
+	}
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case *frame:
+		// data may be reused by the transport after Unmarshal returns
+		m.payload = append(m.payload[:0], data...)
+		return nil
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	default:
+		return fmt.Errorf("grpcproxy: unexpected message type %T", v)
+	}
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
@@ -0,0 +1,265 @@
+package grpcproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// trailerFlag marks the frame carrying the trailers at the end of a gRPC-Web response
+	trailerFlag = 0x80
+	// compressedFlag marks a compressed message, which the proxy doesn't support
+	compressedFlag = 0x01
+)
+
+// GRPCWeb serves gRPC-Web requests (negotiated by Content-Type) by forwarding them as native gRPC
+// calls to the backends, so browsers can call unary and server-streaming RPCs without the JSON
+// translation. Other requests are passed to next.
+func (p *Proxy) GRPCWeb(cfg config.GRPCWebConfig) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			contentType := r.Header.Get("Content-Type")
+			if r.Method != http.MethodPost || !strings.HasPrefix(contentType, grpcWebContentType) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			text := strings.HasPrefix(contentType, grpcWebTextContentType)
+			p.serveGRPCWeb(w, r, text, cfg.MaxMessageBytes)
+		})
+	}
+}
+
+func (p *Proxy) serveGRPCWeb(w http.ResponseWriter, r *http.Request, text bool, maxMessageBytes int) {
+	ctx := r.Context()
+	if timeout, ok := parseTimeout(r.Header.Get("Grpc-Timeout")); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx = p.OutgoingContext(ctx, r)
+
+	if text {
+		w.Header().Set("Content-Type", grpcWebTextContentType+"+proto")
+	} else {
+		w.Header().Set("Content-Type", grpcWebContentType+"+proto")
+	}
+
+	rw := &grpcWebWriter{w: w, rc: http.NewResponseController(w), text: text}
+
+	var body io.Reader = r.Body
+	if text {
+		body = base64.NewDecoder(base64.StdEncoding, r.Body)
+	}
+
+	messages, err := readFrames(bufio.NewReader(body), maxMessageBytes)
+	if err != nil {
+		rw.writeTrailers(nil, status.Convert(err))
+		return
+	}
+
+	conn, err := p.conn(r.URL.Path)
+	if err != nil {
+		rw.writeTrailers(nil, status.Convert(err))
+		return
+	}
+
+	stream, err := conn.NewStream(ctx, streamDesc, r.URL.Path, rawCall)
+	if err != nil {
+		rw.writeTrailers(nil, status.Convert(err))
+		return
+	}
+
+	for _, msg := range messages {
+		if err := stream.SendMsg(msg); err != nil {
+			// The real error is returned by RecvMsg
+			break
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		p.logger.Debug("grpc-web close send failed", zap.Error(err))
+	}
+
+	header, err := stream.Header()
+	if err != nil {
+		rw.writeTrailers(nil, status.Convert(err))
+		return
+	}
+	rw.writeHeader(header)
+
+	for {
+		msg := &frame{}
+		if err := stream.RecvMsg(msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				rw.writeTrailers(stream.Trailer(), status.New(codes.OK, ""))
+			} else {
+				rw.writeTrailers(stream.Trailer(), status.Convert(err))
+			}
+			return
+		}
+
+		if err := rw.writeFrame(0, msg.payload); err != nil {
+			// Client went away; the request context cancels the backend stream
+			p.logger.Debug("grpc-web write failed", zap.Error(err))
+			return
+		}
+	}
+}
+
+// readFrames reads the length-prefixed messages of a request body. The declared length of each
+// message is checked against maxBytes before its payload is allocated.
+func readFrames(r io.Reader, maxBytes int) ([]*frame, error) {
+	var frames []*frame
+	var prefix [5]byte
+
+	for {
+		if _, err := io.ReadFull(r, prefix[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return frames, nil
+			}
+			return nil, status.Errorf(codes.InvalidArgument, "malformed grpc-web frame: %v", err)
+		}
+
+		if prefix[0]&compressedFlag != 0 {
+			return nil, status.Error(codes.InvalidArgument, "compressed grpc-web messages are not supported")
+		}
+
+		length := binary.BigEndian.Uint32(prefix[1:])
+		if uint64(length) > uint64(maxBytes) {
+			return nil, status.Errorf(codes.ResourceExhausted, "grpc-web message larger than max (%d vs. %d)", length, maxBytes)
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "malformed grpc-web frame: %v", err)
+		}
+		frames = append(frames, &frame{payload: payload})
+	}
+}
+
+// grpcWebWriter writes gRPC-Web frames, base64-encoding each of them in text mode
+type grpcWebWriter struct {
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	text        bool
+	wroteHeader bool
+}
+
+func (g *grpcWebWriter) writeHeader(md metadata.MD) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	for key, values := range md {
+		if key == "content-type" {
+			continue
+		}
+		for _, v := range values {
+			g.w.Header().Add(key, v)
+		}
+	}
+	g.w.WriteHeader(http.StatusOK)
+}
+
+func (g *grpcWebWriter) writeFrame(flag byte, payload []byte) error {
+	buf := make([]byte, 5+len(payload))
+	buf[0] = flag
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(payload)))
+	copy(buf[5:], payload)
+
+	if g.text {
+		buf = []byte(base64.StdEncoding.EncodeToString(buf))
+	}
+
+	if _, err := g.w.Write(buf); err != nil {
+		return err
+	}
+	return g.rc.Flush()
+}
+
+// writeTrailers ends the response with the trailer frame. Before any message was written,
+// the status is also sent as headers (a trailers-only response).
+func (g *grpcWebWriter) writeTrailers(trailer metadata.MD, st *status.Status) {
+	code := strconv.Itoa(int(st.Code()))
+	message := encodeGRPCMessage(st.Message())
+
+	if !g.wroteHeader {
+		g.w.Header().Set("Grpc-Status", code)
+		if message != "" {
+			g.w.Header().Set("Grpc-Message", message)
+		}
+		g.writeHeader(nil)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "grpc-status: %s\r\n", code)
+	if message != "" {
+		fmt.Fprintf(&b, "grpc-message: %s\r\n", message)
+	}
+	for key, values := range trailer {
+		for _, v := range values {
+			fmt.Fprintf(&b, "%s: %s\r\n", key, v)
+		}
+	}
+
+	_ = g.writeFrame(trailerFlag, []byte(b.String()))
+}
+
+// encodeGRPCMessage percent-encodes the status message as required by the gRPC protocol
+func encodeGRPCMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// parseTimeout parses a grpc-timeout header value, e.g. "500m" or "3S"
+func parseTimeout(v string) (time.Duration, bool) {
+	if len(v) < 2 {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+	unit, ok := units[v[len(v)-1]]
+	if !ok {
+		return 0, false
+	}
+	return time.Duration(n) * unit, true
+}
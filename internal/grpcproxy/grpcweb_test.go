@@ -0,0 +1,39 @@
+package grpcproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestReadFrames(t *testing.T) {
+	frameOf := func(declared uint32, payload []byte) []byte {
+		buf := make([]byte, 5, 5+len(payload))
+		binary.BigEndian.PutUint32(buf[1:], declared)
+		return append(buf, payload...)
+	}
+
+	tests := []struct {
+		name string
+		body []byte
+		want codes.Code
+	}{
+		{"messages within the limit", append(frameOf(3, []byte("abc")), frameOf(0, nil)...), codes.OK},
+		{"message at the limit", frameOf(16, make([]byte, 16)), codes.OK},
+		{"declared length over the limit", frameOf(16+1, make([]byte, 16+1)), codes.ResourceExhausted},
+		// Only the 5 byte prefix is sent, the 4 GiB payload must not be allocated
+		{"huge declared length", frameOf(1<<32-1, nil), codes.ResourceExhausted},
+		{"truncated payload", frameOf(8, []byte("abc")), codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readFrames(bytes.NewReader(tt.body), 16)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("expected %s, got %s (%v)", tt.want, got, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+// Package grpcproxy forwards raw gRPC calls to the backend services without decoding
+// the messages, for clients that speak gRPC (or gRPC-Web) instead of JSON.
+package grpcproxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/fekuna/omnipos-pkg/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// MetadataFunc builds the outgoing gRPC metadata of an HTTP request
+type MetadataFunc func(ctx context.Context, r *http.Request) metadata.MD
+
+// streamDesc allows any call shape; the backend enforces the real one
+var streamDesc = &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+
+// rawCall passes frames through the backend calls without decoding them
+var rawCall = grpc.ForceCodec(rawCodec{})
+
+// Proxy routes calls to backend connections by proto package, e.g. "order.v1"
+type Proxy struct {
+	conns    map[string]*grpc.ClientConn
+	metadata MetadataFunc
+	logger   logger.ZapLogger
+}
+
+// NewProxy creates a proxy over the shared backend connections
+// conns: proto package (e.g. "product.v1") -> backend connection
+func NewProxy(conns map[string]*grpc.ClientConn, metadata MetadataFunc, log logger.ZapLogger) *Proxy {
+	return &Proxy{
+		conns:    conns,
+		metadata: metadata,
+		logger:   log,
+	}
+}
+
+// OutgoingContext attaches the metadata of an HTTP request to ctx for backend calls
+func (p *Proxy) OutgoingContext(ctx context.Context, r *http.Request) context.Context {
+	return metadata.NewOutgoingContext(ctx, p.metadata(ctx, r))
+}
+
+// Invoke makes a unary call with decoded messages, e.g. dynamicpb messages built from proto descriptors
+func (p *Proxy) Invoke(ctx context.Context, fullMethod string, req, resp proto.Message) error {
+	conn, err := p.conn(fullMethod)
+	if err != nil {
+		return err
+	}
+	return conn.Invoke(ctx, fullMethod, req, resp)
+}
+
+// InvokeJSON makes a unary call with JSON request and response bodies, using the method's registered
+// proto descriptors. Response fields use their proto names, same as the JSON API.
+func (p *Proxy) InvokeJSON(ctx context.Context, fullMethod string, body []byte) (json.RawMessage, error) {
+	method, err := findMethod(fullMethod)
+	if err != nil {
+		return nil, status.Errorf(codes.Unimplemented, "%v", err)
+	}
+
+	req := dynamicpb.NewMessage(method.Input())
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, req); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid request: %v", err)
+	}
+
+	resp := dynamicpb.NewMessage(method.Output())
+	if err := p.Invoke(ctx, fullMethod, req, resp); err != nil {
+		return nil, err
+	}
+
+	return protojson.MarshalOptions{EmitUnpopulated: true, UseProtoNames: true}.Marshal(resp)
+}
+
+// conn returns the backend connection of a full method name, e.g. "/order.v1.OrderService/CreateOrder"
+func (p *Proxy) conn(fullMethod string) (*grpc.ClientConn, error) {
+	service, _, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "malformed method name %q", fullMethod)
+	}
+
+	pkg := service
+	if i := strings.LastIndex(service, "."); i >= 0 {
+		pkg = service[:i]
+	}
+
+	conn, ok := p.conns[pkg]
+	if !ok {
+		return nil, status.Errorf(codes.Unimplemented, "unknown service %q", service)
+	}
+	return conn, nil
+}
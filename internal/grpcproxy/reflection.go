@@ -0,0 +1,203 @@
+package grpcproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+)
+
+// RegisterReflection registers a gRPC server reflection service (v1) on s that aggregates the
+// reflection services of all backends, so grpcurl and similar tools can introspect the whole API
+// through the proxy listener. Backends must have reflection enabled.
+func (p *Proxy) RegisterReflection(s *grpc.Server) {
+	reflectionpb.RegisterServerReflectionServer(s, &reflectionServer{proxy: p})
+}
+
+type reflectionServer struct {
+	reflectionpb.UnimplementedServerReflectionServer
+	proxy *Proxy
+}
+
+// reflectionStreams holds the reflection streams opened to backends for one client stream
+type reflectionStreams struct {
+	ctx     context.Context
+	streams map[*grpc.ClientConn]reflectionpb.ServerReflection_ServerReflectionInfoClient
+}
+
+func (r *reflectionServer) ServerReflectionInfo(stream reflectionpb.ServerReflection_ServerReflectionInfoServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	backends := &reflectionStreams{
+		// Reflection only exposes the API schema; it isn't made on behalf of a merchant
+		ctx:     middleware.WithGatewayCaller(ctx, "reflection"),
+		streams: make(map[*grpc.ClientConn]reflectionpb.ServerReflection_ServerReflectionInfoClient),
+	}
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		resp := r.reflect(backends, req)
+		resp.ValidHost = req.GetHost()
+		resp.OriginalRequest = req
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// reflect answers list requests from all backends and symbol lookups from the backend of the
+// symbol's package, falling back to asking every backend
+func (r *reflectionServer) reflect(backends *reflectionStreams, req *reflectionpb.ServerReflectionRequest) *reflectionpb.ServerReflectionResponse {
+	var symbol string
+	switch m := req.GetMessageRequest().(type) {
+	case *reflectionpb.ServerReflectionRequest_ListServices:
+		return r.listServices(backends, req)
+	case *reflectionpb.ServerReflectionRequest_FileContainingSymbol:
+		symbol = m.FileContainingSymbol
+	case *reflectionpb.ServerReflectionRequest_FileContainingExtension:
+		symbol = m.FileContainingExtension.GetContainingType()
+	case *reflectionpb.ServerReflectionRequest_AllExtensionNumbersOfType:
+		symbol = m.AllExtensionNumbersOfType
+	}
+
+	conns := r.proxy.backendConns()
+	if conn, ok := r.proxy.connForSymbol(symbol); ok {
+		conns = append([]*grpc.ClientConn{conn}, conns...)
+	}
+
+	var resp *reflectionpb.ServerReflectionResponse
+	tried := make(map[*grpc.ClientConn]bool, len(conns))
+	for _, conn := range conns {
+		if tried[conn] {
+			continue
+		}
+		tried[conn] = true
+
+		var err error
+		resp, err = backends.ask(conn, req)
+		if err != nil {
+			r.proxy.logger.Warn("backend reflection failed", zap.String("backend", conn.Target()), zap.Error(err))
+			continue
+		}
+		if resp.GetErrorResponse() == nil {
+			return resp
+		}
+	}
+
+	if resp == nil {
+		return errorResponse(int32(codes.NotFound), "symbol not found")
+	}
+	return resp
+}
+
+// listServices merges the services of all backends; unreachable backends are left out
+func (r *reflectionServer) listServices(backends *reflectionStreams, req *reflectionpb.ServerReflectionRequest) *reflectionpb.ServerReflectionResponse {
+	seen := make(map[string]bool)
+	for _, conn := range r.proxy.backendConns() {
+		resp, err := backends.ask(conn, req)
+		if err != nil {
+			r.proxy.logger.Warn("backend reflection failed", zap.String("backend", conn.Target()), zap.Error(err))
+			continue
+		}
+		for _, service := range resp.GetListServicesResponse().GetService() {
+			// The backends' own reflection services aren't reachable through the proxy
+			if !strings.HasPrefix(service.GetName(), "grpc.reflection.") {
+				seen[service.GetName()] = true
+			}
+		}
+	}
+	seen[reflectionpb.ServerReflection_ServiceDesc.ServiceName] = true
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	services := make([]*reflectionpb.ServiceResponse, len(names))
+	for i, name := range names {
+		services[i] = &reflectionpb.ServiceResponse{Name: name}
+	}
+	return &reflectionpb.ServerReflectionResponse{
+		MessageResponse: &reflectionpb.ServerReflectionResponse_ListServicesResponse{
+			ListServicesResponse: &reflectionpb.ListServiceResponse{Service: services},
+		},
+	}
+}
+
+// ask sends a request on the backend's reflection stream, opening it on first use
+func (b *reflectionStreams) ask(conn *grpc.ClientConn, req *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+	stream, ok := b.streams[conn]
+	if !ok {
+		var err error
+		stream, err = reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(b.ctx)
+		if err != nil {
+			return nil, err
+		}
+		b.streams[conn] = stream
+	}
+
+	if err := stream.Send(req); err != nil {
+		delete(b.streams, conn)
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		delete(b.streams, conn)
+		return nil, err
+	}
+	return resp, nil
+}
+
+// backendConns returns the distinct backend connections
+func (p *Proxy) backendConns() []*grpc.ClientConn {
+	seen := make(map[*grpc.ClientConn]bool)
+	conns := make([]*grpc.ClientConn, 0, len(p.conns))
+	for _, conn := range p.conns {
+		if !seen[conn] {
+			seen[conn] = true
+			conns = append(conns, conn)
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Target() < conns[j].Target() })
+	return conns
+}
+
+// connForSymbol returns the backend of a fully-qualified symbol by its proto package,
+// e.g. "order.v1.OrderService.CreateOrder" -> the "order.v1" backend
+func (p *Proxy) connForSymbol(symbol string) (*grpc.ClientConn, bool) {
+	for pkg := symbol; pkg != ""; {
+		if conn, ok := p.conns[pkg]; ok {
+			return conn, true
+		}
+		i := strings.LastIndex(pkg, ".")
+		if i < 0 {
+			break
+		}
+		pkg = pkg[:i]
+	}
+	return nil, false
+}
+
+func errorResponse(code int32, message string) *reflectionpb.ServerReflectionResponse {
+	return &reflectionpb.ServerReflectionResponse{
+		MessageResponse: &reflectionpb.ServerReflectionResponse_ErrorResponse{
+			ErrorResponse: &reflectionpb.ErrorResponse{ErrorCode: code, ErrorMessage: message},
+		},
+	}
+}
@@ -0,0 +1,116 @@
+package grpcproxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// NewServer returns a gRPC server forwarding every call to the backend of its service,
+// for clients that speak native gRPC. Backend dial options (e.g. the auth interceptor) apply.
+func (p *Proxy) NewServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ForceServerCodec(rawCodec{}),
+		grpc.UnknownServiceHandler(p.handleStream),
+	)
+	return grpc.NewServer(opts...)
+}
+
+// handleStream proxies a call of any shape, forwarding messages in both directions
+func (p *Proxy) handleStream(_ interface{}, serverStream grpc.ServerStream) error {
+	method, ok := grpc.MethodFromServerStream(serverStream)
+	if !ok {
+		return status.Error(codes.Internal, "failed to get method from stream")
+	}
+
+	conn, err := p.conn(method)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(serverStream.Context())
+	defer cancel()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = metadata.NewOutgoingContext(ctx, forwardedMetadata(md))
+
+	clientStream, err := conn.NewStream(ctx, streamDesc, method, rawCall)
+	if err != nil {
+		return err
+	}
+
+	// Client -> backend
+	sendErr := make(chan error, 1)
+	go func() {
+		for {
+			msg := &frame{}
+			if err := serverStream.RecvMsg(msg); err != nil {
+				if errors.Is(err, io.EOF) {
+					sendErr <- clientStream.CloseSend()
+					return
+				}
+				sendErr <- err
+				return
+			}
+			if err := clientStream.SendMsg(msg); err != nil {
+				// The backend's status is returned by RecvMsg below
+				sendErr <- nil
+				return
+			}
+		}
+	}()
+
+	// Backend -> client
+	header, err := clientStream.Header()
+	if err != nil {
+		return err
+	}
+	if err := serverStream.SendHeader(header); err != nil {
+		return err
+	}
+
+	for {
+		msg := &frame{}
+		if err := clientStream.RecvMsg(msg); err != nil {
+			serverStream.SetTrailer(clientStream.Trailer())
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		if err := serverStream.SendMsg(msg); err != nil {
+			return err
+		}
+
+		select {
+		case err := <-sendErr:
+			if err != nil {
+				return status.Errorf(codes.Canceled, "client stream failed: %v", err)
+			}
+		default:
+		}
+	}
+}
+
+// forwardedMetadata drops transport and gateway-owned keys from the caller's metadata
+func forwardedMetadata(md metadata.MD) metadata.MD {
+	out := make(metadata.MD, len(md))
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") || strings.HasPrefix(key, "grpc-") {
+			continue
+		}
+		switch key {
+		case "content-type", "user-agent", "te", "x-merchant-id", "x-gateway-caller", "x-tenant-id":
+			continue
+		}
+		out[key] = values
+	}
+	return out
+}
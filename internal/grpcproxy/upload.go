@@ -0,0 +1,407 @@
+package grpcproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// uploadFile is the file part of a multipart upload
+type uploadFile struct {
+	field       string
+	filename    string
+	contentType string
+	data        []byte
+}
+
+// UploadBridge translates multipart/form-data uploads on designated routes into gRPC calls.
+// Form fields and path parameters set the request fields of the same name (dotted for nested
+// messages), the file goes into a bytes field. Client-streaming methods receive the file in chunks.
+type UploadBridge struct {
+	proxy   *Proxy
+	routes  *middleware.RouteTable
+	cfg     config.UploadConfig
+	methods map[string]bool
+	types   map[string]bool
+	logger  logger.ZapLogger
+}
+
+// NewUploadBridge creates a new upload bridge
+func NewUploadBridge(proxy *Proxy, routes *middleware.RouteTable, cfg config.UploadConfig, log logger.ZapLogger) *UploadBridge {
+	methods := make(map[string]bool, len(cfg.Methods))
+	for _, method := range cfg.Methods {
+		methods[method] = true
+	}
+
+	types := make(map[string]bool, len(cfg.AllowedTypes))
+	for _, typ := range cfg.AllowedTypes {
+		types[typ] = true
+	}
+
+	if cfg.ChunkSize <= 0 {
+		cfg.ChunkSize = 64 << 10
+	}
+
+	return &UploadBridge{
+		proxy:   proxy,
+		routes:  routes,
+		cfg:     cfg,
+		methods: methods,
+		types:   types,
+		logger:  log,
+	}
+}
+
+// Handle serves multipart requests to upload routes and passes everything else to next
+func (u *UploadBridge) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if !u.cfg.Enabled || mediaType != "multipart/form-data" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route, ok := u.routes.MatchRequest(r)
+		if !ok || !u.methods[route.Method] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u.serveUpload(w, r, route)
+	})
+}
+
+func (u *UploadBridge) serveUpload(w http.ResponseWriter, r *http.Request, route *middleware.Route) {
+	method, err := findMethod(route.Method)
+	if err != nil {
+		u.logger.Error("upload method not found", zap.String("method", route.Method), zap.Error(err))
+		customRuntime.WriteResponse(w, http.StatusNotImplemented, "upload method not available", nil)
+		return
+	}
+
+	fields, file, code, err := u.readForm(r)
+	if err != nil {
+		customRuntime.WriteResponse(w, code, err.Error(), nil)
+		return
+	}
+
+	for name, value := range route.PathParams(r.URL.Path) {
+		fields[name] = value
+	}
+
+	req := dynamicpb.NewMessage(method.Input())
+	for name, value := range fields {
+		if err := setField(req, name, value); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+	}
+
+	dataField := bytesField(method.Input(), file.field)
+	if dataField == nil {
+		customRuntime.WriteResponse(w, http.StatusNotImplemented, "upload method has no bytes field", nil)
+		return
+	}
+	setFileInfo(req, file)
+
+	ctx := u.proxy.OutgoingContext(r.Context(), r)
+	resp := dynamicpb.NewMessage(method.Output())
+
+	if method.IsStreamingClient() {
+		err = u.sendChunks(ctx, route.Method, req, dataField, file.data, resp)
+	} else {
+		req.Set(dataField, protoreflect.ValueOfBytes(file.data))
+		err = u.proxy.Invoke(ctx, route.Method, req, resp)
+	}
+	if err != nil {
+		st := status.Convert(err)
+		customRuntime.WriteResponse(w, runtime.HTTPStatusFromCode(st.Code()), st.Message(), nil)
+		return
+	}
+
+	body, err := protojson.MarshalOptions{EmitUnpopulated: true, UseProtoNames: true}.Marshal(resp)
+	if err != nil {
+		customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to encode response", nil)
+		return
+	}
+	customRuntime.WriteResponse(w, http.StatusOK, "success", json.RawMessage(body))
+}
+
+// readForm reads the form fields and the single file of the request, validating its size and type
+func (u *UploadBridge) readForm(r *http.Request) (map[string]string, *uploadFile, int, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, http.StatusBadRequest, errors.New("invalid multipart body")
+	}
+
+	fields := make(map[string]string)
+	var file *uploadFile
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, bodyErrorStatus(err), errors.New("invalid multipart body")
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, 64<<10))
+			if err != nil {
+				return nil, nil, bodyErrorStatus(err), errors.New("invalid multipart body")
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+
+		if file != nil {
+			return nil, nil, http.StatusBadRequest, errors.New("only one file per upload is supported")
+		}
+
+		data, err := io.ReadAll(io.LimitReader(part, u.cfg.MaxFileBytes+1))
+		if err != nil {
+			return nil, nil, bodyErrorStatus(err), errors.New("invalid multipart body")
+		}
+		if int64(len(data)) > u.cfg.MaxFileBytes {
+			return nil, nil, http.StatusRequestEntityTooLarge, fmt.Errorf("file exceeds the maximum size of %d bytes", u.cfg.MaxFileBytes)
+		}
+
+		contentType, err := u.validateType(part.Header.Get("Content-Type"), data)
+		if err != nil {
+			return nil, nil, http.StatusUnsupportedMediaType, err
+		}
+
+		file = &uploadFile{
+			field:       part.FormName(),
+			filename:    part.FileName(),
+			contentType: contentType,
+			data:        data,
+		}
+	}
+
+	if file == nil {
+		return nil, nil, http.StatusBadRequest, errors.New("missing file")
+	}
+	return fields, file, http.StatusOK, nil
+}
+
+// validateType checks the declared content type against the allow list. Images must also
+// be sniffed as the declared type, so a renamed executable can't pass as a picture.
+func (u *UploadBridge) validateType(declared string, data []byte) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(declared)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+
+	if !u.types[mediaType] {
+		return "", fmt.Errorf("file type %q is not allowed", mediaType)
+	}
+
+	if strings.HasPrefix(mediaType, "image/") {
+		if sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(data)); sniffed != mediaType {
+			return "", fmt.Errorf("file content does not match its type %q", mediaType)
+		}
+	}
+	return mediaType, nil
+}
+
+// sendChunks streams the file in chunks; the first message also carries the form fields.
+// When the bytes field is part of a oneof, the fields are sent in a message of their own.
+func (u *UploadBridge) sendChunks(ctx context.Context, fullMethod string, first *dynamicpb.Message, dataField protoreflect.FieldDescriptor, data []byte, resp proto.Message) error {
+	conn, err := u.proxy.conn(fullMethod)
+	if err != nil {
+		return err
+	}
+
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ClientStreams: true}, fullMethod)
+	if err != nil {
+		return err
+	}
+
+	if dataField.ContainingOneof() != nil {
+		if err := stream.SendMsg(first); err != nil {
+			return recvStatus(stream, resp, err)
+		}
+		first = nil
+	}
+
+	for offset := 0; offset < len(data) || first != nil; offset += u.cfg.ChunkSize {
+		msg := first
+		if msg == nil {
+			msg = dynamicpb.NewMessage(dataField.ContainingMessage())
+		}
+		first = nil
+
+		end := min(offset+u.cfg.ChunkSize, len(data))
+		msg.Set(dataField, protoreflect.ValueOfBytes(data[offset:end]))
+
+		if err := stream.SendMsg(msg); err != nil {
+			return recvStatus(stream, resp, err)
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	return stream.RecvMsg(resp)
+}
+
+// recvStatus returns the backend's status after a failed send, which only reports io.EOF
+func recvStatus(stream grpc.ClientStream, resp proto.Message, err error) error {
+	if errors.Is(err, io.EOF) {
+		if err := stream.RecvMsg(resp); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func findMethod(fullMethod string) (protoreflect.MethodDescriptor, error) {
+	service, name, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return nil, fmt.Errorf("malformed method name %q", fullMethod)
+	}
+
+	desc, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, err
+	}
+
+	serviceDesc, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a service", service)
+	}
+
+	method := serviceDesc.Methods().ByName(protoreflect.Name(name))
+	if method == nil {
+		return nil, fmt.Errorf("method %s not found", fullMethod)
+	}
+	return method, nil
+}
+
+// bytesField returns the bytes field named after the form file field, or the first top-level bytes field
+func bytesField(msg protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	if field := msg.Fields().ByName(protoreflect.Name(name)); field != nil && field.Kind() == protoreflect.BytesKind && !field.IsList() {
+		return field
+	}
+
+	fields := msg.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if field := fields.Get(i); field.Kind() == protoreflect.BytesKind && !field.IsList() {
+			return field
+		}
+	}
+	return nil
+}
+
+// setFileInfo fills the conventional filename and content type fields, unless set from the form
+func setFileInfo(msg *dynamicpb.Message, file *uploadFile) {
+	for name, value := range map[string]string{
+		"filename":     file.filename,
+		"file_name":    file.filename,
+		"content_type": file.contentType,
+		"mime_type":    file.contentType,
+	} {
+		field := msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+		if field != nil && field.Kind() == protoreflect.StringKind && !field.IsList() && !msg.Has(field) {
+			msg.Set(field, protoreflect.ValueOfString(value))
+		}
+	}
+}
+
+// setField sets a scalar field by its (dotted) proto name from a form value
+func setField(msg protoreflect.Message, path, value string) error {
+	name, rest, nested := strings.Cut(path, ".")
+
+	field := msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if field == nil {
+		// Unknown form fields are ignored, same as unknown JSON fields
+		return nil
+	}
+
+	if nested {
+		if field.Kind() != protoreflect.MessageKind || field.IsList() || field.IsMap() {
+			return fmt.Errorf("field %q is not a message", name)
+		}
+		return setField(msg.Mutable(field).Message(), rest, value)
+	}
+
+	v, err := scalarValue(field, value)
+	if err != nil {
+		return fmt.Errorf("invalid value for field %q: %w", path, err)
+	}
+
+	if field.IsList() {
+		msg.Mutable(field).List().Append(v)
+		return nil
+	}
+	msg.Set(field, v)
+	return nil
+}
+
+func scalarValue(field protoreflect.FieldDescriptor, value string) (protoreflect.Value, error) {
+	switch field.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(value), nil
+	case protoreflect.BytesKind:
+		return protoreflect.ValueOfBytes([]byte(value)), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(value)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(value, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(value, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(value, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(value, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(value, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.EnumKind:
+		if enumValue := field.Enum().Values().ByName(protoreflect.Name(value)); enumValue != nil {
+			return protoreflect.ValueOfEnum(enumValue.Number()), nil
+		}
+		v, err := strconv.ParseInt(value, 10, 32)
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	default:
+		return protoreflect.Value{}, errors.New("unsupported field type")
+	}
+}
+
+func bodyErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,296 @@
+// Package health serves the liveness, readiness and backend health endpoints probed by Kubernetes
+package health
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/backend"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Backend is a backend service probed by /healthz/backends
+type Backend struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+}
+
+// BackendStatus is the result of probing a backend
+type BackendStatus struct {
+	Name        string     `json:"name"`
+	Addr        string     `json:"addr"`
+	Status      string     `json:"status"` // grpc.health.v1 serving status, or REACHABLE for backends without the health service
+	Healthy     bool       `json:"healthy"`
+	Connection  string     `json:"connection"` // state of the gateway's connection, e.g. READY or TRANSIENT_FAILURE
+	LatencyMS   float64    `json:"latency_ms"`
+	Error       string     `json:"error,omitempty"`
+	LastError   string     `json:"last_error,omitempty"` // most recent failed check, even when the backend has recovered since
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// checkError is the most recent failed check of a backend
+type checkError struct {
+	message string
+	at      time.Time
+}
+
+type backendConn struct {
+	Backend
+	client healthpb.HealthClient
+	err    error // the connection couldn't be set up
+}
+
+// Checker serves /healthz (liveness), /readyz (readiness) and /healthz/backends, which runs
+// grpc.health.v1 checks against every backend service
+type Checker struct {
+	cfg      config.HealthConfig
+	conns    *backend.Manager
+	backends []backendConn
+	ready    atomic.Bool
+	warming  atomic.Bool
+	logger   logger.ZapLogger
+
+	mu         sync.Mutex
+	lastErrors map[string]checkError // by backend name
+}
+
+// NewChecker checks the backends over their shared connections; the checker starts ready
+func NewChecker(backends []Backend, conns *backend.Manager, cfg config.HealthConfig, log logger.ZapLogger) *Checker {
+	c := &Checker{
+		cfg:        cfg,
+		conns:      conns,
+		logger:     log,
+		lastErrors: make(map[string]checkError),
+	}
+
+	for _, b := range backends {
+		conn, err := conns.Conn(b.Addr)
+		if err != nil {
+			c.backends = append(c.backends, backendConn{Backend: b, err: err})
+			continue
+		}
+		c.backends = append(c.backends, backendConn{Backend: b, client: healthpb.NewHealthClient(conn)})
+	}
+
+	c.ready.Store(true)
+	return c
+}
+
+// SetReady flips readiness; the gateway turns it off on shutdown so it's taken out of rotation
+// before connections are drained
+func (c *Checker) SetReady(ready bool) {
+	c.ready.Store(ready)
+}
+
+// WarmUp fails readiness until every backend is connected and has answered a health check, or
+// cfg.WarmUpTimeout elapses, so the first requests after a deploy don't pay for the dials. The backends
+// are warmed up in the background.
+func (c *Checker) WarmUp(ctx context.Context) {
+	c.warming.Store(true)
+	go c.warmUp(ctx)
+}
+
+func (c *Checker) warmUp(ctx context.Context) {
+	defer c.warming.Store(false)
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.WarmUpTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, b := range c.backends {
+		if b.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			conn, err := c.conns.Conn(addr)
+			if err != nil {
+				return
+			}
+			for state := conn.GetState(); state != connectivity.Ready; state = conn.GetState() {
+				conn.Connect()
+				if !conn.WaitForStateChange(ctx, state) {
+					return
+				}
+			}
+		}(b.Addr)
+	}
+	wg.Wait()
+
+	var unhealthy []string
+	for _, s := range c.CheckBackends(ctx) {
+		if !s.Healthy {
+			unhealthy = append(unhealthy, s.Name)
+		}
+	}
+	if len(unhealthy) > 0 {
+		c.logger.Warn("backends not healthy after warm-up", zap.Strings("backends", unhealthy), zap.Duration("duration", time.Since(start)))
+		return
+	}
+	c.logger.Info("backends warmed up", zap.Duration("duration", time.Since(start)))
+}
+
+// RegisterRoutes registers the health routes
+func (c *Checker) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", c.serveLiveness)
+	mux.HandleFunc("/readyz", c.serveReadiness)
+	mux.HandleFunc("/healthz/backends", c.serveBackends)
+}
+
+func (c *Checker) serveLiveness(w http.ResponseWriter, r *http.Request) {
+	customRuntime.WriteResponse(w, http.StatusOK, "ok", nil)
+}
+
+// serveReadiness doesn't probe the backends, so a backend outage doesn't take every gateway replica out of
+// rotation; backends whose connection is failing are reported as degraded, and their routes answer 503
+func (c *Checker) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	if !c.ready.Load() {
+		customRuntime.WriteResponse(w, http.StatusServiceUnavailable, "shutting down", nil)
+		return
+	}
+	if c.warming.Load() {
+		customRuntime.WriteResponse(w, http.StatusServiceUnavailable, "warming up", nil)
+		return
+	}
+
+	unavailable := make(map[string]bool)
+	for _, addr := range c.conns.Unavailable() {
+		unavailable[addr] = true
+	}
+
+	var degraded []Backend
+	for _, b := range c.backends {
+		if b.err != nil || unavailable[b.Addr] {
+			degraded = append(degraded, b.Backend)
+		}
+	}
+	if len(degraded) > 0 {
+		customRuntime.WriteResponse(w, http.StatusOK, "degraded", map[string]interface{}{
+			"degraded_backends": degraded,
+		})
+		return
+	}
+	customRuntime.WriteResponse(w, http.StatusOK, "ok", nil)
+}
+
+// serveBackends checks every backend, or those named in ?name= ("order,payment") for deploy gates of a
+// subset of the services
+func (c *Checker) serveBackends(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	if name := r.URL.Query().Get("name"); name != "" {
+		names = strings.Split(name, ",")
+	}
+	statuses := c.CheckBackends(r.Context(), names...)
+	if len(statuses) < len(names) {
+		// A gate on a misspelled backend mustn't pass
+		customRuntime.WriteResponse(w, http.StatusBadRequest, "unknown backend name", nil)
+		return
+	}
+
+	code, message := http.StatusOK, "ok"
+	for _, s := range statuses {
+		if !s.Healthy {
+			code, message = http.StatusServiceUnavailable, "one or more backends are unhealthy"
+			break
+		}
+	}
+	customRuntime.WriteResponse(w, code, message, statuses)
+}
+
+// CheckBackends probes the backends named, or all backends, concurrently
+func (c *Checker) CheckBackends(ctx context.Context, names ...string) []BackendStatus {
+	backends := c.backends
+	if len(names) > 0 {
+		backends = slices.DeleteFunc(slices.Clone(backends), func(b backendConn) bool {
+			return !slices.Contains(names, b.Name)
+		})
+	}
+	statuses := make([]BackendStatus, len(backends))
+
+	var wg sync.WaitGroup
+	for i, backend := range backends {
+		wg.Add(1)
+		go func(i int, backend backendConn) {
+			defer wg.Done()
+			statuses[i] = c.check(ctx, backend)
+		}(i, backend)
+	}
+	wg.Wait()
+
+	return statuses
+}
+
+func (c *Checker) check(ctx context.Context, backend backendConn) BackendStatus {
+	result := c.probe(ctx, backend)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if result.Error != "" {
+		c.lastErrors[backend.Name] = checkError{message: result.Error, at: time.Now().UTC()}
+	}
+	if last, ok := c.lastErrors[backend.Name]; ok {
+		result.LastError = last.message
+		result.LastErrorAt = &last.at
+	}
+	return result
+}
+
+// probe runs the grpc.health.v1 check of a backend, with the state of its connection
+func (c *Checker) probe(ctx context.Context, backend backendConn) BackendStatus {
+	if backend.err != nil {
+		return BackendStatus{
+			Name:       backend.Name,
+			Addr:       backend.Addr,
+			Status:     healthpb.HealthCheckResponse_UNKNOWN.String(),
+			Connection: connectivity.Shutdown.String(),
+			Error:      backend.err.Error(),
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(middleware.WithGatewayCaller(ctx, "health"), c.cfg.CheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := backend.client.Check(ctx, &healthpb.HealthCheckRequest{})
+	result := BackendStatus{
+		Name:      backend.Name,
+		Addr:      backend.Addr,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if conn, err := c.conns.Conn(backend.Addr); err == nil {
+		result.Connection = conn.GetState().String()
+	}
+
+	switch {
+	case err == nil:
+		result.Status = resp.GetStatus().String()
+		result.Healthy = resp.GetStatus() == healthpb.HealthCheckResponse_SERVING
+		if !result.Healthy {
+			result.Error = "backend reports " + result.Status
+		}
+	case status.Code(err) == codes.Unimplemented:
+		// The backend answered but doesn't register the health service
+		result.Status = "REACHABLE"
+		result.Healthy = true
+	default:
+		result.Status = healthpb.HealthCheckResponse_UNKNOWN.String()
+		result.Error = status.Convert(err).Message()
+		c.logger.Warn("backend health check failed", zap.String("backend", backend.Name), zap.String("addr", backend.Addr), zap.Error(err))
+	}
+	return result
+}
@@ -0,0 +1,192 @@
+// Package i18n translates the errors of well-known backend error codes into the language of the
+// client (the Indonesian POS app, the English dashboard), from message catalogs embedded in the gateway
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"google.golang.org/genproto/googleapis/rpc/code"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+//go:embed catalogs/*.json
+var embeddedCatalogs embed.FS
+
+// LangHeader selects the language of a request, over Accept-Language
+const LangHeader = "X-Lang"
+
+type languageKey struct{}
+
+// Translator holds a message catalog per language ("id", "en"), keyed by google.rpc.ErrorInfo reason
+// or BadRequest field violation reason (e.g. "INSUFFICIENT_STOCK"), or by status code ("NOT_FOUND")
+type Translator struct {
+	catalogs        map[string]map[string]string
+	defaultLanguage string
+}
+
+// NewTranslator loads the embedded catalogs, and those of cfg.CatalogDir ("<language>.json") over them
+func NewTranslator(cfg config.I18nConfig) (*Translator, error) {
+	t := &Translator{
+		catalogs:        make(map[string]map[string]string),
+		defaultLanguage: strings.ToLower(cfg.DefaultLanguage),
+	}
+	if err := t.load(embeddedCatalogs, "catalogs"); err != nil {
+		return nil, err
+	}
+	if cfg.CatalogDir != "" {
+		if err := t.load(os.DirFS(cfg.CatalogDir), "."); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := t.catalogs[t.defaultLanguage]; !ok {
+		return nil, fmt.Errorf("no message catalog for the default language %q", cfg.DefaultLanguage)
+	}
+	return t, nil
+}
+
+func (t *Translator) load(files fs.FS, dir string) error {
+	names, err := fs.Glob(files, path.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return fmt.Errorf("invalid message catalog %s: %w", name, err)
+		}
+
+		language := strings.ToLower(strings.TrimSuffix(path.Base(name), ".json"))
+		if t.catalogs[language] == nil {
+			t.catalogs[language] = make(map[string]string, len(messages))
+		}
+		for key, message := range messages {
+			t.catalogs[language][key] = message
+		}
+	}
+	return nil
+}
+
+// Negotiate stores the language of requests in their context: that of X-Lang, or the most preferred
+// of Accept-Language with a catalog, "id-ID" falling back to "id", then the default language
+func (t *Translator) Negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), languageKey{}, t.negotiate(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (t *Translator) negotiate(r *http.Request) string {
+	var candidates []string
+	if lang := r.Header.Get(LangHeader); lang != "" {
+		candidates = append(candidates, lang)
+	}
+	candidates = append(candidates, acceptedLanguages(r.Header.Get("Accept-Language"))...)
+
+	for _, candidate := range candidates {
+		candidate = strings.ToLower(strings.TrimSpace(candidate))
+		if _, ok := t.catalogs[candidate]; ok {
+			return candidate
+		}
+		if base, _, ok := strings.Cut(candidate, "-"); ok {
+			if _, ok := t.catalogs[base]; ok {
+				return base
+			}
+		}
+	}
+	return t.defaultLanguage
+}
+
+// acceptedLanguages returns the languages of an Accept-Language header, most preferred first
+func acceptedLanguages(header string) []string {
+	type accepted struct {
+		language string
+		quality  float64
+	}
+	var languages []accepted
+	for _, part := range strings.Split(header, ",") {
+		language, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = v
+			}
+		}
+		if language != "" && language != "*" && quality > 0 {
+			languages = append(languages, accepted{language, quality})
+		}
+	}
+	sort.SliceStable(languages, func(i, j int) bool { return languages[i].quality > languages[j].quality })
+
+	result := make([]string, len(languages))
+	for i, l := range languages {
+		result[i] = l.language
+	}
+	return result
+}
+
+// Language returns the language negotiated for the request of ctx, "" outside of Negotiate
+func Language(ctx context.Context) string {
+	language, _ := ctx.Value(languageKey{}).(string)
+	return language
+}
+
+// LocalizeStatus returns st with its message, and the descriptions of its field violations, in the
+// language of ctx. The message is looked up by the reason of the ErrorInfo detail, then by the status
+// code; what isn't in the catalog keeps the backend's text.
+func (t *Translator) LocalizeStatus(ctx context.Context, st *status.Status) *status.Status {
+	catalog, ok := t.catalogs[Language(ctx)]
+	if !ok {
+		return st
+	}
+
+	localized := proto.Clone(st).(*status.Status)
+	var reason string
+	for i, packed := range localized.GetDetails() {
+		detail, err := packed.UnmarshalNew()
+		if err != nil {
+			continue
+		}
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			reason = detail.GetReason()
+		case *errdetails.BadRequest:
+			translated := false
+			for _, v := range detail.GetFieldViolations() {
+				if message, ok := catalog[v.GetReason()]; ok {
+					v.Description = message
+					translated = true
+				}
+			}
+			if translated {
+				if repacked, err := anypb.New(detail); err == nil {
+					localized.Details[i] = repacked
+				}
+			}
+		}
+	}
+
+	if message, ok := catalog[reason]; ok && reason != "" {
+		localized.Message = message
+	} else if message, ok := catalog[code.Code(st.GetCode()).String()]; ok {
+		localized.Message = message
+	}
+	return localized
+}
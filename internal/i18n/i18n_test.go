@@ -0,0 +1,91 @@
+package i18n
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func newTestTranslator(t *testing.T) *Translator {
+	t.Helper()
+	translator, err := NewTranslator(config.I18nConfig{DefaultLanguage: "en"})
+	if err != nil {
+		t.Fatalf("NewTranslator: %v", err)
+	}
+	return translator
+}
+
+func TestTranslator_Negotiate(t *testing.T) {
+	translator := newTestTranslator(t)
+
+	tests := []struct {
+		lang           string
+		acceptLanguage string
+		want           string
+	}{
+		{"", "id-ID,id;q=0.9,en;q=0.8", "id"},
+		{"", "fr;q=0.9, en-US;q=0.5", "en"},
+		{"", "en;q=0.5, id;q=0.8", "id"},
+		{"id", "en", "id"},
+		{"", "fr", "en"},
+		{"", "", "en"},
+	}
+	for _, tt := range tests {
+		var got string
+		handler := translator.Negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = Language(r.Context())
+		}))
+		req := httptest.NewRequest(http.MethodGet, "/v1/orders", nil)
+		if tt.lang != "" {
+			req.Header.Set(LangHeader, tt.lang)
+		}
+		if tt.acceptLanguage != "" {
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got != tt.want {
+			t.Errorf("X-Lang %q, Accept-Language %q: expected %s, got %s", tt.lang, tt.acceptLanguage, tt.want, got)
+		}
+	}
+}
+
+func TestTranslator_LocalizeStatus(t *testing.T) {
+	translator := newTestTranslator(t)
+	ctx := context.WithValue(context.Background(), languageKey{}, "id")
+
+	info, _ := anypb.New(&errdetails.ErrorInfo{Reason: "INSUFFICIENT_STOCK", Domain: "product.omnipos"})
+	badRequest, _ := anypb.New(&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+		{Field: "quantity", Reason: "MUST_BE_POSITIVE", Description: "must be positive"},
+		{Field: "note", Reason: "SOMETHING_ELSE", Description: "unknown"},
+	}})
+	st := &status.Status{Code: 9, Message: "not enough stock for SKU-1", Details: []*anypb.Any{info, badRequest}}
+
+	localized := translator.LocalizeStatus(ctx, st)
+	if localized.GetMessage() != "Stok tidak mencukupi" {
+		t.Errorf("expected the reason's message, got %q", localized.GetMessage())
+	}
+	if st.GetMessage() != "not enough stock for SKU-1" {
+		t.Errorf("expected the original status to be left alone")
+	}
+	detail, _ := localized.GetDetails()[1].UnmarshalNew()
+	violations := detail.(*errdetails.BadRequest).GetFieldViolations()
+	if violations[0].GetDescription() != "Harus lebih besar dari nol" || violations[1].GetDescription() != "unknown" {
+		t.Errorf("unexpected field violations %v", violations)
+	}
+
+	// Without a known reason, the code's message; English has none, so the backend's message stays
+	if got := translator.LocalizeStatus(ctx, &status.Status{Code: 5, Message: "order not found"}).GetMessage(); got != "Data tidak ditemukan" {
+		t.Errorf("expected the NOT_FOUND message, got %q", got)
+	}
+	en := context.WithValue(context.Background(), languageKey{}, "en")
+	if got := translator.LocalizeStatus(en, &status.Status{Code: 5, Message: "order not found"}).GetMessage(); got != "order not found" {
+		t.Errorf("expected the backend's message, got %q", got)
+	}
+}
@@ -0,0 +1,125 @@
+// Package logging lets the log level be changed at runtime, through the admin API or signals, to
+// diagnose an incident at debug level without restarting
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LevelLogger filters the entries of a logger created at debug level by an atomic level
+type LevelLogger struct {
+	logger.ZapLogger
+	level   zap.AtomicLevel
+	initial zapcore.Level
+}
+
+// NewLevelLogger creates a logger starting at cfg.Level
+func NewLevelLogger(cfg logger.ZapLoggerConfig) *LevelLogger {
+	initial := zapcore.InfoLevel
+	if err := initial.Set(cfg.Level); err != nil {
+		initial = zapcore.InfoLevel
+	}
+
+	cfg.Level = zapcore.DebugLevel.String()
+	return &LevelLogger{
+		ZapLogger: logger.NewZapLogger(&cfg),
+		level:     zap.NewAtomicLevelAt(initial),
+		initial:   initial,
+	}
+}
+
+func (l *LevelLogger) Debug(msg string, fields ...zap.Field) {
+	if l.level.Enabled(zapcore.DebugLevel) {
+		l.ZapLogger.Debug(msg, fields...)
+	}
+}
+
+func (l *LevelLogger) Info(msg string, fields ...zap.Field) {
+	if l.level.Enabled(zapcore.InfoLevel) {
+		l.ZapLogger.Info(msg, fields...)
+	}
+}
+
+func (l *LevelLogger) Warn(msg string, fields ...zap.Field) {
+	if l.level.Enabled(zapcore.WarnLevel) {
+		l.ZapLogger.Warn(msg, fields...)
+	}
+}
+
+func (l *LevelLogger) Error(msg string, fields ...zap.Field) {
+	if l.level.Enabled(zapcore.ErrorLevel) {
+		l.ZapLogger.Error(msg, fields...)
+	}
+}
+
+// SetLevel changes the level of every log line from now on
+func (l *LevelLogger) SetLevel(level zapcore.Level) {
+	if previous := l.level.Level(); previous != level {
+		l.level.SetLevel(level)
+		l.ZapLogger.Warn("log level changed", zap.Stringer("from", previous), zap.Stringer("to", level))
+	}
+}
+
+// HandleSignals switches to debug on SIGUSR1 and back to the configured level on SIGUSR2 until ctx is cancelled
+func (l *LevelLogger) HandleSignals(ctx context.Context) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigCh:
+			if sig == syscall.SIGUSR1 {
+				l.SetLevel(zapcore.DebugLevel)
+			} else {
+				l.SetLevel(l.initial)
+			}
+		}
+	}
+}
+
+// RegisterAdminRoutes registers GET (current level) and PUT ({"level": "debug"}) /admin/log-level
+func (l *LevelLogger) RegisterAdminRoutes(mux *http.ServeMux, adminAuth func(http.Handler) http.Handler) {
+	mux.Handle("/admin/log-level", adminAuth(http.HandlerFunc(l.serveLevel)))
+}
+
+func (l *LevelLogger) serveLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var req struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid request body", nil)
+			return
+		}
+		var level zapcore.Level
+		if err := level.Set(req.Level); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "level must be one of debug, info, warn, error", nil)
+			return
+		}
+		l.SetLevel(level)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	customRuntime.WriteResponse(w, http.StatusOK, "success", map[string]string{
+		"level":   l.level.Level().String(),
+		"initial": l.initial.String(),
+	})
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type traceIDKey struct{}
+
+// WithTraceID attaches the trace ID of a request to ctx, so the latencies observed while serving it carry
+// it as an exemplar
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	if traceID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, traceIDKey{}, traceID)
+}
+
+// TraceID returns the trace ID attached to ctx
+func TraceID(ctx context.Context) string {
+	traceID, _ := ctx.Value(traceIDKey{}).(string)
+	return traceID
+}
+
+// ParseTraceparent returns the trace ID of a W3C traceparent header ("00-<trace id>-<span id>-<flags>"),
+// or "" when it's missing or malformed
+func ParseTraceparent(header string) string {
+	traceID, _ := parseTraceparent(header)
+	return traceID
+}
+
+// SampledTraceID returns the trace ID of a traceparent header when its trace is sampled, so exemplars
+// only point at traces that were recorded
+func SampledTraceID(header string) string {
+	traceID, sampled := parseTraceparent(header)
+	if !sampled {
+		return ""
+	}
+	return traceID
+}
+
+func parseTraceparent(header string) (traceID string, sampled bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || strings.Trim(parts[1], "0") == "" || len(parts[3]) != 2 {
+		return "", false
+	}
+	for _, c := range parts[1] {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return "", false
+		}
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return "", false
+	}
+	return parts[1], flags&1 == 1
+}
+
+// Observe observes value, with the trace ID of ctx as exemplar when there is one. Exemplars are only
+// served in the OpenMetrics format.
+func Observe(ctx context.Context, observer prometheus.Observer, value float64) {
+	if traceID := TraceID(ctx); traceID != "" {
+		if exemplar, ok := observer.(prometheus.ExemplarObserver); ok {
+			exemplar.ObserveWithExemplar(value, prometheus.Labels{"trace_id": traceID})
+			return
+		}
+	}
+	observer.Observe(value)
+}
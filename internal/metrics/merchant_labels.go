@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// otherMerchants labels the merchants over the cardinality limit
+const otherMerchants = "other"
+
+// merchantLabels bounds the merchant label of the per-merchant metrics: allowlisted merchants are always
+// labeled, plus the TopN busiest merchants of the previous window (or the first ones seen while there's
+// room); everyone else is "other". Series of merchants that drop out of the top are deleted.
+var merchantLabels = struct {
+	sync.Mutex
+	cfg     config.MetricsConfig
+	allow   map[string]bool
+	labeled map[string]bool
+	counts  map[string]int // requests in the current window
+}{
+	labeled: make(map[string]bool),
+	counts:  make(map[string]int),
+}
+
+// merchantVecs are the metrics labeled by merchant
+var merchantVecs = []interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}{HTTPRequests, QuotaConsumed}
+
+// ConfigureMerchantLabels enables the merchant label of the per-merchant metrics; it's empty until then
+func ConfigureMerchantLabels(cfg config.MetricsConfig) {
+	merchantLabels.Lock()
+	defer merchantLabels.Unlock()
+
+	merchantLabels.cfg = cfg
+	merchantLabels.allow = make(map[string]bool, len(cfg.MerchantAllowlist))
+	for _, merchantID := range cfg.MerchantAllowlist {
+		merchantLabels.allow[merchantID] = true
+	}
+}
+
+// CountRequest counts a request in HTTPRequests, ranking its merchant
+func CountRequest(route string, code int, merchantID string) {
+	HTTPRequests.WithLabelValues(route, strconv.Itoa(code), merchantLabel(merchantID, true)).Inc()
+}
+
+// MerchantLabel returns the label value of merchantID
+func MerchantLabel(merchantID string) string {
+	return merchantLabel(merchantID, false)
+}
+
+func merchantLabel(merchantID string, count bool) string {
+	merchantLabels.Lock()
+	defer merchantLabels.Unlock()
+
+	if !merchantLabels.cfg.MerchantLabels || merchantID == "" {
+		return ""
+	}
+
+	if count {
+		merchantLabels.counts[merchantID]++
+	}
+	if merchantLabels.allow[merchantID] || merchantLabels.labeled[merchantID] {
+		return merchantID
+	}
+	if len(merchantLabels.labeled) < merchantLabels.cfg.MerchantTopN {
+		merchantLabels.labeled[merchantID] = true
+		return merchantID
+	}
+	return otherMerchants
+}
+
+// RunMerchantLabels re-ranks the merchants every MerchantWindow until ctx is cancelled
+func RunMerchantLabels(ctx context.Context) {
+	merchantLabels.Lock()
+	window := merchantLabels.cfg.MerchantWindow
+	enabled := merchantLabels.cfg.MerchantLabels
+	merchantLabels.Unlock()
+	if !enabled || window <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rankMerchants()
+		}
+	}
+}
+
+func rankMerchants() {
+	merchantLabels.Lock()
+	defer merchantLabels.Unlock()
+
+	ranked := make([]string, 0, len(merchantLabels.counts))
+	for merchantID := range merchantLabels.counts {
+		if !merchantLabels.allow[merchantID] {
+			ranked = append(ranked, merchantID)
+		}
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		return merchantLabels.counts[ranked[i]] > merchantLabels.counts[ranked[j]]
+	})
+
+	top := make(map[string]bool, merchantLabels.cfg.MerchantTopN)
+	for _, merchantID := range ranked[:min(len(ranked), merchantLabels.cfg.MerchantTopN)] {
+		top[merchantID] = true
+	}
+	for merchantID := range merchantLabels.labeled {
+		if !top[merchantID] {
+			for _, vec := range merchantVecs {
+				vec.DeletePartialMatch(prometheus.Labels{"merchant": merchantID})
+			}
+		}
+	}
+
+	merchantLabels.labeled = top
+	merchantLabels.counts = make(map[string]int)
+}
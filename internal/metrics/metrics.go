@@ -0,0 +1,351 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "omnipos_gateway"
+
+// Registry holds all gateway metrics, including Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format, or in OpenMetrics (with exemplars)
+// to scrapers asking for it when openMetrics is set
+func Handler(openMetrics bool) http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry, EnableOpenMetrics: openMetrics})
+}
+
+// Rate limiter metrics
+var (
+	// RateLimitRequests counts rate limit decisions by route (gRPC method), key class and result
+	RateLimitRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rate_limit",
+		Name:      "requests_total",
+		Help:      "Rate limit decisions by route, key class and result (allowed, delayed, throttled, penalized).",
+	}, []string{"route", "key_class", "result"})
+
+	// RateLimitRedisDuration observes the latency of limiter calls to Redis
+	RateLimitRedisDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "rate_limit",
+		Name:      "redis_duration_seconds",
+		Help:      "Latency of rate limiter calls to Redis.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25},
+	}, []string{"algorithm", "error"})
+
+	// RateLimitFallbacks counts Redis failures by the failure policy that handled them
+	RateLimitFallbacks = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "rate_limit",
+		Name:      "fallback_total",
+		Help:      "Rate limiter Redis failures by failure policy (open, closed, local).",
+	}, []string{"policy"})
+)
+
+// Backend routing metrics
+var (
+	// ShadowRequests counts calls mirrored to shadow backends by gRPC service and result
+	ShadowRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "shadow",
+		Name:      "requests_total",
+		Help:      "Calls mirrored to shadow backends by gRPC service and result (ok, error, dropped).",
+	}, []string{"service", "result"})
+
+	// BackendRetries counts retried backend calls by gRPC service and the status code that triggered the retry
+	BackendRetries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "retries_total",
+		Help:      "Retried backend calls by gRPC service and the status code of the failed attempt.",
+	}, []string{"service", "code"})
+
+	// BackendHedges counts hedged attempts by gRPC service and whether the hedge answered first
+	BackendHedges = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "hedges_total",
+		Help:      "Hedged backend attempts by gRPC service and result (won, lost).",
+	}, []string{"service", "result"})
+
+	// BackendConnectionState reports the state of each backend connection: 1 for the current state, 0 otherwise
+	BackendConnectionState = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "connection_state",
+		Help:      "State of backend connections by address (1 for the current state: IDLE, CONNECTING, READY, TRANSIENT_FAILURE, SHUTDOWN).",
+	}, []string{"addr", "state"})
+
+	// BackendDialAttempts counts connection attempts to backend hosts by configured address and result
+	BackendDialAttempts = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "dial_attempts_total",
+		Help:      "Backend connection attempts by address and result (success, error).",
+	}, []string{"addr", "result"})
+
+	// BackendStateTransitions counts the state changes of backend connections by address, the services
+	// hosted there and new state
+	BackendStateTransitions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "state_transitions_total",
+		Help:      "State changes of backend connections by address, services and new state.",
+	}, []string{"addr", "services", "state"})
+
+	// BackendFailingSince is the time the connection to a backend entered TRANSIENT_FAILURE, 0 when it isn't failing
+	BackendFailingSince = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "failing_since_seconds",
+		Help:      "Unix time the backend connection entered TRANSIENT_FAILURE by address and services, 0 when it isn't failing.",
+	}, []string{"addr", "services"})
+
+	// BackendFailureDuration observes how long backend connections stayed in TRANSIENT_FAILURE
+	BackendFailureDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "transient_failure_duration_seconds",
+		Help:      "Time backend connections spent in TRANSIENT_FAILURE before recovering, by address and services.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 300, 900, 3600},
+	}, []string{"addr", "services"})
+
+	// BackendResolverUpdates counts the address updates of backend connections (reroutes, failovers,
+	// service discovery) by address, services and result (update, error)
+	BackendResolverUpdates = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "resolver_updates_total",
+		Help:      "Address updates of backend connections by address, services and result (update, error).",
+	}, []string{"addr", "services", "result"})
+
+	// BackendRequestDuration observes the latency of each backend call attempt by backend services and gRPC method
+	BackendRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of backend call attempts (unary) by backend services and gRPC method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"services", "method"})
+
+	// BackendRequests counts calls that reached a backend by address and gRPC status code
+	BackendRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "requests_total",
+		Help:      "Backend calls by address and gRPC status code.",
+	}, []string{"addr", "code"})
+
+	// OutlierEjections counts backend hosts ejected from load balancing for their error rate
+	OutlierEjections = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "backend",
+		Name:      "outlier_ejections_total",
+		Help:      "Backend hosts ejected from load balancing for their error rate, by host.",
+	}, []string{"host"})
+
+	// CircuitBreakerState reports the circuit of each backend: 0 closed, 1 half-open, 2 open
+	CircuitBreakerState = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "circuit_breaker",
+		Name:      "state",
+		Help:      "Circuit breaker state by backend (0 closed, 1 half-open, 2 open).",
+	}, []string{"backend"})
+
+	// CircuitBreakerRejections counts calls fast-failed by an open circuit
+	CircuitBreakerRejections = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "circuit_breaker",
+		Name:      "rejections_total",
+		Help:      "Backend calls fast-failed by an open circuit, by backend.",
+	}, []string{"backend"})
+)
+
+// Audit metrics
+var (
+	// AuditRecords counts the audit records of mutating requests by result
+	AuditRecords = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "audit",
+		Name:      "records_total",
+		Help:      "Audit records forwarded to the audit service by result (sent, failed, dropped).",
+	}, []string{"result"})
+)
+
+// Error reporting metrics
+var (
+	// ErrorReports counts the errors reported to the error tracker by result
+	ErrorReports = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "error_reporting",
+		Name:      "events_total",
+		Help:      "Errors reported to the error tracker by result (sent, failed, dropped).",
+	}, []string{"result"})
+)
+
+// OTLP export metrics
+var (
+	// OTLPExports counts the pushes of the metrics to the OTLP collector by result
+	OTLPExports = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "otlp",
+		Name:      "exports_total",
+		Help:      "Pushes of the metrics to the OTLP collector by result (sent, failed).",
+	}, []string{"result"})
+)
+
+// Tracing metrics
+var (
+	// TraceSamplingDecisions counts the traces started by the gateway by route (gRPC method) and decision
+	TraceSamplingDecisions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "tracing",
+		Name:      "sampling_decisions_total",
+		Help:      "Traces started by the gateway by route (gRPC method, or unmatched) and whether they're sampled.",
+	}, []string{"route", "sampled"})
+)
+
+// Usage metrics
+var (
+	// UsageExports counts the daily usage records exported for billing by result
+	UsageExports = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "usage",
+		Name:      "exports_total",
+		Help:      "Daily usage records exported for billing by result (sent, failed).",
+	}, []string{"result"})
+)
+
+// Synthetic probe metrics
+var (
+	// ProbeRequests counts the synthetic probe requests by probe and result
+	ProbeRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "probe",
+		Name:      "requests_total",
+		Help:      "Synthetic probe requests through the gateway by probe and result (success, failure).",
+	}, []string{"probe", "result"})
+
+	// ProbeDuration observes the latency of the synthetic probe requests by probe
+	ProbeDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "probe",
+		Name:      "duration_seconds",
+		Help:      "Latency of synthetic probe requests through the gateway by probe.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"probe"})
+
+	// ProbeUp reports whether the last request of each probe succeeded
+	ProbeUp = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "probe",
+		Name:      "up",
+		Help:      "Whether the last synthetic probe request succeeded (1) or failed (0), by probe.",
+	}, []string{"probe"})
+)
+
+// Access log metrics
+var (
+	// AccessLogEntries counts the access log entries exported by result
+	AccessLogEntries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "access_log",
+		Name:      "entries_total",
+		Help:      "Access log entries exported to the sink by result (sent, failed, dropped).",
+	}, []string{"result"})
+)
+
+// Security event metrics
+var (
+	// SecurityEvents counts the security events by type
+	SecurityEvents = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "security",
+		Name:      "events_total",
+		Help:      "Security events (auth failures, rate-limit hits, IP bans, signature failures) by type.",
+	}, []string{"type"})
+
+	// SecurityEventExports counts the security events produced to Kafka by result
+	SecurityEventExports = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "security",
+		Name:      "exports_total",
+		Help:      "Security events produced to the Kafka topic by result (sent, failed, dropped).",
+	}, []string{"result"})
+)
+
+// Request metrics
+var (
+	// HTTPRequests counts requests by route (gRPC method), status code and merchant (see MerchantLabel)
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests by route (gRPC method, or unmatched), status code and merchant.",
+	}, []string{"route", "code", "merchant"})
+
+	// Panics counts the panics recovered by layer (http, grpc_client)
+	Panics = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "panics_total",
+		Help:      "Panics recovered by layer (http, grpc_client).",
+	}, []string{"layer"})
+
+	// HTTPInFlight reports the requests being served
+	HTTPInFlight = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_in_flight",
+		Help:      "HTTP requests being served.",
+	})
+
+	// HTTPRequestDuration observes the end-to-end latency of requests by route (gRPC method), from the
+	// gateway's point of view; compare with BackendRequestDuration to tell gateway overhead from backend time
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "End-to-end latency of HTTP requests by route (gRPC method, or unmatched).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route"})
+
+	// DeprecatedRequests counts the requests to deprecated methods by route (gRPC method) and merchant
+	// (see MerchantLabel), to tell when a route can be removed
+	DeprecatedRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "deprecated_requests_total",
+		Help:      "Requests to deprecated methods by route (gRPC method) and merchant.",
+	}, []string{"route", "merchant"})
+
+	// QuotaConsumed counts requests counted against monthly quotas by merchant
+	QuotaConsumed = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "quota",
+		Name:      "consumed_total",
+		Help:      "Requests counted against monthly quotas by merchant.",
+	}, []string{"merchant"})
+
+	// SlowRequests counts requests over the slow request threshold by route (gRPC method)
+	SlowRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "slow_requests_total",
+		Help:      "Requests slower than the slow request threshold by route (gRPC method, or unmatched).",
+	}, []string{"route"})
+)
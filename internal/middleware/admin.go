@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-gateway/internal/security"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// AdminTokenHeader carries the static admin token for gateway admin endpoints
+const AdminTokenHeader = "X-Admin-Token"
+
+// AdminAuth protects gateway admin endpoints with the static admin token.
+// Admin endpoints are disabled entirely when no token is configured.
+func AdminAuth(cfg config.AdminConfig, log logger.ZapLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.Token == "" {
+				customRuntime.WriteResponse(w, http.StatusNotFound, "admin api is disabled", nil)
+				return
+			}
+
+			token := r.Header.Get(AdminTokenHeader)
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) != 1 {
+				log.Warn("admin authentication failed", zap.String("path", r.URL.Path), zap.String("ip", ClientIP(r)))
+				RecordSecurityEvent(r, security.AuthFailure, "admin", "invalid admin token", "")
+				customRuntime.WriteResponse(w, http.StatusUnauthorized, "invalid admin token", nil)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -3,7 +3,9 @@ package middleware
 import (
 	"context"
 	"strings"
+	"time"
 
+	"github.com/fekuna/omnipos-gateway/internal/security"
 	"github.com/fekuna/omnipos-pkg/logger"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"go.uber.org/zap"
@@ -40,76 +42,163 @@ func (a *AuthInterceptor) Unary() grpc.UnaryClientInterceptor {
 		invoker grpc.UnaryInvoker,
 		opts ...grpc.CallOption,
 	) error {
-		// Skip authentication for public endpoints
-		if a.publicEndpoints[method] {
-			return invoker(ctx, method, req, reply, cc, opts...)
+		start := time.Now()
+		authCtx, err := a.authenticate(ctx, method)
+		RecordServerTiming(ctx, TimingAuth, time.Since(start))
+		if err != nil {
+			return err
 		}
 
-		// Try to get metadata from incoming context first (from grpc-gateway)
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok || len(md) == 0 {
-			// If not in incoming context, try outgoing context
-			md, ok = metadata.FromOutgoingContext(ctx)
-		}
+		// Call the actual gRPC method
+		return invoker(authCtx, method, req, reply, cc, opts...)
+	}
+}
 
-		if !ok || len(md) == 0 {
-			a.logger.Warn("no metadata found in request context")
-			return status.Error(codes.Unauthenticated, "missing authorization header")
+// Stream returns a stream client interceptor for authentication, used by the gRPC proxies
+func (a *AuthInterceptor) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		start := time.Now()
+		authCtx, err := a.authenticate(ctx, method)
+		RecordServerTiming(ctx, TimingAuth, time.Since(start))
+		if err != nil {
+			return nil, err
 		}
 
-		// Debug: Log all metadata keys
-		a.logger.Info("🔍 INSPECTING METADATA KEYS")
-		for key, values := range md {
-			a.logger.Info("metadata key found", zap.String("key", key), zap.Strings("values", values))
-		}
+		return streamer(authCtx, desc, cc, method, opts...)
+	}
+}
 
-		// Get authorization header
-		authHeaders := md.Get("authorization")
-		if len(authHeaders) == 0 {
-			// Try grpcgateway-authorization (grpc-gateway specific)
-			authHeaders = md.Get("grpcgateway-authorization")
-		}
+type (
+	merchantKey      struct{}
+	gatewayCallerKey struct{}
+)
 
-		if len(authHeaders) == 0 {
-			a.logger.Warn("no authorization header found in metadata")
-			return status.Error(codes.Unauthenticated, "missing authorization header")
-		}
+// WithMerchant authenticates the backend calls made with ctx as merchantID without a bearer token,
+// for gateway-issued credentials such as signed receipt links
+func WithMerchant(ctx context.Context, merchantID string) context.Context {
+	return context.WithValue(ctx, merchantKey{}, merchantID)
+}
 
-		// Extract token from "Bearer <token>"
-		authHeader := authHeaders[0]
-		if !strings.HasPrefix(authHeader, "Bearer ") {
-			a.logger.Warn("invalid authorization header format", zap.String("header", authHeader))
-			return status.Error(codes.Unauthenticated, "invalid authorization header format")
-		}
+// WithGatewayCaller marks the backend calls made with ctx as made by the gateway itself on behalf of
+// caller (e.g. "webhook:stripe") after its own verification; they carry x-gateway-caller instead of a merchant
+func WithGatewayCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, gatewayCallerKey{}, caller)
+}
 
-		token := strings.TrimPrefix(authHeader, "Bearer ")
+// authenticate validates the bearer token of non-public methods and adds the merchant ID
+// to the outgoing metadata for internal services
+func (a *AuthInterceptor) authenticate(ctx context.Context, method string) (context.Context, error) {
+	// Skip authentication for public endpoints
+	if a.publicEndpoints[method] {
+		return ctx, nil
+	}
 
-		// Validate token and extract merchant ID
-		merchantID, err := a.jwtHelper.ExtractMerchantID(token)
-		if err != nil {
-			a.logger.Warn("token validation failed", zap.Error(err))
-			if err == ErrExpiredToken {
-				return status.Error(codes.Unauthenticated, "token has expired")
-			}
-			return status.Error(codes.Unauthenticated, "invalid token")
-		}
+	// Calls already authenticated by the gateway itself
+	if merchantID, ok := ctx.Value(merchantKey{}).(string); ok && merchantID != "" {
+		return metadata.AppendToOutgoingContext(ctx, "x-merchant-id", merchantID), nil
+	}
+	if caller, ok := ctx.Value(gatewayCallerKey{}).(string); ok && caller != "" {
+		return metadata.AppendToOutgoingContext(ctx, "x-gateway-caller", caller), nil
+	}
+
+	// Try to get metadata from incoming context first (from grpc-gateway)
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md) == 0 {
+		// If not in incoming context, try outgoing context
+		md, ok = metadata.FromOutgoingContext(ctx)
+	}
+
+	if !ok || len(md) == 0 {
+		a.logger.Warn("no metadata found in request context")
+		return nil, a.reject(ctx, md, method, "missing authorization header")
+	}
+
+	// Debug: Log all metadata keys
+	a.logger.Info("🔍 INSPECTING METADATA KEYS")
+	for key, values := range md {
+		a.logger.Info("metadata key found", zap.String("key", key), zap.Strings("values", values))
+	}
+
+	// Get authorization header
+	authHeaders := md.Get("authorization")
+	if len(authHeaders) == 0 {
+		// Try grpcgateway-authorization (grpc-gateway specific)
+		authHeaders = md.Get("grpcgateway-authorization")
+	}
 
-		a.logger.Debug("authentication successful", zap.String("merchant_id", merchantID))
+	if len(authHeaders) == 0 {
+		a.logger.Warn("no authorization header found in metadata")
+		return nil, a.reject(ctx, md, method, "missing authorization header")
+	}
+
+	// Extract token from "Bearer <token>"
+	authHeader := authHeaders[0]
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		a.logger.Warn("invalid authorization header format", zap.String("header", authHeader))
+		return nil, a.reject(ctx, md, method, "invalid authorization header format")
+	}
 
-		// Add merchant ID to outgoing metadata for internal service
-		outgoingMD := metadata.Pairs(
-			"x-merchant-id", merchantID,
-		)
+	token := strings.TrimPrefix(authHeader, "Bearer ")
 
-		// Merge with existing outgoing metadata if any
-		if existingMD, ok := metadata.FromOutgoingContext(ctx); ok {
-			outgoingMD = metadata.Join(existingMD, outgoingMD)
+	// Validate token and extract merchant ID
+	claims, err := a.jwtHelper.Claims(ctx, token)
+	if err != nil {
+		a.logger.Warn("token validation failed", zap.Error(err))
+		if err == ErrExpiredToken {
+			return nil, a.reject(ctx, md, method, "token has expired")
 		}
+		return nil, a.reject(ctx, md, method, "invalid token")
+	}
 
-		ctx = metadata.NewOutgoingContext(ctx, outgoingMD)
+	a.logger.Debug("authentication successful", zap.String("merchant_id", claims.MerchantID))
 
-		// Call the actual gRPC method
-		return invoker(ctx, method, req, reply, cc, opts...)
+	// Add merchant ID to outgoing metadata for internal service
+	outgoingMD := metadata.Pairs(
+		"x-merchant-id", claims.MerchantID,
+	)
+
+	// Merge with existing outgoing metadata if any
+	if existingMD, ok := metadata.FromOutgoingContext(ctx); ok {
+		outgoingMD = metadata.Join(existingMD, outgoingMD)
+	}
+
+	ctx = metadata.NewOutgoingContext(ctx, outgoingMD)
+	return ctx, nil
+}
+
+// reject records the authentication failure of a call to method and returns its error. The client IP
+// is the one grpc-gateway forwards, when there's one.
+func (a *AuthInterceptor) reject(ctx context.Context, md metadata.MD, method, reason string) error {
+	var ip string
+	if forwarded := md.Get("x-forwarded-for"); len(forwarded) > 0 {
+		ip = strings.TrimSpace(strings.Split(forwarded[0], ",")[0])
+	}
+	security.Record(ctx, security.Event{
+		Type:      security.AuthFailure,
+		Source:    "auth",
+		Reason:    reason,
+		IP:        ip,
+		Principal: "ip:" + ip,
+		Path:      method,
+	})
+	return status.Error(codes.Unauthenticated, reason)
+}
+
+// OutgoingHeaderMatcher maps gRPC response header metadata to HTTP headers. Content-Disposition is
+// passed through as is so raw downloads (google.api.HttpBody) can be saved under a filename.
+func OutgoingHeaderMatcher(key string) (string, bool) {
+	switch strings.ToLower(key) {
+	case "content-disposition":
+		return "Content-Disposition", true
+	default:
+		return runtime.MetadataHeaderPrefix + key, true
 	}
 }
 
@@ -118,6 +207,9 @@ func HTTPHeaderMatcher(key string) (string, bool) {
 	switch strings.ToLower(key) {
 	case "authorization":
 		return "authorization", true
+	case "grpc-metadata-x-merchant-id", "grpc-metadata-x-gateway-caller", "grpc-metadata-x-tenant-id":
+		// Set by the gateway only
+		return "", false
 	default:
 		return runtime.DefaultHeaderMatcher(key)
 	}
@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"context"
+	"math/rand/v2"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+type backendKey struct{}
+
+// WithBackend pins the backend calls made with ctx to addr instead of the service's default address
+func WithBackend(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, backendKey{}, addr)
+}
+
+func backendFromContext(ctx context.Context) (string, bool) {
+	addr, ok := ctx.Value(backendKey{}).(string)
+	return addr, ok && addr != ""
+}
+
+// BackendDialer dials the backends calls are routed to, with the settings of the connection they were
+// made on (see backend.Manager.RoutedConn), and tells which address a connection was dialed for
+type BackendDialer interface {
+	RoutedConn(from *grpc.ClientConn, addr string) (*grpc.ClientConn, error)
+	ConfiguredAddr(conn *grpc.ClientConn) (string, bool)
+}
+
+// BackendRouter redirects backend calls away from the connection they were made on: to the address
+// pinned in their context (see WithBackend), so one grpc-gateway registration per service can reach
+// several generations of that service, or to the service's canary for a share of the calls.
+// Other calls go to the connection they were made on. A sample of unary calls can also be mirrored to
+// a shadow backend, fire-and-forget, to try new service versions against real traffic.
+type BackendRouter struct {
+	canary      config.CanaryConfig
+	shadow      config.ShadowConfig
+	shadowSlots chan struct{}
+	idempotent  map[string]bool
+	dialer      BackendDialer
+	logger      logger.ZapLogger
+}
+
+// NewBackendRouter creates a backend router; connections to other addresses are dialed lazily by dialer.
+// Only the idempotent methods (see DiscoverIdempotentMethods) are mirrored to shadows.
+func NewBackendRouter(canary config.CanaryConfig, shadow config.ShadowConfig, idempotentMethods map[string]bool, dialer BackendDialer, log logger.ZapLogger) *BackendRouter {
+	return &BackendRouter{
+		canary:      canary,
+		shadow:      shadow,
+		shadowSlots: make(chan struct{}, max(shadow.MaxInFlight, 1)),
+		idempotent:  idempotentMethods,
+		dialer:      dialer,
+		logger:      log,
+	}
+}
+
+// Unary returns a unary client interceptor routing calls to their pinned or canary backend
+func (b *BackendRouter) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		b.mirror(ctx, method, req, opts)
+
+		if addr, ok := b.target(ctx, method); ok && !b.dialedFor(cc, addr) {
+			conn, err := b.dialer.RoutedConn(cc, addr)
+			if err != nil {
+				return err
+			}
+			return conn.Invoke(ctx, method, req, reply, opts...)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Stream returns a stream client interceptor routing streams to their pinned or canary backend
+func (b *BackendRouter) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		if addr, ok := b.target(ctx, method); ok && !b.dialedFor(cc, addr) {
+			conn, err := b.dialer.RoutedConn(cc, addr)
+			if err != nil {
+				return nil, err
+			}
+			return conn.NewStream(ctx, desc, method, opts...)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// dialedFor reports whether cc is the connection of addr, whose calls need no other connection.
+// cc.Target() can't tell: it's the resolver target ("backend:///host:port"), not the address.
+func (b *BackendRouter) dialedFor(cc *grpc.ClientConn, addr string) bool {
+	configured, ok := b.dialer.ConfiguredAddr(cc)
+	return ok && configured == addr
+}
+
+// target returns the address a call should be sent to instead of its connection's, if any.
+// A pinned backend takes precedence over the canary.
+func (b *BackendRouter) target(ctx context.Context, method string) (string, bool) {
+	if addr, ok := backendFromContext(ctx); ok {
+		return addr, true
+	}
+	if !b.canary.Enabled {
+		return "", false
+	}
+
+	service := serviceName(method)
+	addr, ok := b.canary.Backends[service]
+	if !ok || addr == "" {
+		return "", false
+	}
+
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && b.canary.Header != "" {
+		for _, value := range md.Get(b.canary.Header) {
+			if value == b.canary.HeaderValue {
+				return addr, true
+			}
+		}
+	}
+
+	if percent := b.canary.Percent[service]; percent > 0 && rand.IntN(100) < percent {
+		return addr, true
+	}
+	return "", false
+}
+
+// shadowStrippedMetadata are the credentials and identity of the caller, never sent to shadows
+var shadowStrippedMetadata = []string{
+	"authorization", "grpcgateway-authorization", "cookie", "grpcgateway-cookie", "x-merchant-id", "x-gateway-caller",
+}
+
+// mirror sends a copy of a sampled idempotent unary call to the service's shadow backend in the
+// background; the response is discarded. Calls that change state aren't mirrored, they would be
+// applied twice wherever the shadow shares a database or downstream services with production.
+func (b *BackendRouter) mirror(ctx context.Context, method string, req interface{}, opts []grpc.CallOption) {
+	if !b.shadow.Enabled || !b.idempotent[method] {
+		return
+	}
+
+	service := serviceName(method)
+	addr, ok := b.shadow.Backends[service]
+	if !ok || addr == "" {
+		return
+	}
+	if percent := b.shadow.Percent[service]; percent <= 0 || rand.IntN(100) >= percent {
+		return
+	}
+
+	select {
+	case b.shadowSlots <- struct{}{}:
+	default:
+		metrics.ShadowRequests.WithLabelValues(service, "dropped").Inc()
+		return
+	}
+
+	shadowCtx, cancel := context.WithTimeout(b.shadowContext(ctx), b.shadow.Timeout)
+	opts = shadowCallOptions(opts)
+	go func() {
+		defer func() { <-b.shadowSlots }()
+		defer cancel()
+
+		// Dialed with the settings of the shadow's own address, not the credentials of the production service
+		conn, err := b.dialer.RoutedConn(nil, addr)
+		if err == nil {
+			// The shadow's response is never read, so decode it into an empty message
+			err = conn.Invoke(shadowCtx, method, req, &emptypb.Empty{}, opts...)
+		}
+		if err != nil {
+			b.logger.Debug("shadow call failed", zap.String("method", method), zap.String("addr", addr), zap.Error(err))
+			metrics.ShadowRequests.WithLabelValues(service, "error").Inc()
+			return
+		}
+		metrics.ShadowRequests.WithLabelValues(service, "ok").Inc()
+	}()
+}
+
+// shadowContext detaches the mirrored call from the request so it outlives it, keeping the outgoing
+// metadata (request ID, locale) but the caller's credentials, which are replaced by shadow.Metadata
+func (b *BackendRouter) shadowContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	for _, key := range shadowStrippedMetadata {
+		md.Delete(key)
+	}
+	for key, value := range b.shadow.Metadata {
+		md.Set(key, value)
+	}
+	return metadata.NewOutgoingContext(context.Background(), md)
+}
+
+// shadowCallOptions drops the options capturing call results (headers, trailers, peer) so the mirrored
+// call doesn't write into the original call's results
+func shadowCallOptions(opts []grpc.CallOption) []grpc.CallOption {
+	kept := make([]grpc.CallOption, 0, len(opts))
+	for _, opt := range opts {
+		switch opt.(type) {
+		case grpc.HeaderCallOption, grpc.TrailerCallOption, grpc.PeerCallOption, grpc.OnFinishCallOption:
+			continue
+		}
+		kept = append(kept, opt)
+	}
+	return kept
+}
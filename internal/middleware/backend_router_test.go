@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+var errTestDial = errors.New("dial refused")
+
+// recordingDialer records the connection calls were routed from, and refuses to dial
+type recordingDialer struct {
+	conn       *grpc.ClientConn
+	configured string
+	from       *grpc.ClientConn
+	addr       string
+}
+
+func (d *recordingDialer) RoutedConn(from *grpc.ClientConn, addr string) (*grpc.ClientConn, error) {
+	d.from, d.addr = from, addr
+	return nil, errTestDial
+}
+
+func (d *recordingDialer) ConfiguredAddr(conn *grpc.ClientConn) (string, bool) {
+	return d.configured, conn != nil && conn == d.conn
+}
+
+func TestBackendRouter_Unary_PinnedBackend(t *testing.T) {
+	cc, err := grpc.NewClient("backend:///order-service:8083", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer cc.Close()
+
+	dialer := &recordingDialer{conn: cc, configured: "order-service:8083"}
+	router := NewBackendRouter(config.CanaryConfig{}, config.ShadowConfig{}, nil, dialer, logger.NewZapLogger(&logger.ZapLoggerConfig{}))
+	interceptor := router.Unary()
+	invoked := false
+	invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		invoked = true
+		return nil
+	}
+	const method = "/order.v1.OrderService/GetOrder"
+
+	// A version-routed call is dialed like the connection it was made on
+	ctx := WithBackend(context.Background(), "order-service-v2:8083")
+	if err := interceptor(ctx, method, &emptypb.Empty{}, &emptypb.Empty{}, cc, invoker); !errors.Is(err, errTestDial) {
+		t.Fatalf("expected the call to go through the dialer, got %v", err)
+	}
+	if invoked || dialer.from != cc || dialer.addr != "order-service-v2:8083" {
+		t.Errorf("expected order-service-v2:8083 to be dialed from the service's connection, got %q (invoked %v)", dialer.addr, invoked)
+	}
+
+	dialer.addr = ""
+	if err := interceptor(context.Background(), method, &emptypb.Empty{}, &emptypb.Empty{}, cc, invoker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !invoked || dialer.addr != "" {
+		t.Errorf("expected an unpinned call to stay on its connection")
+	}
+
+	// A call pinned to the connection's own address stays on it
+	invoked = false
+	ctx = WithBackend(context.Background(), "order-service:8083")
+	if err := interceptor(ctx, method, &emptypb.Empty{}, &emptypb.Empty{}, cc, invoker); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !invoked || dialer.addr != "" {
+		t.Errorf("expected a call pinned to order-service:8083 to stay on its connection, dialed %q", dialer.addr)
+	}
+}
+
+// mirrorDialer reports the shadow calls the router dials, and refuses to dial
+type mirrorDialer chan *grpc.ClientConn
+
+func (d mirrorDialer) RoutedConn(from *grpc.ClientConn, addr string) (*grpc.ClientConn, error) {
+	d <- from
+	return nil, errTestDial
+}
+
+func (d mirrorDialer) ConfiguredAddr(*grpc.ClientConn) (string, bool) {
+	return "", false
+}
+
+func TestBackendRouter_Mirror(t *testing.T) {
+	dialer := make(mirrorDialer, 2)
+	shadow := config.ShadowConfig{
+		Enabled:     true,
+		Backends:    map[string]string{"order.v1.OrderService": "order-service-staging:8083"},
+		Percent:     map[string]int{"order.v1.OrderService": 100},
+		Timeout:     time.Second,
+		MaxInFlight: 10,
+		Metadata:    map[string]string{"authorization": "Bearer staging"},
+	}
+	idempotent := map[string]bool{"/order.v1.OrderService/GetOrder": true}
+	router := NewBackendRouter(config.CanaryConfig{}, shadow, idempotent, dialer, logger.NewZapLogger(&logger.ZapLoggerConfig{}))
+	interceptor := router.Unary()
+	invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		return nil
+	}
+
+	// State-changing calls are never mirrored
+	_ = interceptor(context.Background(), "/order.v1.OrderService/CreateOrder", &emptypb.Empty{}, &emptypb.Empty{}, nil, invoker)
+	_ = interceptor(context.Background(), "/order.v1.OrderService/GetOrder", &emptypb.Empty{}, &emptypb.Empty{}, nil, invoker)
+	select {
+	case from := <-dialer:
+		if from != nil {
+			t.Errorf("expected the shadow to be dialed with its own settings")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected GetOrder to be mirrored")
+	}
+	select {
+	case <-dialer:
+		t.Error("expected CreateOrder not to be mirrored")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The caller's credentials and identity are replaced
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		"authorization", "Bearer production", "x-merchant-id", "m-1", "cookie", "session=abc", "x-request-id", "req-1")
+	md, _ := metadata.FromOutgoingContext(router.shadowContext(ctx))
+	if got := md.Get("authorization"); len(got) != 1 || got[0] != "Bearer staging" {
+		t.Errorf("expected the shadow authorization, got %v", got)
+	}
+	if len(md.Get("x-merchant-id")) > 0 || len(md.Get("cookie")) > 0 {
+		t.Errorf("expected the caller's identity to be stripped, got %v", md)
+	}
+	if got := md.Get("x-request-id"); len(got) != 1 || got[0] != "req-1" {
+		t.Errorf("expected the request ID to be kept, got %v", got)
+	}
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// BodyLimiter enforces a maximum request body size, with a larger limit for upload routes
+type BodyLimiter struct {
+	cfg           config.BodyLimitConfig
+	routes        *RouteTable
+	uploadMethods map[string]bool
+	logger        logger.ZapLogger
+}
+
+// NewBodyLimiter creates a new request body size limiter
+func NewBodyLimiter(cfg config.BodyLimitConfig, routes *RouteTable, log logger.ZapLogger) *BodyLimiter {
+	uploadMethods := make(map[string]bool, len(cfg.UploadMethods))
+	for _, method := range cfg.UploadMethods {
+		uploadMethods[method] = true
+	}
+
+	return &BodyLimiter{
+		cfg:           cfg,
+		routes:        routes,
+		uploadMethods: uploadMethods,
+		logger:        log,
+	}
+}
+
+// Limit rejects oversized bodies with a 413 envelope and caps the body reader for the rest of the chain
+func (b *BodyLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !b.cfg.Enabled || r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		maxBytes := b.maxBytes(r)
+
+		if r.ContentLength > maxBytes {
+			b.reject(w, r, maxBytes)
+			return
+		}
+
+		if r.ContentLength < 0 {
+			// Unknown length (chunked): buffer up to the limit so oversized bodies are rejected here
+			// instead of failing halfway through the backend call
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					b.reject(w, r, maxBytes)
+					return
+				}
+				customRuntime.WriteResponse(w, http.StatusBadRequest, "failed to read request body", nil)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+		} else {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (b *BodyLimiter) maxBytes(r *http.Request) int64 {
+	for _, prefix := range b.cfg.UploadPaths {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return b.cfg.UploadMaxBytes
+		}
+	}
+
+	if len(b.uploadMethods) > 0 {
+		if route, ok := b.routes.MatchRequest(r); ok && b.uploadMethods[route.Method] {
+			return b.cfg.UploadMaxBytes
+		}
+	}
+
+	return b.cfg.MaxBytes
+}
+
+func (b *BodyLimiter) reject(w http.ResponseWriter, r *http.Request, maxBytes int64) {
+	b.logger.Warn("request body too large",
+		zap.String("path", r.URL.Path), zap.Int64("content_length", r.ContentLength), zap.Int64("max_bytes", maxBytes))
+	customRuntime.WriteResponse(w, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("request body exceeds the maximum size of %d bytes", maxBytes),
+		map[string]int64{"max_bytes": maxBytes})
+}
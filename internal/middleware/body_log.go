@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	pkgMiddleware "github.com/fekuna/omnipos-pkg/middleware"
+	"go.uber.org/zap"
+)
+
+const redacted = "[REDACTED]"
+
+// BodyLogger logs request and response bodies for support investigations. It's opt-in: bodies are
+// captured up to cfg.MaxBytes, and JSON fields named in cfg.RedactFields or marked debug_redact in
+// the proto definitions are masked. Bodies that aren't JSON, or are truncated (and so can't be
+// redacted reliably), are logged by size only.
+type BodyLogger struct {
+	cfg    config.BodyLogConfig
+	redact map[string]bool // lower-cased field names
+	logger logger.ZapLogger
+}
+
+// NewBodyLogger creates a body logger masking cfg.RedactFields and redactedFields
+func NewBodyLogger(cfg config.BodyLogConfig, redactedFields map[string]bool, log logger.ZapLogger) *BodyLogger {
+	redact := make(map[string]bool, len(cfg.RedactFields)+len(redactedFields))
+	for _, field := range cfg.RedactFields {
+		redact[strings.ToLower(field)] = true
+	}
+	for field := range redactedFields {
+		redact[strings.ToLower(field)] = true
+	}
+
+	return &BodyLogger{
+		cfg:    cfg,
+		redact: redact,
+		logger: log,
+	}
+}
+
+// Log logs the bodies of requests to cfg.Paths (all paths when empty)
+func (b *BodyLogger) Log(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !b.cfg.Enabled || !b.logged(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var reqBody []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			// Read the captured prefix, then hand the whole body on
+			reqBody, _ = io.ReadAll(io.LimitReader(r.Body, int64(b.cfg.MaxBytes)+1))
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(reqBody), r.Body), r.Body}
+		}
+
+		rec := &bodyRecorder{StatusRecorder: NewStatusRecorder(w), max: b.cfg.MaxBytes}
+		next.ServeHTTP(rec, r)
+
+		b.logger.Info("http body",
+			zap.String("request_id", pkgMiddleware.GetRequestID(r.Context())),
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", rec.Status),
+			zap.String("request_body", b.format(reqBody, r.Header.Get("Content-Type"))),
+			zap.String("response_body", b.format(rec.body.Bytes(), rec.Header().Get("Content-Type"))),
+		)
+	})
+}
+
+func (b *BodyLogger) logged(path string) bool {
+	if len(b.cfg.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range b.cfg.Paths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// format returns the redacted JSON body, or a size summary of bodies that can't be redacted
+func (b *BodyLogger) format(body []byte, contentType string) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if len(body) > b.cfg.MaxBytes {
+		return fmt.Sprintf("[truncated body over %d bytes]", b.cfg.MaxBytes)
+	}
+
+	var v interface{}
+	if !strings.Contains(contentType, "json") || json.Unmarshal(body, &v) != nil {
+		return fmt.Sprintf("[%d bytes of %s]", len(body), contentType)
+	}
+	data, err := json.Marshal(b.redactValue(v))
+	if err != nil {
+		return fmt.Sprintf("[%d bytes of %s]", len(body), contentType)
+	}
+	return string(data)
+}
+
+// redactValue masks the redacted fields of v at any depth
+func (b *BodyLogger) redactValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for key, field := range val {
+			if b.redact[strings.ToLower(key)] {
+				val[key] = redacted
+			} else {
+				val[key] = b.redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = b.redactValue(item)
+		}
+	}
+	return v
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// bodyRecorder captures the status and the first max bytes of a response
+type bodyRecorder struct {
+	*StatusRecorder
+	max  int
+	body bytes.Buffer
+}
+
+func (r *bodyRecorder) Write(p []byte) (int, error) {
+	// Keep one byte over the cap to tell truncated bodies apart
+	if room := r.max + 1 - r.body.Len(); room > 0 {
+		r.body.Write(p[:min(len(p), room)])
+	}
+	return r.StatusRecorder.Write(p)
+}
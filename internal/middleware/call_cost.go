@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrCallLimited rejects an additional backend call of a request once a limiter is exhausted
+var ErrCallLimited = errors.New("rate limit or quota exceeded")
+
+// callCharge charges one additional backend call to the limiter that admitted the request
+type callCharge func(ctx context.Context) error
+
+type callChargesKey struct{}
+
+// withCallCharge records a limiter's charge in the request context, after the ones of the
+// limiters before it in the chain
+func withCallCharge(r *http.Request, charge callCharge) *http.Request {
+	charges, _ := r.Context().Value(callChargesKey{}).([]callCharge)
+	charges = append(charges[:len(charges):len(charges)], charge)
+	return r.WithContext(context.WithValue(r.Context(), callChargesKey{}, charges))
+}
+
+// ChargeCall charges one more backend call of the request of ctx to the rate limiter and quota that
+// admitted it. Handlers fanning one request out into several calls (GraphQL fields) charge every
+// call after the first, which the request itself paid for. It returns ErrCallLimited once a limiter
+// is exhausted.
+func ChargeCall(ctx context.Context) error {
+	charges, _ := ctx.Value(callChargesKey{}).([]callCharge)
+	for _, charge := range charges {
+		if err := charge(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestChargeCall(t *testing.T) {
+	if err := ChargeCall(context.Background()); err != nil {
+		t.Fatalf("expected requests without limiters to be free, got %v", err)
+	}
+
+	var calls []string
+	charge := func(name string, err error) callCharge {
+		return func(ctx context.Context) error {
+			calls = append(calls, name)
+			return err
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/graphql", nil)
+	r = withCallCharge(r, charge("rate_limit", nil))
+	limited := withCallCharge(r, charge("quota", ErrCallLimited))
+	allowed := withCallCharge(r, charge("quota", nil))
+
+	if err := ChargeCall(limited.Context()); !errors.Is(err, ErrCallLimited) {
+		t.Errorf("expected ErrCallLimited, got %v", err)
+	}
+	if err := ChargeCall(allowed.Context()); err != nil {
+		t.Errorf("expected the call to be allowed, got %v", err)
+	}
+	// Each request charges its own limiters, in chain order
+	if want := []string{"rate_limit", "quota", "rate_limit", "quota"}; !slices.Equal(calls, want) {
+		t.Errorf("expected charges %v, got %v", want, calls)
+	}
+}
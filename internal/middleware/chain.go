@@ -0,0 +1,13 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// Chain wraps h with the given middlewares; the first middleware is the outermost one
+func Chain(h http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Circuit states, as reported by the state gauge
+const (
+	circuitClosed = iota
+	circuitHalfOpen
+	circuitOpen
+)
+
+var circuitStateNames = map[int]string{
+	circuitClosed:   "closed",
+	circuitHalfOpen: "half-open",
+	circuitOpen:     "open",
+}
+
+// circuitOpenError is returned for calls to a backend whose circuit is open; it maps to a 503 envelope
+// with a Retry-After of the time left until the circuit lets probes through
+func circuitOpenError(retryAfter time.Duration) error {
+	st, err := status.New(codes.Unavailable, "service temporarily unavailable, please retry later").
+		WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		return status.Error(codes.Unavailable, "service temporarily unavailable, please retry later")
+	}
+	return st.Err()
+}
+
+// CircuitBreaker fast-fails calls to a backend that is down instead of letting every request wait for
+// the dial or deadline timeout. A backend's circuit opens after consecutive failures or a high failure
+// ratio, rejects calls for OpenTimeout, then lets a few probe calls through (half-open): a successful
+// probe closes the circuit, a failed one opens it again. Circuits are kept per backend address.
+type CircuitBreaker struct {
+	cfg    config.CircuitBreakerConfig
+	logger logger.ZapLogger
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state       int
+	openedAt    time.Time
+	probes      int // probe calls in flight while half-open
+	consecutive int
+	requests    int
+	failures    int
+	windowStart time.Time
+}
+
+// NewCircuitBreaker creates a circuit breaker
+func NewCircuitBreaker(cfg config.CircuitBreakerConfig, log logger.ZapLogger) *CircuitBreaker {
+	return &CircuitBreaker{
+		cfg:      cfg,
+		logger:   log,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// Unary returns a unary client interceptor guarding calls with the circuit of their backend
+func (cb *CircuitBreaker) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if !cb.cfg.Enabled {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		backend := cc.Target()
+		probe, ok := cb.allow(backend)
+		if !ok {
+			metrics.CircuitBreakerRejections.WithLabelValues(backend).Inc()
+			return cb.openError(backend)
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		cb.record(backend, probe, err)
+		return err
+	}
+}
+
+// Stream returns a stream client interceptor guarding stream creation with the circuit of their backend
+func (cb *CircuitBreaker) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		if !cb.cfg.Enabled {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+
+		backend := cc.Target()
+		probe, ok := cb.allow(backend)
+		if !ok {
+			metrics.CircuitBreakerRejections.WithLabelValues(backend).Inc()
+			return nil, cb.openError(backend)
+		}
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		cb.record(backend, probe, err)
+		return stream, err
+	}
+}
+
+// allow reports whether a call to backend may proceed, and whether it's a half-open probe
+func (cb *CircuitBreaker) allow(backend string) (probe bool, ok bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c := cb.circuit(backend)
+	switch c.state {
+	case circuitOpen:
+		if time.Since(c.openedAt) < cb.cfg.OpenTimeout {
+			return false, false
+		}
+		cb.setState(backend, c, circuitHalfOpen)
+		fallthrough
+	case circuitHalfOpen:
+		if c.probes >= max(cb.cfg.HalfOpenProbes, 1) {
+			return false, false
+		}
+		c.probes++
+		return true, true
+	}
+	return false, true
+}
+
+// openError returns the error of a call rejected by the circuit of backend
+func (cb *CircuitBreaker) openError(backend string) error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	// Half-open circuits busy with their probes are retried after a second
+	retryAfter := time.Second
+	if c := cb.circuit(backend); c.state == circuitOpen {
+		retryAfter = max(cb.cfg.OpenTimeout-time.Since(c.openedAt), retryAfter)
+	}
+	return circuitOpenError(retryAfter)
+}
+
+// record updates the circuit of backend with the outcome of a call
+func (cb *CircuitBreaker) record(backend string, probe bool, err error) {
+	failed := retryableCode(status.Code(err))
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	c := cb.circuit(backend)
+	if probe {
+		c.probes--
+		if c.state != circuitHalfOpen {
+			return
+		}
+		if failed {
+			cb.trip(backend, c)
+		} else {
+			cb.setState(backend, c, circuitClosed)
+		}
+		return
+	}
+	if c.state != circuitClosed {
+		// Calls admitted before the circuit opened don't count
+		return
+	}
+
+	if now := time.Now(); now.Sub(c.windowStart) > cb.cfg.Window {
+		c.requests, c.failures, c.windowStart = 0, 0, now
+	}
+	c.requests++
+	if !failed {
+		c.consecutive = 0
+		return
+	}
+	c.failures++
+	c.consecutive++
+
+	if c.consecutive >= cb.cfg.ConsecutiveFailures ||
+		(c.requests >= cb.cfg.MinRequests && float64(c.failures)/float64(c.requests) >= cb.cfg.FailureRatio) {
+		cb.trip(backend, c)
+	}
+}
+
+func (cb *CircuitBreaker) trip(backend string, c *circuit) {
+	c.openedAt = time.Now()
+	cb.setState(backend, c, circuitOpen)
+}
+
+// setState moves a circuit to state, resetting its counters
+func (cb *CircuitBreaker) setState(backend string, c *circuit, state int) {
+	if c.state == state {
+		return
+	}
+
+	logFn := cb.logger.Warn
+	if state == circuitClosed {
+		logFn = cb.logger.Info
+	}
+	logFn("circuit breaker state changed", zap.String("backend", backend),
+		zap.String("from", circuitStateNames[c.state]), zap.String("to", circuitStateNames[state]))
+
+	c.state = state
+	c.consecutive, c.requests, c.failures, c.windowStart = 0, 0, 0, time.Now()
+	metrics.CircuitBreakerState.WithLabelValues(backend).Set(float64(state))
+}
+
+// circuit returns the circuit of backend, creating a closed one on first use; callers hold cb.mu
+func (cb *CircuitBreaker) circuit(backend string) *circuit {
+	c, ok := cb.circuits[backend]
+	if !ok {
+		c = &circuit{windowStart: time.Now()}
+		cb.circuits[backend] = c
+	}
+	return c
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := NewCircuitBreaker(config.CircuitBreakerConfig{
+		Enabled:             true,
+		ConsecutiveFailures: 3,
+		FailureRatio:        1,
+		MinRequests:         100,
+		Window:              time.Minute,
+		OpenTimeout:         20 * time.Millisecond,
+		HalfOpenProbes:      1,
+	}, logger.NewZapLogger(&logger.ZapLoggerConfig{}))
+
+	const backend = "order-service:8083"
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	for i := 0; i < 3; i++ {
+		if _, ok := cb.allow(backend); !ok {
+			t.Fatalf("call %d rejected before the circuit tripped", i)
+		}
+		cb.record(backend, false, unavailable)
+	}
+	if _, ok := cb.allow(backend); ok {
+		t.Fatal("call allowed while the circuit is open")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	probe, ok := cb.allow(backend)
+	if !ok || !probe {
+		t.Fatal("probe not allowed after the open timeout")
+	}
+	if _, ok := cb.allow(backend); ok {
+		t.Fatal("second call allowed while the probe is in flight")
+	}
+
+	cb.record(backend, true, nil)
+	if probe, ok := cb.allow(backend); !ok || probe {
+		t.Fatal("circuit not closed after a successful probe")
+	}
+}
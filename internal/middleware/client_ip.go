@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// trustedProxies are the proxies whose forwarding headers ClientIP believes
+var trustedProxies atomic.Pointer[[]netip.Prefix]
+
+// ConfigureTrustedProxies sets the CIDRs (or single IPs) of the proxies in front of the gateway, e.g.
+// the load balancer. X-Forwarded-For and X-Real-IP are ignored unless the peer is one of them.
+func ConfigureTrustedProxies(cidrs []string) error {
+	prefixes, err := parsePrefixes(cidrs)
+	if err != nil {
+		return err
+	}
+	trustedProxies.Store(&prefixes)
+	return nil
+}
+
+// ClientIP returns the IP of the client of r. It's the peer address unless the peer is a trusted
+// proxy, in which case it's the rightmost X-Forwarded-For hop that isn't a trusted proxy (the hops left
+// of it were sent by the client and can't be believed), or X-Real-IP without X-Forwarded-For.
+func ClientIP(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	peer = strings.Trim(peer, "[]")
+	if !isTrustedProxy(peer) {
+		return peer
+	}
+
+	if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if hop == "" {
+				continue
+			}
+			if i == 0 || !isTrustedProxy(hop) {
+				return hop
+			}
+		}
+	}
+	if xrp := strings.TrimSpace(r.Header.Get("X-Real-IP")); xrp != "" {
+		return xrp
+	}
+	return peer
+}
+
+func isTrustedProxy(ip string) bool {
+	prefixes := trustedProxies.Load()
+	if prefixes == nil {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return containsAddr(*prefixes, addr.Unmap())
+}
@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fekuna/omnipos-gateway/config"
+)
+
+// compressor is implemented by the gzip and zlib writers, which are pooled
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressors creates the writer of each supported Content-Encoding at a compression level
+var compressors = map[string]func(level int) (compressor, error){
+	"gzip": func(level int) (compressor, error) {
+		return gzip.NewWriterLevel(io.Discard, level)
+	},
+	// HTTP's deflate is the zlib format (RFC 9110), not raw DEFLATE
+	"deflate": func(level int) (compressor, error) {
+		return zlib.NewWriterLevel(io.Discard, level)
+	},
+}
+
+// Compression compresses the responses of clients accepting it (Accept-Encoding), when they're at
+// least MinBytes long and of a configured content type. Responses already encoded by their handler,
+// e.g. precompressed static files, are left alone.
+type Compression struct {
+	encodings    []string
+	pools        map[string]*sync.Pool
+	minBytes     int
+	contentTypes []string
+}
+
+// NewCompression creates the compression middleware
+func NewCompression(cfg config.CompressionConfig) (*Compression, error) {
+	c := &Compression{
+		encodings:    cfg.Encodings,
+		pools:        make(map[string]*sync.Pool, len(cfg.Encodings)),
+		minBytes:     cfg.MinBytes,
+		contentTypes: cfg.ContentTypes,
+	}
+	for _, encoding := range cfg.Encodings {
+		newCompressor, ok := compressors[encoding]
+		if !ok {
+			return nil, fmt.Errorf("unsupported compression encoding %q", encoding)
+		}
+		if _, err := newCompressor(cfg.Level); err != nil {
+			return nil, fmt.Errorf("invalid compression level %d: %w", cfg.Level, err)
+		}
+		c.pools[encoding] = &sync.Pool{New: func() interface{} {
+			w, _ := newCompressor(cfg.Level)
+			return w
+		}}
+	}
+	return c, nil
+}
+
+// Compress negotiates the encoding of responses
+func (c *Compression) Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := c.negotiate(r.Header.Get("Accept-Encoding"))
+		// Upgraded connections (WebSockets) are hijacked, HEAD responses have no body
+		if encoding == "" || r.Header.Get("Upgrade") != "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Not deferred: after a panic the buffered start of the response is dropped, so the recovery
+		// middleware can still answer 500
+		cw := &compressWriter{ResponseWriter: w, compression: c, encoding: encoding}
+		next.ServeHTTP(cw, r)
+		cw.close()
+	})
+}
+
+// negotiate returns the preferred encoding accepted with a non-zero quality, "" if none is
+func (c *Compression) negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil {
+				quality = v
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = quality > 0
+	}
+
+	for _, encoding := range c.encodings {
+		if ok, listed := accepted[encoding]; listed {
+			if ok {
+				return encoding
+			}
+			continue
+		}
+		if accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+func (c *Compression) compressible(contentType string) bool {
+	for _, prefix := range c.contentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter buffers the start of the response until it's known to be long enough to compress
+type compressWriter struct {
+	http.ResponseWriter
+	compression *Compression
+	encoding    string
+	status      int
+	buf         []byte
+	decided     bool
+	compressor  compressor // nil when the response is sent uncompressed
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	switch {
+	case w.decided:
+		w.ResponseWriter.WriteHeader(status)
+	case status < http.StatusOK:
+		// Informational responses (103 Early Hints) precede the actual one
+		w.ResponseWriter.WriteHeader(status)
+	case w.status == 0:
+		w.status = status
+		if status == http.StatusNoContent || status == http.StatusNotModified {
+			_ = w.decide(false)
+		}
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.compressor != nil {
+			return w.compressor.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.compression.minBytes {
+		if err := w.decide(w.compressible()); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush compresses streamed responses regardless of the size of what's buffered so far
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		if err := w.decide(w.compressible()); err != nil {
+			return
+		}
+	}
+	if w.compressor != nil {
+		if err := w.compressor.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// compressible tells whether the response may be compressed, and varies it on Accept-Encoding if so
+func (w *compressWriter) compressible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		// Sniffed before compressing, the server would sniff the compressed bytes otherwise
+		contentType = http.DetectContentType(w.buf)
+		header.Set("Content-Type", contentType)
+	}
+	if !w.compression.compressible(contentType) {
+		return false
+	}
+	header.Add("Vary", "Accept-Encoding")
+	return true
+}
+
+// decide sends the header and what's buffered, compressed or not
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if compress {
+		header := w.Header()
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		// The compressed representation isn't byte-for-byte the one a strong ETag identifies
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.compressor = w.compression.pools[w.encoding].Get().(compressor)
+		w.compressor.Reset(w.ResponseWriter)
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	if w.compressor != nil {
+		_, err := w.compressor.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+// close sends short responses uncompressed and finishes compressed ones
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			return // nothing written, the server answers 200
+		}
+		_ = w.decide(false)
+		return
+	}
+	if w.compressor != nil {
+		_ = w.compressor.Close()
+		w.compressor.Reset(io.Discard)
+		w.compression.pools[w.encoding].Put(w.compressor)
+		w.compressor = nil
+	}
+}
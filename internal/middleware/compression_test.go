@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fekuna/omnipos-gateway/config"
+)
+
+func TestCompression_Compress(t *testing.T) {
+	compression, err := NewCompression(config.CompressionConfig{
+		Encodings:    []string{"gzip", "deflate"},
+		Level:        5,
+		MinBytes:     1024,
+		ContentTypes: []string{"application/json"},
+	})
+	if err != nil {
+		t.Fatalf("NewCompression: %v", err)
+	}
+
+	large := `{"data":"` + strings.Repeat("x", 4096) + `"}`
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		want           string // Content-Encoding
+	}{
+		{"large JSON", "br, deflate, gzip", "application/json", large, "gzip"},
+		{"deflate", "br, deflate", "application/json", large, "deflate"},
+		{"preferred encoding refused", "gzip;q=0, deflate", "application/json", large, "deflate"},
+		{"wildcard", "*", "application/json", large, "gzip"},
+		{"not accepted", "br, zstd", "application/json", large, ""},
+		{"small JSON", "gzip", "application/json", `{"status":200}`, ""},
+		{"other content type", "gzip", "application/pdf", large, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := compression.Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(http.StatusCreated)
+				// Written in pieces, below MinBytes at first
+				_, _ = io.WriteString(w, tt.body[:len(tt.body)/2])
+				_, _ = io.WriteString(w, tt.body[len(tt.body)/2:])
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/products", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusCreated {
+				t.Errorf("expected status 201, got %d", rec.Code)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.want {
+				t.Fatalf("expected Content-Encoding %q, got %q", tt.want, got)
+			}
+
+			if body := decompress(t, tt.want, rec.Body); body != tt.body {
+				t.Errorf("expected the body to round-trip, got %d bytes", len(body))
+			}
+		})
+	}
+}
+
+func TestNewCompression_UnsupportedEncoding(t *testing.T) {
+	if _, err := NewCompression(config.CompressionConfig{Encodings: []string{"zstd"}, Level: 5}); err == nil {
+		t.Error("expected an error for an unsupported encoding")
+	}
+}
+
+func TestCompression_StreamedRoundTrip(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			compression, err := NewCompression(config.CompressionConfig{
+				Encodings:    []string{encoding},
+				Level:        9,
+				MinBytes:     1024,
+				ContentTypes: []string{"text/"},
+			})
+			if err != nil {
+				t.Fatalf("NewCompression: %v", err)
+			}
+
+			// Events flushed one by one, then a response longer than the pooled writers' window
+			var want strings.Builder
+			handler := compression.Compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for i := 0; i < 2000; i++ {
+					event := fmt.Sprintf("id: %d\ndata: {\"order\":%d,\"total\":%d}\n\n", i, i*7919%10007, i*31)
+					want.WriteString(event)
+					_, _ = io.WriteString(w, event)
+					w.(http.Flusher).Flush()
+				}
+			}))
+
+			// Twice, the second response reuses the pooled writer
+			for range 2 {
+				want.Reset()
+				req := httptest.NewRequest(http.MethodGet, "/v1/events", nil)
+				req.Header.Set("Accept-Encoding", encoding)
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+
+				if got := rec.Header().Get("Content-Encoding"); got != encoding {
+					t.Fatalf("expected Content-Encoding %q, got %q", encoding, got)
+				}
+				if body := decompress(t, encoding, rec.Body); body != want.String() {
+					t.Fatalf("expected %d bytes to round-trip, got %d", want.Len(), len(body))
+				}
+			}
+		})
+	}
+}
+
+// decompress decodes a response body of the given Content-Encoding
+func decompress(t *testing.T, encoding string, body io.Reader) string {
+	t.Helper()
+	var r io.Reader
+	switch encoding {
+	case "":
+		r = body
+	case "gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatalf("invalid gzip body: %v", err)
+		}
+		r = gz
+	case "deflate":
+		zr, err := zlib.NewReader(body)
+		if err != nil {
+			t.Fatalf("invalid deflate body: %v", err)
+		}
+		r = zr
+	default:
+		t.Fatalf("unexpected encoding %q", encoding)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("invalid %s body: %v", encoding, err)
+	}
+	return string(data)
+}
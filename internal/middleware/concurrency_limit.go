@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// ConcurrencyLimiter caps the number of in-flight requests per merchant and across the gateway instance,
+// so a single tenant issuing slow requests can't occupy every worker.
+type ConcurrencyLimiter struct {
+	jwtHelper *JWTHelper
+	cfg       config.ConcurrencyConfig
+	logger    logger.ZapLogger
+
+	mu       sync.Mutex
+	inFlight int
+	tenants  map[string]int
+}
+
+// NewConcurrencyLimiter creates a new concurrency limiter
+func NewConcurrencyLimiter(jwtHelper *JWTHelper, cfg config.ConcurrencyConfig, log logger.ZapLogger) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		jwtHelper: jwtHelper,
+		cfg:       cfg,
+		logger:    log,
+		tenants:   make(map[string]int),
+	}
+}
+
+// Limit rejects requests once the global or the merchant's in-flight limit is reached
+func (cl *ConcurrencyLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !cl.cfg.Enabled || isRateLimitExempt(r) || cl.exempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var merchantID string
+		if claims, err := cl.jwtHelper.RequestClaims(r); err == nil {
+			merchantID = claims.MerchantID
+		}
+
+		if status, msg := cl.acquire(merchantID); status != 0 {
+			cl.logger.Warn("concurrency limit reached", zap.String("merchant_id", merchantID), zap.String("path", r.URL.Path))
+			w.Header().Set("Retry-After", "1")
+			customRuntime.WriteResponse(w, status, msg, nil)
+			return
+		}
+		defer cl.release(merchantID)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// exempt reports whether path is or lies below one of the exempt paths
+func (cl *ConcurrencyLimiter) exempt(path string) bool {
+	for _, prefix := range cl.cfg.ExemptPaths {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire reserves a slot, returning a non-zero HTTP status when no slot is available
+func (cl *ConcurrencyLimiter) acquire(merchantID string) (int, string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.cfg.MaxInFlight > 0 && cl.inFlight >= cl.cfg.MaxInFlight {
+		return http.StatusServiceUnavailable, "server is busy, please retry"
+	}
+	if merchantID != "" && cl.cfg.MaxInFlightPerTenant > 0 && cl.tenants[merchantID] >= cl.cfg.MaxInFlightPerTenant {
+		return http.StatusTooManyRequests, "too many concurrent requests"
+	}
+
+	cl.inFlight++
+	if merchantID != "" {
+		cl.tenants[merchantID]++
+	}
+	return 0, ""
+}
+
+func (cl *ConcurrencyLimiter) release(merchantID string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.inFlight--
+	if merchantID != "" {
+		if cl.tenants[merchantID]--; cl.tenants[merchantID] <= 0 {
+			delete(cl.tenants, merchantID)
+		}
+	}
+}
+
+// ConcurrencyStats is a snapshot of the concurrency limiter
+type ConcurrencyStats struct {
+	InFlight             int `json:"in_flight"` // requests holding a slot
+	Tenants              int `json:"tenants"`   // merchants with requests in flight
+	MaxInFlight          int `json:"max_in_flight"`
+	MaxInFlightPerTenant int `json:"max_in_flight_per_tenant"`
+}
+
+// Stats returns a snapshot of the limiter
+func (cl *ConcurrencyLimiter) Stats() ConcurrencyStats {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	return ConcurrencyStats{
+		InFlight:             cl.inFlight,
+		Tenants:              len(cl.tenants),
+		MaxInFlight:          cl.cfg.MaxInFlight,
+		MaxInFlightPerTenant: cl.cfg.MaxInFlightPerTenant,
+	}
+}
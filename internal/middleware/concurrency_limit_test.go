@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+)
+
+func TestConcurrencyLimiter_ExemptPaths(t *testing.T) {
+	limiter := NewConcurrencyLimiter(nil, config.ConcurrencyConfig{
+		Enabled:     true,
+		MaxInFlight: 1,
+		ExemptPaths: []string{"/v1/events/poll"},
+	}, logger.NewZapLogger(&logger.ZapLoggerConfig{}))
+
+	// A poll waiting for events holds no slot, so the other request gets the only one
+	polling := make(chan struct{})
+	release := make(chan struct{})
+	handler := limiter.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/events/poll" {
+			close(polling)
+			<-release
+		}
+	}))
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/events/poll", nil))
+	<-polling
+	defer close(release)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/orders", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected the request to be served during a poll, got %d", rec.Code)
+	}
+	if stats := limiter.Stats(); stats.InFlight != 0 {
+		t.Errorf("expected no slot in use, got %d", stats.InFlight)
+	}
+}
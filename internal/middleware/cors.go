@@ -4,21 +4,17 @@ import (
 	"net/http"
 )
 
-// CORS is a simple middleware that adds CORS headers to the response
+// CORS is a simple middleware that adds CORS headers to the response; preflight requests are
+// answered by MethodHandling with the methods of the route
 func CORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*") // TODO: In production, replace with specific origins
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PATCH")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, X-CSRF-Token, X-Device-Id, X-Canary, X-HTTP-Method-Override, X-Grpc-Web, X-User-Agent, Grpc-Timeout, X-Request-Timeout, X-Field-Casing")
+		w.Header().Set("Access-Control-Expose-Headers", "Grpc-Status, Grpc-Message")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
-		// Handle preflight request
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
 		next.ServeHTTP(w, r)
 	})
 }
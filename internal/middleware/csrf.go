@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// CSRFProtection implements double-submit CSRF tokens for the cookie session mode.
+// The token is issued as a readable cookie and must be echoed back in a header on mutating requests.
+type CSRFProtection struct {
+	cfg        config.CSRFConfig
+	sessionCfg config.SessionConfig
+	logger     logger.ZapLogger
+}
+
+// NewCSRFProtection creates a new CSRF protection middleware
+func NewCSRFProtection(cfg config.CSRFConfig, sessionCfg config.SessionConfig, log logger.ZapLogger) *CSRFProtection {
+	return &CSRFProtection{
+		cfg:        cfg,
+		sessionCfg: sessionCfg,
+		logger:     log,
+	}
+}
+
+func (c *CSRFProtection) enabled() bool {
+	return c.cfg.Enabled && c.sessionCfg.CookieEnabled
+}
+
+// Protect validates the CSRF token on mutating requests authenticated by the session cookie.
+// It must run before SessionCookie.Authenticate so header-authenticated clients can be told apart.
+func (c *CSRFProtection) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.enabled() || isSafeMethod(r.Method) || c.isExempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Requests carrying their own Authorization header are not exposed to CSRF
+		if r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if _, err := r.Cookie(c.sessionCfg.CookieName); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(c.cfg.CookieName)
+		header := r.Header.Get(c.cfg.HeaderName)
+		if err != nil || cookie.Value == "" || header == "" ||
+			subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			c.logger.Warn("csrf token validation failed", zap.String("path", r.URL.Path), zap.String("method", r.Method))
+			customRuntime.WriteResponse(w, http.StatusForbidden, "invalid csrf token", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RegisterRoutes registers the CSRF token issuing route
+func (c *CSRFProtection) RegisterRoutes(mux *http.ServeMux) {
+	if !c.enabled() {
+		return
+	}
+
+	mux.HandleFunc(c.cfg.TokenPath, c.issueToken)
+}
+
+// issueToken generates a new CSRF token and sets it as a cookie readable by the dashboard
+func (c *CSRFProtection) issueToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		c.logger.Error("failed to generate csrf token", zap.Error(err))
+		customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to generate csrf token", nil)
+		return
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.cfg.CookieName,
+		Value:    token,
+		Path:     c.sessionCfg.CookiePath,
+		Domain:   c.sessionCfg.CookieDomain,
+		Secure:   c.sessionCfg.CookieSecure,
+		HttpOnly: false, // must be readable by the client to echo it back in the header
+		SameSite: parseSameSite(c.sessionCfg.CookieSameSite),
+	})
+
+	w.Header().Set("Cache-Control", "no-store")
+	customRuntime.WriteResponse(w, http.StatusOK, "success", map[string]string{
+		"csrf_token": token,
+		"header":     c.cfg.HeaderName,
+	})
+}
+
+func (c *CSRFProtection) isExempt(path string) bool {
+	for _, prefix := range c.cfg.ExemptPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
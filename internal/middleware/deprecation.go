@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// deprecation is what the callers of a deprecated method are told
+type deprecation struct {
+	since  time.Time // zero when unknown
+	sunset time.Time // zero when not planned yet
+}
+
+// Deprecations flags the responses of deprecated methods with Deprecation (RFC 9745), Sunset
+// (RFC 8594) and Link headers, and logs and counts every call with its caller, so we know when it's
+// safe to remove old routes
+type Deprecations struct {
+	jwtHelper *JWTHelper
+	routes    *RouteTable
+	methods   map[string]deprecation
+	link      string
+	logger    logger.ZapLogger
+}
+
+// NewDeprecations creates the deprecation middleware for the methods marked deprecated in the proto
+// definitions and those configured
+func NewDeprecations(jwtHelper *JWTHelper, routes *RouteTable, deprecated map[string]bool, cfg config.DeprecationConfig, log logger.ZapLogger) (*Deprecations, error) {
+	methods := make(map[string]deprecation, len(deprecated))
+	for method := range deprecated {
+		methods[method] = deprecation{}
+	}
+	for method, date := range cfg.Dates {
+		since, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deprecation date of %s: %w", method, err)
+		}
+		d := methods[method]
+		d.since = since
+		methods[method] = d
+	}
+	for method, date := range cfg.Sunsets {
+		sunset, err := time.Parse(time.DateOnly, date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sunset date of %s: %w", method, err)
+		}
+		d, ok := methods[method]
+		if !ok {
+			return nil, fmt.Errorf("sunset date of %s, which isn't deprecated", method)
+		}
+		d.sunset = sunset
+		methods[method] = d
+	}
+
+	return &Deprecations{
+		jwtHelper: jwtHelper,
+		routes:    routes,
+		methods:   methods,
+		link:      cfg.Link,
+		logger:    log,
+	}, nil
+}
+
+// Flag adds the deprecation headers to the requests of deprecated methods and records their caller
+func (d *Deprecations) Flag(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := d.routes.MatchRequest(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		dep, ok := d.methods[route.Method]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if dep.since.IsZero() {
+			w.Header().Set("Deprecation", "true")
+		} else {
+			w.Header().Set("Deprecation", fmt.Sprintf("@%d", dep.since.Unix()))
+		}
+		if !dep.sunset.IsZero() {
+			w.Header().Set("Sunset", dep.sunset.UTC().Format(http.TimeFormat))
+		}
+		if d.link != "" {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"; type="text/html"`, d.link))
+		}
+
+		var merchantID, userID string
+		if claims, err := d.jwtHelper.RequestClaims(r); err == nil {
+			merchantID, userID = claims.MerchantID, claims.Subject
+		}
+		metrics.DeprecatedRequests.WithLabelValues(route.Method, metrics.MerchantLabel(merchantID)).Inc()
+		d.logger.Warn("deprecated endpoint called",
+			zap.String("grpc_method", route.Method),
+			zap.String("path", r.URL.Path),
+			zap.String("merchant_id", merchantID),
+			zap.String("user_id", userID),
+			zap.Bool("api_key", r.Header.Get(APIKeyHeader) != ""),
+			zap.String("ip", ClientIP(r)),
+			zap.String("user_agent", r.UserAgent()),
+		)
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorHandler answers UNAVAILABLE backend errors (backend down, circuit open, connection failing) with
+// a 503 envelope carrying a Retry-After header and the error code, so clients such as offline-sync POS
+// devices can back off uniformly. The delay comes from the error's google.rpc.RetryInfo (set by the
+// circuit breaker or the backend), defaulting to cfg.RetryAfter. Other errors get the default handling.
+func ErrorHandler(cfg config.ErrorConfig) runtime.ErrorHandlerFunc {
+	return func(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+		st := status.Convert(err)
+		if st.Code() != codes.Unavailable {
+			runtime.DefaultHTTPErrorHandler(ctx, mux, marshaler, w, r, err)
+			return
+		}
+
+		retryAfter := int(math.Ceil(retryDelay(st, cfg.RetryAfter).Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+
+		if _, ok := marshaler.(*customRuntime.CustomMarshaler); !ok {
+			runtime.DefaultHTTPErrorHandler(ctx, mux, marshaler, w, r, err)
+			return
+		}
+		customRuntime.WriteResponse(w, http.StatusServiceUnavailable, st.Message(), map[string]interface{}{
+			"code":        st.Code().String(),
+			"retry_after": max(retryAfter, 1),
+		})
+	}
+}
+
+// retryDelay returns the delay of the RetryInfo detail of st, or def
+func retryDelay(st *status.Status, def time.Duration) time.Duration {
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.RetryInfo); ok && info.GetRetryDelay() != nil {
+			return info.GetRetryDelay().AsDuration()
+		}
+	}
+	return def
+}
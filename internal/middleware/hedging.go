@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// Hedging reduces the tail latency of hot reads (product lookup, price check): when an attempt hasn't
+// answered within the hedging delay, another one is fired, which the round robin balancer sends to
+// another subchannel, and the first response wins. Transient failures fire the next attempt right away.
+// Only methods safe to call more than once should be hedged.
+type Hedging struct {
+	methods map[string]bool
+	cfg     config.HedgingConfig
+	logger  logger.ZapLogger
+}
+
+// NewHedging creates the hedging interceptor for the methods listed in cfg
+func NewHedging(cfg config.HedgingConfig, log logger.ZapLogger) *Hedging {
+	methods := make(map[string]bool, len(cfg.Methods))
+	for _, method := range cfg.Methods {
+		methods[method] = true
+	}
+
+	return &Hedging{
+		methods: methods,
+		cfg:     cfg,
+		logger:  log,
+	}
+}
+
+// hedgeResult is the outcome of one attempt, with the call results it captured
+type hedgeResult struct {
+	attempt int
+	reply   proto.Message
+	header  metadata.MD
+	trailer metadata.MD
+	peer    peer.Peer
+	err     error
+}
+
+// Unary returns a unary client interceptor hedging the configured methods
+func (h *Hedging) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		msg, ok := reply.(proto.Message)
+		if !ok || !h.methods[method] || h.cfg.MaxAttempts < 2 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		// Losing attempts are cancelled once a response wins
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		results := make(chan *hedgeResult, h.cfg.MaxAttempts)
+		launched := 0
+		launch := func() {
+			launched++
+			res := &hedgeResult{attempt: launched, reply: msg.ProtoReflect().New().Interface()}
+			// Each attempt captures its own headers, trailers and peer; the winner's are copied to the caller
+			attemptOpts := append(shadowCallOptions(opts), grpc.Header(&res.header), grpc.Trailer(&res.trailer), grpc.Peer(&res.peer))
+			go func() {
+				res.err = invoker(ctx, method, req, res.reply, cc, attemptOpts...)
+				results <- res
+			}()
+		}
+
+		launch()
+		timer := time.NewTimer(h.cfg.Delay)
+		defer timer.Stop()
+
+		service := serviceName(method)
+		var err error
+		for pending := 1; pending > 0; {
+			select {
+			case <-timer.C:
+				if launched < h.cfg.MaxAttempts {
+					h.logger.Debug("hedging backend call", zap.String("method", method), zap.Int("attempt", launched+1))
+					launch()
+					pending++
+					timer.Reset(h.cfg.Delay)
+				}
+
+			case res := <-results:
+				pending--
+				if res.err == nil {
+					if res.attempt > 1 {
+						metrics.BackendHedges.WithLabelValues(service, "won").Inc()
+					} else if launched > 1 {
+						metrics.BackendHedges.WithLabelValues(service, "lost").Inc()
+					}
+					proto.Merge(msg, res.reply)
+					copyCallResults(opts, res)
+					return nil
+				}
+
+				err = res.err
+				if !retryableCode(status.Code(err)) {
+					copyCallResults(opts, res)
+					return err
+				}
+				if launched < h.cfg.MaxAttempts {
+					launch()
+					pending++
+				}
+			}
+		}
+		return err
+	}
+}
+
+// copyCallResults writes the results captured by the winning attempt into the caller's call options
+func copyCallResults(opts []grpc.CallOption, res *hedgeResult) {
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case grpc.HeaderCallOption:
+			*o.HeaderAddr = res.header
+		case grpc.TrailerCallOption:
+			*o.TrailerAddr = res.trailer
+		case grpc.PeerCallOption:
+			*o.PeerAddr = res.peer
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-gateway/internal/security"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// ipBansKey is a Redis sorted set of banned IPs/CIDRs scored by ban expiry (unix seconds, +inf = permanent)
+const ipBansKey = "ip_filter:bans"
+
+// IPBan is an IP or CIDR banned at runtime
+type IPBan struct {
+	IP        string     `json:"ip"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// IPFilter rejects requests by client IP using static CIDR allow/deny lists and
+// a dynamic deny list stored in Redis, which is refreshed periodically into memory.
+type IPFilter struct {
+	redisClient *cache.RedisClient
+	cfg         config.IPFilterConfig
+	logger      logger.ZapLogger
+
+	allowlist []netip.Prefix
+	denylist  []netip.Prefix
+
+	mu      sync.RWMutex
+	dynamic []netip.Prefix
+}
+
+// NewIPFilter creates a new IP filter, failing on invalid static entries
+func NewIPFilter(redisClient *cache.RedisClient, cfg config.IPFilterConfig, log logger.ZapLogger) (*IPFilter, error) {
+	allowlist, err := parsePrefixes(cfg.Allowlist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip allowlist: %w", err)
+	}
+
+	denylist, err := parsePrefixes(cfg.Denylist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip denylist: %w", err)
+	}
+
+	return &IPFilter{
+		redisClient: redisClient,
+		cfg:         cfg,
+		logger:      log,
+		allowlist:   allowlist,
+		denylist:    denylist,
+	}, nil
+}
+
+// Run refreshes the dynamic deny list until ctx is cancelled
+func (f *IPFilter) Run(ctx context.Context) {
+	if !f.cfg.Enabled || !f.cfg.DynamicDeny {
+		return
+	}
+
+	ticker := time.NewTicker(f.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := f.refresh(ctx); err != nil {
+			f.logger.Error("failed to refresh ip deny list", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Filter rejects requests from denied or non-allowlisted IPs
+func (f *IPFilter) Filter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.cfg.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := ClientIP(r)
+		if !f.allowed(ip) {
+			f.logger.Warn("request rejected by ip filter", zap.String("ip", ip), zap.String("path", r.URL.Path))
+			RecordSecurityEvent(r, security.IPBlocked, "ip_filter", "access denied", "")
+			customRuntime.WriteResponse(w, http.StatusForbidden, "access denied", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (f *IPFilter) allowed(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		// Unparseable addresses can only pass when no allowlist is configured
+		return len(f.allowlist) == 0
+	}
+	addr = addr.Unmap()
+
+	if len(f.allowlist) > 0 && !containsAddr(f.allowlist, addr) {
+		return false
+	}
+	if containsAddr(f.denylist, addr) {
+		return false
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return !containsAddr(f.dynamic, addr)
+}
+
+// refresh loads the active bans from Redis and drops expired ones
+func (f *IPFilter) refresh(ctx context.Context) error {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := f.redisClient.Client.ZRemRangeByScore(ctx, ipBansKey, "-inf", "("+now).Err(); err != nil {
+		return err
+	}
+
+	members, err := f.redisClient.Client.ZRange(ctx, ipBansKey, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	dynamic := make([]netip.Prefix, 0, len(members))
+	for _, member := range members {
+		prefix, err := parsePrefix(member)
+		if err != nil {
+			f.logger.Warn("ignoring invalid ip ban", zap.String("ip", member), zap.Error(err))
+			continue
+		}
+		dynamic = append(dynamic, prefix)
+	}
+
+	f.mu.Lock()
+	f.dynamic = dynamic
+	f.mu.Unlock()
+	return nil
+}
+
+// RegisterAdminRoutes registers the runtime ban management routes
+func (f *IPFilter) RegisterAdminRoutes(mux *http.ServeMux, adminAuth func(http.Handler) http.Handler) {
+	mux.Handle("/admin/ip-bans", adminAuth(http.HandlerFunc(f.serveBans)))
+}
+
+// serveBans lists (GET), adds (POST {"ip", "duration"}) and removes (DELETE ?ip=) runtime bans
+func (f *IPFilter) serveBans(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		bans, err := f.redisClient.Client.ZRangeWithScores(ctx, ipBansKey, 0, -1).Result()
+		if err != nil {
+			f.logger.Error("failed to list ip bans", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to list ip bans", nil)
+			return
+		}
+
+		result := make([]IPBan, 0, len(bans))
+		for _, ban := range bans {
+			item := IPBan{IP: fmt.Sprint(ban.Member)}
+			if !math.IsInf(ban.Score, 1) {
+				expiresAt := time.Unix(int64(ban.Score), 0).UTC()
+				item.ExpiresAt = &expiresAt
+			}
+			result = append(result, item)
+		}
+		customRuntime.WriteResponse(w, http.StatusOK, "success", result)
+
+	case http.MethodPost:
+		var req struct {
+			IP       string `json:"ip"`
+			Duration string `json:"duration"` // optional, e.g. "1h"; permanent when empty
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid request body", nil)
+			return
+		}
+
+		prefix, err := parsePrefix(req.IP)
+		if err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid ip or cidr", nil)
+			return
+		}
+
+		score := math.Inf(1)
+		if req.Duration != "" {
+			duration, err := time.ParseDuration(req.Duration)
+			if err != nil || duration <= 0 {
+				customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid duration", nil)
+				return
+			}
+			score = float64(time.Now().Add(duration).Unix())
+		}
+
+		if err := f.redisClient.Client.ZAdd(ctx, ipBansKey, redis.Z{Score: score, Member: prefix.String()}).Err(); err != nil {
+			f.logger.Error("failed to add ip ban", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to add ip ban", nil)
+			return
+		}
+
+		f.logger.Info("ip banned", zap.String("ip", prefix.String()), zap.String("duration", req.Duration))
+		reason := "banned permanently"
+		if req.Duration != "" {
+			reason = "banned for " + req.Duration
+		}
+		RecordSecurityEvent(r, security.IPBanned, "ip_filter", reason, "ip:"+prefix.String())
+		f.refreshAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+
+	case http.MethodDelete:
+		prefix, err := parsePrefix(r.URL.Query().Get("ip"))
+		if err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid ip or cidr", nil)
+			return
+		}
+
+		if err := f.redisClient.Client.ZRem(ctx, ipBansKey, prefix.String()).Err(); err != nil {
+			f.logger.Error("failed to remove ip ban", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to remove ip ban", nil)
+			return
+		}
+
+		f.logger.Info("ip unbanned", zap.String("ip", prefix.String()))
+		RecordSecurityEvent(r, security.IPUnbanned, "ip_filter", "ban removed", "ip:"+prefix.String())
+		f.refreshAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+// refreshAfterChange applies a ban change on this instance immediately; others pick it up on their next refresh
+func (f *IPFilter) refreshAfterChange(ctx context.Context) {
+	if err := f.refresh(ctx); err != nil {
+		f.logger.Error("failed to refresh ip deny list", zap.Error(err))
+	}
+}
+
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		prefix, err := parsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// parsePrefix parses a CIDR or a single IP (as a full-length prefix)
+func parsePrefix(v string) (netip.Prefix, error) {
+	if prefix, err := netip.ParsePrefix(v); err == nil {
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(v)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
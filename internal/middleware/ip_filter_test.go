@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+)
+
+func TestIPFilter_Filter_SpoofedForwardedFor(t *testing.T) {
+	if err := ConfigureTrustedProxies([]string{"10.0.0.0/8"}); err != nil {
+		t.Fatalf("ConfigureTrustedProxies: %v", err)
+	}
+	defer ConfigureTrustedProxies(nil)
+
+	filter, err := NewIPFilter(nil, config.IPFilterConfig{Enabled: true}, logger.NewZapLogger(&logger.ZapLoggerConfig{}))
+	if err != nil {
+		t.Fatalf("NewIPFilter: %v", err)
+	}
+	// A runtime ban, as refreshed from ip_filter:bans
+	filter.dynamic = []netip.Prefix{netip.MustParsePrefix("203.0.113.7/32")}
+	handler := filter.Filter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		xRealIP    string
+		wantStatus int
+	}{
+		{"banned peer", "203.0.113.7:1234", "", "", http.StatusForbidden},
+		{"banned peer spoofing X-Forwarded-For", "203.0.113.7:1234", "198.51.100.1", "", http.StatusForbidden},
+		{"banned peer spoofing X-Real-IP", "203.0.113.7:1234", "", "198.51.100.1", http.StatusForbidden},
+		{"banned client behind the proxy", "10.0.0.2:1234", "203.0.113.7", "", http.StatusForbidden},
+		{"banned client spoofing a hop behind the proxy", "10.0.0.2:1234", "198.51.100.1, 203.0.113.7", "", http.StatusForbidden},
+		{"banned client behind two proxies", "10.0.0.2:1234", "203.0.113.7, 10.0.0.3", "", http.StatusForbidden},
+		{"client behind the proxy", "10.0.0.2:1234", "198.51.100.1", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/products", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			if tt.xRealIP != "" {
+				req.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected %d, got %d", tt.wantStatus, rec.Code)
+			}
+		})
+	}
+}
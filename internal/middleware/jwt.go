@@ -1,7 +1,11 @@
 package middleware
 
 import (
+	"context"
 	"errors"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -15,6 +19,7 @@ var (
 // JWTClaims represents the claims stored in the JWT token
 type JWTClaims struct {
 	MerchantID string `json:"merchant_id"`
+	DeviceID   string `json:"device_id,omitempty"` // POS terminal the token was issued to, if any
 	jwt.RegisteredClaims
 }
 
@@ -57,11 +62,61 @@ func (h *JWTHelper) ValidateToken(tokenString string) (*JWTClaims, error) {
 	return claims, nil
 }
 
-// ExtractMerchantID extracts the merchant ID from a valid token
-func (h *JWTHelper) ExtractMerchantID(tokenString string) (string, error) {
-	claims, err := h.ValidateToken(tokenString)
-	if err != nil {
-		return "", err
+// claimsCache holds the validation results of the tokens of a request, so the middlewares and
+// interceptors reading its claims verify the signature once
+type claimsCache struct {
+	mu      sync.Mutex
+	results map[string]claimsResult
+}
+
+type claimsResult struct {
+	claims *JWTClaims
+	err    error
+}
+
+type claimsCacheKey struct{}
+
+// CacheClaims lets the rest of the chain share the validated claims of the request through its
+// context (see RequestClaims); it must run first. The bearer token may still be set further down the
+// chain, e.g. from the session cookie, so it's validated the first time claims are asked for.
+func (h *JWTHelper) CacheClaims(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cache := &claimsCache{results: make(map[string]claimsResult, 1)}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsCacheKey{}, cache)))
+	})
+}
+
+// Claims returns the claims of token, validated once per request for the requests of CacheClaims
+func (h *JWTHelper) Claims(ctx context.Context, token string) (*JWTClaims, error) {
+	cache, ok := ctx.Value(claimsCacheKey{}).(*claimsCache)
+	if !ok {
+		return h.ValidateToken(token)
+	}
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	result, ok := cache.results[token]
+	if !ok {
+		result.claims, result.err = h.ValidateToken(token)
+		cache.results[token] = result
+	}
+	return result.claims, result.err
+}
+
+// RequestClaims returns the claims of the request's bearer token, ErrInvalidToken without one
+func (h *JWTHelper) RequestClaims(r *http.Request) (*JWTClaims, error) {
+	token := BearerToken(r)
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	return h.Claims(r.Context(), token)
+}
+
+// BearerToken extracts the token from a "Bearer <token>" Authorization header
+func BearerToken(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return ""
 	}
-	return claims.MerchantID, nil
+	return strings.TrimPrefix(authHeader, "Bearer ")
 }
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWTHelper_CacheClaims(t *testing.T) {
+	helper := NewJWTHelper("secret")
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaims{
+		MerchantID:       "m-1",
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+
+	var first, second *JWTClaims
+	handler := helper.CacheClaims(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := helper.RequestClaims(r); err != ErrInvalidToken {
+			t.Errorf("expected ErrInvalidToken without a token, got %v", err)
+		}
+		// Set further down the chain, e.g. from the session cookie
+		r.Header.Set("Authorization", "Bearer "+token)
+		first, _ = helper.RequestClaims(r)
+		second, _ = helper.Claims(r.Context(), token)
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/orders", nil))
+
+	if first == nil || first.MerchantID != "m-1" {
+		t.Fatalf("expected the claims of merchant m-1, got %+v", first)
+	}
+	if second != first {
+		t.Errorf("expected the token to be validated once per request")
+	}
+}
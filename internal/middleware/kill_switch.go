@@ -0,0 +1,269 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// disabledRoutesKey is a Redis hash of routes disabled at runtime, keyed by gRPC method
+const disabledRoutesKey = "routes:disabled"
+
+// Kill switch modes
+const (
+	KillModeUnavailable = "unavailable" // 503, the route is expected back
+	KillModeGone        = "gone"        // 410, the route was removed for good
+)
+
+// DisabledRoute is a gRPC method switched off at the gateway
+type DisabledRoute struct {
+	Method    string     `json:"method"` // e.g. "/report.v1.ReportService/GetSalesReport"
+	Mode      string     `json:"mode"`   // "unavailable" or "gone"
+	Message   string     `json:"message,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// KillSwitch turns off individual routes without a deploy, e.g. a report query melting a database.
+// Requests to a disabled method get a 503 (or 410 for removed routes) envelope and never reach the
+// backend. Routes are disabled through config (static) or the admin API (stored in Redis and
+// refreshed periodically).
+type KillSwitch struct {
+	redisClient *cache.RedisClient
+	routes      *RouteTable
+	cfg         config.KillSwitchConfig
+	logger      logger.ZapLogger
+
+	static map[string]*DisabledRoute
+
+	mu      sync.RWMutex
+	dynamic map[string]*DisabledRoute
+}
+
+// NewKillSwitch creates the route kill switch
+func NewKillSwitch(redisClient *cache.RedisClient, routes *RouteTable, cfg config.KillSwitchConfig, log logger.ZapLogger) *KillSwitch {
+	static := make(map[string]*DisabledRoute)
+	for _, method := range cfg.DisabledMethods {
+		static[method] = &DisabledRoute{Method: method, Mode: KillModeUnavailable}
+	}
+	for _, method := range cfg.GoneMethods {
+		static[method] = &DisabledRoute{Method: method, Mode: KillModeGone}
+	}
+
+	return &KillSwitch{
+		redisClient: redisClient,
+		routes:      routes,
+		cfg:         cfg,
+		logger:      log,
+		static:      static,
+		dynamic:     make(map[string]*DisabledRoute),
+	}
+}
+
+// Run refreshes the runtime disabled routes until ctx is cancelled
+func (k *KillSwitch) Run(ctx context.Context) {
+	ticker := time.NewTicker(k.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := k.refresh(ctx); err != nil {
+			k.logger.Error("failed to refresh disabled routes", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check rejects requests to disabled routes
+func (k *KillSwitch) Check(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, matched := k.routes.MatchRequest(r)
+		if !matched {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		disabled, ok := k.disabled(route.Method)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		code, message := http.StatusServiceUnavailable, "this endpoint is temporarily disabled"
+		if disabled.Mode == KillModeGone {
+			code, message = http.StatusGone, "this endpoint is no longer available"
+		}
+		if disabled.Message != "" {
+			message = disabled.Message
+		}
+		customRuntime.WriteResponse(w, code, message, map[string]interface{}{
+			"disabled": true,
+			"method":   disabled.Method,
+		})
+	})
+}
+
+// disabled returns the active switch of method; runtime switches take precedence over static ones
+func (k *KillSwitch) disabled(method string) (*DisabledRoute, bool) {
+	k.mu.RLock()
+	disabled, ok := k.dynamic[method]
+	k.mu.RUnlock()
+
+	if ok && (disabled.ExpiresAt == nil || time.Now().Before(*disabled.ExpiresAt)) {
+		return disabled, true
+	}
+
+	disabled, ok = k.static[method]
+	return disabled, ok
+}
+
+// refresh reloads the runtime disabled routes from Redis, deleting expired ones
+func (k *KillSwitch) refresh(ctx context.Context) error {
+	values, err := k.redisClient.Client.HGetAll(ctx, disabledRoutesKey).Result()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	dynamic := make(map[string]*DisabledRoute, len(values))
+	var expired []string
+
+	for field, value := range values {
+		var disabled DisabledRoute
+		if err := json.Unmarshal([]byte(value), &disabled); err != nil {
+			k.logger.Warn("ignoring invalid disabled route", zap.String("method", field), zap.Error(err))
+			continue
+		}
+		if disabled.ExpiresAt != nil && now.After(*disabled.ExpiresAt) {
+			expired = append(expired, field)
+			continue
+		}
+		dynamic[field] = &disabled
+	}
+
+	if len(expired) > 0 {
+		if err := k.redisClient.Client.HDel(ctx, disabledRoutesKey, expired...).Err(); err != nil {
+			k.logger.Warn("failed to delete expired disabled routes", zap.Error(err))
+		}
+	}
+
+	k.mu.Lock()
+	k.dynamic = dynamic
+	k.mu.Unlock()
+	return nil
+}
+
+// RegisterAdminRoutes registers the kill switch management route
+func (k *KillSwitch) RegisterAdminRoutes(mux *http.ServeMux, adminAuth func(http.Handler) http.Handler) {
+	mux.Handle("/admin/routes/disabled", adminAuth(http.HandlerFunc(k.serveDisabled)))
+}
+
+// serveDisabled lists (GET), disables (POST) and re-enables (DELETE ?method=) routes at runtime.
+// Static switches from config are listed but can only be changed through config.
+func (k *KillSwitch) serveDisabled(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		k.mu.RLock()
+		dynamic := make([]*DisabledRoute, 0, len(k.dynamic))
+		for _, disabled := range k.dynamic {
+			dynamic = append(dynamic, disabled)
+		}
+		k.mu.RUnlock()
+
+		static := make([]*DisabledRoute, 0, len(k.static))
+		for _, disabled := range k.static {
+			static = append(static, disabled)
+		}
+
+		customRuntime.WriteResponse(w, http.StatusOK, "success", map[string]interface{}{
+			"runtime": dynamic,
+			"static":  static,
+		})
+
+	case http.MethodPost:
+		var req struct {
+			Method   string `json:"method"` // e.g. "/report.v1.ReportService/GetSalesReport"
+			Mode     string `json:"mode"`   // optional, "unavailable" (default) or "gone"
+			Message  string `json:"message"`
+			Duration string `json:"duration"` // optional, e.g. "30m"; until re-enabled when empty
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid request body", nil)
+			return
+		}
+
+		if req.Method == "" {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "method is required", nil)
+			return
+		}
+		if req.Mode == "" {
+			req.Mode = KillModeUnavailable
+		}
+		if req.Mode != KillModeUnavailable && req.Mode != KillModeGone {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "mode must be unavailable or gone", nil)
+			return
+		}
+
+		disabled := &DisabledRoute{
+			Method:  req.Method,
+			Mode:    req.Mode,
+			Message: req.Message,
+		}
+		if req.Duration != "" {
+			duration, err := time.ParseDuration(req.Duration)
+			if err != nil || duration <= 0 {
+				customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid duration", nil)
+				return
+			}
+			expiresAt := time.Now().Add(duration).UTC()
+			disabled.ExpiresAt = &expiresAt
+		}
+
+		value, _ := json.Marshal(disabled)
+		if err := k.redisClient.Client.HSet(ctx, disabledRoutesKey, disabled.Method, value).Err(); err != nil {
+			k.logger.Error("failed to disable route", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to disable route", nil)
+			return
+		}
+
+		k.logger.Warn("route disabled", zap.String("method", disabled.Method), zap.String("mode", disabled.Mode), zap.String("duration", req.Duration))
+		k.refreshAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", disabled)
+
+	case http.MethodDelete:
+		method := r.URL.Query().Get("method")
+		if err := k.redisClient.Client.HDel(ctx, disabledRoutesKey, method).Err(); err != nil {
+			k.logger.Error("failed to re-enable route", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to re-enable route", nil)
+			return
+		}
+
+		k.logger.Info("route re-enabled", zap.String("method", method))
+		k.refreshAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+// refreshAfterChange applies a change on this instance immediately; others pick it up on their next refresh
+func (k *KillSwitch) refreshAfterChange(ctx context.Context) {
+	if err := k.refresh(ctx); err != nil {
+		k.logger.Error("failed to refresh disabled routes", zap.Error(err))
+	}
+}
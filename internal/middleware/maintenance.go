@@ -0,0 +1,288 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// maintenanceKey is a Redis hash of maintenance windows switched on at runtime, keyed by target
+const maintenanceKey = "maintenance"
+
+// MaintenanceTargetGlobal puts the whole gateway in maintenance; other targets are gRPC services
+const MaintenanceTargetGlobal = "global"
+
+// MaintenanceWindow is a maintenance switch for the whole gateway or a gRPC service
+type MaintenanceWindow struct {
+	Target     string     `json:"target"` // "global" or a gRPC service, e.g. "order.v1.OrderService"
+	Message    string     `json:"message"`
+	RetryAfter int        `json:"retry_after"` // seconds
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// Maintenance returns a 503 envelope with Retry-After for requests to services in maintenance.
+// Windows come from config (static) or are switched on at runtime through the admin API (stored in
+// Redis and refreshed periodically). Health, metrics, docs and admin routes keep being served.
+type Maintenance struct {
+	redisClient *cache.RedisClient
+	routes      *RouteTable
+	cfg         config.MaintenanceConfig
+	logger      logger.ZapLogger
+
+	static map[string]*MaintenanceWindow
+
+	mu      sync.RWMutex
+	dynamic map[string]*MaintenanceWindow
+}
+
+// NewMaintenance creates the maintenance switch
+func NewMaintenance(redisClient *cache.RedisClient, routes *RouteTable, cfg config.MaintenanceConfig, log logger.ZapLogger) *Maintenance {
+	static := make(map[string]*MaintenanceWindow)
+	targets := cfg.Services
+	if cfg.Enabled {
+		targets = append([]string{MaintenanceTargetGlobal}, targets...)
+	}
+	for _, target := range targets {
+		static[target] = &MaintenanceWindow{
+			Target:     target,
+			Message:    cfg.Message,
+			RetryAfter: int(cfg.RetryAfter.Seconds()),
+		}
+	}
+
+	return &Maintenance{
+		redisClient: redisClient,
+		routes:      routes,
+		cfg:         cfg,
+		logger:      log,
+		static:      static,
+		dynamic:     make(map[string]*MaintenanceWindow),
+	}
+}
+
+// Run refreshes the runtime maintenance windows until ctx is cancelled
+func (m *Maintenance) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.refresh(ctx); err != nil {
+			m.logger.Error("failed to refresh maintenance windows", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check rejects requests to the gateway or a service in maintenance
+func (m *Maintenance) Check(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.exempt(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		window, ok := m.window(MaintenanceTargetGlobal)
+		if !ok {
+			if route, matched := m.routes.MatchRequest(r); matched {
+				window, ok = m.window(serviceName(route.Method))
+			}
+		}
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if window.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(window.RetryAfter))
+		}
+		message := window.Message
+		if message == "" {
+			message = m.cfg.Message
+		}
+		customRuntime.WriteResponse(w, http.StatusServiceUnavailable, message, map[string]interface{}{
+			"maintenance": true,
+			"target":      window.Target,
+		})
+	})
+}
+
+func (m *Maintenance) exempt(path string) bool {
+	for _, prefix := range m.cfg.ExemptPaths {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// window returns the active maintenance window of target; runtime windows take precedence over static ones
+func (m *Maintenance) window(target string) (*MaintenanceWindow, bool) {
+	m.mu.RLock()
+	window, ok := m.dynamic[target]
+	m.mu.RUnlock()
+
+	if ok && (window.ExpiresAt == nil || time.Now().Before(*window.ExpiresAt)) {
+		return window, true
+	}
+
+	window, ok = m.static[target]
+	return window, ok
+}
+
+// refresh reloads the runtime windows from Redis, deleting expired ones
+func (m *Maintenance) refresh(ctx context.Context) error {
+	values, err := m.redisClient.Client.HGetAll(ctx, maintenanceKey).Result()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	dynamic := make(map[string]*MaintenanceWindow, len(values))
+	var expired []string
+
+	for field, value := range values {
+		var window MaintenanceWindow
+		if err := json.Unmarshal([]byte(value), &window); err != nil {
+			m.logger.Warn("ignoring invalid maintenance window", zap.String("target", field), zap.Error(err))
+			continue
+		}
+		if window.ExpiresAt != nil && now.After(*window.ExpiresAt) {
+			expired = append(expired, field)
+			continue
+		}
+		dynamic[field] = &window
+	}
+
+	if len(expired) > 0 {
+		if err := m.redisClient.Client.HDel(ctx, maintenanceKey, expired...).Err(); err != nil {
+			m.logger.Warn("failed to delete expired maintenance windows", zap.Error(err))
+		}
+	}
+
+	m.mu.Lock()
+	m.dynamic = dynamic
+	m.mu.Unlock()
+	return nil
+}
+
+// RegisterAdminRoutes registers the maintenance management route
+func (m *Maintenance) RegisterAdminRoutes(mux *http.ServeMux, adminAuth func(http.Handler) http.Handler) {
+	mux.Handle("/admin/maintenance", adminAuth(http.HandlerFunc(m.serveMaintenance)))
+}
+
+// serveMaintenance lists (GET), switches on (POST) and switches off (DELETE ?target=) runtime maintenance windows.
+// Static windows from config are listed but can only be changed through config.
+func (m *Maintenance) serveMaintenance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		m.mu.RLock()
+		dynamic := make([]*MaintenanceWindow, 0, len(m.dynamic))
+		for _, window := range m.dynamic {
+			dynamic = append(dynamic, window)
+		}
+		m.mu.RUnlock()
+
+		static := make([]*MaintenanceWindow, 0, len(m.static))
+		for _, window := range m.static {
+			static = append(static, window)
+		}
+
+		customRuntime.WriteResponse(w, http.StatusOK, "success", map[string]interface{}{
+			"runtime": dynamic,
+			"static":  static,
+		})
+
+	case http.MethodPost:
+		var req struct {
+			Target     string `json:"target"` // "global" or a gRPC service, e.g. "order.v1.OrderService"
+			Message    string `json:"message"`
+			RetryAfter string `json:"retry_after"` // optional, e.g. "10m"; defaults to the configured value
+			Duration   string `json:"duration"`    // optional, e.g. "2h"; until switched off when empty
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid request body", nil)
+			return
+		}
+
+		if req.Target == "" {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "target is required", nil)
+			return
+		}
+
+		retryAfter := m.cfg.RetryAfter
+		if req.RetryAfter != "" {
+			d, err := time.ParseDuration(req.RetryAfter)
+			if err != nil || d < 0 {
+				customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid retry_after", nil)
+				return
+			}
+			retryAfter = d
+		}
+
+		window := &MaintenanceWindow{
+			Target:     req.Target,
+			Message:    req.Message,
+			RetryAfter: int(retryAfter.Seconds()),
+		}
+		if req.Duration != "" {
+			duration, err := time.ParseDuration(req.Duration)
+			if err != nil || duration <= 0 {
+				customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid duration", nil)
+				return
+			}
+			expiresAt := time.Now().Add(duration).UTC()
+			window.ExpiresAt = &expiresAt
+		}
+
+		value, _ := json.Marshal(window)
+		if err := m.redisClient.Client.HSet(ctx, maintenanceKey, window.Target, value).Err(); err != nil {
+			m.logger.Error("failed to set maintenance window", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to set maintenance window", nil)
+			return
+		}
+
+		m.logger.Info("maintenance switched on", zap.String("target", window.Target), zap.String("duration", req.Duration))
+		m.refreshAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", window)
+
+	case http.MethodDelete:
+		target := r.URL.Query().Get("target")
+		if err := m.redisClient.Client.HDel(ctx, maintenanceKey, target).Err(); err != nil {
+			m.logger.Error("failed to delete maintenance window", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to delete maintenance window", nil)
+			return
+		}
+
+		m.logger.Info("maintenance switched off", zap.String("target", target))
+		m.refreshAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+// refreshAfterChange applies a change on this instance immediately; others pick it up on their next refresh
+func (m *Maintenance) refreshAfterChange(ctx context.Context) {
+	if err := m.refresh(ctx); err != nil {
+		m.logger.Error("failed to refresh maintenance windows", zap.Error(err))
+	}
+}
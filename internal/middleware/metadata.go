@@ -12,8 +12,12 @@ import (
 func MetadataAnnotator(ctx context.Context, req *http.Request) metadata.MD {
 	md := make(metadata.MD)
 
-	// Language
-	if lang := req.Header.Get("Accept-Language"); lang != "" {
+	// Language, chosen with X-Lang or Accept-Language
+	lang := req.Header.Get("X-Lang")
+	if lang == "" {
+		lang = req.Header.Get("Accept-Language")
+	}
+	if lang != "" {
 		md.Set("x-lang", lang)
 	}
 
@@ -27,5 +31,18 @@ func MetadataAnnotator(ctx context.Context, req *http.Request) metadata.MD {
 		md.Set(pkgMiddleware.RequestIDHeader, reqID)
 	}
 
+	// Trace context, started by the trace sampler when the caller didn't send one
+	if traceparent := req.Header.Get(TraceparentHeader); traceparent != "" {
+		md.Set(TraceparentHeader, traceparent)
+		if tracestate := req.Header.Get("tracestate"); tracestate != "" {
+			md.Set("tracestate", tracestate)
+		}
+	}
+
+	// Tenant resolved from the request host
+	if tenant := TenantFromContext(req.Context()); tenant != "" {
+		md.Set("x-tenant-id", tenant)
+	}
+
 	return md
 }
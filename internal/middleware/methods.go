@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// MethodOverrideHeader carries the intended method of POST requests from clients behind proxies that
+// only let GET and POST through
+const MethodOverrideHeader = "X-HTTP-Method-Override"
+
+// overridableMethods are the methods a POST can be overridden to
+var overridableMethods = map[string]bool{
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// MethodHandling answers OPTIONS with the methods actually routed for the path, serves HEAD from the
+// GET route and applies X-HTTP-Method-Override, none of which grpc-gateway handles itself
+type MethodHandling struct {
+	routes *RouteTable
+	cfg    config.HTTPConfig
+	logger logger.ZapLogger
+}
+
+// NewMethodHandling creates the method handling middleware
+func NewMethodHandling(routes *RouteTable, cfg config.HTTPConfig, log logger.ZapLogger) *MethodHandling {
+	return &MethodHandling{
+		routes: routes,
+		cfg:    cfg,
+		logger: log,
+	}
+}
+
+// Handle must run after the path rewrites, so routes are matched on the final path, and before
+// middleware matching the request method
+func (m *MethodHandling) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodOptions:
+			if methods := m.routes.AllowedMethods(r.URL.Path); methods != nil {
+				allow := strings.Join(methods, ", ")
+				w.Header().Set("Allow", allow)
+				if r.Header.Get("Access-Control-Request-Method") != "" {
+					w.Header().Set("Access-Control-Allow-Methods", allow)
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+
+		case http.MethodHead:
+			if _, ok := m.routes.Match(http.MethodHead, r.URL.Path); !ok {
+				if _, ok := m.routes.Match(http.MethodGet, r.URL.Path); ok {
+					// The server discards the body written for the original HEAD request
+					r = withMethod(r, http.MethodGet)
+				}
+			}
+
+		case http.MethodPost:
+			if override := strings.ToUpper(r.Header.Get(MethodOverrideHeader)); override != "" && m.cfg.MethodOverride {
+				if !overridableMethods[override] {
+					m.logger.Debug("ignoring method override", zap.String("method", override))
+					break
+				}
+				r = withMethod(r, override)
+				r.Header.Del(MethodOverrideHeader)
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func withMethod(r *http.Request, method string) *http.Request {
+	r2 := r.Clone(r.Context())
+	r2.Method = method
+	return r2
+}
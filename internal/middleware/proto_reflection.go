@@ -2,12 +2,17 @@ package middleware
 
 import (
 	"fmt"
+	"net/http"
 	"sync"
+	"time"
 
 	authv1 "github.com/fekuna/omnipos-proto/gen/go/omnipos/auth/v1"
+	ratelimitv1 "github.com/fekuna/omnipos-proto/gen/go/omnipos/ratelimit/v1"
+	"google.golang.org/genproto/googleapis/api/annotations"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
 )
 
 var (
@@ -28,6 +33,20 @@ func DiscoverPublicEndpoints() (map[string]bool, error) {
 
 	publicEndpoints := make(map[string]bool)
 
+	rangeMethods(func(fullMethodName string, method protoreflect.MethodDescriptor) {
+		// Check if the method has the public_endpoint option set to true
+		if isPublic := isPublicEndpoint(method); isPublic {
+			publicEndpoints[fullMethodName] = true
+		}
+	})
+
+	// Cache the result
+	publicEndpointsCache = publicEndpoints
+	return publicEndpoints, nil
+}
+
+// rangeMethods calls fn for every method of every registered gRPC service
+func rangeMethods(fn func(fullMethodName string, method protoreflect.MethodDescriptor)) {
 	// Iterate through all registered services
 	protoregistry.GlobalFiles.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
 		services := fd.Services()
@@ -39,20 +58,188 @@ func DiscoverPublicEndpoints() (map[string]bool, error) {
 				method := methods.Get(j)
 
 				// Build the full method name in gRPC format: /package.Service/Method
-				fullMethodName := fmt.Sprintf("/%s/%s", service.FullName(), method.Name())
-
-				// Check if the method has the public_endpoint option set to true
-				if isPublic := isPublicEndpoint(method); isPublic {
-					publicEndpoints[fullMethodName] = true
-				}
+				fn(fmt.Sprintf("/%s/%s", service.FullName(), method.Name()), method)
 			}
 		}
 		return true
 	})
+}
 
-	// Cache the result
-	publicEndpointsCache = publicEndpoints
-	return publicEndpoints, nil
+// DiscoverRoutes builds a route table from the (google.api.http) annotations of all registered methods,
+// including additional bindings.
+func DiscoverRoutes() (*RouteTable, error) {
+	routes := NewRouteTable()
+
+	rangeMethods(func(fullMethodName string, method protoreflect.MethodDescriptor) {
+		opts := method.Options()
+		if opts == nil || !proto.HasExtension(opts, annotations.E_Http) {
+			return
+		}
+
+		rule, ok := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			return
+		}
+
+		addHTTPRule(routes, fullMethodName, rule)
+		for _, binding := range rule.GetAdditionalBindings() {
+			addHTTPRule(routes, fullMethodName, binding)
+		}
+	})
+
+	return routes, nil
+}
+
+func addHTTPRule(routes *RouteTable, fullMethodName string, rule *annotations.HttpRule) {
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		routes.Add(http.MethodGet, pattern.Get, fullMethodName)
+	case *annotations.HttpRule_Post:
+		routes.Add(http.MethodPost, pattern.Post, fullMethodName)
+	case *annotations.HttpRule_Put:
+		routes.Add(http.MethodPut, pattern.Put, fullMethodName)
+	case *annotations.HttpRule_Patch:
+		routes.Add(http.MethodPatch, pattern.Patch, fullMethodName)
+	case *annotations.HttpRule_Delete:
+		routes.Add(http.MethodDelete, pattern.Delete, fullMethodName)
+	case *annotations.HttpRule_Custom:
+		routes.Add(pattern.Custom.GetKind(), pattern.Custom.GetPath(), fullMethodName)
+	}
+}
+
+// DiscoverIdempotentMethods returns the methods that are safe to retry: those bound to an HTTP GET
+// (including additional bindings) or declaring an idempotency_level of NO_SIDE_EFFECTS or IDEMPOTENT.
+func DiscoverIdempotentMethods() (map[string]bool, error) {
+	idempotent := make(map[string]bool)
+
+	rangeMethods(func(fullMethodName string, method protoreflect.MethodDescriptor) {
+		opts, ok := method.Options().(*descriptorpb.MethodOptions)
+		if !ok || opts == nil {
+			return
+		}
+
+		if opts.GetIdempotencyLevel() != descriptorpb.MethodOptions_IDEMPOTENCY_UNKNOWN {
+			idempotent[fullMethodName] = true
+			return
+		}
+
+		rule, ok := proto.GetExtension(opts, annotations.E_Http).(*annotations.HttpRule)
+		if !ok || rule == nil {
+			return
+		}
+		for _, binding := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+			if binding.GetGet() != "" {
+				idempotent[fullMethodName] = true
+				return
+			}
+		}
+	})
+
+	return idempotent, nil
+}
+
+// DiscoverDeprecatedMethods returns the methods marked with the deprecated option, or belonging to a
+// deprecated service
+func DiscoverDeprecatedMethods() (map[string]bool, error) {
+	deprecated := make(map[string]bool)
+
+	rangeMethods(func(fullMethodName string, method protoreflect.MethodDescriptor) {
+		if opts, ok := method.Options().(*descriptorpb.MethodOptions); ok && opts.GetDeprecated() {
+			deprecated[fullMethodName] = true
+			return
+		}
+		if service, ok := method.Parent().(protoreflect.ServiceDescriptor); ok {
+			if opts, ok := service.Options().(*descriptorpb.ServiceOptions); ok && opts.GetDeprecated() {
+				deprecated[fullMethodName] = true
+			}
+		}
+	})
+
+	return deprecated, nil
+}
+
+// DiscoverRedactedFields returns the names (proto and JSON) of the request and response message fields,
+// at any depth, marked with the debug_redact option
+func DiscoverRedactedFields() (map[string]bool, error) {
+	redacted := make(map[string]bool)
+	seen := make(map[protoreflect.FullName]bool)
+
+	var scan func(message protoreflect.MessageDescriptor)
+	scan = func(message protoreflect.MessageDescriptor) {
+		if seen[message.FullName()] {
+			return
+		}
+		seen[message.FullName()] = true
+
+		fields := message.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			field := fields.Get(i)
+			if opts, ok := field.Options().(*descriptorpb.FieldOptions); ok && opts.GetDebugRedact() {
+				redacted[string(field.Name())] = true
+				redacted[field.JSONName()] = true
+			}
+			if field.Message() != nil {
+				scan(field.Message())
+			}
+		}
+	}
+
+	rangeMethods(func(_ string, method protoreflect.MethodDescriptor) {
+		scan(method.Input())
+		scan(method.Output())
+	})
+
+	return redacted, nil
+}
+
+// MethodRateLimit is a per-method rate limit declared with the (ratelimit.v1.limit) option.
+// RPS is zero when the option only declares a cost.
+type MethodRateLimit struct {
+	RPS    int
+	Burst  int
+	Period time.Duration
+	Cost   int
+}
+
+// DiscoverMethodRateLimits scans all registered gRPC services and builds a map of per-method rate limits
+// by reading the custom (ratelimit.v1.limit) option from proto method definitions.
+func DiscoverMethodRateLimits() (map[string]MethodRateLimit, error) {
+	limits := make(map[string]MethodRateLimit)
+	var err error
+
+	rangeMethods(func(fullMethodName string, method protoreflect.MethodDescriptor) {
+		opts := method.Options()
+		if err != nil || opts == nil || !proto.HasExtension(opts, ratelimitv1.E_Limit) {
+			return
+		}
+
+		limit, ok := proto.GetExtension(opts, ratelimitv1.E_Limit).(*ratelimitv1.Limit)
+		if !ok || limit == nil || (limit.GetRps() <= 0 && limit.GetCost() <= 0) {
+			return
+		}
+
+		methodLimit := MethodRateLimit{
+			RPS:    max(0, int(limit.GetRps())),
+			Burst:  int(limit.GetBurst()),
+			Period: time.Second,
+			Cost:   max(1, int(limit.GetCost())),
+		}
+		if methodLimit.Burst <= 0 {
+			methodLimit.Burst = methodLimit.RPS
+		}
+		if period := limit.GetPeriod(); period != nil && period.AsDuration() > 0 {
+			methodLimit.Period = period.AsDuration()
+		}
+		// The windowed rate limit algorithms count in whole milliseconds
+		if methodLimit.Period < time.Millisecond {
+			err = fmt.Errorf("rate limit period of %s must be at least 1ms, got %s", fullMethodName, methodLimit.Period)
+			return
+		}
+
+		limits[fullMethodName] = methodLimit
+	})
+
+	return limits, err
 }
 
 // isPublicEndpoint checks if a method has the (auth.v1.public_endpoint) option set to true
@@ -0,0 +1,242 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// QuotaUsage is the monthly request usage of a merchant
+type QuotaUsage struct {
+	MerchantID string    `json:"merchant_id"`
+	Plan       string    `json:"plan"`
+	Period     string    `json:"period"`
+	Limit      int64     `json:"limit"` // 0 = unlimited
+	Used       int64     `json:"used"`
+	Remaining  int64     `json:"remaining"`
+	ResetAt    time.Time `json:"reset_at"`
+}
+
+// quotaScript increments the usage counter unless the quota (ARGV[1], 0 = unlimited) is used up, so
+// rejected requests don't count. Returns {allowed, used}.
+var quotaScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+
+local used = tonumber(redis.call("GET", KEYS[1]) or "0")
+if limit > 0 and used >= limit then
+	return {0, used}
+end
+
+used = redis.call("INCR", KEYS[1])
+redis.call("EXPIREAT", KEYS[1], ARGV[2])
+return {1, used}
+`)
+
+// QuotaManager tracks monthly request counts per merchant in Redis and rejects requests
+// once the merchant's plan quota is exhausted. Merchant plans are read from "quota:plan:<merchant_id>".
+type QuotaManager struct {
+	redisClient *cache.RedisClient
+	jwtHelper   *JWTHelper
+	cfg         config.QuotaConfig
+	logger      logger.ZapLogger
+}
+
+// NewQuotaManager creates a new quota manager
+func NewQuotaManager(redisClient *cache.RedisClient, jwtHelper *JWTHelper, cfg config.QuotaConfig, log logger.ZapLogger) *QuotaManager {
+	return &QuotaManager{
+		redisClient: redisClient,
+		jwtHelper:   jwtHelper,
+		cfg:         cfg,
+		logger:      log,
+	}
+}
+
+// Enforce counts authenticated requests against the merchant's monthly quota
+func (q *QuotaManager) Enforce(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !q.cfg.Enabled || r.URL.Path == q.cfg.UsagePath || isRateLimitExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		merchantID := q.merchantID(r)
+		if merchantID == "" {
+			// Unauthenticated requests are rejected (or allowed as public) further down the chain
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		usage, allowed, err := q.consume(r.Context(), merchantID)
+		if err != nil {
+			q.logger.Error("quota error", zap.Error(err))
+			// Fail open, same as the rate limiter
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		setQuotaHeaders(w, usage)
+
+		if !allowed {
+			customRuntime.WriteResponse(w, http.StatusTooManyRequests,
+				fmt.Sprintf("monthly request quota of %d for plan %q exceeded, resets at %s", usage.Limit, usage.Plan, usage.ResetAt.Format(time.RFC3339)),
+				usage)
+			return
+		}
+
+		metrics.QuotaConsumed.WithLabelValues(metrics.MerchantLabel(merchantID)).Inc()
+		next.ServeHTTP(w, withCallCharge(r, q.chargeCall(merchantID)))
+	})
+}
+
+// chargeCall counts an additional backend call of the request against the merchant's quota
+func (q *QuotaManager) chargeCall(merchantID string) callCharge {
+	return func(ctx context.Context) error {
+		_, allowed, err := q.consume(ctx, merchantID)
+		if err != nil {
+			q.logger.Error("quota error while charging a call", zap.Error(err))
+			return nil
+		}
+		if !allowed {
+			return ErrCallLimited
+		}
+		metrics.QuotaConsumed.WithLabelValues(metrics.MerchantLabel(merchantID)).Inc()
+		return nil
+	}
+}
+
+// RegisterRoutes registers the quota usage route
+func (q *QuotaManager) RegisterRoutes(mux *http.ServeMux) {
+	if !q.cfg.Enabled {
+		return
+	}
+
+	mux.HandleFunc(q.cfg.UsagePath, q.serveUsage)
+}
+
+// serveUsage returns the current monthly usage of the authenticated merchant
+func (q *QuotaManager) serveUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	merchantID := q.merchantID(r)
+	if merchantID == "" {
+		customRuntime.WriteResponse(w, http.StatusUnauthorized, "missing or invalid authorization header", nil)
+		return
+	}
+
+	usage, err := q.usage(r.Context(), merchantID)
+	if err != nil {
+		q.logger.Error("failed to read quota usage", zap.Error(err))
+		customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to read quota usage", nil)
+		return
+	}
+
+	setQuotaHeaders(w, usage)
+	customRuntime.WriteResponse(w, http.StatusOK, "success", usage)
+}
+
+func (q *QuotaManager) merchantID(r *http.Request) string {
+	claims, err := q.jwtHelper.RequestClaims(r)
+	if err != nil {
+		return ""
+	}
+	return claims.MerchantID
+}
+
+// consume increments the merchant's counter for the current month, unless the quota is used up
+func (q *QuotaManager) consume(ctx context.Context, merchantID string) (*QuotaUsage, bool, error) {
+	usage, err := q.newUsage(ctx, merchantID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := quotaUsageKey(merchantID, usage.Period)
+	// Keep the counter around for a while after the period ends so usage can still be inspected
+	expireAt := usage.ResetAt.Add(7 * 24 * time.Hour).Unix()
+	values, err := quotaScript.Run(ctx, q.redisClient.Client, []string{key}, usage.Limit, expireAt).Int64Slice()
+	if err != nil {
+		return nil, false, err
+	}
+
+	usage.Used = values[1]
+	usage.Remaining = remaining(usage.Limit, usage.Used)
+	return usage, values[0] == 1, nil
+}
+
+// usage reads the merchant's counter for the current month without incrementing it
+func (q *QuotaManager) usage(ctx context.Context, merchantID string) (*QuotaUsage, error) {
+	usage, err := q.newUsage(ctx, merchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := q.redisClient.Client.Get(ctx, quotaUsageKey(merchantID, usage.Period)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	usage.Used = used
+	usage.Remaining = remaining(usage.Limit, usage.Used)
+	return usage, nil
+}
+
+func (q *QuotaManager) newUsage(ctx context.Context, merchantID string) (*QuotaUsage, error) {
+	plan, err := q.redisClient.Client.Get(ctx, "quota:plan:"+merchantID).Result()
+	if errors.Is(err, redis.Nil) || plan == "" {
+		plan = q.cfg.DefaultPlan
+	} else if err != nil {
+		return nil, err
+	}
+
+	limit, ok := q.cfg.Plans[plan]
+	if !ok {
+		q.logger.Warn("unknown quota plan, using default", zap.String("merchant_id", merchantID), zap.String("plan", plan))
+		plan = q.cfg.DefaultPlan
+		limit = q.cfg.Plans[plan]
+	}
+
+	now := time.Now().UTC()
+	return &QuotaUsage{
+		MerchantID: merchantID,
+		Plan:       plan,
+		Period:     now.Format("2006-01"),
+		Limit:      int64(limit),
+		ResetAt:    time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC),
+	}, nil
+}
+
+func quotaUsageKey(merchantID, period string) string {
+	return fmt.Sprintf("quota:usage:%s:%s", merchantID, period)
+}
+
+func remaining(limit, used int64) int64 {
+	if limit == 0 {
+		return -1
+	}
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+func setQuotaHeaders(w http.ResponseWriter, usage *QuotaUsage) {
+	w.Header().Set("X-Quota-Plan", usage.Plan)
+	w.Header().Set("X-Quota-Limit", strconv.FormatInt(usage.Limit, 10))
+	w.Header().Set("X-Quota-Used", strconv.FormatInt(usage.Used, 10))
+	w.Header().Set("X-Quota-Remaining", strconv.FormatInt(usage.Remaining, 10))
+	w.Header().Set("X-Quota-Reset", strconv.FormatInt(usage.ResetAt.Unix(), 10))
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/google/uuid"
+)
+
+// newTestRedis connects to the Redis at REDIS_TEST_ADDR, skipping the test without one
+func newTestRedis(t *testing.T) *cache.RedisClient {
+	t.Helper()
+	addr := os.Getenv("REDIS_TEST_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_TEST_ADDR not set")
+	}
+	redisClient, err := cache.NewRedisClient(&cache.Config{Addr: addr})
+	if err != nil {
+		t.Fatalf("NewRedisClient: %v", err)
+	}
+	t.Cleanup(func() { redisClient.Close() })
+	return redisClient
+}
+
+func TestQuotaManager_Consume_RejectedRequestsDontCount(t *testing.T) {
+	redisClient := newTestRedis(t)
+	quota := NewQuotaManager(redisClient, nil, config.QuotaConfig{
+		Enabled:     true,
+		DefaultPlan: "free",
+		Plans:       map[string]int{"free": 2},
+	}, logger.NewZapLogger(&logger.ZapLoggerConfig{}))
+
+	ctx := context.Background()
+	merchantID := "test-" + uuid.NewString()
+	for i := 1; i <= 5; i++ {
+		usage, allowed, err := quota.consume(ctx, merchantID)
+		if err != nil {
+			t.Fatalf("consume: %v", err)
+		}
+		t.Cleanup(func() { redisClient.Client.Del(ctx, quotaUsageKey(merchantID, usage.Period)) })
+
+		if wantAllowed := i <= 2; allowed != wantAllowed {
+			t.Errorf("request %d: expected allowed %v, got %v", i, wantAllowed, allowed)
+		}
+		if wantUsed := int64(min(i, 2)); usage.Used != wantUsed {
+			t.Errorf("request %d: expected %d used, got %d", i, wantUsed, usage.Used)
+		}
+	}
+
+	usage, err := quota.usage(ctx, merchantID)
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	if usage.Used != 2 || usage.Remaining != 0 {
+		t.Errorf("expected the usage to stop at the quota, got %d used and %d remaining", usage.Used, usage.Remaining)
+	}
+}
@@ -1,48 +1,143 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"net/http"
-	"strings"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-gateway/internal/security"
 	"github.com/fekuna/omnipos-pkg/cache"
 	"github.com/fekuna/omnipos-pkg/logger"
 	"github.com/go-redis/redis_rate/v10"
 	"go.uber.org/zap"
 )
 
+// DeviceIDHeader identifies the POS terminal sending the request
+const DeviceIDHeader = "X-Device-Id"
+
+const (
+	FailurePolicyOpen   = "open"
+	FailurePolicyClosed = "closed"
+	FailurePolicyLocal  = "local"
+)
+
 type RateLimiter struct {
-	limiter *redis_rate.Limiter
-	cfg     config.RateLimitConfig
-	logger  logger.ZapLogger
+	limiter      rateLimitAlgorithm
+	fallback     *localLimiter
+	jwtHelper    *JWTHelper
+	cfg          config.RateLimitConfig
+	routes       *RouteTable
+	methodLimits map[string]MethodRateLimit
+	overrides    *rateLimitOverrides
+	rates        atomic.Pointer[RateLimitRates] // hot-reloadable public/auth rates
+	penalties    *penaltyBox
+	redisClient  *cache.RedisClient
+	logger       logger.ZapLogger
 }
 
-func NewRateLimiter(redisClient *cache.RedisClient, cfg config.RateLimitConfig, log logger.ZapLogger) *RateLimiter {
-	return &RateLimiter{
-		limiter: redis_rate.NewLimiter(redisClient.Client),
-		cfg:     cfg,
-		logger:  log,
+// NewRateLimiter creates a new rate limiter
+// methodLimits: per-method limits (from the ratelimit.v1.limit option) that replace the global public/auth pair
+// for requests matched to that method through routes
+func NewRateLimiter(redisClient *cache.RedisClient, jwtHelper *JWTHelper, cfg config.RateLimitConfig, routes *RouteTable, methodLimits map[string]MethodRateLimit, log logger.ZapLogger) *RateLimiter {
+	limiter, err := newRateLimitAlgorithm(cfg.Algorithm, redisClient.Client)
+	if err != nil {
+		log.Warn("invalid rate limit algorithm, falling back to gcra", zap.Error(err))
+		limiter = redis_rate.NewLimiter(redisClient.Client)
+	}
+
+	if cfg.Period <= 0 {
+		cfg.Period = time.Second
+	}
+	if cfg.OverrideRefreshInterval <= 0 {
+		cfg.OverrideRefreshInterval = 5 * time.Second
+	}
+	if cfg.PenaltyWindow <= 0 {
+		cfg.PenaltyWindow = time.Minute
+	}
+	if cfg.PenaltyDuration <= 0 {
+		cfg.PenaltyDuration = 15 * time.Minute
+	}
+
+	rl := &RateLimiter{
+		limiter:      limiter,
+		fallback:     newLocalLimiter(),
+		jwtHelper:    jwtHelper,
+		cfg:          cfg,
+		routes:       routes,
+		methodLimits: methodLimits,
+		overrides:    newRateLimitOverrides(redisClient, log),
+		penalties: &penaltyBox{
+			redisClient: redisClient,
+			threshold:   max(cfg.PenaltyThreshold, 1),
+			window:      cfg.PenaltyWindow,
+			duration:    cfg.PenaltyDuration,
+		},
+		redisClient: redisClient,
+		logger:      log,
 	}
+	rl.rates.Store(ratesFromConfig(cfg))
+	return rl
 }
 
 func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !rl.cfg.Enabled {
+		if !rl.cfg.Enabled || isRateLimitExempt(r) {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		ctx := r.Context()
-		key, limit := rl.getLimit(r)
+		bucket := rl.getBucket(r)
+		limit := bucket.limit
+
+		if rl.cfg.PenaltyEnabled {
+			penalty, err := rl.penalties.remaining(ctx, bucket.principal)
+			if err != nil {
+				rl.logger.Warn("failed to check rate limit penalty", zap.Error(err))
+			} else if penalty > 0 {
+				metrics.RateLimitRequests.WithLabelValues(bucket.route, bucket.class, "penalized").Inc()
+				RecordSecurityEvent(r, security.RateLimited, "rate_limit", "in penalty box", bucket.principal)
+				rl.writePenalty(w, penalty)
+				return
+			}
+		}
+
+		start := time.Now()
+		res, err := rl.limiter.AllowN(ctx, bucket.key, limit, bucket.cost)
+		metrics.RateLimitRedisDuration.WithLabelValues(rl.cfg.Algorithm, strconv.FormatBool(err != nil)).Observe(time.Since(start).Seconds())
 
-		res, err := rl.limiter.Allow(ctx, key, limit)
 		if err != nil {
-			rl.logger.Error("rate limit error", zap.Error(err))
-			// Fail open or closed? Here we fail open to avoid blocking valid traffic on redis errors
-			next.ServeHTTP(w, r)
-			return
+			rl.logger.Error("rate limit error", zap.Error(err), zap.String("failure_policy", rl.cfg.FailurePolicy))
+
+			switch rl.cfg.FailurePolicy {
+			case FailurePolicyClosed:
+				metrics.RateLimitFallbacks.WithLabelValues(FailurePolicyClosed).Inc()
+				w.Header().Set("Retry-After", "1")
+				customRuntime.WriteResponse(w, http.StatusServiceUnavailable, "rate limiter unavailable", nil)
+				return
+			case FailurePolicyLocal:
+				// Per-instance token bucket at reduced rates
+				metrics.RateLimitFallbacks.WithLabelValues(FailurePolicyLocal).Inc()
+				limit = rl.fallbackLimit(limit)
+				res = rl.fallback.AllowN(bucket.key, limit, bucket.cost)
+			default:
+				// Fail open to avoid blocking valid traffic on redis errors
+				metrics.RateLimitFallbacks.WithLabelValues(FailurePolicyOpen).Inc()
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		shaped := false
+		if res.Allowed == 0 && err == nil && rl.cfg.ShapingEnabled {
+			res, shaped = rl.shape(ctx, bucket, res)
 		}
 
 		w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", limit.Rate))
@@ -50,68 +145,252 @@ func (rl *RateLimiter) Limit(next http.Handler) http.Handler {
 		w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", res.ResetAfter/time.Millisecond))
 
 		if res.Allowed == 0 {
-			w.Header().Set("Retry-After", fmt.Sprintf("%d", res.RetryAfter/time.Second))
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			metrics.RateLimitRequests.WithLabelValues(bucket.route, bucket.class, "throttled").Inc()
+
+			if rl.cfg.PenaltyEnabled {
+				penalty, err := rl.penalties.recordViolation(ctx, bucket.principal)
+				if err != nil {
+					rl.logger.Warn("failed to record rate limit violation", zap.Error(err))
+				} else if penalty > 0 {
+					rl.logger.Warn("client moved into rate limit penalty box",
+						zap.String("principal", bucket.principal), zap.Duration("duration", penalty))
+					RecordSecurityEvent(r, security.RateLimited, "rate_limit", "moved into penalty box for "+penalty.String(), bucket.principal)
+					rl.writePenalty(w, penalty)
+					return
+				}
+			}
+
+			RecordSecurityEvent(r, security.RateLimited, "rate_limit", "rate limit exceeded on "+bucket.route, bucket.principal)
+			retryAfter := int64(math.Ceil(res.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter))
+			customRuntime.WriteResponse(w, http.StatusTooManyRequests, "too many requests", map[string]interface{}{
+				"limit":          limit.Rate,
+				"period_ms":      limit.Period.Milliseconds(),
+				"remaining":      res.Remaining,
+				"retry_after":    retryAfter,
+				"retry_after_ms": res.RetryAfter.Milliseconds(),
+				"reset_after_ms": res.ResetAfter.Milliseconds(),
+			})
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		result := "allowed"
+		if shaped {
+			result = "delayed"
+		}
+		metrics.RateLimitRequests.WithLabelValues(bucket.route, bucket.class, result).Inc()
+		next.ServeHTTP(w, withCallCharge(r, rl.chargeCall(bucket)))
 	})
 }
 
-func (rl *RateLimiter) getLimit(r *http.Request) (string, redis_rate.Limit) {
-	// Check for Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if authHeader != "" {
-		// Use a simple hash of the auth header or just the header itself as key
-		// In a real scenario, we might want to extract the user ID here,
-		// but since we are upstream of the auth middleware, we might not have it parsed yet unless we duplicate logic.
-		// However, the main.go sets up auth middleware *after* standard HTTP middleware typically?
-		// Actually, in main.go, the generic mux is wrapped.
-		// To be safe and efficient, we'll use the auth header as the key if present.
-		// We can prefix it to avoid collisions.
-		key := fmt.Sprintf("rate_limit:auth:%s", authHeader)
-		return key, redis_rate.Limit{
-			Rate:   rl.cfg.AuthRPS,
-			Burst:  rl.cfg.AuthBurst,
-			Period: time.Second,
+// shape waits for the bucket to allow a throttled request, retrying as long as the total wait
+// stays within the configured maximum. It reports whether the request was eventually allowed.
+func (rl *RateLimiter) shape(ctx context.Context, bucket rateLimitBucket, res *redis_rate.Result) (*redis_rate.Result, bool) {
+	deadline := time.Now().Add(rl.cfg.ShapingMaxWait)
+
+	for res.Allowed == 0 && res.RetryAfter > 0 && time.Now().Add(res.RetryAfter).Before(deadline) {
+		timer := time.NewTimer(res.RetryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return res, false
+		case <-timer.C:
+		}
+
+		next, err := rl.limiter.AllowN(ctx, bucket.key, bucket.limit, bucket.cost)
+		if err != nil {
+			rl.logger.Warn("rate limit error while shaping", zap.Error(err))
+			return res, false
 		}
+		res = next
 	}
 
-	// Fallback to IP
-	ip := getClientIP(r)
-	key := fmt.Sprintf("rate_limit:ip:%s", ip)
-	return key, redis_rate.Limit{
-		Rate:   rl.cfg.PublicRPS,
-		Burst:  rl.cfg.PublicBurst,
-		Period: time.Second,
+	return res, res.Allowed > 0
+}
+
+// chargeCall deducts the cost of an additional backend call of the request from its bucket
+func (rl *RateLimiter) chargeCall(bucket rateLimitBucket) callCharge {
+	return func(ctx context.Context) error {
+		res, err := rl.limiter.AllowN(ctx, bucket.key, bucket.limit, bucket.cost)
+		if err != nil {
+			rl.logger.Warn("rate limit error while charging a call", zap.Error(err))
+			if rl.cfg.FailurePolicy == FailurePolicyClosed {
+				return ErrCallLimited
+			}
+			return nil
+		}
+		if res.Allowed == 0 {
+			metrics.RateLimitRequests.WithLabelValues(bucket.route, bucket.class, "throttled").Inc()
+			return ErrCallLimited
+		}
+		return nil
+	}
+}
+
+// writePenalty rejects a request from a principal in the penalty box
+func (rl *RateLimiter) writePenalty(w http.ResponseWriter, penalty time.Duration) {
+	retryAfter := int64(math.Ceil(penalty.Seconds()))
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter))
+	customRuntime.WriteResponse(w, http.StatusTooManyRequests, "too many requests, temporarily blocked after repeatedly exceeding the rate limit", map[string]interface{}{
+		"penalized":      true,
+		"retry_after":    retryAfter,
+		"retry_after_ms": penalty.Milliseconds(),
+	})
+}
+
+// fallbackLimit scales a limit down to the configured fallback percentage
+func (rl *RateLimiter) fallbackLimit(limit redis_rate.Limit) redis_rate.Limit {
+	percent := rl.cfg.FallbackPercent
+	if percent <= 0 || percent > 100 {
+		percent = 100
 	}
+
+	limit.Rate = max(1, limit.Rate*percent/100)
+	limit.Burst = max(1, limit.Burst*percent/100)
+	return limit
+}
+
+// Key classes of rate limit buckets, used as metric labels
+const (
+	keyClassPublic     = "public"
+	keyClassPublicRead = "public_read"
+	keyClassAuth       = "auth"
+	keyClassAuthRead   = "auth_read"
+	keyClassMethod     = "method"
+	keyClassOverride   = "override"
+)
+
+// rateLimitBucket describes the bucket a request is counted against
+type rateLimitBucket struct {
+	key       string
+	principal string // client identity the bucket belongs to, e.g. "ip:1.2.3.4"
+	limit     redis_rate.Limit
+	cost      int
+	route     string // matched gRPC method, or "unmatched"
+	class     string
 }
 
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For
-	xff := r.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
+// getBucket returns the bucket, limit and cost of the request.
+// Precedence: route override > per-method limit > merchant override > global auth/public pair.
+func (rl *RateLimiter) getBucket(r *http.Request) rateLimitBucket {
+	bucket := rateLimitBucket{cost: 1, route: "unmatched"}
+	claims := rl.getClaims(r)
+
+	principal := "ip:" + ClientIP(r)
+	if claims != nil {
+		// Authenticated callers are keyed on their token subject, so rotating tokens keeps the same bucket
+		principal = fmt.Sprintf("merchant:%s:user:%s", claims.MerchantID, claims.Subject)
+
+		if deviceID := rl.getDeviceID(r, claims); deviceID != "" {
+			principal += ":device:" + deviceID
+		}
 	}
 
-	// Check X-Real-IP
-	xrp := r.Header.Get("X-Real-IP")
-	if xrp != "" {
-		return xrp
+	bucket.principal = principal
+
+	if route, ok := rl.routes.MatchRequest(r); ok {
+		bucket.route = route.Method
+		bucket.cost = rl.getCost(route.Method)
+
+		if override, ok := rl.overrides.get(OverrideScopeRoute, route.Method); ok {
+			bucket.key = fmt.Sprintf("rate_limit:method:%s:%s", route.Method, principal)
+			bucket.limit = override.limit()
+			bucket.class = keyClassOverride
+			return bucket
+		}
+
+		if methodLimit, ok := rl.methodLimits[route.Method]; ok && methodLimit.RPS > 0 {
+			bucket.key = fmt.Sprintf("rate_limit:method:%s:%s", route.Method, principal)
+			bucket.limit = redis_rate.Limit{
+				Rate:   methodLimit.RPS,
+				Burst:  methodLimit.Burst,
+				Period: methodLimit.Period,
+			}
+			bucket.class = keyClassMethod
+			return bucket
+		}
 	}
 
-	// Fallback to RemoteAddr
-	// RemoteAddr contains port, need to strip it
-	addr := r.RemoteAddr
-	if strings.Contains(addr, ":") {
-		// handle ipv6 [::1]:port or ipv4 1.2.3.4:port
-		// simple split by last colon
-		lastColon := strings.LastIndex(addr, ":")
-		if lastColon != -1 {
-			addr = addr[:lastColon]
+	read := rl.cfg.SplitReadWrite && isReadMethod(r.Method)
+	rates := rl.rates.Load()
+
+	if claims != nil {
+		bucket.key = fmt.Sprintf("rate_limit:auth:%s", principal)
+
+		if override, ok := rl.overrides.get(OverrideScopeMerchant, claims.MerchantID); ok {
+			bucket.limit = override.limit()
+			bucket.class = keyClassOverride
+			return bucket
+		}
+
+		bucket.limit = redis_rate.Limit{
+			Rate:   rates.AuthRPS,
+			Burst:  rates.AuthBurst,
+			Period: rl.cfg.Period,
+		}
+		bucket.class = keyClassAuth
+
+		if read {
+			bucket.key = fmt.Sprintf("rate_limit:auth:read:%s", principal)
+			bucket.limit.Rate, bucket.limit.Burst = rates.ReadAuthRPS, rates.ReadAuthBurst
+			bucket.class = keyClassAuthRead
 		}
+		return bucket
+	}
+
+	// Fallback to IP
+	bucket.key = fmt.Sprintf("rate_limit:%s", principal)
+	bucket.limit = redis_rate.Limit{
+		Rate:   rates.PublicRPS,
+		Burst:  rates.PublicBurst,
+		Period: rl.cfg.Period,
+	}
+	bucket.class = keyClassPublic
+
+	if read {
+		bucket.key = fmt.Sprintf("rate_limit:read:%s", principal)
+		bucket.limit.Rate, bucket.limit.Burst = rates.ReadPublicRPS, rates.ReadPublicBurst
+		bucket.class = keyClassPublicRead
+	}
+	return bucket
+}
+
+// isReadMethod reports whether the method only reads state
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// getCost returns the number of tokens a request to method deducts; config overrides the proto option
+func (rl *RateLimiter) getCost(method string) int {
+	if cost, ok := rl.cfg.RouteCosts[method]; ok && cost > 0 {
+		return cost
+	}
+	if methodLimit, ok := rl.methodLimits[method]; ok && methodLimit.Cost > 0 {
+		return methodLimit.Cost
+	}
+	return 1
+}
+
+// getDeviceID returns the X-Device-Id header when per-device limits are enabled and the header
+// matches the device the token was issued to. Unbound devices share the account's bucket,
+// so clients can't escape their limit by rotating the header.
+func (rl *RateLimiter) getDeviceID(r *http.Request, claims *JWTClaims) string {
+	if !rl.cfg.PerDevice || claims.DeviceID == "" {
+		return ""
+	}
+
+	deviceID := r.Header.Get(DeviceIDHeader)
+	if deviceID != claims.DeviceID {
+		return ""
+	}
+	return deviceID
+}
+
+// getClaims returns the claims of a valid bearer token, or nil for public requests
+func (rl *RateLimiter) getClaims(r *http.Request) *JWTClaims {
+	claims, err := rl.jwtHelper.RequestClaims(r)
+	if err != nil {
+		return nil
 	}
-	return addr
+	return claims
 }
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis_rate/v10"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	AlgorithmGCRA          = "gcra"
+	AlgorithmSlidingWindow = "sliding_window"
+	AlgorithmFixedWindow   = "fixed_window"
+)
+
+// rateLimitAlgorithm decides whether n requests identified by key fit into limit.
+// *redis_rate.Limiter (GCRA) satisfies it directly.
+type rateLimitAlgorithm interface {
+	AllowN(ctx context.Context, key string, limit redis_rate.Limit, n int) (*redis_rate.Result, error)
+}
+
+// newRateLimitAlgorithm returns the algorithm selected by name, defaulting to GCRA
+func newRateLimitAlgorithm(name string, client *redis.Client) (rateLimitAlgorithm, error) {
+	switch name {
+	case AlgorithmGCRA, "":
+		return redis_rate.NewLimiter(client), nil
+	case AlgorithmSlidingWindow:
+		return &slidingWindowLimiter{client: client}, nil
+	case AlgorithmFixedWindow:
+		return &fixedWindowLimiter{client: client}, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit algorithm %q", name)
+	}
+}
+
+// fixedWindowScript increments the window counter unless it would exceed the limit.
+// Returns {allowed, count, pttl}.
+var fixedWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+if current + n > limit then
+	return {0, current, redis.call("PTTL", KEYS[1])}
+end
+
+current = redis.call("INCRBY", KEYS[1], n)
+if current == n then
+	redis.call("PEXPIRE", KEYS[1], period)
+end
+return {1, current, redis.call("PTTL", KEYS[1])}
+`)
+
+// fixedWindowLimiter counts requests in discrete windows of limit.Period; Burst is ignored
+type fixedWindowLimiter struct {
+	client *redis.Client
+}
+
+func (l *fixedWindowLimiter) AllowN(ctx context.Context, key string, limit redis_rate.Limit, n int) (*redis_rate.Result, error) {
+	window := time.Now().UnixMilli() / limit.Period.Milliseconds()
+	redisKey := fmt.Sprintf("rate:fw:%s:%d", key, window)
+
+	values, err := fixedWindowScript.Run(ctx, l.client, []string{redisKey}, limit.Rate, limit.Period.Milliseconds(), n).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, count, ttl := values[0], values[1], time.Duration(values[2])*time.Millisecond
+	if ttl < 0 {
+		ttl = limit.Period
+	}
+
+	res := &redis_rate.Result{
+		Limit:      limit,
+		Remaining:  max(0, limit.Rate-int(count)),
+		RetryAfter: -1,
+		ResetAfter: ttl,
+	}
+	if allowed == 1 {
+		res.Allowed = n
+	} else {
+		res.RetryAfter = ttl
+	}
+	return res, nil
+}
+
+// slidingWindowScript approximates a sliding window by weighting the previous window's count
+// by how much of it still overlaps the sliding window. Returns {allowed, previous, current}.
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local elapsed = tonumber(ARGV[3])
+local n = tonumber(ARGV[4])
+
+local previous = tonumber(redis.call("GET", KEYS[2]) or "0")
+local current = tonumber(redis.call("GET", KEYS[1]) or "0")
+local weighted = previous * (period - elapsed) / period + current
+
+if weighted + n > limit then
+	return {0, previous, current}
+end
+
+current = redis.call("INCRBY", KEYS[1], n)
+redis.call("PEXPIRE", KEYS[1], period * 2)
+return {1, previous, current}
+`)
+
+// slidingWindowLimiter enforces limit.Rate requests over any window of limit.Period; Burst is ignored
+type slidingWindowLimiter struct {
+	client *redis.Client
+}
+
+func (l *slidingWindowLimiter) AllowN(ctx context.Context, key string, limit redis_rate.Limit, n int) (*redis_rate.Result, error) {
+	period := limit.Period.Milliseconds()
+	now := time.Now().UnixMilli()
+	window := now / period
+	elapsed := now - window*period
+
+	keys := []string{
+		fmt.Sprintf("rate:sw:%s:%d", key, window),
+		fmt.Sprintf("rate:sw:%s:%d", key, window-1),
+	}
+
+	values, err := slidingWindowScript.Run(ctx, l.client, keys, limit.Rate, period, elapsed, n).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, previous, current := values[0], float64(values[1]), float64(values[2])
+	weighted := previous*float64(period-elapsed)/float64(period) + current
+	untilNextWindow := time.Duration(period-elapsed) * time.Millisecond
+
+	res := &redis_rate.Result{
+		Limit:      limit,
+		Remaining:  max(0, limit.Rate-int(weighted)),
+		RetryAfter: -1,
+		ResetAfter: untilNextWindow,
+	}
+	if allowed == 1 {
+		res.Allowed = n
+		return res, nil
+	}
+
+	// The previous window's weight decays linearly; wait until enough of it has expired,
+	// or until the next window if the current window alone is over the limit
+	res.RetryAfter = untilNextWindow
+	if excess := weighted + float64(n) - float64(limit.Rate); previous > 0 && excess <= weighted-current {
+		decayPerMs := previous / float64(period)
+		if wait := time.Duration(excess/decayPerMs) * time.Millisecond; wait < res.RetryAfter {
+			res.RetryAfter = wait
+		}
+	}
+	return res, nil
+}
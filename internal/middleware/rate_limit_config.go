@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// rateLimitRatesKey holds a JSON RateLimitRates document that replaces the configured rates at runtime
+const rateLimitRatesKey = "rate_limit:rates"
+
+// RateLimitRates are the global public/auth rates, which can be changed without a restart
+type RateLimitRates struct {
+	PublicRPS       int `json:"public_rps"`
+	PublicBurst     int `json:"public_burst"`
+	AuthRPS         int `json:"auth_rps"`
+	AuthBurst       int `json:"auth_burst"`
+	ReadPublicRPS   int `json:"read_public_rps"`
+	ReadPublicBurst int `json:"read_public_burst"`
+	ReadAuthRPS     int `json:"read_auth_rps"`
+	ReadAuthBurst   int `json:"read_auth_burst"`
+}
+
+func ratesFromConfig(cfg config.RateLimitConfig) *RateLimitRates {
+	return &RateLimitRates{
+		PublicRPS:       cfg.PublicRPS,
+		PublicBurst:     cfg.PublicBurst,
+		AuthRPS:         cfg.AuthRPS,
+		AuthBurst:       cfg.AuthBurst,
+		ReadPublicRPS:   cfg.ReadPublicRPS,
+		ReadPublicBurst: cfg.ReadPublicBurst,
+		ReadAuthRPS:     cfg.ReadAuthRPS,
+		ReadAuthBurst:   cfg.ReadAuthBurst,
+	}
+}
+
+func (r *RateLimitRates) validate() error {
+	for _, v := range []int{r.PublicRPS, r.PublicBurst, r.AuthRPS, r.AuthBurst, r.ReadPublicRPS, r.ReadPublicBurst, r.ReadAuthRPS, r.ReadAuthBurst} {
+		if v <= 0 {
+			return errors.New("all rates and bursts must be positive")
+		}
+	}
+	return nil
+}
+
+// refreshRates applies the rates stored in Redis, or the configured ones once they are removed
+func (rl *RateLimiter) refreshRates(ctx context.Context) error {
+	value, err := rl.redisClient.Client.Get(ctx, rateLimitRatesKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		rl.setRates(ratesFromConfig(rl.cfg))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var rates RateLimitRates
+	if err := json.Unmarshal(value, &rates); err != nil {
+		return err
+	}
+	if err := rates.validate(); err != nil {
+		return err
+	}
+
+	rl.setRates(&rates)
+	return nil
+}
+
+func (rl *RateLimiter) setRates(rates *RateLimitRates) {
+	if old := rl.rates.Swap(rates); old != nil && *old != *rates {
+		rl.logger.Info("rate limit rates changed",
+			zap.Int("public_rps", rates.PublicRPS), zap.Int("public_burst", rates.PublicBurst),
+			zap.Int("auth_rps", rates.AuthRPS), zap.Int("auth_burst", rates.AuthBurst))
+	}
+}
+
+// serveRates returns (GET), replaces (PUT) or resets to the configured values (DELETE) the rates of all instances
+func (rl *RateLimiter) serveRates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		customRuntime.WriteResponse(w, http.StatusOK, "success", rl.rates.Load())
+
+	case http.MethodPut:
+		var rates RateLimitRates
+		if err := json.NewDecoder(r.Body).Decode(&rates); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid request body", nil)
+			return
+		}
+		if err := rates.validate(); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+
+		value, _ := json.Marshal(rates)
+		if err := rl.redisClient.Client.Set(ctx, rateLimitRatesKey, value, 0).Err(); err != nil {
+			rl.logger.Error("failed to set rate limit rates", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to set rate limit rates", nil)
+			return
+		}
+
+		rl.setRates(&rates)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", rates)
+
+	case http.MethodDelete:
+		if err := rl.redisClient.Client.Del(ctx, rateLimitRatesKey).Err(); err != nil {
+			rl.logger.Error("failed to reset rate limit rates", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to reset rate limit rates", nil)
+			return
+		}
+
+		rl.setRates(ratesFromConfig(rl.cfg))
+		customRuntime.WriteResponse(w, http.StatusOK, "success", rl.rates.Load())
+
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+	}
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+)
+
+// APIKeyHeader identifies trusted callers that authenticate with a static key
+const APIKeyHeader = "X-API-Key"
+
+type exemptContextKey struct{}
+
+// RateLimitExemptions marks requests from health probes and trusted callers as exempt,
+// so the rate, concurrency and quota limiters skip them.
+type RateLimitExemptions struct {
+	paths  []string
+	cidrs  []netip.Prefix
+	tokens map[string]bool
+}
+
+// NewRateLimitExemptions creates the exemption list from the rate limit config
+func NewRateLimitExemptions(cfg config.RateLimitConfig) (*RateLimitExemptions, error) {
+	cidrs, err := parsePrefixes(cfg.ExemptCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit exempt cidrs: %w", err)
+	}
+
+	tokens := make(map[string]bool, len(cfg.ExemptTokens))
+	for _, token := range cfg.ExemptTokens {
+		tokens[token] = true
+	}
+
+	return &RateLimitExemptions{
+		paths:  cfg.ExemptPaths,
+		cidrs:  cidrs,
+		tokens: tokens,
+	}, nil
+}
+
+// Mark flags exempt requests in the request context; it must run before the limiters
+func (e *RateLimitExemptions) Mark(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if e.matches(r) {
+			r = r.WithContext(context.WithValue(r.Context(), exemptContextKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (e *RateLimitExemptions) matches(r *http.Request) bool {
+	for _, path := range e.paths {
+		if r.URL.Path == path || strings.HasPrefix(r.URL.Path, strings.TrimSuffix(path, "/")+"/") {
+			return true
+		}
+	}
+
+	if len(e.tokens) > 0 {
+		if token := BearerToken(r); token != "" && e.tokens[token] {
+			return true
+		}
+		if key := r.Header.Get(APIKeyHeader); key != "" && e.tokens[key] {
+			return true
+		}
+	}
+
+	// ClientIP only believes X-Forwarded-For from trusted proxies, so clients can't claim an exempt IP
+	if len(e.cidrs) > 0 {
+		if addr, err := netip.ParseAddr(ClientIP(r)); err == nil && containsAddr(e.cidrs, addr.Unmap()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRateLimitExempt reports whether the request was marked exempt by RateLimitExemptions
+func isRateLimitExempt(r *http.Request) bool {
+	exempt, _ := r.Context().Value(exemptContextKey{}).(bool)
+	return exempt
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fekuna/omnipos-gateway/config"
+)
+
+func TestRateLimitExemptions_Mark_ExemptCIDRs(t *testing.T) {
+	if err := ConfigureTrustedProxies([]string{"192.168.0.0/16"}); err != nil {
+		t.Fatalf("ConfigureTrustedProxies: %v", err)
+	}
+	defer ConfigureTrustedProxies(nil)
+
+	exemptions, err := NewRateLimitExemptions(config.RateLimitConfig{ExemptCIDRs: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatalf("NewRateLimitExemptions: %v", err)
+	}
+	var exempt bool
+	handler := exemptions.Mark(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		exempt = isRateLimitExempt(r)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        string
+		wantExempt bool
+	}{
+		{"exempt peer", "10.0.0.1:1234", "", true},
+		{"spoofed X-Forwarded-For", "203.0.113.7:1234", "10.0.0.1", false},
+		{"spoofed hop behind the proxy", "192.168.1.1:1234", "10.0.0.1, 203.0.113.7", false},
+		{"exempt client behind the proxy", "192.168.1.1:1234", "10.0.0.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/products", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.xff != "" {
+				req.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if exempt != tt.wantExempt {
+				t.Errorf("expected exempt %v, got %v", tt.wantExempt, exempt)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis_rate/v10"
+)
+
+// localLimiter is a per-instance in-memory token bucket limiter used as a fallback when Redis is unavailable
+type localLimiter struct {
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+}
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+func newLocalLimiter() *localLimiter {
+	return &localLimiter{
+		buckets:     make(map[string]*tokenBucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+// AllowN takes n tokens from the bucket identified by key, mirroring redis_rate's result semantics
+func (l *localLimiter) AllowN(key string, limit redis_rate.Limit, n int) *redis_rate.Result {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cleanup(now)
+
+	// tokens per nanosecond
+	rate := float64(limit.Rate) / float64(limit.Period)
+	capacity := float64(limit.Burst)
+	if rate <= 0 || capacity <= 0 {
+		return &redis_rate.Result{Limit: limit, Allowed: 0, RetryAfter: -1, ResetAfter: -1}
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, lastSeen: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens += float64(now.Sub(bucket.lastSeen)) * rate
+	if bucket.tokens > capacity {
+		bucket.tokens = capacity
+	}
+	bucket.lastSeen = now
+
+	res := &redis_rate.Result{Limit: limit, RetryAfter: -1}
+	if bucket.tokens >= float64(n) {
+		bucket.tokens -= float64(n)
+		res.Allowed = n
+	} else {
+		res.RetryAfter = time.Duration((float64(n) - bucket.tokens) / rate)
+	}
+	res.Remaining = int(bucket.tokens)
+	res.ResetAfter = time.Duration((capacity - bucket.tokens) / rate)
+
+	return res
+}
+
+// cleanup drops buckets that have been idle long enough to be full again
+func (l *localLimiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < time.Minute {
+		return
+	}
+	l.lastCleanup = now
+
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.lastSeen) > 10*time.Minute {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/go-redis/redis_rate/v10"
+	"go.uber.org/zap"
+)
+
+// rateLimitOverridesKey is a Redis hash of temporary limit overrides, keyed by "<scope>:<target>"
+const rateLimitOverridesKey = "rate_limit:overrides"
+
+const (
+	OverrideScopeMerchant = "merchant"
+	OverrideScopeRoute    = "route"
+)
+
+// RateLimitOverride temporarily replaces the limit of a merchant or a route (gRPC method)
+type RateLimitOverride struct {
+	Scope     string        `json:"scope"`
+	Target    string        `json:"target"`
+	RPS       int           `json:"rps"`
+	Burst     int           `json:"burst"`
+	Period    time.Duration `json:"period"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+func (o *RateLimitOverride) limit() redis_rate.Limit {
+	return redis_rate.Limit{Rate: o.RPS, Burst: o.Burst, Period: o.Period}
+}
+
+// rateLimitOverrides keeps an in-memory copy of the overrides stored in Redis, refreshed periodically
+// so the limiter doesn't need an extra Redis round trip per request.
+type rateLimitOverrides struct {
+	redisClient *cache.RedisClient
+	logger      logger.ZapLogger
+
+	mu        sync.RWMutex
+	overrides map[string]*RateLimitOverride
+}
+
+func newRateLimitOverrides(redisClient *cache.RedisClient, log logger.ZapLogger) *rateLimitOverrides {
+	return &rateLimitOverrides{
+		redisClient: redisClient,
+		logger:      log,
+		overrides:   make(map[string]*RateLimitOverride),
+	}
+}
+
+// get returns the active override for scope and target
+func (o *rateLimitOverrides) get(scope, target string) (*RateLimitOverride, bool) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	override, ok := o.overrides[scope+":"+target]
+	if !ok || time.Now().After(override.ExpiresAt) {
+		return nil, false
+	}
+	return override, true
+}
+
+// refresh reloads the overrides from Redis, deleting expired ones
+func (o *rateLimitOverrides) refresh(ctx context.Context) error {
+	values, err := o.redisClient.Client.HGetAll(ctx, rateLimitOverridesKey).Result()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	overrides := make(map[string]*RateLimitOverride, len(values))
+	var expired []string
+
+	for field, value := range values {
+		var override RateLimitOverride
+		if err := json.Unmarshal([]byte(value), &override); err != nil {
+			o.logger.Warn("ignoring invalid rate limit override", zap.String("field", field), zap.Error(err))
+			continue
+		}
+		if override.Period < time.Millisecond {
+			o.logger.Warn("ignoring rate limit override with a period under 1ms", zap.String("field", field), zap.Duration("period", override.Period))
+			continue
+		}
+		if now.After(override.ExpiresAt) {
+			expired = append(expired, field)
+			continue
+		}
+		overrides[field] = &override
+	}
+
+	if len(expired) > 0 {
+		if err := o.redisClient.Client.HDel(ctx, rateLimitOverridesKey, expired...).Err(); err != nil {
+			o.logger.Warn("failed to delete expired rate limit overrides", zap.Error(err))
+		}
+	}
+
+	o.mu.Lock()
+	o.overrides = overrides
+	o.mu.Unlock()
+	return nil
+}
+
+func (o *rateLimitOverrides) list() []*RateLimitOverride {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	list := make([]*RateLimitOverride, 0, len(o.overrides))
+	for _, override := range o.overrides {
+		list = append(list, override)
+	}
+	return list
+}
+
+// Run refreshes the rate limit overrides and rates until ctx is cancelled
+func (rl *RateLimiter) Run(ctx context.Context) {
+	if !rl.cfg.Enabled {
+		return
+	}
+
+	ticker := time.NewTicker(rl.cfg.OverrideRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := rl.overrides.refresh(ctx); err != nil {
+			rl.logger.Error("failed to refresh rate limit overrides", zap.Error(err))
+		}
+		if err := rl.refreshRates(ctx); err != nil {
+			rl.logger.Error("failed to refresh rate limit rates", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RegisterAdminRoutes registers the rate limit override and rate management routes
+func (rl *RateLimiter) RegisterAdminRoutes(mux *http.ServeMux, adminAuth func(http.Handler) http.Handler) {
+	mux.Handle("/admin/rate-limit-overrides", adminAuth(http.HandlerFunc(rl.serveOverrides)))
+	mux.Handle("/admin/rate-limit-rates", adminAuth(http.HandlerFunc(rl.serveRates)))
+}
+
+// serveOverrides lists (GET), sets (POST) and removes (DELETE ?scope=&target=) rate limit overrides
+func (rl *RateLimiter) serveOverrides(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		customRuntime.WriteResponse(w, http.StatusOK, "success", rl.overrides.list())
+
+	case http.MethodPost:
+		var req struct {
+			Scope    string `json:"scope"`  // "merchant" or "route"
+			Target   string `json:"target"` // merchant ID or gRPC method, e.g. "/order.v1.OrderService/CreateOrder"
+			RPS      int    `json:"rps"`
+			Burst    int    `json:"burst"`
+			Period   string `json:"period"`   // optional, defaults to 1s
+			Duration string `json:"duration"` // how long the override stays active, e.g. "6h"
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid request body", nil)
+			return
+		}
+
+		if (req.Scope != OverrideScopeMerchant && req.Scope != OverrideScopeRoute) || req.Target == "" || req.RPS <= 0 {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "scope (merchant|route), target and rps are required", nil)
+			return
+		}
+
+		period := time.Second
+		if req.Period != "" {
+			p, err := time.ParseDuration(req.Period)
+			if err != nil || p < time.Millisecond {
+				customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid period", nil)
+				return
+			}
+			period = p
+		}
+
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid duration", nil)
+			return
+		}
+
+		override := &RateLimitOverride{
+			Scope:     req.Scope,
+			Target:    req.Target,
+			RPS:       req.RPS,
+			Burst:     max(req.Burst, req.RPS),
+			Period:    period,
+			ExpiresAt: time.Now().Add(duration).UTC(),
+		}
+
+		value, _ := json.Marshal(override)
+		if err := rl.redisClient.Client.HSet(ctx, rateLimitOverridesKey, override.Scope+":"+override.Target, value).Err(); err != nil {
+			rl.logger.Error("failed to set rate limit override", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to set rate limit override", nil)
+			return
+		}
+
+		rl.logger.Info("rate limit override set",
+			zap.String("scope", override.Scope), zap.String("target", override.Target),
+			zap.Int("rps", override.RPS), zap.Time("expires_at", override.ExpiresAt))
+		rl.refreshOverridesAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", override)
+
+	case http.MethodDelete:
+		scope, target := r.URL.Query().Get("scope"), r.URL.Query().Get("target")
+		if err := rl.redisClient.Client.HDel(ctx, rateLimitOverridesKey, scope+":"+target).Err(); err != nil {
+			rl.logger.Error("failed to delete rate limit override", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to delete rate limit override", nil)
+			return
+		}
+
+		rl.logger.Info("rate limit override removed", zap.String("scope", scope), zap.String("target", target))
+		rl.refreshOverridesAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+// refreshOverridesAfterChange applies a change on this instance immediately; others pick it up on their next refresh
+func (rl *RateLimiter) refreshOverridesAfterChange(ctx context.Context) {
+	if err := rl.overrides.refresh(ctx); err != nil {
+		rl.logger.Error("failed to refresh rate limit overrides", zap.Error(err))
+	}
+}
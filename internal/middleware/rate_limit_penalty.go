@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+// penaltyScript counts a rate limit violation and moves the principal into the penalty box
+// once the threshold is reached within the window. Returns the penalty TTL in ms, or 0.
+var penaltyScript = redis.NewScript(`
+local threshold = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local duration = tonumber(ARGV[3])
+
+local violations = redis.call("INCR", KEYS[1])
+if violations == 1 then
+	redis.call("PEXPIRE", KEYS[1], window)
+end
+
+if violations < threshold then
+	return 0
+end
+
+redis.call("DEL", KEYS[1])
+redis.call("SET", KEYS[2], "1", "PX", duration)
+return duration
+`)
+
+// penaltyBox bans principals that keep exceeding their limit, so abusive clients
+// retrying at full rate are rejected without touching their buckets.
+type penaltyBox struct {
+	redisClient *cache.RedisClient
+	threshold   int
+	window      time.Duration
+	duration    time.Duration
+}
+
+// remaining returns how long the principal stays in the penalty box, or 0
+func (p *penaltyBox) remaining(ctx context.Context, principal string) (time.Duration, error) {
+	ttl, err := p.redisClient.Client.PTTL(ctx, penaltyKey(principal)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// -2 (missing key) and -1 (no expiry, never set by us) both mean no penalty
+	return max(ttl, 0), nil
+}
+
+// recordViolation counts a throttled request and returns the penalty duration if it triggered one
+func (p *penaltyBox) recordViolation(ctx context.Context, principal string) (time.Duration, error) {
+	keys := []string{"rate_limit:violations:" + principal, penaltyKey(principal)}
+	ms, err := penaltyScript.Run(ctx, p.redisClient.Client, keys, p.threshold, p.window.Milliseconds(), p.duration.Milliseconds()).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+func penaltyKey(principal string) string {
+	return "rate_limit:penalty:" + principal
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/logger"
+	pkgMiddleware "github.com/fekuna/omnipos-pkg/middleware"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Recovery turns panics into errors: a 500 envelope carrying the request ID to correlate with the logged
+// stack for requests, an INTERNAL error for backend calls. Each panic is logged once, with its stack,
+// and counted.
+type Recovery struct {
+	logger logger.ZapLogger
+}
+
+// NewRecovery creates the recovering middleware and interceptors
+func NewRecovery(log logger.ZapLogger) *Recovery {
+	return &Recovery{logger: log}
+}
+
+// Handle recovers the panics of requests. It goes right inside the request ID middleware, outside of
+// the error capture, which reports panics and re-raises them.
+func (rc *Recovery) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := NewStatusRecorder(w)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// Deliberate abort of the response, e.g. a proxied stream cut short
+				panic(v)
+			}
+
+			metrics.Panics.WithLabelValues("http").Inc()
+			rc.logger.Error("panic serving request",
+				zap.String("panic", fmt.Sprint(v)),
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("request_id", pkgMiddleware.GetRequestID(r.Context())),
+				zap.String("stack", string(debug.Stack())),
+			)
+
+			if rec.Wrote {
+				// Too late for an error response: abort the connection rather than leave a truncated body
+				// looking complete
+				panic(http.ErrAbortHandler)
+			}
+			customRuntime.WriteResponse(rec, http.StatusInternalServerError, "internal server error", nil)
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// Unary returns a unary client interceptor turning panics of the calls below it (interceptors, codecs)
+// into INTERNAL errors; it goes first in the interceptor chain
+func (rc *Recovery) Unary() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+		defer rc.recoverCall(ctx, method, &err)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Stream returns a stream client interceptor turning panics opening backend streams into INTERNAL errors
+func (rc *Recovery) Stream() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (stream grpc.ClientStream, err error) {
+		defer rc.recoverCall(ctx, method, &err)
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func (rc *Recovery) recoverCall(ctx context.Context, method string, err *error) {
+	v := recover()
+	if v == nil {
+		return
+	}
+
+	metrics.Panics.WithLabelValues("grpc_client").Inc()
+	rc.logger.Error("panic calling backend",
+		zap.String("panic", fmt.Sprint(v)),
+		zap.String("grpc_method", method),
+		zap.String("request_id", pkgMiddleware.GetRequestID(ctx)),
+		zap.String("stack", string(debug.Stack())),
+	)
+	*err = status.Error(codes.Internal, "internal error")
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+)
+
+// RequestMetrics counts requests by route, status code and merchant, and observes their latency by route
+type RequestMetrics struct {
+	jwtHelper *JWTHelper
+	routes    *RouteTable
+}
+
+// NewRequestMetrics creates the request counting middleware
+func NewRequestMetrics(jwtHelper *JWTHelper, routes *RouteTable) *RequestMetrics {
+	return &RequestMetrics{jwtHelper: jwtHelper, routes: routes}
+}
+
+// Count counts requests once they're answered. The trace ID of their traceparent header, when sampled, is
+// kept for the latency exemplars of the request and its backend calls.
+func (m *RequestMetrics) Count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		metrics.HTTPInFlight.Inc()
+		defer metrics.HTTPInFlight.Dec()
+		r = r.WithContext(metrics.WithTraceID(r.Context(), metrics.SampledTraceID(r.Header.Get(TraceparentHeader))))
+		rec := NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if matched, ok := m.routes.MatchRequest(r); ok {
+			route = matched.Method
+		}
+		var merchantID string
+		if claims, err := m.jwtHelper.RequestClaims(r); err == nil {
+			merchantID = claims.MerchantID
+		}
+		metrics.CountRequest(route, rec.Status, merchantID)
+		metrics.Observe(r.Context(), metrics.HTTPRequestDuration.WithLabelValues(route), time.Since(start).Seconds())
+	})
+}
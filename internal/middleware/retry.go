@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RetryInterceptor retries idempotent backend calls failing with UNAVAILABLE or DEADLINE_EXCEEDED, so
+// transient backend restarts don't surface as 5xx to terminals. Attempts are spaced by exponential
+// backoff with full jitter and never outlive the call's deadline.
+type RetryInterceptor struct {
+	idempotent map[string]bool
+	cfg        config.RetryConfig
+	logger     logger.ZapLogger
+}
+
+// NewRetryInterceptor creates a retry interceptor for the idempotent methods (see DiscoverIdempotentMethods)
+// and the methods listed in cfg
+func NewRetryInterceptor(idempotent map[string]bool, cfg config.RetryConfig, log logger.ZapLogger) *RetryInterceptor {
+	methods := make(map[string]bool, len(idempotent)+len(cfg.Methods))
+	for method := range idempotent {
+		methods[method] = true
+	}
+	for _, method := range cfg.Methods {
+		methods[method] = true
+	}
+
+	return &RetryInterceptor{
+		idempotent: methods,
+		cfg:        cfg,
+		logger:     log,
+	}
+}
+
+// Unary returns a unary client interceptor retrying failed idempotent calls
+func (ri *RetryInterceptor) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		if !ri.cfg.Enabled || !ri.idempotent[method] {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		for attempt := 1; ; attempt++ {
+			err := ri.invoke(ctx, method, req, reply, cc, invoker, opts)
+			code := status.Code(err)
+			if err == nil || attempt >= ri.cfg.MaxAttempts || !retryableCode(code) {
+				return err
+			}
+
+			backoff := ri.backoff(attempt)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= backoff {
+				return err
+			}
+
+			ri.logger.Debug("retrying backend call", zap.String("method", method), zap.Int("attempt", attempt), zap.String("code", code.String()), zap.Duration("backoff", backoff))
+			metrics.BackendRetries.WithLabelValues(serviceName(method), code.String()).Inc()
+
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+func (ri *RetryInterceptor) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts []grpc.CallOption) error {
+	if ri.cfg.PerAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ri.cfg.PerAttemptTimeout)
+		defer cancel()
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// backoff returns a random delay up to InitialBackoff * 2^(attempt-1), capped at MaxBackoff
+func (ri *RetryInterceptor) backoff(attempt int) time.Duration {
+	backoff := ri.cfg.InitialBackoff << (attempt - 1)
+	if backoff <= 0 || backoff > ri.cfg.MaxBackoff {
+		backoff = ri.cfg.MaxBackoff
+	}
+	return rand.N(backoff) + 1
+}
+
+func retryableCode(code codes.Code) bool {
+	return code == codes.Unavailable || code == codes.DeadlineExceeded
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryInterceptor_Unary(t *testing.T) {
+	retry := NewRetryInterceptor(map[string]bool{
+		"/product.v1.ProductService/GetProduct": true,
+	}, config.RetryConfig{
+		Enabled:        true,
+		MaxAttempts:    3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}, logger.NewZapLogger(&logger.ZapLoggerConfig{}))
+
+	tests := []struct {
+		name         string
+		method       string
+		errs         []codes.Code
+		wantAttempts int
+		wantCode     codes.Code
+	}{
+		{"recovers", "/product.v1.ProductService/GetProduct", []codes.Code{codes.Unavailable, codes.OK}, 2, codes.OK},
+		{"gives up", "/product.v1.ProductService/GetProduct", []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.Unavailable}, 3, codes.Unavailable},
+		{"not retryable", "/product.v1.ProductService/GetProduct", []codes.Code{codes.NotFound}, 1, codes.NotFound},
+		{"not idempotent", "/order.v1.OrderService/CreateOrder", []codes.Code{codes.Unavailable}, 1, codes.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				code := tt.errs[attempts]
+				attempts++
+				return status.Error(code, code.String())
+			}
+
+			err := retry.Unary()(context.Background(), tt.method, nil, nil, nil, invoker)
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+			if code := status.Code(err); code != tt.wantCode {
+				t.Errorf("code = %s, want %s", code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+type rewriteRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// PathRewrite rewrites request paths before routing: deployment prefixes are stripped, then vanity
+// aliases (exact paths, e.g. "/login") are resolved, then the first matching regexp rule is applied,
+// so published URLs and legacy client paths reach the current proto routes.
+// Rules apply to the escaped path; the query string and body are left untouched.
+type PathRewrite struct {
+	stripPrefixes []string
+	aliases       map[string]string
+	rules         []rewriteRule
+	logger        logger.ZapLogger
+}
+
+// NewPathRewrite creates the path rewrite middleware, failing on invalid rules
+func NewPathRewrite(cfg config.RewriteConfig, log logger.ZapLogger) (*PathRewrite, error) {
+	var stripPrefixes []string
+	for _, prefix := range cfg.StripPrefixes {
+		if prefix = strings.TrimRight(prefix, "/"); prefix != "" {
+			stripPrefixes = append(stripPrefixes, prefix)
+		}
+	}
+
+	aliases := make(map[string]string, len(cfg.Aliases))
+	for alias, target := range cfg.Aliases {
+		if !strings.HasPrefix(alias, "/") || !strings.HasPrefix(target, "/") {
+			return nil, fmt.Errorf("invalid rewrite alias %q: paths must start with /", alias)
+		}
+		aliases[strings.TrimRight(alias, "/")] = target
+	}
+
+	rules := make([]rewriteRule, 0, len(cfg.Rules))
+	for _, rule := range cfg.Rules {
+		pattern, replacement, ok := strings.Cut(rule, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rewrite rule %q: must be <regexp>=<replacement>", rule)
+		}
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite rule %q: %w", rule, err)
+		}
+		rules = append(rules, rewriteRule{pattern: re, replacement: replacement})
+	}
+
+	return &PathRewrite{
+		stripPrefixes: stripPrefixes,
+		aliases:       aliases,
+		rules:         rules,
+		logger:        log,
+	}, nil
+}
+
+// Rewrite rewrites the request path; it must run before the middlewares matching requests against the proto routes
+func (p *PathRewrite) Rewrite(next http.Handler) http.Handler {
+	if len(p.stripPrefixes) == 0 && len(p.aliases) == 0 && len(p.rules) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		escaped := r.URL.EscapedPath()
+		path := p.rewrite(escaped)
+		if path == escaped {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		unescaped, err := url.PathUnescape(path)
+		if err != nil {
+			p.logger.Warn("invalid rewritten path", zap.String("path", escaped), zap.String("rewritten", path), zap.Error(err))
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p.logger.Debug("path rewritten", zap.String("path", escaped), zap.String("rewritten", path))
+
+		r2 := new(http.Request)
+		*r2 = *r
+		u := *r.URL
+		u.Path = unescaped
+		u.RawPath = path
+		r2.URL = &u
+		next.ServeHTTP(w, r2)
+	})
+}
+
+func (p *PathRewrite) rewrite(path string) string {
+	for _, prefix := range p.stripPrefixes {
+		if rest, ok := matchPrefix(path, prefix); ok && (rest == "" || rest[0] == '/') {
+			path = rest
+			if path == "" {
+				path = "/"
+			}
+			break
+		}
+	}
+
+	// Aliases match with or without a trailing slash
+	if target, ok := p.aliases[strings.TrimRight(path, "/")]; ok {
+		return target
+	}
+
+	for _, rule := range p.rules {
+		if rule.pattern.MatchString(path) {
+			return rule.pattern.ReplaceAllString(path, rule.replacement)
+		}
+	}
+	return path
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/fekuna/omnipos-gateway/config"
+)
+
+func TestPathRewrite_Rewrite(t *testing.T) {
+	rewrite, err := NewPathRewrite(config.RewriteConfig{
+		StripPrefixes: []string{"/api/"},
+		Rules: []string{
+			`^/legacy/orders/([^/]+)$=/v1/orders/$1`,
+			`^/legacy/(.*)$=/v1/$1`,
+		},
+		Aliases: map[string]string{
+			"/login":  "/v1/merchants/login",
+			"/legacy": "/v1/legacy-home",
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewPathRewrite: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v1/orders", "/v1/orders"},
+		{"/api", "/"},
+		{"/apiv1/orders", "/apiv1/orders"},
+		{"/legacy/orders/123", "/v1/orders/123"},
+		{"/api/legacy/products/p1", "/v1/products/p1"},
+		{"/v1/orders/123", "/v1/orders/123"},
+		{"/login", "/v1/merchants/login"},
+		{"/api/login/", "/v1/merchants/login"},
+		{"/login/extra", "/login/extra"},
+		{"/legacy", "/v1/legacy-home"},
+	}
+
+	for _, tt := range tests {
+		if got := rewrite.rewrite(tt.path); got != tt.want {
+			t.Errorf("rewrite(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	if _, err := NewPathRewrite(config.RewriteConfig{Rules: []string{"^/(unclosed=/v1"}}, nil); err == nil {
+		t.Error("expected an error for an invalid regexp")
+	}
+}
@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Route maps an HTTP rule declared in the proto definitions to its gRPC method
+type Route struct {
+	Method     string // Full gRPC method name, e.g. "/user.v1.MerchantService/LoginMerchant"
+	HTTPMethod string
+	Pattern    string
+
+	segments  []string
+	verb      string
+	variables map[int]string
+	literals  int
+}
+
+// RouteTable resolves incoming HTTP requests to gRPC methods before they reach the grpc-gateway mux,
+// so HTTP middleware can apply per-method policies.
+type RouteTable struct {
+	routes []*Route
+}
+
+// NewRouteTable creates an empty route table
+func NewRouteTable() *RouteTable {
+	return &RouteTable{}
+}
+
+// Add registers an HTTP rule for a gRPC method
+func (t *RouteTable) Add(httpMethod, pattern, method string) {
+	segments, verb, variables := parsePathTemplate(pattern)
+
+	literals := 0
+	for _, seg := range segments {
+		if seg != "*" && seg != "**" {
+			literals++
+		}
+	}
+
+	t.routes = append(t.routes, &Route{
+		Method:     method,
+		HTTPMethod: strings.ToUpper(httpMethod),
+		Pattern:    pattern,
+		segments:   segments,
+		verb:       verb,
+		variables:  variables,
+		literals:   literals,
+	})
+}
+
+// Routes returns all registered routes
+func (t *RouteTable) Routes() []*Route {
+	return t.routes
+}
+
+// Match returns the most specific route matching the HTTP method and path
+func (t *RouteTable) Match(httpMethod, path string) (*Route, bool) {
+	var best *Route
+	for _, route := range t.routes {
+		if route.HTTPMethod != httpMethod || !route.matches(path) {
+			continue
+		}
+		if best == nil || route.literals > best.literals {
+			best = route
+		}
+	}
+	return best, best != nil
+}
+
+// AllowedMethods returns the HTTP methods routed for the path, with HEAD for GET routes and OPTIONS,
+// or nil when no route matches the path
+func (t *RouteTable) AllowedMethods(path string) []string {
+	seen := make(map[string]bool)
+	for _, route := range t.routes {
+		if route.matches(path) {
+			seen[route.HTTPMethod] = true
+		}
+	}
+	if len(seen) == 0 {
+		return nil
+	}
+
+	if seen[http.MethodGet] {
+		seen[http.MethodHead] = true
+	}
+	seen[http.MethodOptions] = true
+
+	methods := make([]string, 0, len(seen))
+	for method := range seen {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// MatchRequest returns the route matching the request
+func (t *RouteTable) MatchRequest(r *http.Request) (*Route, bool) {
+	if t == nil {
+		return nil, false
+	}
+	return t.Match(r.Method, r.URL.Path)
+}
+
+// PathParams returns the values of the route's single-segment path variables, keyed by field path.
+// The path must match the route.
+func (r *Route) PathParams(path string) map[string]string {
+	components := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if r.verb != "" {
+		last := len(components) - 1
+		components[last] = strings.TrimSuffix(components[last], ":"+r.verb)
+	}
+
+	params := make(map[string]string, len(r.variables))
+	for i, field := range r.variables {
+		if i < len(components) {
+			if value, err := url.PathUnescape(components[i]); err == nil {
+				params[field] = value
+			}
+		}
+	}
+	return params
+}
+
+func (r *Route) matches(path string) bool {
+	components := strings.Split(strings.TrimPrefix(path, "/"), "/")
+
+	if r.verb != "" {
+		last := components[len(components)-1]
+		if !strings.HasSuffix(last, ":"+r.verb) {
+			return false
+		}
+		components[len(components)-1] = strings.TrimSuffix(last, ":"+r.verb)
+	}
+
+	for i, seg := range r.segments {
+		if seg == "**" {
+			return true
+		}
+		if i >= len(components) {
+			return false
+		}
+		if seg != "*" && seg != components[i] {
+			return false
+		}
+		if seg == "*" && components[i] == "" {
+			return false
+		}
+	}
+
+	return len(components) == len(r.segments)
+}
+
+// parsePathTemplate flattens a google.api.http path template into segments,
+// expanding variables ("{id}" -> "*", "{name=shelves/*}" -> "shelves", "*") and splitting off the verb.
+// It also returns the field paths of single-segment variables by segment index.
+func parsePathTemplate(template string) ([]string, string, map[int]string) {
+	template = strings.TrimPrefix(template, "/")
+
+	var (
+		segments  []string
+		verb      string
+		current   strings.Builder
+		variables = make(map[int]string)
+	)
+
+	flush := func() {
+		segments = append(segments, current.String())
+		current.Reset()
+	}
+
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end == -1 {
+				current.WriteString(template[i:])
+				i = len(template)
+				continue
+			}
+			variable := template[i+1 : i+end]
+			if eq := strings.IndexByte(variable, '='); eq != -1 {
+				parts := strings.Split(variable[eq+1:], "/")
+				for j, part := range parts {
+					current.WriteString(part)
+					if j < len(parts)-1 {
+						flush()
+					}
+				}
+			} else {
+				if current.Len() == 0 {
+					variables[len(segments)] = variable
+				}
+				current.WriteString("*")
+			}
+			i += end
+		case c == '/':
+			flush()
+		case c == ':' && !strings.Contains(template[i:], "/"):
+			verb = template[i+1:]
+			i = len(template)
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+
+	return segments, verb, variables
+}
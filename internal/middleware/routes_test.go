@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRouteTable_Match(t *testing.T) {
+	routes := NewRouteTable()
+	routes.Add("POST", "/v1/merchants/login", "/user.v1.MerchantService/LoginMerchant")
+	routes.Add("GET", "/v1/merchants/{id}", "/user.v1.MerchantService/GetMerchant")
+	routes.Add("GET", "/v1/products/{product_id}/variants/{id}", "/product.v1.ProductVariantService/GetVariant")
+	routes.Add("POST", "/v1/orders/{id}:cancel", "/order.v1.OrderService/CancelOrder")
+	routes.Add("GET", "/v1/{name=stores/*}", "/store.v1.StoreService/GetStore")
+
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"POST", "/v1/merchants/login", "/user.v1.MerchantService/LoginMerchant"},
+		{"GET", "/v1/merchants/abc", "/user.v1.MerchantService/GetMerchant"},
+		{"GET", "/v1/products/p1/variants/v1", "/product.v1.ProductVariantService/GetVariant"},
+		{"POST", "/v1/orders/123:cancel", "/order.v1.OrderService/CancelOrder"},
+		{"GET", "/v1/stores/s1", "/store.v1.StoreService/GetStore"},
+		{"GET", "/v1/merchants/login", "/user.v1.MerchantService/GetMerchant"},
+		{"POST", "/v1/orders/123", ""},
+		{"GET", "/v1/merchants", ""},
+		{"GET", "/v1/merchants/abc/extra", ""},
+		{"DELETE", "/v1/merchants/abc", ""},
+	}
+
+	for _, tt := range tests {
+		route, ok := routes.Match(tt.method, tt.path)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%s %s: expected no match, got %s", tt.method, tt.path, route.Method)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s %s: expected %s, got no match", tt.method, tt.path, tt.want)
+			continue
+		}
+		if route.Method != tt.want {
+			t.Errorf("%s %s: expected %s, got %s", tt.method, tt.path, tt.want, route.Method)
+		}
+	}
+}
+
+func TestRouteTable_MatchPrefersLiterals(t *testing.T) {
+	routes := NewRouteTable()
+	routes.Add("GET", "/v1/products/{id}", "/product.v1.ProductService/GetProduct")
+	routes.Add("GET", "/v1/products/search", "/product.v1.ProductService/SearchProducts")
+
+	route, ok := routes.Match("GET", "/v1/products/search")
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if route.Method != "/product.v1.ProductService/SearchProducts" {
+		t.Errorf("Expected the literal route to win, got %s", route.Method)
+	}
+}
+
+func TestRoute_PathParams(t *testing.T) {
+	routes := NewRouteTable()
+	routes.Add("POST", "/v1/products/{product_id}/images", "/product.v1.ProductService/UploadProductImage")
+	routes.Add("POST", "/v1/orders/{id}:cancel", "/order.v1.OrderService/CancelOrder")
+
+	route, _ := routes.Match("POST", "/v1/products/p%201/images")
+	if got := route.PathParams("/v1/products/p%201/images")["product_id"]; got != "p 1" {
+		t.Errorf("Expected product_id %q, got %q", "p 1", got)
+	}
+
+	route, _ = routes.Match("POST", "/v1/orders/123:cancel")
+	if got := route.PathParams("/v1/orders/123:cancel")["id"]; got != "123" {
+		t.Errorf("Expected id %q, got %q", "123", got)
+	}
+}
+
+func TestRouteTable_AllowedMethods(t *testing.T) {
+	routes := NewRouteTable()
+	routes.Add("GET", "/v1/products/{id}", "/product.v1.ProductService/GetProduct")
+	routes.Add("PUT", "/v1/products/{id}", "/product.v1.ProductService/UpdateProduct")
+	routes.Add("DELETE", "/v1/products/{id}", "/product.v1.ProductService/DeleteProduct")
+	routes.Add("POST", "/v1/products", "/product.v1.ProductService/CreateProduct")
+
+	if got := strings.Join(routes.AllowedMethods("/v1/products/p1"), ", "); got != "DELETE, GET, HEAD, OPTIONS, PUT" {
+		t.Errorf("/v1/products/p1: got %q", got)
+	}
+	if got := strings.Join(routes.AllowedMethods("/v1/products"), ", "); got != "OPTIONS, POST" {
+		t.Errorf("/v1/products: got %q", got)
+	}
+	if got := routes.AllowedMethods("/v1/unknown"); got != nil {
+		t.Errorf("/v1/unknown: expected nil, got %v", got)
+	}
+}
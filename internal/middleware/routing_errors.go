@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+)
+
+// RoutingErrorHandler answers requests no grpc-gateway route matches with the standard envelope
+// instead of a bare status, with a hint at what went wrong. The gateway mux is the catch-all route of
+// the outer mux, so this covers unknown paths of both. Binary protobuf clients get the default
+// google.rpc.Status body.
+func RoutingErrorHandler(routes *RouteTable) runtime.RoutingErrorHandlerFunc {
+	return func(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, httpStatus int) {
+		if _, ok := marshaler.(*customRuntime.CustomMarshaler); !ok {
+			runtime.DefaultRoutingErrorHandler(ctx, mux, marshaler, w, r, httpStatus)
+			return
+		}
+
+		data := map[string]interface{}{
+			"method": r.Method,
+			"path":   r.URL.Path,
+		}
+
+		switch httpStatus {
+		case http.StatusMethodNotAllowed:
+			if methods := routes.AllowedMethods(r.URL.Path); methods != nil {
+				w.Header().Set("Allow", strings.Join(methods, ", "))
+				data["allowed_methods"] = methods
+			}
+			customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", data)
+
+		case http.StatusNotFound:
+			data["hint"] = notFoundHint(routes, r.URL.Path)
+			customRuntime.WriteResponse(w, http.StatusNotFound, "route not found", data)
+
+		default:
+			customRuntime.WriteResponse(w, httpStatus, strings.ToLower(http.StatusText(httpStatus)), data)
+		}
+	}
+}
+
+func notFoundHint(routes *RouteTable, path string) string {
+	if trimmed := strings.TrimSuffix(path, "/"); trimmed != path && routes.AllowedMethods(trimmed) != nil {
+		return "did you mean " + trimmed + "?"
+	}
+	if !strings.HasPrefix(path, "/"+protoAPIVersion+"/") {
+		return "API routes start with /" + protoAPIVersion + "/"
+	}
+	return "see /swagger-ui/ for the available routes"
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/fekuna/omnipos-gateway/internal/security"
+)
+
+// RecordSecurityEvent records a security event raised by source while handling r. principal is what the
+// event is attributed to (merchant, device), the client IP when empty.
+func RecordSecurityEvent(r *http.Request, eventType, source, reason, principal string) {
+	ip := ClientIP(r)
+	if principal == "" {
+		principal = "ip:" + ip
+	}
+	security.Record(r.Context(), security.Event{
+		Type:      eventType,
+		Source:    source,
+		Reason:    reason,
+		IP:        ip,
+		Principal: principal,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+	})
+}
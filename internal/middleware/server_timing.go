@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server-Timing metrics
+const (
+	TimingAuth    = "auth"    // bearer token validation
+	TimingBackend = "backend" // backend RPCs, summed over calls and attempts
+	TimingMarshal = "marshal" // from the end of the last backend RPC to the response header
+	TimingTotal   = "total"   // from the start of the request to the response header
+)
+
+// serverTimings accumulates the durations of a request; backend calls may run concurrently (hedging,
+// GraphQL fields)
+type serverTimings struct {
+	mu         sync.Mutex
+	start      time.Time
+	durations  map[string]time.Duration
+	backendEnd time.Time
+}
+
+type serverTimingsKey struct{}
+
+// RecordServerTiming adds d to the name timing of the request of ctx, if it's timed
+func RecordServerTiming(ctx context.Context, name string, d time.Duration) {
+	t, ok := ctx.Value(serverTimingsKey{}).(*serverTimings)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.durations[name] += d
+	if name == TimingBackend {
+		t.backendEnd = time.Now()
+	}
+}
+
+// header formats the timings, e.g. "auth;dur=0.21, backend;dur=30.5, marshal;dur=0.4, total;dur=32.1"
+func (t *serverTimings) header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var entries []string
+	for _, name := range []string{TimingAuth, TimingBackend} {
+		if d, ok := t.durations[name]; ok {
+			entries = append(entries, timingEntry(name, d))
+		}
+	}
+	if !t.backendEnd.IsZero() {
+		entries = append(entries, timingEntry(TimingMarshal, now.Sub(t.backendEnd)))
+	}
+	entries = append(entries, timingEntry(TimingTotal, now.Sub(t.start)))
+	return strings.Join(entries, ", ")
+}
+
+func timingEntry(name string, d time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.2f", name, float64(d.Microseconds())/1000)
+}
+
+// ServerTiming adds a Server-Timing header breaking the latency of responses down into auth, backend
+// RPC and marshaling, so frontend engineers can see where it's spent without the server traces
+func ServerTiming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := &serverTimings{start: time.Now(), durations: make(map[string]time.Duration)}
+		r = r.WithContext(context.WithValue(r.Context(), serverTimingsKey{}, t))
+		if origin := r.Header.Get("Origin"); origin != "" {
+			// Lets the browser expose the timings to the page's scripts across origins
+			w.Header().Set("Timing-Allow-Origin", origin)
+		}
+
+		next.ServeHTTP(&timingWriter{ResponseWriter: w, timings: t}, r)
+	})
+}
+
+// timingWriter sets the Server-Timing header when the response header is sent
+type timingWriter struct {
+	http.ResponseWriter
+	timings *serverTimings
+	wrote   bool
+}
+
+func (w *timingWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.wrote = true
+		w.Header().Set("Server-Timing", w.timings.header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// SessionCookie implements the cookie-based session mode: the access token is kept in a
+// secure httpOnly cookie and translated into an Authorization header for the rest of the chain.
+type SessionCookie struct {
+	cfg          config.SessionConfig
+	jwtHelper    *JWTHelper
+	logger       logger.ZapLogger
+	loginMethods map[string]bool
+}
+
+// NewSessionCookie creates a new cookie session handler
+func NewSessionCookie(cfg config.SessionConfig, jwtHelper *JWTHelper, log logger.ZapLogger) *SessionCookie {
+	loginMethods := make(map[string]bool, len(cfg.LoginMethods))
+	for _, method := range cfg.LoginMethods {
+		loginMethods[method] = true
+	}
+
+	return &SessionCookie{
+		cfg:          cfg,
+		jwtHelper:    jwtHelper,
+		logger:       log,
+		loginMethods: loginMethods,
+	}
+}
+
+// Authenticate copies the session cookie into the Authorization header when the request doesn't carry one
+func (s *SessionCookie) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.cfg.CookieEnabled || r.Header.Get("Authorization") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if cookie, err := r.Cookie(s.cfg.CookieName); err == nil && cookie.Value != "" {
+			r.Header.Set("Authorization", "Bearer "+cookie.Value)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ForwardResponse is a grpc-gateway forward response option that sets the session cookie
+// when one of the configured login methods returns an access token. The token is blanked in
+// the response body, so it only ever reaches the browser in the httpOnly cookie.
+func (s *SessionCookie) ForwardResponse(ctx context.Context, w http.ResponseWriter, resp proto.Message) error {
+	if !s.cfg.CookieEnabled {
+		return nil
+	}
+
+	method, ok := runtime.RPCMethod(ctx)
+	if !ok || !s.loginMethods[method] {
+		return nil
+	}
+
+	token := findStringField(resp.ProtoReflect(), protoreflect.Name(s.cfg.TokenField))
+	if token == "" {
+		s.logger.Warn("login response has no access token field", zap.String("method", method), zap.String("field", s.cfg.TokenField))
+		return nil
+	}
+
+	expires := time.Now().Add(s.cfg.CookieMaxAge)
+	if claims, err := s.jwtHelper.ValidateToken(token); err == nil && claims.ExpiresAt != nil {
+		expires = claims.ExpiresAt.Time
+	}
+
+	http.SetCookie(w, s.newCookie(token, expires))
+	clearStringField(resp.ProtoReflect(), protoreflect.Name(s.cfg.TokenField))
+	return nil
+}
+
+// RegisterRoutes registers the session management routes
+func (s *SessionCookie) RegisterRoutes(mux *http.ServeMux) {
+	if !s.cfg.CookieEnabled {
+		return
+	}
+
+	mux.HandleFunc(s.cfg.LogoutPath, s.logout)
+}
+
+// logout clears the session cookie
+func (s *SessionCookie) logout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	cookie := s.newCookie("", time.Unix(0, 0))
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+
+	customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+}
+
+func (s *SessionCookie) newCookie(value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     s.cfg.CookieName,
+		Value:    value,
+		Path:     s.cfg.CookiePath,
+		Domain:   s.cfg.CookieDomain,
+		Expires:  expires,
+		Secure:   s.cfg.CookieSecure,
+		HttpOnly: true,
+		SameSite: parseSameSite(s.cfg.CookieSameSite),
+	}
+}
+
+func parseSameSite(v string) http.SameSite {
+	switch strings.ToLower(v) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteStrictMode
+	}
+}
+
+// findStringField looks up a string field by name in the message or any of its nested messages
+func findStringField(m protoreflect.Message, name protoreflect.Name) string {
+	var found string
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Name() == name && fd.Kind() == protoreflect.StringKind && !fd.IsList() && !fd.IsMap():
+			found = v.String()
+		case fd.Kind() == protoreflect.MessageKind && !fd.IsList() && !fd.IsMap():
+			found = findStringField(v.Message(), name)
+		}
+		return found == ""
+	})
+	return found
+}
+
+// clearStringField clears the string fields named name in the message and all of its nested messages
+func clearStringField(m protoreflect.Message, name protoreflect.Name) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.Name() == name && fd.Kind() == protoreflect.StringKind && !fd.IsList() && !fd.IsMap():
+			m.Clear(fd)
+		case fd.Kind() == protoreflect.MessageKind && !fd.IsList() && !fd.IsMap():
+			clearStringField(v.Message(), name)
+		}
+		return true
+	})
+}
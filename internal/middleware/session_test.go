@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/sourcecontextpb"
+	"google.golang.org/protobuf/types/known/typepb"
+)
+
+func TestSessionCookie_ForwardResponse_BlanksToken(t *testing.T) {
+	const loginMethod = "/auth.v1.AuthService/Login"
+	session := NewSessionCookie(config.SessionConfig{
+		CookieEnabled: true,
+		CookieName:    "omnipos_session",
+		CookiePath:    "/",
+		CookieMaxAge:  time.Hour,
+		LoginMethods:  []string{loginMethod},
+		TokenField:    "file_name",
+	}, NewJWTHelper("secret"), logger.NewZapLogger(&logger.ZapLoggerConfig{}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/login", nil)
+	ctx, err := runtime.AnnotateContext(context.Background(), runtime.NewServeMux(), req, loginMethod)
+	if err != nil {
+		t.Fatalf("AnnotateContext: %v", err)
+	}
+
+	// The source context of a Type stands in for the nested token of a login response
+	resp := &typepb.Type{Name: "LoginResponse", SourceContext: &sourcecontextpb.SourceContext{FileName: "access-token"}}
+	rec := httptest.NewRecorder()
+	if err := session.ForwardResponse(ctx, rec, resp); err != nil {
+		t.Fatalf("ForwardResponse: %v", err)
+	}
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != "access-token" || !cookies[0].HttpOnly {
+		t.Fatalf("expected the httpOnly session cookie holding the token, got %v", cookies)
+	}
+	body, err := protojson.Marshal(resp)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if strings.Contains(string(body), "access-token") {
+		t.Errorf("expected the token to be blanked in the body, got %s", body)
+	}
+	if resp.GetName() != "LoginResponse" {
+		t.Errorf("expected the other fields to be kept, got %s", body)
+	}
+}
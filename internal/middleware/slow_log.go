@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-pkg/logger"
+	pkgMiddleware "github.com/fekuna/omnipos-pkg/middleware"
+	"go.uber.org/zap"
+)
+
+// SlowLog logs a warning for requests taking longer than cfg.Threshold, with the route, merchant and
+// backend, and counts them by route, so tail-latency regressions show up without tracing
+type SlowLog struct {
+	cfg       config.SlowLogConfig
+	jwtHelper *JWTHelper
+	routes    *RouteTable
+	backends  map[string]string // gRPC service -> backend address
+	logger    logger.ZapLogger
+}
+
+// NewSlowLog creates a slow request logger
+func NewSlowLog(jwtHelper *JWTHelper, routes *RouteTable, backends map[string]string, cfg config.SlowLogConfig, log logger.ZapLogger) *SlowLog {
+	return &SlowLog{
+		cfg:       cfg,
+		jwtHelper: jwtHelper,
+		routes:    routes,
+		backends:  backends,
+		logger:    log,
+	}
+}
+
+// Log times requests; a zero threshold disables it
+func (s *SlowLog) Log(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.Threshold <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		elapsed := time.Since(start)
+		if elapsed < s.cfg.Threshold {
+			return
+		}
+
+		fields := []zap.Field{
+			zap.String("method", r.Method),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", rec.Status),
+			zap.Duration("duration", elapsed),
+			zap.Duration("threshold", s.cfg.Threshold),
+			// Set on the response by the request ID middleware further down the chain
+			zap.String("request_id", rec.Header().Get(pkgMiddleware.RequestIDHeader)),
+		}
+
+		route := "unmatched"
+		if matched, ok := s.routes.MatchRequest(r); ok {
+			route = matched.Method
+			service := serviceName(matched.Method)
+			fields = append(fields, zap.String("route", matched.Method), zap.String("pattern", matched.Pattern), zap.String("backend", s.backends[service]))
+		}
+		if claims, err := s.jwtHelper.RequestClaims(r); err == nil {
+			fields = append(fields, zap.String("merchant_id", claims.MerchantID))
+		}
+
+		metrics.SlowRequests.WithLabelValues(route).Inc()
+		s.logger.Warn("slow request", fields...)
+	})
+}
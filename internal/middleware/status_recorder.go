@@ -0,0 +1,40 @@
+package middleware
+
+import "net/http"
+
+// StatusRecorder records the status code and body size of a response for middleware that acts after the handler
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int64
+	Wrote  bool // whether the header was sent
+}
+
+// NewStatusRecorder wraps w; the status defaults to 200 for handlers that never call WriteHeader
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (r *StatusRecorder) WriteHeader(status int) {
+	r.Status = status
+	r.Wrote = true
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *StatusRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.Bytes += int64(n)
+	r.Wrote = true
+	return n, err
+}
+
+func (r *StatusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *StatusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
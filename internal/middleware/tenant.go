@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// tenantsKey is a Redis hash of subdomain -> merchant ID mappings managed at runtime
+const tenantsKey = "tenant:subdomains"
+
+type tenantKey struct{}
+
+// TenantFromContext returns the merchant resolved from the request host, if any
+func TenantFromContext(ctx context.Context) string {
+	merchantID, _ := ctx.Value(tenantKey{}).(string)
+	return merchantID
+}
+
+// TenantResolver resolves the merchant of white-label deployments from the request host
+// ("{merchant}.omnipos.app"). Subdomains map to merchant IDs through config or the admin API (stored in
+// Redis and refreshed periodically); unmapped subdomains are merchant IDs themselves. The bearer token,
+// when present, must belong to the same merchant. The merchant is forwarded to the backends as x-tenant-id.
+type TenantResolver struct {
+	redisClient *cache.RedisClient
+	jwtHelper   *JWTHelper
+	cfg         config.TenantConfig
+	logger      logger.ZapLogger
+
+	mu      sync.RWMutex
+	dynamic map[string]string
+}
+
+// NewTenantResolver creates the tenant resolver
+func NewTenantResolver(redisClient *cache.RedisClient, jwtHelper *JWTHelper, cfg config.TenantConfig, log logger.ZapLogger) *TenantResolver {
+	for i, domain := range cfg.BaseDomains {
+		cfg.BaseDomains[i] = "." + strings.Trim(strings.ToLower(domain), ".")
+	}
+
+	return &TenantResolver{
+		redisClient: redisClient,
+		jwtHelper:   jwtHelper,
+		cfg:         cfg,
+		logger:      log,
+		dynamic:     make(map[string]string),
+	}
+}
+
+// Run refreshes the runtime subdomain mappings until ctx is cancelled
+func (t *TenantResolver) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := t.refresh(ctx); err != nil {
+			t.logger.Error("failed to refresh tenant subdomains", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Resolve adds the tenant of the request host to the request context; it must run after the session
+// cookie is turned into an Authorization header
+func (t *TenantResolver) Resolve(next http.Handler) http.Handler {
+	if !t.cfg.Enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subdomain, ok := t.subdomain(r)
+		if !ok {
+			// Other hosts (the API domain, health probes on the pod IP) rely on the token alone
+			next.ServeHTTP(w, r)
+			return
+		}
+		merchantID := t.merchant(subdomain)
+
+		// Invalid tokens are left to the auth interceptor
+		if claims, err := t.jwtHelper.RequestClaims(r); err == nil && claims.MerchantID != merchantID {
+			t.logger.Warn("token used on another tenant's host",
+				zap.String("host", r.Host), zap.String("tenant", merchantID), zap.String("merchant_id", claims.MerchantID))
+			customRuntime.WriteResponse(w, http.StatusForbidden, "token does not belong to this tenant", nil)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, merchantID)))
+	})
+}
+
+// subdomain returns the tenant label of the request host, e.g. "acme" for "acme.omnipos.app"
+func (t *TenantResolver) subdomain(r *http.Request) (string, bool) {
+	host := r.Host
+	if t.cfg.TrustForwardedHost {
+		if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" {
+			host, _, _ = strings.Cut(forwarded, ",")
+		}
+	}
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	for _, domain := range t.cfg.BaseDomains {
+		label, ok := strings.CutSuffix(host, domain)
+		if !ok || label == "" || strings.Contains(label, ".") {
+			continue
+		}
+		for _, reserved := range t.cfg.ReservedSubdomains {
+			if label == reserved {
+				return "", false
+			}
+		}
+		return label, true
+	}
+	return "", false
+}
+
+// merchant maps a subdomain to its merchant ID; runtime mappings take precedence over static ones
+func (t *TenantResolver) merchant(subdomain string) string {
+	t.mu.RLock()
+	merchantID, ok := t.dynamic[subdomain]
+	t.mu.RUnlock()
+	if ok {
+		return merchantID
+	}
+
+	if merchantID, ok := t.cfg.Subdomains[subdomain]; ok {
+		return merchantID
+	}
+	return subdomain
+}
+
+// refresh reloads the runtime subdomain mappings from Redis
+func (t *TenantResolver) refresh(ctx context.Context) error {
+	values, err := t.redisClient.Client.HGetAll(ctx, tenantsKey).Result()
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.dynamic = values
+	t.mu.Unlock()
+	return nil
+}
+
+// RegisterAdminRoutes registers the subdomain mapping management route
+func (t *TenantResolver) RegisterAdminRoutes(mux *http.ServeMux, adminAuth func(http.Handler) http.Handler) {
+	mux.Handle("/admin/tenants", adminAuth(http.HandlerFunc(t.serveTenants)))
+}
+
+// serveTenants lists (GET), maps (POST {"subdomain", "merchant_id"}) and unmaps (DELETE ?subdomain=) subdomains.
+// Static mappings from config are listed but can only be changed through config.
+func (t *TenantResolver) serveTenants(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		t.mu.RLock()
+		dynamic := make(map[string]string, len(t.dynamic))
+		for subdomain, merchantID := range t.dynamic {
+			dynamic[subdomain] = merchantID
+		}
+		t.mu.RUnlock()
+
+		customRuntime.WriteResponse(w, http.StatusOK, "success", map[string]interface{}{
+			"runtime": dynamic,
+			"static":  t.cfg.Subdomains,
+		})
+
+	case http.MethodPost:
+		var req struct {
+			Subdomain  string `json:"subdomain"`
+			MerchantID string `json:"merchant_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid request body", nil)
+			return
+		}
+
+		subdomain := strings.ToLower(req.Subdomain)
+		if subdomain == "" || strings.Contains(subdomain, ".") || req.MerchantID == "" {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "subdomain and merchant_id are required", nil)
+			return
+		}
+
+		if err := t.redisClient.Client.HSet(ctx, tenantsKey, subdomain, req.MerchantID).Err(); err != nil {
+			t.logger.Error("failed to map tenant subdomain", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to map tenant subdomain", nil)
+			return
+		}
+
+		t.logger.Info("tenant subdomain mapped", zap.String("subdomain", subdomain), zap.String("merchant_id", req.MerchantID))
+		t.refreshAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+
+	case http.MethodDelete:
+		subdomain := strings.ToLower(r.URL.Query().Get("subdomain"))
+		if err := t.redisClient.Client.HDel(ctx, tenantsKey, subdomain).Err(); err != nil {
+			t.logger.Error("failed to unmap tenant subdomain", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to unmap tenant subdomain", nil)
+			return
+		}
+
+		t.logger.Info("tenant subdomain unmapped", zap.String("subdomain", subdomain))
+		t.refreshAfterChange(ctx)
+		customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+// refreshAfterChange applies a change on this instance immediately; others pick it up on their next refresh
+func (t *TenantResolver) refreshAfterChange(ctx context.Context) {
+	if err := t.refresh(ctx); err != nil {
+		t.logger.Error("failed to refresh tenant subdomains", zap.Error(err))
+	}
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"google.golang.org/grpc"
+)
+
+// RequestTimeoutHeader lets HTTP clients ask for a shorter (or, up to the cap, longer) deadline than
+// the configured one, as a duration ("2500ms") or seconds ("5")
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+// Timeouts bounds how long backend calls may take, so a slow query can't hold a gateway worker for the
+// full server write timeout. Calls get the timeout of their method, else of their service, else the
+// default; deadlines requested by clients (grpc-timeout, X-Request-Timeout) replace it but are capped.
+type Timeouts struct {
+	cfg    config.TimeoutConfig
+	logger logger.ZapLogger
+}
+
+// NewTimeouts creates the backend call timeouts
+func NewTimeouts(cfg config.TimeoutConfig, log logger.ZapLogger) *Timeouts {
+	return &Timeouts{
+		cfg:    cfg,
+		logger: log,
+	}
+}
+
+// Deadline applies the X-Request-Timeout header to the request context; grpc-timeout is applied by the gateway mux
+func (t *Timeouts) Deadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value := r.Header.Get(RequestTimeoutHeader)
+		if value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		timeout, ok := parseRequestTimeout(value)
+		if !ok {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid "+RequestTimeoutHeader+" header", nil)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), t.capped(timeout))
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Unary returns a unary client interceptor applying the call timeout
+func (t *Timeouts) Unary() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx, cancel := t.withTimeout(ctx, method, true)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// Stream returns a stream client interceptor applying the call timeout. Streams are long-lived, so
+// only method timeouts and the cap apply to them.
+func (t *Timeouts) Stream() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx, cancel := t.withTimeout(ctx, method, false)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		// Streams outlive this call; the timer is released once their context is done
+		context.AfterFunc(ctx, cancel)
+		return stream, nil
+	}
+}
+
+// withTimeout caps a deadline set by the client, or applies the configured timeout of method
+func (t *Timeouts) withTimeout(ctx context.Context, method string, unary bool) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if t.cfg.Max > 0 && time.Until(deadline) > t.cfg.Max {
+			return context.WithTimeout(ctx, t.cfg.Max)
+		}
+		return ctx, func() {}
+	}
+
+	timeout := t.timeout(method, unary)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timeout returns the configured timeout of method, e.g. "/report.v1.ReportService/GetSalesReport"
+func (t *Timeouts) timeout(method string, unary bool) time.Duration {
+	if timeout, ok := t.cfg.Methods[method]; ok {
+		return timeout
+	}
+	if !unary {
+		return 0
+	}
+
+	if timeout, ok := t.cfg.Services[serviceName(method)]; ok {
+		return timeout
+	}
+	return t.cfg.Default
+}
+
+func (t *Timeouts) capped(timeout time.Duration) time.Duration {
+	if t.cfg.Max > 0 {
+		return min(timeout, t.cfg.Max)
+	}
+	return timeout
+}
+
+// parseRequestTimeout parses a duration ("2500ms") or a number of seconds ("5")
+func parseRequestTimeout(value string) (time.Duration, bool) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), seconds > 0
+	}
+	timeout, err := time.ParseDuration(value)
+	return timeout, err == nil && timeout > 0
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+)
+
+// TraceparentHeader carries the W3C trace context of a request
+const TraceparentHeader = "traceparent"
+
+// TraceSampler decides which requests are traced, at the rate of their route, so high-volume reads
+// don't blow the tracing budget while critical flows stay fully traced. Requests coming with a
+// traceparent keep the caller's decision; the others get a new trace, passed on to the backends.
+type TraceSampler struct {
+	cfg    config.TracingConfig
+	routes *RouteTable
+}
+
+// NewTraceSampler creates the trace sampler, validating the sample rates
+func NewTraceSampler(routes *RouteTable, cfg config.TracingConfig) (*TraceSampler, error) {
+	if cfg.SampleRate < 0 || cfg.SampleRate > 1 {
+		return nil, fmt.Errorf("invalid trace sample rate %v: must be between 0 and 1", cfg.SampleRate)
+	}
+	for method, rate := range cfg.RouteSampleRates {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid trace sample rate %v of %s: must be between 0 and 1", rate, method)
+		}
+	}
+	return &TraceSampler{cfg: cfg, routes: routes}, nil
+}
+
+// Sample starts the trace of requests without a valid traceparent header
+func (s *TraceSampler) Sample(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.cfg.Enabled || metrics.ParseTraceparent(r.Header.Get(TraceparentHeader)) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route, rate := "unmatched", s.cfg.SampleRate
+		if matched, ok := s.routes.MatchRequest(r); ok {
+			route = matched.Method
+			if routeRate, ok := s.cfg.RouteSampleRates[matched.Method]; ok {
+				rate = routeRate
+			}
+		}
+
+		sampled := rate > 0 && rand.Float64() < rate
+		flags := "00"
+		if sampled {
+			flags = "01"
+		}
+		metrics.TraceSamplingDecisions.WithLabelValues(route, fmt.Sprint(sampled)).Inc()
+
+		// Unsampled traces are passed on too, so the backends don't sample the request on their own
+		traceparent := fmt.Sprintf("00-%016x%016x-%016x-%s", rand.Uint64(), rand.Uint64(), rand.Uint64(), flags)
+		r.Header.Set(TraceparentHeader, traceparent)
+		r.Header.Del("tracestate")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,178 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-gateway/internal/security"
+	"github.com/fekuna/omnipos-pkg/logger"
+	pkgMiddleware "github.com/fekuna/omnipos-pkg/middleware"
+	"go.uber.org/zap"
+)
+
+// MerchantIDHeader carries the authenticated merchant to HTTP upstreams, like x-merchant-id for gRPC backends
+const MerchantIDHeader = "X-Merchant-Id"
+
+type upstream struct {
+	prefix string
+	target *url.URL
+	public bool
+	// credentials forwards the client's Authorization header and gateway cookies
+	credentials bool
+	proxy       *httputil.ReverseProxy
+}
+
+// Upstreams proxies path prefixes to plain HTTP services (legacy reporting, image CDN origin) so they sit
+// behind the same hostname and middleware chain as the gRPC services. Requests to non-public upstreams
+// need a valid bearer token, same as non-public gRPC methods. The client's credentials stay at the
+// gateway unless an upstream opts in to them.
+type Upstreams struct {
+	jwtHelper *JWTHelper
+	cfg       config.UpstreamConfig
+	cookies   map[string]bool // gateway session and CSRF cookie names
+	upstreams []*upstream
+	logger    logger.ZapLogger
+}
+
+// NewUpstreams creates the upstream proxies, failing on invalid upstream URLs. cookies are the names of
+// the gateway's own cookies, stripped from requests like the Authorization header.
+func NewUpstreams(jwtHelper *JWTHelper, cfg config.UpstreamConfig, cookies []string, log logger.ZapLogger) (*Upstreams, error) {
+	public := make(map[string]bool, len(cfg.PublicPrefixes))
+	for _, prefix := range cfg.PublicPrefixes {
+		public[strings.TrimRight(prefix, "/")] = true
+	}
+	credentials := make(map[string]bool, len(cfg.ForwardCredentials))
+	for _, prefix := range cfg.ForwardCredentials {
+		credentials["/"+strings.Trim(prefix, "/")] = true
+	}
+
+	u := &Upstreams{
+		jwtHelper: jwtHelper,
+		cfg:       cfg,
+		cookies:   make(map[string]bool, len(cookies)),
+		logger:    log,
+	}
+	for _, name := range cookies {
+		if name != "" {
+			u.cookies[name] = true
+		}
+	}
+
+	for prefix, rawURL := range cfg.Routes {
+		prefix = "/" + strings.Trim(prefix, "/")
+		target, err := url.Parse(rawURL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return nil, fmt.Errorf("invalid upstream %q for %s: must be an http(s) URL", rawURL, prefix)
+		}
+
+		up := &upstream{
+			prefix:      prefix,
+			target:      target,
+			public:      public[prefix],
+			credentials: credentials[prefix],
+		}
+		up.proxy = &httputil.ReverseProxy{
+			Rewrite:      u.rewrite(up),
+			Transport:    u.transport(),
+			ErrorHandler: u.proxyError(up),
+		}
+		u.upstreams = append(u.upstreams, up)
+	}
+
+	return u, nil
+}
+
+// RegisterRoutes registers a route per upstream prefix
+func (u *Upstreams) RegisterRoutes(mux *http.ServeMux) {
+	for _, up := range u.upstreams {
+		handler := u.handler(up)
+		mux.Handle(up.prefix, handler)
+		mux.Handle(up.prefix+"/", handler)
+		u.logger.Info("http upstream registered", zap.String("prefix", up.prefix), zap.String("target", up.target.String()), zap.Bool("public", up.public))
+	}
+}
+
+func (u *Upstreams) handler(up *upstream) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Never trust a merchant ID sent by the client
+		r.Header.Del(MerchantIDHeader)
+
+		if !up.public {
+			token := BearerToken(r)
+			if token == "" {
+				RecordSecurityEvent(r, security.AuthFailure, "upstream", "missing authorization header", "")
+				customRuntime.WriteResponse(w, http.StatusUnauthorized, "missing authorization header", nil)
+				return
+			}
+
+			claims, err := u.jwtHelper.Claims(r.Context(), token)
+			if err != nil {
+				message := "invalid token"
+				if err == ErrExpiredToken {
+					message = "token has expired"
+				}
+				RecordSecurityEvent(r, security.AuthFailure, "upstream", message, "")
+				customRuntime.WriteResponse(w, http.StatusUnauthorized, message, nil)
+				return
+			}
+			r.Header.Set(MerchantIDHeader, claims.MerchantID)
+		}
+
+		up.proxy.ServeHTTP(w, r)
+	})
+}
+
+func (u *Upstreams) rewrite(up *upstream) func(*httputil.ProxyRequest) {
+	return func(pr *httputil.ProxyRequest) {
+		if u.cfg.StripPrefix {
+			path := strings.TrimPrefix(pr.In.URL.Path, up.prefix)
+			if !strings.HasPrefix(path, "/") {
+				path = "/" + path
+			}
+			pr.Out.URL.Path = path
+			pr.Out.URL.RawPath = ""
+		}
+
+		pr.SetURL(up.target)
+		pr.SetXForwarded()
+		pr.Out.Header.Set("X-Forwarded-Prefix", up.prefix)
+		if !up.credentials {
+			u.stripCredentials(pr.Out)
+		}
+		if reqID := pkgMiddleware.GetRequestID(pr.In.Context()); reqID != "" {
+			pr.Out.Header.Set(pkgMiddleware.RequestIDHeader, reqID)
+		}
+	}
+}
+
+// stripCredentials removes the client's Authorization header and the gateway's cookies, keeping any
+// other cookie the upstream set itself
+func (u *Upstreams) stripCredentials(r *http.Request) {
+	r.Header.Del("Authorization")
+
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if !u.cookies[cookie.Name] {
+			r.AddCookie(cookie)
+		}
+	}
+}
+
+func (u *Upstreams) transport() http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = u.cfg.Timeout
+	return transport
+}
+
+func (u *Upstreams) proxyError(up *upstream) func(http.ResponseWriter, *http.Request, error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		u.logger.Error("http upstream error", zap.String("prefix", up.prefix), zap.String("path", r.URL.Path), zap.Error(err))
+		customRuntime.WriteResponse(w, http.StatusBadGateway, "upstream service unavailable", nil)
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+)
+
+func TestUpstreams_StripCredentials(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	}))
+	defer backend.Close()
+
+	cfg := config.UpstreamConfig{
+		Routes:             map[string]string{"/cdn": backend.URL, "/reports": backend.URL},
+		PublicPrefixes:     []string{"/cdn", "/reports"},
+		StripPrefix:        true,
+		Timeout:            time.Second,
+		ForwardCredentials: []string{"/reports/"},
+	}
+	upstreams, err := NewUpstreams(nil, cfg, []string{"omnipos_session", "omnipos_csrf"}, logger.NewZapLogger(&logger.ZapLoggerConfig{}))
+	if err != nil {
+		t.Fatalf("NewUpstreams: %v", err)
+	}
+	mux := http.NewServeMux()
+	upstreams.RegisterRoutes(mux)
+
+	send := func(path string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.AddCookie(&http.Cookie{Name: "omnipos_session", Value: "token"})
+		req.AddCookie(&http.Cookie{Name: "omnipos_csrf", Value: "csrf"})
+		req.AddCookie(&http.Cookie{Name: "cdn_pref", Value: "webp"})
+		mux.ServeHTTP(httptest.NewRecorder(), req)
+		select {
+		case r := <-received:
+			return r
+		default:
+			t.Fatalf("expected %s to reach the upstream", path)
+			return nil
+		}
+	}
+
+	r := send("/cdn/logo.png")
+	if r.Header.Get("Authorization") != "" {
+		t.Errorf("expected the Authorization header to be stripped, got %q", r.Header.Get("Authorization"))
+	}
+	if _, err := r.Cookie("omnipos_session"); err == nil {
+		t.Error("expected the session cookie to be stripped")
+	}
+	if _, err := r.Cookie("omnipos_csrf"); err == nil {
+		t.Error("expected the CSRF cookie to be stripped")
+	}
+	if c, err := r.Cookie("cdn_pref"); err != nil || c.Value != "webp" {
+		t.Errorf("expected the upstream's own cookie to be kept, got %v", c)
+	}
+
+	r = send("/reports/daily")
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("expected an opted-in upstream to get the Authorization header, got %q", r.Header.Get("Authorization"))
+	}
+	if _, err := r.Cookie("omnipos_session"); err != nil {
+		t.Error("expected an opted-in upstream to get the session cookie")
+	}
+}
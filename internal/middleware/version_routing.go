@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// protoAPIVersion is the URL version the proto HTTP rules are bound to
+const protoAPIVersion = "v1"
+
+var versionPrefixPattern = regexp.MustCompile(`^/v[0-9]+/`)
+
+type versionRoute struct {
+	prefix  string // e.g. "/v2/orders"
+	rewrite string // e.g. "/v1/orders"
+	addr    string
+}
+
+// VersionRouting serves other URL versions of a service (e.g. "/v2/orders/*") from another service
+// generation during migrations. The path is rewritten to the version the proto HTTP rules are bound to,
+// so the same grpc-gateway routes apply, and the backend calls are pinned to the configured address,
+// which the BackendRouter dials with the TLS settings and credentials of the service's own address.
+type VersionRouting struct {
+	routes []versionRoute
+	logger logger.ZapLogger
+}
+
+// NewVersionRouting creates the version routing middleware from cfg.VersionRoutes
+func NewVersionRouting(cfg config.GRPCServicesConfig, log logger.ZapLogger) *VersionRouting {
+	routes := make([]versionRoute, 0, len(cfg.VersionRoutes))
+	for prefix, addr := range cfg.VersionRoutes {
+		prefix = "/" + strings.Trim(prefix, "/")
+		if !versionPrefixPattern.MatchString(prefix+"/") || addr == "" {
+			log.Warn("ignoring invalid version route", zap.String("prefix", prefix), zap.String("addr", addr))
+			continue
+		}
+
+		_, rest, _ := strings.Cut(strings.TrimPrefix(prefix, "/"), "/")
+		routes = append(routes, versionRoute{
+			prefix:  prefix,
+			rewrite: "/" + protoAPIVersion + "/" + rest,
+			addr:    addr,
+		})
+		log.Info("version route", zap.String("prefix", prefix), zap.String("addr", addr))
+	}
+
+	// Longest prefix first so "/v2/orders/refunds" wins over "/v2/orders"
+	sort.Slice(routes, func(i, j int) bool { return len(routes[i].prefix) > len(routes[j].prefix) })
+
+	return &VersionRouting{
+		routes: routes,
+		logger: log,
+	}
+}
+
+// Route rewrites versioned paths and pins their backend calls; it must run before the rate limiters,
+// which match requests against the proto routes
+func (v *VersionRouting) Route(next http.Handler) http.Handler {
+	if len(v.routes) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, route := range v.routes {
+			rest, ok := matchPrefix(r.URL.Path, route.prefix)
+			if !ok {
+				continue
+			}
+
+			u := *r.URL
+			u.Path = route.rewrite + rest
+			if u.RawPath != "" {
+				if rawRest, ok := matchPrefix(u.RawPath, route.prefix); ok {
+					u.RawPath = route.rewrite + rawRest
+				} else {
+					u.RawPath = ""
+				}
+			}
+
+			r = r.WithContext(WithBackend(r.Context(), route.addr))
+			r.URL = &u
+			break
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// matchPrefix reports whether path is prefix or lies below it, returning the remainder
+func matchPrefix(path, prefix string) (string, bool) {
+	if !strings.HasPrefix(path, prefix) {
+		return "", false
+	}
+
+	rest := path[len(prefix):]
+	if rest != "" && rest[0] != '/' && rest[0] != ':' {
+		return "", false
+	}
+	return rest, true
+}
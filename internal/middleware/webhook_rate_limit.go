@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-gateway/internal/security"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// leakyBucketScript adds one request to a bucket that drains at a constant rate.
+// Returns {allowed, level, retry_after_ms}.
+var leakyBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "level", "updated")
+local level = tonumber(state[1]) or 0
+local updated = tonumber(state[2]) or now
+
+level = math.max(0, level - (now - updated) * rate / 1000)
+
+local allowed = 0
+local retry_after = 0
+if level + 1 <= capacity then
+	level = level + 1
+	allowed = 1
+else
+	retry_after = math.ceil((level + 1 - capacity) * 1000 / rate)
+end
+
+redis.call("HSET", KEYS[1], "level", tostring(level), "updated", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity * 1000 / rate) + 1000)
+return {allowed, math.floor(level), retry_after}
+`)
+
+// WebhookRateLimiter limits inbound payment-provider webhooks with a leaky bucket per provider and
+// signing identity. Webhook requests are kept out of the user rate limits, so a webhook storm can't
+// starve interactive traffic and vice versa.
+type WebhookRateLimiter struct {
+	redisClient *cache.RedisClient
+	cfg         config.WebhookRateLimitConfig
+	logger      logger.ZapLogger
+}
+
+// NewWebhookRateLimiter creates a new webhook rate limiter
+func NewWebhookRateLimiter(redisClient *cache.RedisClient, cfg config.WebhookRateLimitConfig, log logger.ZapLogger) *WebhookRateLimiter {
+	return &WebhookRateLimiter{
+		redisClient: redisClient,
+		cfg:         cfg,
+		logger:      log,
+	}
+}
+
+// Limit throttles webhook requests and marks them exempt from the user limiters; it must run before them
+func (l *WebhookRateLimiter) Limit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.cfg.Enabled || isRateLimitExempt(r) || !strings.HasPrefix(r.URL.Path, l.cfg.PathPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := fmt.Sprintf("rate_limit:webhook:%s:%s", webhookProvider(r, l.cfg.PathPrefix), l.identity(r))
+		allowed, retryAfter, err := l.allow(r.Context(), key)
+		if err != nil {
+			// Fail open: dropping provider callbacks is worse than letting a burst through
+			l.logger.Error("webhook rate limit error", zap.Error(err))
+		} else if !allowed {
+			RecordSecurityEvent(r, security.RateLimited, "webhook_rate_limit", "webhook rate limit exceeded", l.identity(r))
+			seconds := int64(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", seconds))
+			customRuntime.WriteResponse(w, http.StatusTooManyRequests, "too many webhook requests", map[string]interface{}{
+				"retry_after":    seconds,
+				"retry_after_ms": retryAfter.Milliseconds(),
+			})
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), exemptContextKey{}, true))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *WebhookRateLimiter) allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	values, err := leakyBucketScript.Run(ctx, l.redisClient.Client, []string{key}, l.cfg.RPS, l.cfg.Capacity, time.Now().UnixMilli()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return values[0] == 1, time.Duration(values[2]) * time.Millisecond, nil
+}
+
+// identity returns a hash of the first signature header present, so each signing key (i.e. each
+// provider account) gets its own bucket. Unsigned requests are keyed on the client IP.
+func (l *WebhookRateLimiter) identity(r *http.Request) string {
+	for _, header := range l.cfg.IdentityHeaders {
+		if value := r.Header.Get(header); value != "" {
+			sum := sha256.Sum256([]byte(value))
+			return "sig:" + hex.EncodeToString(sum[:8])
+		}
+	}
+	return "ip:" + ClientIP(r)
+}
+
+// webhookProvider returns the first path segment after prefix, e.g. "xendit" for /webhooks/xendit
+func webhookProvider(r *http.Request, prefix string) string {
+	provider, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	if provider == "" {
+		return "unknown"
+	}
+	return provider
+}
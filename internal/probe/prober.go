@@ -0,0 +1,127 @@
+// Package probe exercises read endpoints end-to-end through the gateway on a schedule, so breakage
+// between deploys shows up in metrics before customers report it
+package probe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// ProbeHeader marks synthetic requests, so they can be told apart in logs and access logs
+const ProbeHeader = "X-Synthetic-Probe"
+
+// Prober sends a GET to every configured endpoint each interval and reports whether it answered 2xx
+// and how long it took
+type Prober struct {
+	cfg    config.ProbeConfig
+	client *http.Client
+	logger logger.ZapLogger
+
+	mu      sync.Mutex
+	failing map[string]bool // by probe name
+}
+
+// NewProber creates a prober
+func NewProber(cfg config.ProbeConfig, log logger.ZapLogger) *Prober {
+	return &Prober{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		logger:  log,
+		failing: make(map[string]bool),
+	}
+}
+
+// Run probes the endpoints every interval until ctx is cancelled; the first probes run after one
+// interval, once the gateway is listening
+func (p *Prober) Run(ctx context.Context) {
+	if !p.cfg.Enabled || len(p.cfg.Endpoints) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var wg sync.WaitGroup
+		for name, path := range p.cfg.Endpoints {
+			wg.Add(1)
+			go func(name, path string) {
+				defer wg.Done()
+				p.probe(ctx, name, path)
+			}(name, path)
+		}
+		wg.Wait()
+	}
+}
+
+func (p *Prober) probe(ctx context.Context, name, path string) {
+	start := time.Now()
+	err := p.get(ctx, path)
+	elapsed := time.Since(start)
+	if ctx.Err() != nil {
+		return
+	}
+
+	metrics.ProbeDuration.WithLabelValues(name).Observe(elapsed.Seconds())
+
+	p.mu.Lock()
+	wasFailing := p.failing[name]
+	p.failing[name] = err != nil
+	p.mu.Unlock()
+
+	if err != nil {
+		metrics.ProbeRequests.WithLabelValues(name, "failure").Inc()
+		metrics.ProbeUp.WithLabelValues(name).Set(0)
+		if !wasFailing {
+			p.logger.Warn("synthetic probe failing", zap.String("probe", name), zap.String("path", path), zap.Duration("duration", elapsed), zap.Error(err))
+		}
+		return
+	}
+
+	metrics.ProbeRequests.WithLabelValues(name, "success").Inc()
+	metrics.ProbeUp.WithLabelValues(name).Set(1)
+	if wasFailing {
+		p.logger.Info("synthetic probe recovered", zap.String("probe", name), zap.String("path", path))
+	}
+}
+
+func (p *Prober) get(ctx context.Context, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.cfg.BaseURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	for key, value := range p.cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set(ProbeHeader, "1")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Read the whole body so streaming or truncated responses count against the latency and fail
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+// Package receipt issues short-lived signed receipt links (/r/{token}) that customers can open
+// without an account, e.g. from an SMS or WhatsApp message
+package receipt
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/grpcproxy"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-gateway/internal/security"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/status"
+)
+
+const linkPrefix = "/r/"
+
+var (
+	errInvalidToken = errors.New("invalid receipt link")
+	errExpiredToken = errors.New("receipt link has expired")
+)
+
+// claims are the signed contents of a receipt link
+type claims struct {
+	MerchantID string `json:"m"`
+	OrderID    string `json:"o"`
+	ExpiresAt  int64  `json:"e"`
+}
+
+// Link is an issued receipt link
+type Link struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Handler issues receipt links to authenticated merchants and resolves them to the order (and payment)
+// of the receipt on behalf of the merchant that issued them
+type Handler struct {
+	proxy     *grpcproxy.Proxy
+	jwtHelper *middleware.JWTHelper
+	cfg       config.ReceiptConfig
+	logger    logger.ZapLogger
+}
+
+// NewHandler creates a new receipt link handler
+func NewHandler(proxy *grpcproxy.Proxy, jwtHelper *middleware.JWTHelper, cfg config.ReceiptConfig, log logger.ZapLogger) *Handler {
+	return &Handler{
+		proxy:     proxy,
+		jwtHelper: jwtHelper,
+		cfg:       cfg,
+		logger:    log,
+	}
+}
+
+// RegisterRoutes registers the link issuing route and the public link route
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc(h.cfg.IssuePath, h.serveIssue)
+	mux.HandleFunc(linkPrefix, h.serveLink)
+}
+
+// serveIssue issues a link to a receipt of the authenticated merchant: POST {"order_id", "ttl"}
+func (h *Handler) serveIssue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	token := middleware.BearerToken(r)
+	if token == "" {
+		middleware.RecordSecurityEvent(r, security.AuthFailure, "receipt", "missing or invalid authorization header", "")
+		customRuntime.WriteResponse(w, http.StatusUnauthorized, "missing or invalid authorization header", nil)
+		return
+	}
+	jwtClaims, err := h.jwtHelper.Claims(r.Context(), token)
+	if err != nil {
+		middleware.RecordSecurityEvent(r, security.AuthFailure, "receipt", "missing or invalid authorization header", "")
+		customRuntime.WriteResponse(w, http.StatusUnauthorized, "missing or invalid authorization header", nil)
+		return
+	}
+	merchantID := jwtClaims.MerchantID
+
+	var req struct {
+		OrderID string `json:"order_id"`
+		TTL     string `json:"ttl"` // optional, e.g. "72h"
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.OrderID == "" {
+		customRuntime.WriteResponse(w, http.StatusBadRequest, "order_id is required", nil)
+		return
+	}
+
+	ttl := h.cfg.TTL
+	if req.TTL != "" {
+		ttl, err = time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 || ttl > h.cfg.MaxTTL {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid ttl", nil)
+			return
+		}
+	}
+
+	// Only issue links to orders the merchant can read
+	ctx := middleware.WithMerchant(h.proxy.OutgoingContext(r.Context(), r), merchantID)
+	if _, err := h.call(ctx, h.cfg.OrderMethod, h.cfg.OrderIDField, req.OrderID); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	c := claims{
+		MerchantID: merchantID,
+		OrderID:    req.OrderID,
+		ExpiresAt:  time.Now().Add(ttl).Unix(),
+	}
+	signed := h.sign(c)
+
+	customRuntime.WriteResponse(w, http.StatusOK, "success", Link{
+		URL:       strings.TrimRight(h.cfg.BaseURL, "/") + linkPrefix + signed,
+		Token:     signed,
+		ExpiresAt: time.Unix(c.ExpiresAt, 0).UTC(),
+	})
+}
+
+// serveLink resolves a receipt link. Browsers are redirected to the receipt page, which fetches the
+// receipt from the same link as JSON.
+func (h *Handler) serveLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	token := strings.TrimPrefix(r.URL.Path, linkPrefix)
+	c, err := h.verify(token)
+	if err != nil {
+		customRuntime.WriteResponse(w, http.StatusNotFound, err.Error(), nil)
+		return
+	}
+
+	if h.cfg.PageURL != "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		http.Redirect(w, r, h.cfg.PageURL+"?token="+url.QueryEscape(token), http.StatusFound)
+		return
+	}
+
+	ctx := middleware.WithMerchant(h.proxy.OutgoingContext(r.Context(), r), c.MerchantID)
+	order, err := h.call(ctx, h.cfg.OrderMethod, h.cfg.OrderIDField, c.OrderID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	receipt := map[string]interface{}{
+		"order":      order,
+		"expires_at": time.Unix(c.ExpiresAt, 0).UTC(),
+	}
+	if h.cfg.PaymentMethod != "" {
+		// The receipt is still useful without its payment details
+		if payment, err := h.call(ctx, h.cfg.PaymentMethod, h.cfg.PaymentOrderIDField, c.OrderID); err != nil {
+			h.logger.Warn("failed to resolve receipt payment", zap.String("order_id", c.OrderID), zap.Error(err))
+		} else {
+			receipt["payment"] = payment
+		}
+	}
+
+	w.Header().Set("Cache-Control", "private, no-store")
+	customRuntime.WriteResponse(w, http.StatusOK, "success", receipt)
+}
+
+func (h *Handler) call(ctx context.Context, method, field, orderID string) (json.RawMessage, error) {
+	body, err := json.Marshal(map[string]string{field: orderID})
+	if err != nil {
+		return nil, err
+	}
+	return h.proxy.InvokeJSON(ctx, method, body)
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	code := runtime.HTTPStatusFromCode(st.Code())
+	if code >= http.StatusInternalServerError {
+		h.logger.Error("receipt backend call failed", zap.Error(err))
+	}
+	customRuntime.WriteResponse(w, code, st.Message(), nil)
+}
+
+// sign encodes claims as "<base64url payload>.<base64url HMAC-SHA256>"
+func (h *Handler) sign(c claims) string {
+	payload, _ := json.Marshal(c)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(h.mac(encoded))
+}
+
+func (h *Handler) verify(token string) (*claims, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errInvalidToken
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, h.mac(encoded)) {
+		return nil, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil || c.MerchantID == "" || c.OrderID == "" {
+		return nil, errInvalidToken
+	}
+	if time.Now().Unix() > c.ExpiresAt {
+		return nil, errExpiredToken
+	}
+	return &c, nil
+}
+
+func (h *Handler) mac(payload string) []byte {
+	m := hmac.New(sha256.New, []byte(h.cfg.SigningKey))
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
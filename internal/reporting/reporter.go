@@ -0,0 +1,165 @@
+// Package reporting captures panics, 5xx responses and backend INTERNAL errors to an error tracker
+// (Sentry), with the request context and merchant tags
+package reporting
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	"github.com/fekuna/omnipos-pkg/logger"
+	pkgMiddleware "github.com/fekuna/omnipos-pkg/middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Event is an error reported to the error tracker
+type Event struct {
+	Level      string // "error" or "fatal" (panics)
+	Message    string
+	Stacktrace string // of panics
+	Method     string // HTTP method, or gRPC method of backend errors
+	Path       string
+	RequestID  string
+	Tags       map[string]string // e.g. merchant_id, status, grpc_code
+	Time       time.Time
+}
+
+// Reporter sends events to an error tracker
+type Reporter interface {
+	// Report queues event
+	Report(event Event)
+	// Run sends the queued events until ctx is cancelled
+	Run(ctx context.Context)
+}
+
+// NewReporter returns the Sentry reporter of cfg.DSN, or a reporter dropping events when there's none
+func NewReporter(cfg config.ErrorReportingConfig, log logger.ZapLogger) (Reporter, error) {
+	if cfg.DSN == "" {
+		return nopReporter{}, nil
+	}
+	return newSentryReporter(cfg, log)
+}
+
+type nopReporter struct{}
+
+func (nopReporter) Report(Event) {}
+
+func (nopReporter) Run(context.Context) {}
+
+type reportedKey struct{}
+
+// Capture reports panics and 5xx responses of the requests it wraps, and the INTERNAL errors of backend
+// calls. Panics are re-raised after being reported, so they're still handled by the server.
+type Capture struct {
+	reporter  Reporter
+	jwtHelper *middleware.JWTHelper
+}
+
+// NewCapture creates the capturing middleware and interceptors
+func NewCapture(reporter Reporter, jwtHelper *middleware.JWTHelper) *Capture {
+	return &Capture{reporter: reporter, jwtHelper: jwtHelper}
+}
+
+// Handle reports the panics and 5xx responses of requests
+func (c *Capture) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reported := new(atomic.Bool)
+		r = r.WithContext(context.WithValue(r.Context(), reportedKey{}, reported))
+		rec := middleware.NewStatusRecorder(w)
+
+		defer func() {
+			if v := recover(); v != nil {
+				if v != http.ErrAbortHandler {
+					event := c.requestEvent(r, rec, fmt.Sprintf("panic: %v", v))
+					event.Level = "fatal"
+					event.Stacktrace = string(debug.Stack())
+					c.reporter.Report(event)
+				}
+				panic(v)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+
+		// Backend errors behind the response were reported with their gRPC details already
+		if rec.Status >= 500 && !reported.Load() {
+			event := c.requestEvent(r, rec, fmt.Sprintf("%d %s", rec.Status, http.StatusText(rec.Status)))
+			event.Tags["status"] = strconv.Itoa(rec.Status)
+			c.reporter.Report(event)
+		}
+	})
+}
+
+// requestEvent describes the request r; Handle is the outermost middleware, so the request ID is
+// read from the response
+func (c *Capture) requestEvent(r *http.Request, w http.ResponseWriter, message string) Event {
+	event := Event{
+		Level:     "error",
+		Message:   message,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		RequestID: w.Header().Get(pkgMiddleware.RequestIDHeader),
+		Tags:      make(map[string]string),
+		Time:      time.Now().UTC(),
+	}
+	if claims, err := c.jwtHelper.RequestClaims(r); err == nil {
+		event.Tags["merchant_id"] = claims.MerchantID
+	}
+	return event
+}
+
+// Unary returns a unary client interceptor reporting backend INTERNAL errors; it goes after the auth
+// interceptor so the merchant is known
+func (c *Capture) Unary() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		c.reportBackendError(ctx, method, cc, err)
+		return err
+	}
+}
+
+// Stream returns a stream client interceptor reporting INTERNAL errors opening backend streams
+func (c *Capture) Stream() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		c.reportBackendError(ctx, method, cc, err)
+		return stream, err
+	}
+}
+
+func (c *Capture) reportBackendError(ctx context.Context, method string, cc *grpc.ClientConn, err error) {
+	st := status.Convert(err)
+	if st.Code() != codes.Internal {
+		return
+	}
+
+	event := Event{
+		Level:     "error",
+		Message:   "backend error: " + st.Message(),
+		Method:    method,
+		RequestID: pkgMiddleware.GetRequestID(ctx),
+		Tags: map[string]string{
+			"grpc_code": st.Code().String(),
+			"backend":   cc.Target(),
+		},
+		Time: time.Now().UTC(),
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if merchantID := md.Get("x-merchant-id"); len(merchantID) > 0 {
+			event.Tags["merchant_id"] = merchantID[0]
+		}
+	}
+	c.reporter.Report(event)
+
+	if reported, ok := ctx.Value(reportedKey{}).(*atomic.Bool); ok {
+		reported.Store(true)
+	}
+}
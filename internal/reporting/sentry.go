@@ -0,0 +1,152 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// sentryReporter sends events to Sentry's envelope endpoint in the background; events are dropped when
+// the queue is full so an error storm doesn't slow requests down
+type sentryReporter struct {
+	cfg        config.ErrorReportingConfig
+	dsn        string
+	endpoint   string
+	auth       string
+	serverName string
+	client     *http.Client
+	queue      chan Event
+	logger     logger.ZapLogger
+}
+
+// newSentryReporter parses the DSN ("https://<public key>@<host>/<project id>")
+func newSentryReporter(cfg config.ErrorReportingConfig, log logger.ZapLogger) (*sentryReporter, error) {
+	u, err := url.Parse(cfg.DSN)
+	if err != nil || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN")
+	}
+	path := strings.Trim(u.Path, "/")
+	slash := strings.LastIndex(path, "/")
+	projectID := path[slash+1:]
+	if projectID == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN: missing project ID")
+	}
+	prefix := ""
+	if slash >= 0 {
+		prefix = "/" + path[:slash]
+	}
+
+	serverName, _ := os.Hostname()
+	return &sentryReporter{
+		cfg:        cfg,
+		dsn:        cfg.DSN,
+		endpoint:   fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, prefix, projectID),
+		auth:       fmt.Sprintf("Sentry sentry_version=7, sentry_client=omnipos-gateway/1.0, sentry_key=%s", u.User.Username()),
+		serverName: serverName,
+		client:     &http.Client{Timeout: cfg.Timeout},
+		queue:      make(chan Event, cfg.QueueSize),
+		logger:     log,
+	}, nil
+}
+
+func (s *sentryReporter) Report(event Event) {
+	if s.cfg.SampleRate < 1 && rand.Float64() >= s.cfg.SampleRate {
+		return
+	}
+	select {
+	case s.queue <- event:
+	default:
+		metrics.ErrorReports.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run sends queued events until ctx is cancelled
+func (s *sentryReporter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.queue:
+			if err := s.send(ctx, event); err != nil {
+				metrics.ErrorReports.WithLabelValues("failed").Inc()
+				s.logger.Warn("failed to report error to Sentry", zap.Error(err))
+				continue
+			}
+			metrics.ErrorReports.WithLabelValues("sent").Inc()
+		}
+	}
+}
+
+func (s *sentryReporter) send(ctx context.Context, event Event) error {
+	id := make([]byte, 16)
+	_, _ = cryptorand.Read(id)
+	eventID := hex.EncodeToString(id)
+
+	payload := map[string]interface{}{
+		"event_id":    eventID,
+		"timestamp":   event.Time.Format(time.RFC3339Nano),
+		"level":       event.Level,
+		"platform":    "go",
+		"logger":      "omnipos-gateway",
+		"server_name": s.serverName,
+		"environment": s.cfg.Environment,
+		"message":     map[string]string{"formatted": event.Message},
+		"tags":        event.Tags,
+	}
+	if s.cfg.Release != "" {
+		payload["release"] = s.cfg.Release
+	}
+	if event.Path != "" {
+		payload["request"] = map[string]interface{}{
+			"method":  event.Method,
+			"url":     event.Path,
+			"headers": map[string]string{"X-Request-Id": event.RequestID},
+		}
+	} else {
+		payload["transaction"] = event.Method
+	}
+	extra := map[string]string{"request_id": event.RequestID}
+	if event.Stacktrace != "" {
+		extra["stacktrace"] = event.Stacktrace
+	}
+	payload["extra"] = extra
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	_ = enc.Encode(map[string]string{"event_id": eventID, "dsn": s.dsn, "sent_at": time.Now().UTC().Format(time.RFC3339Nano)})
+	_ = enc.Encode(map[string]string{"type": "event"})
+	if err := enc.Encode(payload); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sentry responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// CSVContentType is negotiated with "Accept: text/csv" by merchants downloading reports
+const CSVContentType = "text/csv"
+
+// utf8BOM makes Excel read the file as UTF-8
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// CSVMarshaler writes list responses as CSV: a row per element of the response's repeated message field
+// (e.g. the orders of a ListOrdersResponse), a column per field. Nested messages are flattened into
+// "parent.child" columns; repeated and map fields are written as JSON in their cell. Other responses
+// are a single row, and errors keep the JSON envelope. Server streams aren't exported.
+type CSVMarshaler struct {
+	envelope *CustomMarshaler
+	json     protojson.MarshalOptions
+}
+
+// NewCSVMarshaler creates a CSV marshaler
+func NewCSVMarshaler() *CSVMarshaler {
+	return &CSVMarshaler{
+		envelope: NewCustomMarshaler(),
+		json:     protojson.MarshalOptions{EmitUnpopulated: true, UseProtoNames: true},
+	}
+}
+
+// Marshal writes raw bodies (google.api.HttpBody) verbatim and errors as the JSON envelope
+func (c *CSVMarshaler) Marshal(v interface{}) ([]byte, error) {
+	body, _ := unwrapResponse(v)
+	switch body := body.(type) {
+	case *httpbody.HttpBody:
+		return body.GetData(), nil
+	case *status.Status, map[string]interface{}:
+		return c.envelope.Marshal(v)
+	case proto.Message:
+		return c.marshalRows(body.ProtoReflect())
+	default:
+		return nil, fmt.Errorf("csv: unsupported response %T", body)
+	}
+}
+
+func (c *CSVMarshaler) marshalRows(message protoreflect.Message) ([]byte, error) {
+	rows := []protoreflect.Message{message}
+	descriptor := message.Descriptor()
+	if list := listField(descriptor); list != nil {
+		descriptor = list.Message()
+		values := message.Get(list).List()
+		rows = make([]protoreflect.Message, values.Len())
+		for i := range rows {
+			rows[i] = values.Get(i).Message()
+		}
+	}
+
+	columns := csvColumns(descriptor, "")
+	var buf bytes.Buffer
+	buf.Write(utf8BOM)
+	w := csv.NewWriter(&buf)
+	w.UseCRLF = true // RFC 4180, and what Excel writes
+	if err := w.Write(columns); err != nil {
+		return nil, err
+	}
+
+	for _, row := range rows {
+		data, err := c.json.Marshal(row.Interface())
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		var fields map[string]interface{}
+		if err := dec.Decode(&fields); err != nil {
+			return nil, err
+		}
+
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			record[i] = csvCell(lookup(fields, column))
+		}
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// listField returns the first repeated message field of a response, e.g. "orders"
+func listField(descriptor protoreflect.MessageDescriptor) protoreflect.FieldDescriptor {
+	fields := descriptor.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if field.IsList() && field.Message() != nil {
+			return field
+		}
+	}
+	return nil
+}
+
+// csvColumns returns the column of each field in declaration order, descending into nested messages
+// except well-known types, which have a JSON scalar form (timestamps, wrappers, ...)
+func csvColumns(descriptor protoreflect.MessageDescriptor, prefix string) []string {
+	var columns []string
+	fields := descriptor.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		name := prefix + string(field.Name())
+		if field.Message() != nil && !field.IsList() && !field.IsMap() &&
+			!strings.HasPrefix(string(field.Message().FullName()), "google.protobuf.") {
+			columns = append(columns, csvColumns(field.Message(), name+".")...)
+			continue
+		}
+		columns = append(columns, name)
+	}
+	return columns
+}
+
+// lookup returns the value of a "parent.child" column in the JSON form of a row
+func lookup(fields map[string]interface{}, column string) interface{} {
+	var value interface{} = fields
+	for _, name := range strings.Split(column, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[name]
+	}
+	return value
+}
+
+// csvCell formats a JSON value as a cell. Text starting like a formula is prefixed with a quote so
+// spreadsheets don't evaluate it.
+func csvCell(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+			return "'" + value
+		}
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return fmt.Sprint(value)
+	default:
+		data, _ := json.Marshal(value)
+		return string(data)
+	}
+}
+
+// NewEncoder returns an encoder writing values as Marshal does
+func (c *CSVMarshaler) NewEncoder(w io.Writer) runtime.Encoder {
+	return runtime.EncoderFunc(func(v interface{}) error {
+		data, err := c.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+// NewDecoder rejects CSV request bodies
+func (c *CSVMarshaler) NewDecoder(r io.Reader) runtime.Decoder {
+	return runtime.DecoderFunc(func(interface{}) error {
+		return fmt.Errorf("csv request bodies are not supported")
+	})
+}
+
+// Unmarshal rejects CSV request bodies
+func (c *CSVMarshaler) Unmarshal(data []byte, v interface{}) error {
+	return fmt.Errorf("csv request bodies are not supported")
+}
+
+// ContentType returns text/csv, or the content type of raw bodies and JSON errors
+func (c *CSVMarshaler) ContentType(v interface{}) string {
+	body, _ := unwrapResponse(v)
+	switch body := body.(type) {
+	case *httpbody.HttpBody:
+		if body.GetContentType() != "" {
+			return body.GetContentType()
+		}
+	case *status.Status, map[string]interface{}:
+		return "application/json"
+	}
+	return CSVContentType + "; charset=utf-8"
+}
+
+// CSVAttachment names the CSV downloads after their path and date, e.g. "orders-2024-05-01.csv", so
+// browsers save them as files
+func CSVAttachment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != CSVContentType {
+			next.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(&csvAttachmentWriter{ResponseWriter: w, path: r.URL.Path}, r)
+	})
+}
+
+type csvAttachmentWriter struct {
+	http.ResponseWriter
+	path  string
+	wrote bool
+}
+
+func (w *csvAttachmentWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.wrote = true
+		if strings.HasPrefix(w.Header().Get("Content-Type"), CSVContentType) && w.Header().Get("Content-Disposition") == "" {
+			name := strings.Map(func(r rune) rune {
+				if r == '-' || r == '_' || r == '.' || ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') {
+					return r
+				}
+				return -1
+			}, path.Base(w.path))
+			if strings.Trim(name, ".") == "" {
+				name = "export"
+			}
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.csv"`, name, time.Now().UTC().Format(time.DateOnly)))
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *csvAttachmentWriter) Write(b []byte) (int, error) {
+	if !w.wrote {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *csvAttachmentWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *csvAttachmentWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,100 @@
+package runtime
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/types/known/typepb"
+)
+
+func TestCSVMarshaler_Marshal(t *testing.T) {
+	cm := NewCSVMarshaler()
+
+	// The fields of a Type stand in for the orders of a list response
+	list := &typepb.Type{
+		Name: "Order",
+		Fields: []*typepb.Field{
+			{Name: "id", Number: 1, Kind: typepb.Field_TYPE_STRING},
+			{Name: "=HYPERLINK(\"x\")", Number: 2, JsonName: "a, \"quoted\"\nvalue"},
+		},
+	}
+	data, err := cm.Marshal(&Response{RequestID: "req-1", Body: list})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.HasPrefix(string(data), string(utf8BOM)) {
+		t.Errorf("expected a UTF-8 BOM")
+	}
+
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(string(data), string(utf8BOM)))).ReadAll()
+	if err != nil {
+		t.Fatalf("response is not valid CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %d records", len(records))
+	}
+
+	column := func(name string) int {
+		for i, header := range records[0] {
+			if header == name {
+				return i
+			}
+		}
+		t.Fatalf("missing column %s in %v", name, records[0])
+		return -1
+	}
+	if got := records[1][column("name")]; got != "id" {
+		t.Errorf("expected name id, got %q", got)
+	}
+	if got := records[1][column("kind")]; got != "TYPE_STRING" {
+		t.Errorf("expected kind TYPE_STRING, got %q", got)
+	}
+	if got := records[2][column("number")]; got != "2" {
+		t.Errorf("expected number 2, got %q", got)
+	}
+	if got := records[2][column("name")]; got != "'=HYPERLINK(\"x\")" {
+		t.Errorf("expected the formula to be escaped, got %q", got)
+	}
+	if got := records[2][column("json_name")]; got != "a, \"quoted\"\nvalue" {
+		t.Errorf("expected the value to round-trip, got %q", got)
+	}
+	if got := records[1][column("options")]; got != "[]" {
+		t.Errorf("expected repeated fields as JSON, got %q", got)
+	}
+	if ct := cm.ContentType(list); ct != "text/csv; charset=utf-8" {
+		t.Errorf("expected text/csv, got %s", ct)
+	}
+}
+
+func TestCSVMarshaler_Marshal_Error(t *testing.T) {
+	cm := NewCSVMarshaler()
+
+	st := &Response{RequestID: "req-1", Body: &status.Status{Code: 5, Message: "order not found"}}
+	data, err := cm.Marshal(st)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"message":"order not found"`) || cm.ContentType(st) != "application/json" {
+		t.Errorf("expected the JSON error envelope, got %s (%s)", data, cm.ContentType(st))
+	}
+}
+
+func TestCSVAttachment(t *testing.T) {
+	handler := CSVAttachment(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		_, _ = w.Write([]byte("id\r\n"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/orders", nil)
+	req.Header.Set("Accept", CSVContentType)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(got, `attachment; filename="orders-`) {
+		t.Errorf("expected an orders attachment, got %q", got)
+	}
+}
@@ -0,0 +1,92 @@
+package runtime
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/internal/i18n"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// RawResponseHeader asks for the response without the envelope ("X-Raw-Response: true"), for
+// integrations requiring an exact payload shape
+const RawResponseHeader = "X-Raw-Response"
+
+// FieldCasingHeader asks for field names in camelCase with "X-Field-Casing: camel", as does the
+// casing=camel query parameter; they're snake_case (the proto names) otherwise
+const FieldCasingHeader = "X-Field-Casing"
+
+type rawResponseKey struct{}
+
+type camelCaseKey struct{}
+
+type prettyKey struct{}
+
+// RawResponses honors the X-Raw-Response header of requests
+func RawResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get(RawResponseHeader), "true") {
+			r = r.WithContext(context.WithValue(r.Context(), rawResponseKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// FieldCasing honors the casing requested with X-Field-Casing or the casing query parameter
+func FieldCasing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		casing := r.Header.Get(FieldCasingHeader)
+		if casing == "" {
+			casing = r.URL.Query().Get("casing")
+		}
+		if strings.EqualFold(casing, "camel") {
+			r = r.WithContext(context.WithValue(r.Context(), camelCaseKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// PrettyPrint honors the pretty=true query parameter, which indents the JSON of the response
+func PrettyPrint(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.URL.Query().Get("pretty"), "true") {
+			r = r.WithContext(context.WithValue(r.Context(), prettyKey{}, true))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NewResponseRewriter returns the grpc-gateway response rewriter: responses and errors are wrapped in a
+// Response, with the request ID and how they're written; raw bodies (google.api.HttpBody) are left alone.
+// Those of rawMethods (full gRPC method names, e.g. payment provider callbacks) or of requests asking
+// with RawResponseHeader go without the envelope, those of requests asking with FieldCasing in
+// camelCase, and those of requests selecting fields with FieldSelection limited to them, indented with
+// PrettyPrint. Errors are translated into the language of the request when translator isn't nil.
+func NewResponseRewriter(rawMethods []string, translator *i18n.Translator) runtime.ForwardResponseRewriter {
+	raw := make(map[string]bool, len(rawMethods))
+	for _, method := range rawMethods {
+		raw[method] = true
+	}
+
+	return func(ctx context.Context, resp proto.Message) (any, error) {
+		if _, ok := resp.(*httpbody.HttpBody); ok {
+			return resp, nil
+		}
+		if st, ok := resp.(*status.Status); ok && translator != nil {
+			resp = translator.LocalizeStatus(ctx, st)
+		}
+		method, _ := runtime.RPCMethod(ctx)
+		requested, _ := ctx.Value(rawResponseKey{}).(bool)
+
+		wrapped := newResponse(ctx, resp)
+		wrapped.Raw = requested || raw[method]
+		wrapped.CamelCase, _ = ctx.Value(camelCaseKey{}).(bool)
+		wrapped.Fields, _ = ctx.Value(fieldsKey{}).([]string)
+		wrapped.Pretty, _ = ctx.Value(prettyKey{}).(bool)
+		return wrapped, nil
+	}
+}
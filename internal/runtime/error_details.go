@@ -0,0 +1,60 @@
+package runtime
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+)
+
+// ErrorDetail is an entry of the "errors" array of error envelopes, from the google.rpc error details
+// of the backend's status, e.g. the form field that failed validation
+type ErrorDetail struct {
+	Field       string            `json:"field,omitempty"`
+	Code        string            `json:"code"`
+	Description string            `json:"description,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// errorDetails maps the BadRequest, PreconditionFailure, QuotaFailure, ErrorInfo and RetryInfo details
+// of st; other details are left out
+func errorDetails(st *status.Status) []ErrorDetail {
+	var details []ErrorDetail
+	for _, packed := range st.GetDetails() {
+		detail, err := packed.UnmarshalNew()
+		if err != nil {
+			continue // type not linked into the gateway
+		}
+
+		switch detail := detail.(type) {
+		case *errdetails.BadRequest:
+			for _, v := range detail.GetFieldViolations() {
+				code := v.GetReason()
+				if code == "" {
+					code = codes.InvalidArgument.String()
+				}
+				details = append(details, ErrorDetail{Field: v.GetField(), Code: code, Description: v.GetDescription()})
+			}
+		case *errdetails.PreconditionFailure:
+			for _, v := range detail.GetViolations() {
+				details = append(details, ErrorDetail{Field: v.GetSubject(), Code: v.GetType(), Description: v.GetDescription()})
+			}
+		case *errdetails.QuotaFailure:
+			for _, v := range detail.GetViolations() {
+				details = append(details, ErrorDetail{Field: v.GetSubject(), Code: codes.ResourceExhausted.String(), Description: v.GetDescription()})
+			}
+		case *errdetails.ErrorInfo:
+			details = append(details, ErrorDetail{Code: detail.GetReason(), Description: detail.GetDomain(), Metadata: detail.GetMetadata()})
+		case *errdetails.RetryInfo:
+			seconds := int(math.Ceil(detail.GetRetryDelay().AsDuration().Seconds()))
+			details = append(details, ErrorDetail{
+				Code:        "RETRY_AFTER",
+				Description: fmt.Sprintf("retry after %ds", seconds),
+				Metadata:    map[string]string{"retry_after": fmt.Sprint(seconds)},
+			})
+		}
+	}
+	return details
+}
@@ -0,0 +1,198 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+type fieldsKey struct{}
+
+// FieldSelection honors the fields query parameter of requests, e.g. "?fields=id,name,variants.price",
+// which limits the response to the listed fields; see Response.Fields
+func FieldSelection(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var paths []string
+		for _, value := range r.URL.Query()["fields"] {
+			for _, path := range strings.Split(value, ",") {
+				if path = strings.TrimSpace(path); path != "" {
+					paths = append(paths, path)
+				}
+			}
+		}
+		if len(paths) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), fieldsKey{}, paths))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fieldMask is a tree of selected fields; a nil subtree selects the whole value. Fields are keyed by
+// both their proto and JSON names, so paths match in either casing.
+type fieldMask map[string]fieldMask
+
+// newFieldMask builds the mask of the field paths selected for the response message. The paths of a
+// list response (e.g. ListProductsResponse) are relative to its elements when they don't name one of
+// its fields: "fields=id,name" selects the id and name of every product, and keeps the pagination.
+func newFieldMask(message proto.Message, paths []string) fieldMask {
+	mask := make(fieldMask)
+	for _, path := range paths {
+		mask.add(strings.Split(path, "."))
+	}
+
+	descriptor := message.ProtoReflect().Descriptor()
+	list := listField(descriptor)
+	if list == nil {
+		return mask
+	}
+	fields := descriptor.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if _, ok := mask[string(fields.Get(i).Name())]; ok {
+			return mask
+		}
+	}
+
+	response := make(fieldMask)
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		var selected fieldMask
+		if field == list {
+			selected = mask
+		}
+		response[string(field.Name())] = selected
+		response[field.JSONName()] = selected
+	}
+	return response
+}
+
+func (m fieldMask) add(path []string) {
+	for _, name := range []string{path[0], snakeCase(path[0]), camelCase(path[0])} {
+		sub, ok := m[name]
+		if len(path) == 1 {
+			m[name] = nil // the whole field, even when a subfield was selected too
+			continue
+		}
+		if ok && sub == nil {
+			continue // the whole field is selected already
+		}
+		if sub == nil {
+			sub = make(fieldMask)
+			m[name] = sub
+		}
+		sub.add(path[1:])
+	}
+}
+
+// filterJSON copies the next JSON value of dec to buf, leaving out the object keys outside of mask.
+// Arrays are filtered element by element, and key order is kept.
+func filterJSON(buf *bytes.Buffer, dec *json.Decoder, mask fieldMask) error {
+	if mask == nil {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		buf.Write(raw)
+		return nil
+	}
+
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('{'):
+		buf.WriteByte('{')
+		first := true
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			sub, ok := mask[key.(string)]
+			if !ok {
+				var skipped json.RawMessage
+				if err := dec.Decode(&skipped); err != nil {
+					return err
+				}
+				continue
+			}
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			name, _ := json.Marshal(key)
+			buf.Write(name)
+			buf.WriteByte(':')
+			if err := filterJSON(buf, dec, sub); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case json.Delim('['):
+		buf.WriteByte('[')
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := filterJSON(buf, dec, mask); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		value, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		buf.Write(value)
+		return nil
+	}
+	_, err = dec.Token() // closing delimiter
+	return err
+}
+
+// selectFields filters the JSON form data of message down to the field paths
+func selectFields(data []byte, message proto.Message, paths []string) ([]byte, error) {
+	var buf bytes.Buffer
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := filterJSON(&buf, dec, newFieldMask(message, paths)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if 'A' <= r && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func camelCase(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		if r == '_' {
+			upper = true
+			continue
+		}
+		if upper && 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
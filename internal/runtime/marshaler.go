@@ -1,13 +1,16 @@
 package runtime
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/api/httpbody"
 	"google.golang.org/genproto/googleapis/rpc/status"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
 // CustomMarshaler is a custom marshaler that wraps the response in a standard format.
@@ -31,8 +34,43 @@ func NewCustomMarshaler() *CustomMarshaler {
 	}
 }
 
-// Marshal wraps the default JSONPb marshaling with a standard response envelope.
+// Marshal wraps the default JSONPb marshaling with a standard response envelope, including the
+// request ID of the Response NewResponseRewriter wraps responses and errors in.
 func (c *CustomMarshaler) Marshal(v interface{}) ([]byte, error) {
+	data, err := c.marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if resp, ok := v.(*Response); ok && resp.Pretty {
+		if _, ok := resp.Body.(*httpbody.HttpBody); !ok {
+			var buf bytes.Buffer
+			if err := json.Indent(&buf, data, "", "  "); err != nil {
+				return nil, err
+			}
+			buf.WriteByte('\n') // for the shell prompt after curl
+			return buf.Bytes(), nil
+		}
+	}
+	return data, nil
+}
+
+func (c *CustomMarshaler) marshal(v interface{}) ([]byte, error) {
+	// Responses marked raw by NewResponseRewriter are the bare payload, errors the bare google.rpc.Status
+	resp, _ := v.(*Response)
+	if resp != nil && resp.Raw {
+		if body, ok := resp.Body.(*httpbody.HttpBody); ok {
+			return body.GetData(), nil
+		}
+		return c.marshalData(resp, resp.Body)
+	}
+
+	v, requestID := unwrapResponse(v)
+
+	// Raw bodies (PDF receipts, CSV exports) are written verbatim, without the envelope
+	if body, ok := v.(*httpbody.HttpBody); ok {
+		return body.GetData(), nil
+	}
+
 	// Check if this is an error response from grpc-gateway
 	// The default error handler passes a map[string]interface{} with specific fields
 	if errMap, ok := v.(map[string]interface{}); ok {
@@ -62,11 +100,7 @@ func (c *CustomMarshaler) Marshal(v interface{}) ([]byte, error) {
 				// "message": <ERROR MSG>
 				// "data": null
 
-				return json.Marshal(map[string]interface{}{
-					"status":  statusCode,
-					"message": msg,
-					"data":    nil,
-				})
+				return json.Marshal(errorEnvelope(statusCode, msg, nil, requestID))
 			}
 		}
 	}
@@ -74,38 +108,75 @@ func (c *CustomMarshaler) Marshal(v interface{}) ([]byte, error) {
 	// Handle *status.Status directly if passed
 	if s, ok := v.(*status.Status); ok {
 		statusCode := runtime.HTTPStatusFromCode(codes.Code(s.Code))
-		return json.Marshal(map[string]interface{}{
-			"status":  statusCode,
-			"message": s.Message,
-			"data":    nil,
-		})
+		return json.Marshal(errorEnvelope(statusCode, s.Message, errorDetails(s), requestID))
 	}
 
 	// First, marshal the original value using the standard JSONPb marshaler.
 	// This ensures we respect all Protobuf JSON mapping rules (snake_case, enums as strings, etc.)
-	data, err := c.JSONPb.Marshal(v)
+	data, err := c.marshalData(resp, v)
 	if err != nil {
 		return nil, err
 	}
 
 	// Define the standard response structure
 	type StandardResponse struct {
-		Status  int             `json:"status"`
-		Message string          `json:"message"`
-		Data    json.RawMessage `json:"data"`
+		Status    int                    `json:"status"`
+		Message   string                 `json:"message"`
+		Data      json.RawMessage        `json:"data"`
+		Meta      map[string]interface{} `json:"meta,omitempty"` // pagination of list responses
+		RequestID string                 `json:"request_id,omitempty"`
 	}
 
 	// Create the wrapped response
 	response := StandardResponse{
-		Status:  200,       // Default status for successful successful gRPC calls handled here
-		Message: "success", // Default message
-		Data:    data,
+		Status:    200,       // Default status for successful successful gRPC calls handled here
+		Message:   "success", // Default message
+		Data:      data,
+		Meta:      paginationMeta(v),
+		RequestID: requestID,
 	}
 
 	// Marshal the wrapped response
 	return json.Marshal(response)
 }
 
+// marshalData marshals the body of a response in the casing it asks for, limited to the fields it
+// selects; resp is nil for responses not wrapped by the response rewriter
+func (c *CustomMarshaler) marshalData(resp *Response, body interface{}) ([]byte, error) {
+	if resp == nil {
+		return c.JSONPb.Marshal(body)
+	}
+
+	jsonpb := c.JSONPb
+	if resp.CamelCase {
+		jsonpb.MarshalOptions.UseProtoNames = false
+	}
+	data, err := jsonpb.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if message, ok := body.(proto.Message); ok && len(resp.Fields) > 0 {
+		return selectFields(data, message, resp.Fields)
+	}
+	return data, nil
+}
+
+func errorEnvelope(statusCode int, message interface{}, details []ErrorDetail, requestID string) map[string]interface{} {
+	envelope := map[string]interface{}{
+		"status":  statusCode,
+		"message": message,
+		"data":    nil,
+	}
+	if len(details) > 0 {
+		envelope["errors"] = details
+	}
+	if requestID != "" {
+		envelope["request_id"] = requestID
+	}
+	return envelope
+}
+
 // CustomEncoder wraps the writer to encode responses using CustomMarshaler.
 type CustomEncoder struct {
 	w io.Writer
@@ -135,5 +206,8 @@ func (c *CustomMarshaler) NewDecoder(r io.Reader) runtime.Decoder {
 
 // ContentType returns the content type for this marshaler.
 func (c *CustomMarshaler) ContentType(v interface{}) string {
+	if body, ok := v.(*httpbody.HttpBody); ok && body.GetContentType() != "" {
+		return body.GetContentType()
+	}
 	return "application/json"
 }
@@ -1,8 +1,27 @@
 package runtime
 
 import (
+	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/typepb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 func TestCustomMarshaler_Marshal(t *testing.T) {
@@ -85,3 +104,245 @@ func TestCustomMarshaler_Marshal_Error(t *testing.T) {
 		t.Errorf("Expected data to be null, got %s", resp.Data)
 	}
 }
+
+func TestCustomMarshaler_Marshal_HttpBody(t *testing.T) {
+	cm := NewCustomMarshaler()
+
+	body := &httpbody.HttpBody{
+		ContentType: "application/pdf",
+		Data:        []byte("%PDF-1.7"),
+	}
+
+	data, err := cm.Marshal(body)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	if string(data) != "%PDF-1.7" {
+		t.Errorf("Expected raw body, got %q", data)
+	}
+	if ct := cm.ContentType(body); ct != "application/pdf" {
+		t.Errorf("Expected content type 'application/pdf', got '%s'", ct)
+	}
+}
+
+func TestCustomMarshaler_Marshal_RequestID(t *testing.T) {
+	cm := NewCustomMarshaler()
+
+	data, err := cm.Marshal(&Response{RequestID: "req-123", Body: map[string]string{"foo": "bar"}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var resp struct {
+		Status    int               `json:"status"`
+		Data      map[string]string `json:"data"`
+		RequestID string            `json:"request_id"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("Failed to unmarshal result: %v", err)
+	}
+
+	if resp.RequestID != "req-123" {
+		t.Errorf("Expected request_id 'req-123', got '%s'", resp.RequestID)
+	}
+	if resp.Data["foo"] != "bar" {
+		t.Errorf("Expected data.foo to be 'bar', got '%s'", resp.Data["foo"])
+	}
+}
+
+func TestCustomMarshaler_Marshal_Raw(t *testing.T) {
+	cm := NewCustomMarshaler()
+
+	data, err := cm.Marshal(&Response{RequestID: "req-1", Body: wrapperspb.String("foo"), Raw: true})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != `"foo"` {
+		t.Errorf("expected the bare payload, got %s", data)
+	}
+
+	data, err = cm.Marshal(&Response{Body: &status.Status{Code: 5, Message: "order not found"}, Raw: true})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var st map[string]interface{}
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatalf("Failed to unmarshal result: %v", err)
+	}
+	if st["code"] != float64(5) || st["message"] != "order not found" {
+		t.Errorf("expected the bare google.rpc.Status, got %s", data)
+	}
+}
+
+func TestNewResponseRewriter_RawHeader(t *testing.T) {
+	rewrite := NewResponseRewriter(nil, nil)
+
+	var ctx context.Context
+	handler := RawResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { ctx = r.Context() }))
+	req := httptest.NewRequest(http.MethodPost, "/v1/payments/callback", nil)
+	req.Header.Set(RawResponseHeader, "true")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	resp, err := rewrite(ctx, wrapperspb.String("foo"))
+	if err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	if r, ok := resp.(*Response); !ok || !r.Raw {
+		t.Errorf("expected a raw response, got %#v", resp)
+	}
+
+	resp, _ = rewrite(context.Background(), wrapperspb.String("foo"))
+	if r, ok := resp.(*Response); ok && r.Raw {
+		t.Errorf("expected an enveloped response, got %#v", resp)
+	}
+}
+
+func TestCustomMarshaler_Marshal_ErrorDetails(t *testing.T) {
+	cm := NewCustomMarshaler()
+
+	badRequest, _ := anypb.New(&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+		{Field: "price", Description: "must be positive"},
+		{Field: "sku", Reason: "SKU_TAKEN", Description: "already used by another product"},
+	}})
+	retryInfo, _ := anypb.New(&errdetails.RetryInfo{RetryDelay: durationpb.New(1500 * time.Millisecond)})
+	data, err := cm.Marshal(&status.Status{Code: 3, Message: "invalid product", Details: []*anypb.Any{badRequest, retryInfo}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var resp struct {
+		Status int           `json:"status"`
+		Errors []ErrorDetail `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("Failed to unmarshal result: %v", err)
+	}
+	want := []ErrorDetail{
+		{Field: "price", Code: "InvalidArgument", Description: "must be positive"},
+		{Field: "sku", Code: "SKU_TAKEN", Description: "already used by another product"},
+		{Code: "RETRY_AFTER", Description: "retry after 2s", Metadata: map[string]string{"retry_after": "2"}},
+	}
+	if resp.Status != 400 || !reflect.DeepEqual(resp.Errors, want) {
+		t.Errorf("expected status 400 and errors %+v, got %s", want, data)
+	}
+}
+
+func TestCustomMarshaler_Marshal_PaginationMeta(t *testing.T) {
+	cm := NewCustomMarshaler()
+
+	// ListOrdersResponse {repeated Order orders = 1; int32 total_count = 2; string next_page_token = 3;}
+	file, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("orders_test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Order"), Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("id"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			}},
+			{Name: proto.String("ListOrdersResponse"), Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("orders"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(), TypeName: proto.String(".test.Order"), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()},
+				{Name: proto.String("total_count"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				{Name: proto.String("next_page_token"), Number: proto.Int32(3), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			}},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewFile: %v", err)
+	}
+	list := dynamicpb.NewMessage(file.Messages().ByName("ListOrdersResponse"))
+	list.Set(list.Descriptor().Fields().ByName("total_count"), protoreflect.ValueOfInt32(42))
+	list.Set(list.Descriptor().Fields().ByName("next_page_token"), protoreflect.ValueOfString("abc"))
+
+	data, err := cm.Marshal(list)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+		Meta map[string]interface{} `json:"meta"`
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("Failed to unmarshal result: %v", err)
+	}
+	if resp.Meta["total"] != float64(42) || resp.Meta["next_page_token"] != "abc" || len(resp.Meta) != 2 {
+		t.Errorf("expected total and next_page_token in meta, got %s", data)
+	}
+	if resp.Data["total_count"] != float64(42) {
+		t.Errorf("expected the fields to stay in data, got %s", data)
+	}
+
+	// Other responses have no meta
+	data, _ = cm.Marshal(wrapperspb.String("foo"))
+	if strings.Contains(string(data), `"meta"`) {
+		t.Errorf("expected no meta, got %s", data)
+	}
+}
+
+func TestCustomMarshaler_Marshal_CamelCase(t *testing.T) {
+	cm := NewCustomMarshaler()
+	field := &typepb.Field{Name: "id", JsonName: "id", TypeUrl: "type.googleapis.com/Order"}
+
+	data, err := cm.Marshal(&Response{Body: field})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"type_url"`) {
+		t.Errorf("expected snake_case field names, got %s", data)
+	}
+
+	data, err = cm.Marshal(&Response{Body: field, CamelCase: true})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(data), `"typeUrl"`) || !strings.Contains(string(data), `"status":200`) {
+		t.Errorf("expected camelCase field names in the envelope, got %s", data)
+	}
+}
+
+func TestCustomMarshaler_Marshal_Fields(t *testing.T) {
+	cm := NewCustomMarshaler()
+
+	// The fields of a Type stand in for the products of a list response
+	list := &typepb.Type{
+		Name: "Product",
+		Fields: []*typepb.Field{
+			{Name: "id", Number: 1, JsonName: "id", Kind: typepb.Field_TYPE_STRING},
+			{Name: "price", Number: 2, JsonName: "price", Kind: typepb.Field_TYPE_INT64},
+		},
+		Syntax: typepb.Syntax_SYNTAX_PROTO3,
+	}
+
+	tests := []struct {
+		fields []string
+		want   string
+	}{
+		// Relative to the list elements, the rest of the response is kept
+		{[]string{"number", "jsonName"}, `{"name":"Product","fields":[{"number":1,"json_name":"id"},{"number":2,"json_name":"price"}],"oneofs":[],"options":[],"source_context":null,"syntax":"SYNTAX_PROTO3","edition":""}`},
+		// Naming fields of the response
+		{[]string{"name", "fields.number"}, `{"name":"Product","fields":[{"number":1},{"number":2}]}`},
+		{[]string{"fields.number", "fields"}, `"fields":[{"kind":"TYPE_STRING"`},
+	}
+	for _, tt := range tests {
+		data, err := cm.Marshal(&Response{Body: list, Fields: tt.fields})
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if !strings.Contains(string(data), tt.want) {
+			t.Errorf("fields %v: expected %s in %s", tt.fields, tt.want, data)
+		}
+	}
+}
+
+func TestCustomMarshaler_Marshal_Pretty(t *testing.T) {
+	cm := NewCustomMarshaler()
+
+	data, err := cm.Marshal(&Response{RequestID: "req-1", Body: wrapperspb.String("foo"), Pretty: true})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	want := "{\n  \"status\": 200,\n  \"message\": \"success\",\n  \"data\": \"foo\",\n  \"request_id\": \"req-1\"\n}\n"
+	if string(data) != want {
+		t.Errorf("expected %q, got %q", want, data)
+	}
+}
@@ -0,0 +1,205 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// MsgpackContentType is negotiated by bandwidth-constrained POS terminals, e.g. for catalog sync
+const MsgpackContentType = "application/msgpack"
+
+// MsgpackMarshaler writes the envelope (responses and errors) as MessagePack. Unlike the JSON
+// marshaler it leaves out unpopulated fields, which with the binary encoding roughly halves the size
+// of catalog payloads; clients apply the proto defaults.
+type MsgpackMarshaler struct {
+	envelope *CustomMarshaler
+}
+
+// NewMsgpackMarshaler creates a MessagePack marshaler
+func NewMsgpackMarshaler() *MsgpackMarshaler {
+	return &MsgpackMarshaler{
+		envelope: &CustomMarshaler{
+			JSONPb: runtime.JSONPb{
+				MarshalOptions: protojson.MarshalOptions{UseProtoNames: true},
+			},
+		},
+	}
+}
+
+// Marshal writes raw bodies (google.api.HttpBody) verbatim
+func (m *MsgpackMarshaler) Marshal(v interface{}) ([]byte, error) {
+	if body, ok := unwrapHTTPBody(v); ok {
+		return body.GetData(), nil
+	}
+
+	data, err := m.envelope.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := writeMsgpack(&buf, dec); err != nil {
+		return nil, fmt.Errorf("msgpack: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeMsgpack converts the next JSON value of dec, keeping the order of object keys
+func writeMsgpack(buf *bytes.Buffer, dec *json.Decoder) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch token := token.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if token {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case string:
+		writeMsgpackString(buf, token)
+	case json.Number:
+		if n, err := strconv.ParseInt(string(token), 10, 64); err == nil {
+			writeMsgpackInt(buf, n)
+			break
+		}
+		f, err := token.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	case json.Delim:
+		// The element count precedes the elements, so they're encoded first
+		var elements bytes.Buffer
+		n := 0
+		for ; dec.More(); n++ {
+			if token == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				writeMsgpackString(&elements, key.(string))
+			}
+			if err := writeMsgpack(&elements, dec); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // closing delimiter
+			return err
+		}
+		if token == '{' {
+			writeMsgpackHeader(buf, n, 0x80, 0xde, 0xdf)
+		} else {
+			writeMsgpackHeader(buf, n, 0x90, 0xdc, 0xdd)
+		}
+		buf.Write(elements.Bytes())
+	}
+	return nil
+}
+
+func writeMsgpackString(buf *bytes.Buffer, s string) {
+	switch n := len(s); {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.Write([]byte{0xd9, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(0xdb)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+	buf.WriteString(s)
+}
+
+// writeMsgpackHeader writes the header of a map or an array of n elements: the fix format for up to
+// 15 elements, then the 16 and 32 bit ones
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix, format16, format32 byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(format16)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	default:
+		buf.WriteByte(format32)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	}
+}
+
+// writeMsgpackInt writes n in its smallest format
+func writeMsgpackInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0 && n <= math.MaxInt8:
+		buf.WriteByte(byte(n))
+	case n >= -32 && n < 0:
+		buf.WriteByte(byte(n))
+	case n >= 0 && n <= math.MaxUint8:
+		buf.Write([]byte{0xcc, byte(n)})
+	case n >= 0 && n <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n >= 0 && n <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	case n >= math.MinInt8 && n < 0:
+		buf.Write([]byte{0xd0, byte(n)})
+	case n >= math.MinInt16 && n < 0:
+		buf.WriteByte(0xd1)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n >= math.MinInt32 && n < 0:
+		buf.WriteByte(0xd2)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		buf.WriteByte(0xd3)
+		buf.Write(binary.BigEndian.AppendUint64(nil, uint64(n)))
+	}
+}
+
+// NewEncoder returns an encoder writing values as Marshal does
+func (m *MsgpackMarshaler) NewEncoder(w io.Writer) runtime.Encoder {
+	return runtime.EncoderFunc(func(v interface{}) error {
+		data, err := m.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+// NewDecoder rejects MessagePack request bodies
+func (m *MsgpackMarshaler) NewDecoder(r io.Reader) runtime.Decoder {
+	return runtime.DecoderFunc(func(interface{}) error {
+		return fmt.Errorf("msgpack request bodies are not supported, send JSON")
+	})
+}
+
+// Unmarshal rejects MessagePack request bodies
+func (m *MsgpackMarshaler) Unmarshal(data []byte, v interface{}) error {
+	return fmt.Errorf("msgpack request bodies are not supported, send JSON")
+}
+
+// ContentType returns application/msgpack, or the content type of raw bodies
+func (m *MsgpackMarshaler) ContentType(v interface{}) string {
+	if body, ok := unwrapHTTPBody(v); ok && body.GetContentType() != "" {
+		return body.GetContentType()
+	}
+	return MsgpackContentType
+}
@@ -0,0 +1,66 @@
+package runtime
+
+import (
+	"bytes"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/types/known/typepb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestMsgpackMarshaler_Marshal(t *testing.T) {
+	mm := NewMsgpackMarshaler()
+
+	data, err := mm.Marshal(&Response{RequestID: "req-1", Body: wrapperspb.String("foo")})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	want := []byte{0x84} // map of 4
+	want = append(want, append([]byte{0xa6}, "status"...)...)
+	want = append(want, 0xcc, 200)
+	want = append(want, append([]byte{0xa7}, "message"...)...)
+	want = append(want, append([]byte{0xa7}, "success"...)...)
+	want = append(want, append([]byte{0xa4}, "data"...)...)
+	want = append(want, append([]byte{0xa3}, "foo"...)...)
+	want = append(want, append([]byte{0xaa}, "request_id"...)...)
+	want = append(want, append([]byte{0xa5}, "req-1"...)...)
+	if !bytes.Equal(data, want) {
+		t.Errorf("expected % x, got % x", want, data)
+	}
+	if ct := mm.ContentType(nil); ct != MsgpackContentType {
+		t.Errorf("expected %s, got %s", MsgpackContentType, ct)
+	}
+}
+
+func TestMsgpackMarshaler_Marshal_Smaller(t *testing.T) {
+	list := &typepb.Type{Name: "Product"}
+	for i := 0; i < 50; i++ {
+		list.Fields = append(list.Fields, &typepb.Field{Name: "sku", Number: int32(i), Kind: typepb.Field_TYPE_STRING})
+	}
+
+	packed, err := NewMsgpackMarshaler().Marshal(list)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	jsonData, err := NewCustomMarshaler().Marshal(list)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if len(packed)*2 > len(jsonData) {
+		t.Errorf("expected at most half of the %d JSON bytes, got %d", len(jsonData), len(packed))
+	}
+}
+
+func TestMsgpackMarshaler_Marshal_Error(t *testing.T) {
+	data, err := NewMsgpackMarshaler().Marshal(&status.Status{Code: 5, Message: "order not found"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	for _, want := range [][]byte{{0xcd, 0x01, 0x94}, append([]byte{0xaf}, "order not found"...), append([]byte{0xa4}, "data\xc0"...)} {
+		if !bytes.Contains(data, want) {
+			t.Errorf("expected % x in % x", want, data)
+		}
+	}
+}
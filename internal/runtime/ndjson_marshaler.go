@@ -0,0 +1,131 @@
+package runtime
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// NDJSONContentType is negotiated by clients consuming server streams and very large lists line by line
+const NDJSONContentType = "application/x-ndjson"
+
+// NDJSONMarshaler writes newline-delimited JSON without the envelope: each message of a server stream
+// is a line, flushed as soon as the backend sends it, and each element of a list response (e.g. the
+// products of a ListProductsResponse) is a line. Other responses are a single line. Errors are the JSON
+// envelope, as their last line in streams.
+type NDJSONMarshaler struct {
+	envelope *CustomMarshaler
+}
+
+// NewNDJSONMarshaler creates an NDJSON marshaler
+func NewNDJSONMarshaler() *NDJSONMarshaler {
+	return &NDJSONMarshaler{envelope: NewCustomMarshaler()}
+}
+
+// Marshal writes raw bodies (google.api.HttpBody) verbatim
+func (n *NDJSONMarshaler) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case map[string]interface{}: // stream message, {"result": message}
+		if result, ok := v["result"]; ok && len(v) == 1 {
+			return n.marshalLine(result)
+		}
+	case map[string]proto.Message: // stream error, {"error": status}
+		if st, ok := v["error"].(*status.Status); ok {
+			return n.envelope.Marshal(st)
+		}
+	}
+
+	body, _ := unwrapResponse(v)
+	switch body := body.(type) {
+	case *httpbody.HttpBody:
+		return body.GetData(), nil
+	case *status.Status, map[string]interface{}:
+		return n.envelope.Marshal(v)
+	case proto.Message:
+		return n.marshalLines(v, body)
+	default:
+		return nil, fmt.Errorf("ndjson: unsupported response %T", body)
+	}
+}
+
+// marshalLine marshals a stream message, in the casing and with the fields the request asks for
+func (n *NDJSONMarshaler) marshalLine(v interface{}) ([]byte, error) {
+	resp, ok := v.(*Response)
+	if !ok {
+		return n.envelope.JSONPb.Marshal(v)
+	}
+	return n.envelope.marshalData(resp, resp.Body)
+}
+
+// marshalLines marshals the elements of a list response, or the response itself, a line each
+func (n *NDJSONMarshaler) marshalLines(v interface{}, body proto.Message) ([]byte, error) {
+	resp, ok := v.(*Response)
+	if !ok {
+		resp = &Response{Body: body}
+	}
+
+	message := body.ProtoReflect()
+	list := listField(message.Descriptor())
+	if list == nil {
+		line, err := n.envelope.marshalData(resp, body)
+		return append(line, '\n'), err
+	}
+
+	var buf bytes.Buffer
+	values := message.Get(list).List()
+	for i := 0; i < values.Len(); i++ {
+		line, err := n.envelope.marshalData(resp, values.Get(i).Message().Interface())
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// Delimiter separates the messages of streams
+func (n *NDJSONMarshaler) Delimiter() []byte {
+	return []byte("\n")
+}
+
+// NewEncoder returns an encoder writing values as Marshal does
+func (n *NDJSONMarshaler) NewEncoder(w io.Writer) runtime.Encoder {
+	return runtime.EncoderFunc(func(v interface{}) error {
+		data, err := n.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+// NewDecoder decodes JSON request bodies, which are single messages
+func (n *NDJSONMarshaler) NewDecoder(r io.Reader) runtime.Decoder {
+	return n.envelope.NewDecoder(r)
+}
+
+// Unmarshal decodes JSON request bodies
+func (n *NDJSONMarshaler) Unmarshal(data []byte, v interface{}) error {
+	return n.envelope.Unmarshal(data, v)
+}
+
+// ContentType returns application/x-ndjson, or the content type of raw bodies and JSON errors
+func (n *NDJSONMarshaler) ContentType(v interface{}) string {
+	body, _ := unwrapResponse(v)
+	switch body := body.(type) {
+	case *httpbody.HttpBody:
+		if body.GetContentType() != "" {
+			return body.GetContentType()
+		}
+	case *status.Status, map[string]interface{}:
+		return "application/json"
+	}
+	return NDJSONContentType
+}
@@ -0,0 +1,55 @@
+package runtime
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/typepb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestNDJSONMarshaler_Marshal(t *testing.T) {
+	nm := NewNDJSONMarshaler()
+
+	// The fields of a Type stand in for the products of a list response
+	list := &typepb.Type{
+		Name: "Product",
+		Fields: []*typepb.Field{
+			{Name: "id", Number: 1},
+			{Name: "price", Number: 2},
+		},
+	}
+	data, err := nm.Marshal(&Response{RequestID: "req-1", Body: list, Fields: []string{"name", "number"}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if want := "{\"number\":1,\"name\":\"id\"}\n{\"number\":2,\"name\":\"price\"}\n"; string(data) != want {
+		t.Errorf("expected a line per element %q, got %q", want, data)
+	}
+	if ct := nm.ContentType(&Response{Body: list}); ct != NDJSONContentType {
+		t.Errorf("expected %s, got %s", NDJSONContentType, ct)
+	}
+}
+
+func TestNDJSONMarshaler_Marshal_Stream(t *testing.T) {
+	nm := NewNDJSONMarshaler()
+
+	// grpc-gateway marshals every message of a stream as {"result": message}, and errors as {"error": status}
+	line, err := nm.Marshal(map[string]interface{}{"result": &Response{RequestID: "req-1", Body: wrapperspb.String("foo")}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(line) != `"foo"` {
+		t.Errorf("expected the bare message, got %s", line)
+	}
+
+	line, err = nm.Marshal(map[string]proto.Message{"error": &status.Status{Code: 14, Message: "backend unavailable"}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if !strings.Contains(string(line), `"status":503`) || strings.Contains(string(line), "\n") {
+		t.Errorf("expected a single error envelope line, got %s", line)
+	}
+}
@@ -0,0 +1,62 @@
+package runtime
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// paginationFields maps the pagination fields of list responses to their name in the envelope's "meta"
+// section, so every list endpoint paginates identically whatever its backend calls them
+var paginationFields = map[protoreflect.Name]string{
+	"total":               "total",
+	"total_count":         "total",
+	"total_size":          "total",
+	"page":                "page",
+	"page_size":           "page_size",
+	"per_page":            "page_size",
+	"total_pages":         "total_pages",
+	"next_page_token":     "next_page_token",
+	"prev_page_token":     "previous_page_token",
+	"previous_page_token": "previous_page_token",
+}
+
+// paginationMeta returns the pagination fields of a list response (a message with a repeated message
+// field), nil for other responses. The fields stay in "data" too, for the clients reading them there.
+func paginationMeta(v interface{}) map[string]interface{} {
+	message, ok := v.(proto.Message)
+	if !ok {
+		return nil
+	}
+	m := message.ProtoReflect()
+	if listField(m.Descriptor()) == nil {
+		return nil
+	}
+
+	var meta map[string]interface{}
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		name, ok := paginationFields[field.Name()]
+		if !ok || field.IsList() || field.IsMap() {
+			continue
+		}
+
+		var value interface{}
+		switch field.Kind() {
+		case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+			protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+			value = m.Get(field).Int()
+		case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+			value = m.Get(field).Uint()
+		case protoreflect.StringKind:
+			value = m.Get(field).String()
+		default:
+			continue
+		}
+		if meta == nil {
+			meta = make(map[string]interface{})
+		}
+		meta[name] = value
+	}
+	return meta
+}
@@ -0,0 +1,40 @@
+package runtime
+
+import (
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+)
+
+// ProtoContentType is negotiated by clients exchanging binary protobuf instead of JSON,
+// e.g. high-volume terminal sync
+const ProtoContentType = "application/x-protobuf"
+
+// ProtoMarshaler exchanges messages as binary protobuf, without the JSON envelope. Errors are
+// written as a binary google.rpc.Status with the HTTP status of their code.
+type ProtoMarshaler struct {
+	runtime.ProtoMarshaller
+	contentType string
+}
+
+// NewProtoMarshaler creates a binary protobuf marshaler answering with contentType
+func NewProtoMarshaler(contentType string) *ProtoMarshaler {
+	return &ProtoMarshaler{contentType: contentType}
+}
+
+// Marshal writes raw bodies (google.api.HttpBody) verbatim, same as the JSON marshaler. The request ID
+// is only sent in the X-Request-Id header.
+func (p *ProtoMarshaler) Marshal(v interface{}) ([]byte, error) {
+	v, _ = unwrapResponse(v)
+	if body, ok := v.(*httpbody.HttpBody); ok {
+		return body.GetData(), nil
+	}
+	return p.ProtoMarshaller.Marshal(v)
+}
+
+// ContentType returns the negotiated protobuf content type, or the content type of a raw body
+func (p *ProtoMarshaler) ContentType(v interface{}) string {
+	if body, ok := v.(*httpbody.HttpBody); ok && body.GetContentType() != "" {
+		return body.GetContentType()
+	}
+	return p.contentType
+}
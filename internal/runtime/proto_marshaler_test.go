@@ -0,0 +1,38 @@
+package runtime
+
+import (
+	"testing"
+
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtoMarshaler_Marshal(t *testing.T) {
+	pm := NewProtoMarshaler(ProtoContentType)
+
+	data, err := pm.Marshal(wrapperspb.String("foo"))
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var got wrapperspb.StringValue
+	if err := proto.Unmarshal(data, &got); err != nil {
+		t.Fatalf("response is not binary protobuf: %v", err)
+	}
+	if got.GetValue() != "foo" {
+		t.Errorf("expected foo, got %q", got.GetValue())
+	}
+	if ct := pm.ContentType(&got); ct != ProtoContentType {
+		t.Errorf("expected content type %s, got %s", ProtoContentType, ct)
+	}
+
+	body := &httpbody.HttpBody{ContentType: "application/pdf", Data: []byte("%PDF")}
+	data, err = pm.Marshal(body)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if string(data) != "%PDF" || pm.ContentType(body) != "application/pdf" {
+		t.Errorf("expected the raw body, got %q (%s)", data, pm.ContentType(body))
+	}
+}
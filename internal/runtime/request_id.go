@@ -0,0 +1,41 @@
+package runtime
+
+import (
+	"context"
+
+	pkgMiddleware "github.com/fekuna/omnipos-pkg/middleware"
+	"google.golang.org/protobuf/proto"
+)
+
+// Response carries the request ID of a grpc-gateway response or error to the marshalers, which add it
+// to the envelope
+type Response struct {
+	RequestID string
+	Body      interface{}
+	Raw       bool     // written without the envelope, see NewResponseRewriter
+	CamelCase bool     // field names in camelCase rather than the proto names, see FieldCasing
+	Fields    []string // field paths the response is limited to, see FieldSelection
+	Pretty    bool     // indented JSON, see PrettyPrint
+}
+
+// responseBody is implemented by messages whose HTTP rule selects a response_body field
+type responseBody interface {
+	XXX_ResponseBody() interface{}
+}
+
+// newResponse wraps resp with the request ID of ctx
+func newResponse(ctx context.Context, resp proto.Message) *Response {
+	// The wrapper hides the response_body selection from grpc-gateway, so it's applied here
+	if rb, ok := resp.(responseBody); ok {
+		return &Response{RequestID: pkgMiddleware.GetRequestID(ctx), Body: rb.XXX_ResponseBody()}
+	}
+	return &Response{RequestID: pkgMiddleware.GetRequestID(ctx), Body: resp}
+}
+
+// unwrapResponse returns the body and request ID of v
+func unwrapResponse(v interface{}) (interface{}, string) {
+	if resp, ok := v.(*Response); ok {
+		return resp.Body, resp.RequestID
+	}
+	return v, ""
+}
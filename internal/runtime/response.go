@@ -0,0 +1,26 @@
+package runtime
+
+import (
+	"encoding/json"
+	"net/http"
+
+	pkgMiddleware "github.com/fekuna/omnipos-pkg/middleware"
+)
+
+// WriteResponse writes the standard {status, message, data} envelope for
+// handlers that live outside of the grpc-gateway mux (middleware, gateway-owned endpoints).
+// The request ID set on the response by the request ID middleware is included.
+func WriteResponse(w http.ResponseWriter, statusCode int, message string, data interface{}) {
+	envelope := map[string]interface{}{
+		"status":  statusCode,
+		"message": message,
+		"data":    data,
+	}
+	if requestID := w.Header().Get(pkgMiddleware.RequestIDHeader); requestID != "" {
+		envelope["request_id"] = requestID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(envelope)
+}
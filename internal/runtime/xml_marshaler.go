@@ -0,0 +1,144 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+)
+
+// XMLContentType is negotiated by integrations (legacy ERP systems) that can't consume JSON
+const XMLContentType = "application/xml"
+
+// xmlName matches the JSON keys usable as element names; other keys (e.g. of proto maps) become
+// <entry key="...">
+var xmlName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// XMLMarshaler writes the JSON envelope (responses and errors) as XML, field order included:
+//
+//	<response><status>200</status><message>success</message><data>...</data><request_id>...</request_id></response>
+//
+// Objects become nested elements, array elements are <item> elements and null is an empty element.
+type XMLMarshaler struct {
+	envelope *CustomMarshaler
+}
+
+// NewXMLMarshaler creates an XML marshaler
+func NewXMLMarshaler() *XMLMarshaler {
+	return &XMLMarshaler{envelope: NewCustomMarshaler()}
+}
+
+// Marshal writes raw bodies (google.api.HttpBody) verbatim
+func (x *XMLMarshaler) Marshal(v interface{}) ([]byte, error) {
+	if body, ok := unwrapHTTPBody(v); ok {
+		return body.GetData(), nil
+	}
+
+	data, err := x.envelope.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := writeXML(&buf, dec, "response", ""); err != nil {
+		return nil, fmt.Errorf("xml: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeXML converts the next JSON value of dec into an element named name
+func writeXML(buf *bytes.Buffer, dec *json.Decoder, name, key string) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	buf.WriteByte('<')
+	buf.WriteString(name)
+	if key != "" {
+		buf.WriteString(` key="`)
+		_ = xml.EscapeText(buf, []byte(key))
+		buf.WriteByte('"')
+	}
+
+	switch token := token.(type) {
+	case nil:
+		buf.WriteString("/>")
+		return nil
+	case json.Delim:
+		buf.WriteByte('>')
+		for dec.More() {
+			child, childKey := "item", ""
+			if token == '{' {
+				k, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child = k.(string)
+				if !xmlName.MatchString(child) {
+					child, childKey = "entry", child
+				}
+			}
+			if err := writeXML(buf, dec, child, childKey); err != nil {
+				return err
+			}
+		}
+		if _, err := dec.Token(); err != nil { // closing delimiter
+			return err
+		}
+	default:
+		buf.WriteByte('>')
+		_ = xml.EscapeText(buf, []byte(fmt.Sprint(token)))
+	}
+
+	buf.WriteString("</")
+	buf.WriteString(name)
+	buf.WriteByte('>')
+	return nil
+}
+
+func unwrapHTTPBody(v interface{}) (*httpbody.HttpBody, bool) {
+	body, _ := unwrapResponse(v)
+	httpBody, ok := body.(*httpbody.HttpBody)
+	return httpBody, ok
+}
+
+// NewEncoder returns an encoder writing values as Marshal does
+func (x *XMLMarshaler) NewEncoder(w io.Writer) runtime.Encoder {
+	return runtime.EncoderFunc(func(v interface{}) error {
+		data, err := x.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+// NewDecoder rejects XML request bodies
+func (x *XMLMarshaler) NewDecoder(r io.Reader) runtime.Decoder {
+	return runtime.DecoderFunc(func(interface{}) error {
+		return fmt.Errorf("xml request bodies are not supported, send JSON")
+	})
+}
+
+// Unmarshal rejects XML request bodies
+func (x *XMLMarshaler) Unmarshal(data []byte, v interface{}) error {
+	return fmt.Errorf("xml request bodies are not supported, send JSON")
+}
+
+// ContentType returns application/xml, or the content type of raw bodies
+func (x *XMLMarshaler) ContentType(v interface{}) string {
+	if body, ok := unwrapHTTPBody(v); ok && body.GetContentType() != "" {
+		return body.GetContentType()
+	}
+	return XMLContentType + "; charset=utf-8"
+}
@@ -0,0 +1,58 @@
+package runtime
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestXMLMarshaler_Marshal(t *testing.T) {
+	xm := NewXMLMarshaler()
+
+	data, _ := structpb.NewStruct(map[string]interface{}{
+		"name":  "Kopi <Susu> & Roti",
+		"tags":  []interface{}{"drink", "hot"},
+		"price": 18000,
+		"attrs": map[string]interface{}{"size L": "large"},
+	})
+	out, err := xm.Marshal(&Response{RequestID: "req-1", Body: data})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	if err := xml.Unmarshal(out, new(interface{})); err != nil {
+		t.Fatalf("response is not well-formed XML: %v\n%s", err, out)
+	}
+	for _, want := range []string{
+		"<response><status>200</status><message>success</message><data>",
+		"<name>Kopi &lt;Susu&gt; &amp; Roti</name>",
+		"<tags><item>drink</item><item>hot</item></tags>",
+		"<price>18000</price>",
+		`<entry key="size L">large</entry>`,
+		"<request_id>req-1</request_id></response>",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %s in %s", want, out)
+		}
+	}
+	if ct := xm.ContentType(data); ct != "application/xml; charset=utf-8" {
+		t.Errorf("expected application/xml, got %s", ct)
+	}
+}
+
+func TestXMLMarshaler_Marshal_Error(t *testing.T) {
+	xm := NewXMLMarshaler()
+
+	out, err := xm.Marshal(&status.Status{Code: 5, Message: "order not found"})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	for _, want := range []string{"<status>404</status>", "<message>order not found</message>", "<data/>"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %s in %s", want, out)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Package security writes the security events of the gateway (authentication failures, rate-limit
+// hits, IP bans, signature verification failures) to a dedicated stream with consistent fields, so a
+// SIEM can ingest them without parsing the application logs
+package security
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/kafka"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-pkg/logger"
+	pkgMiddleware "github.com/fekuna/omnipos-pkg/middleware"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Event types
+const (
+	AuthFailure      = "auth_failure"
+	RateLimited      = "rate_limited"
+	IPBlocked        = "ip_blocked"
+	IPBanned         = "ip_banned"
+	IPUnbanned       = "ip_unbanned"
+	SignatureInvalid = "signature_invalid"
+)
+
+// Event is a security event. Every event carries the same fields, empty when they don't apply.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Source    string    `json:"source"` // component that raised it, e.g. "rate_limit" or "webhook:stripe"
+	Reason    string    `json:"reason"`
+	IP        string    `json:"ip"`
+	Principal string    `json:"principal"` // merchant, device or IP the event is attributed to
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	RequestID string    `json:"request_id"`
+}
+
+// stream writes the events to the security log and queues them for Kafka
+type stream struct {
+	cfg      config.SecurityLogConfig
+	out      *zap.Logger
+	producer *kafka.Producer
+	queue    chan Event
+	logger   logger.ZapLogger
+}
+
+var current struct {
+	sync.RWMutex
+	stream *stream
+}
+
+// Configure opens the security log; events are discarded until it's configured, or when disabled
+func Configure(cfg config.SecurityLogConfig, log logger.ZapLogger) error {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	var out zapcore.WriteSyncer
+	switch cfg.Output {
+	case "", "stdout":
+		out = zapcore.Lock(os.Stdout)
+	case "stderr":
+		out = zapcore.Lock(os.Stderr)
+	default:
+		f, err := os.OpenFile(cfg.Output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+		if err != nil {
+			return fmt.Errorf("failed to open security log: %w", err)
+		}
+		out = zapcore.Lock(f)
+	}
+
+	encoderCfg := zap.NewProductionEncoderConfig()
+	encoderCfg.TimeKey = ""
+	encoderCfg.LevelKey = ""
+	encoderCfg.MessageKey = ""
+
+	s := &stream{
+		cfg:    cfg,
+		out:    zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoderCfg), out, zapcore.InfoLevel)).With(zap.String("log", "security")),
+		logger: log,
+	}
+	if cfg.KafkaREST != "" {
+		s.producer = kafka.NewProducer(cfg.KafkaREST, cfg.KafkaTopic, cfg.Timeout)
+		s.queue = make(chan Event, cfg.QueueSize)
+	}
+
+	current.Lock()
+	current.stream = s
+	current.Unlock()
+	return nil
+}
+
+// Record writes event, completing its time and request ID
+func Record(ctx context.Context, event Event) {
+	metrics.SecurityEvents.WithLabelValues(event.Type).Inc()
+
+	current.RLock()
+	s := current.stream
+	current.RUnlock()
+	if s == nil {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if event.RequestID == "" {
+		event.RequestID = pkgMiddleware.GetRequestID(ctx)
+	}
+
+	s.out.Info("",
+		zap.String("time", event.Time.Format(time.RFC3339Nano)),
+		zap.String("type", event.Type),
+		zap.String("source", event.Source),
+		zap.String("reason", event.Reason),
+		zap.String("ip", event.IP),
+		zap.String("principal", event.Principal),
+		zap.String("method", event.Method),
+		zap.String("path", event.Path),
+		zap.String("request_id", event.RequestID),
+	)
+
+	if s.queue == nil {
+		return
+	}
+	select {
+	case s.queue <- event:
+	default:
+		metrics.SecurityEventExports.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Run produces the queued events to Kafka in batches until ctx is cancelled
+func Run(ctx context.Context) {
+	current.RLock()
+	s := current.stream
+	current.RUnlock()
+	if s == nil || s.queue == nil {
+		return
+	}
+
+	for {
+		var batch []Event
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.queue:
+			batch = append(batch, event)
+		}
+	drain:
+		for len(batch) < max(s.cfg.BatchSize, 1) {
+			select {
+			case event := <-s.queue:
+				batch = append(batch, event)
+			default:
+				break drain
+			}
+		}
+
+		if err := s.produce(ctx, batch); err != nil {
+			metrics.SecurityEventExports.WithLabelValues("failed").Add(float64(len(batch)))
+			s.logger.Warn("failed to produce security events", zap.Int("events", len(batch)), zap.Error(err))
+			continue
+		}
+		metrics.SecurityEventExports.WithLabelValues("sent").Add(float64(len(batch)))
+	}
+}
+
+// produce sends a batch to the topic, keyed by IP so the events of a client stay ordered
+func (s *stream) produce(ctx context.Context, batch []Event) error {
+	records := make([]kafka.Record, len(batch))
+	for i, event := range batch {
+		records[i] = kafka.Record{Key: event.IP, Value: event}
+	}
+	return s.producer.Produce(ctx, records)
+}
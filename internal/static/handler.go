@@ -0,0 +1,178 @@
+// Package static serves small merchant-facing assets (receipt logos, the web POS bundle) so small
+// deployments don't need a separate web server
+package static
+
+import (
+	"compress/gzip"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// embeddedFS holds the public directory, without its dotfiles
+//
+//go:embed public
+var embeddedFS embed.FS
+
+// fingerprinted matches file names with a content hash, e.g. "app.3f9a2c1b.js", which never change
+var fingerprinted = regexp.MustCompile(`\.[0-9a-f]{8,}\.[a-z0-9]+$`)
+
+// compressible types are gzipped on the fly when no precompressed .gz file exists
+var compressible = []string{"text/", "application/javascript", "application/json", "application/xml", "image/svg+xml"}
+
+// Handler serves a static directory, or the embedded public directory, under the configured path.
+// Precompressed "<file>.gz" siblings are served to clients accepting gzip.
+type Handler struct {
+	cfg    config.StaticConfig
+	files  fs.FS
+	logger logger.ZapLogger
+}
+
+// NewHandler creates a new static asset handler
+func NewHandler(cfg config.StaticConfig, log logger.ZapLogger) (*Handler, error) {
+	var files fs.FS
+	if cfg.Dir != "" {
+		info, err := os.Stat(cfg.Dir)
+		if err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("static directory %s not found", cfg.Dir)
+		}
+		files = os.DirFS(cfg.Dir)
+	} else {
+		sub, err := fs.Sub(embeddedFS, "public")
+		if err != nil {
+			return nil, err
+		}
+		files = sub
+	}
+
+	return &Handler{
+		cfg:    cfg,
+		files:  files,
+		logger: log,
+	}, nil
+}
+
+// RegisterRoutes registers the static route
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.Handle(h.cfg.Path, http.StripPrefix(h.cfg.Path, http.HandlerFunc(h.serveFile)))
+	h.logger.Info("static assets enabled", zap.String("path", h.cfg.Path), zap.String("dir", h.cfg.Dir))
+}
+
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" || !fs.ValidPath(name) || hidden(name) {
+		customRuntime.WriteResponse(w, http.StatusNotFound, "not found", nil)
+		return
+	}
+
+	file, info, ok := h.open(name)
+	if !ok {
+		customRuntime.WriteResponse(w, http.StatusNotFound, "not found", nil)
+		return
+	}
+	defer file.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Vary", "Accept-Encoding")
+	h.setCacheHeaders(w, name)
+
+	acceptsGzip := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+
+	if acceptsGzip {
+		if gzFile, gzInfo, ok := h.open(name + ".gz"); ok {
+			defer gzFile.Close()
+			w.Header().Set("Content-Encoding", "gzip")
+			http.ServeContent(w, r, name, gzInfo.ModTime(), gzFile)
+			return
+		}
+
+		if isCompressible(contentType) && info.Size() >= int64(h.cfg.GzipMinBytes) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Header().Del("Content-Length")
+			w.WriteHeader(http.StatusOK)
+			if r.Method == http.MethodHead {
+				return
+			}
+
+			gz := gzip.NewWriter(w)
+			defer gz.Close()
+			if _, err := io.Copy(gz, file); err != nil {
+				h.logger.Warn("failed to write static file", zap.String("file", name), zap.Error(err))
+			}
+			return
+		}
+	}
+
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
+
+// open opens a regular file; directories are never listed
+func (h *Handler) open(name string) (io.ReadSeekCloser, fs.FileInfo, bool) {
+	file, err := h.files.Open(name)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, nil, false
+	}
+
+	seeker, ok := file.(io.ReadSeekCloser)
+	if !ok {
+		file.Close()
+		return nil, nil, false
+	}
+	return seeker, info, true
+}
+
+func (h *Handler) setCacheHeaders(w http.ResponseWriter, name string) {
+	if fingerprinted.MatchString(name) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.cfg.MaxAge.Seconds())))
+}
+
+// hidden reports whether a path lies in or is a dotfile (.env, .git/config, .htpasswd), which a
+// STATIC_DIR may contain but is never served
+func hidden(name string) bool {
+	for _, segment := range strings.Split(name, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func isCompressible(contentType string) bool {
+	for _, prefix := range compressible {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
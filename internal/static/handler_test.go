@@ -0,0 +1,75 @@
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-pkg/logger"
+)
+
+func TestHandler_EmbeddedDotfiles(t *testing.T) {
+	testDotfiles(t, "", []dotfileTest{
+		{"/static/README.md", http.StatusOK},
+		{"/static/.gitkeep", http.StatusNotFound},
+	})
+}
+
+func TestHandler_DirDotfiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"app.js":            "console.log(1)",
+		".env":              "JWT_SECRET_KEY=secret",
+		".htpasswd":         "admin:$apr1$",
+		".git/config":       "[core]",
+		"logos/.DS_Store":   "",
+		".well-known/x.txt": "x",
+	} {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testDotfiles(t, dir, []dotfileTest{
+		{"/static/app.js", http.StatusOK},
+		{"/static/.env", http.StatusNotFound},
+		{"/static/.htpasswd", http.StatusNotFound},
+		{"/static/.git/config", http.StatusNotFound},
+		{"/static/logos/.DS_Store", http.StatusNotFound},
+		{"/static/.well-known/x.txt", http.StatusNotFound},
+	})
+}
+
+type dotfileTest struct {
+	path       string
+	wantStatus int
+}
+
+func testDotfiles(t *testing.T, dir string, tests []dotfileTest) {
+	t.Helper()
+	handler, err := NewHandler(config.StaticConfig{Enabled: true, Path: "/static/", Dir: dir, MaxAge: time.Hour}, logger.NewZapLogger(&logger.ZapLoggerConfig{}))
+	if err != nil {
+		t.Fatalf("NewHandler: %v", err)
+	}
+	mux := http.NewServeMux()
+	handler.RegisterRoutes(mux)
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected %d, got %d", tt.wantStatus, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+// Package stats serves a JSON snapshot of the gateway (uptime, in-flight requests, per-route counters,
+// limiter stats, backend connections) for lightweight dashboards and curl-based diagnostics where
+// Prometheus isn't deployed. The counters are read from the gateway metrics, since process start.
+package stats
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/internal/backend"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Snapshot is the state of the gateway instance
+type Snapshot struct {
+	StartedAt     time.Time            `json:"started_at"`
+	UptimeSeconds int64                `json:"uptime_seconds"`
+	InFlight      int64                `json:"in_flight"`
+	Routes        []Route              `json:"routes"`
+	Limiters      Limiters             `json:"limiters"`
+	Connections   []backend.Connection `json:"connections"`
+}
+
+// Route holds the counters of a route (gRPC method, or "unmatched")
+type Route struct {
+	Route         string           `json:"route"`
+	Requests      int64            `json:"requests"`
+	Codes         map[string]int64 `json:"codes"` // requests by status code
+	MeanLatencyMS float64          `json:"mean_latency_ms"`
+}
+
+// Limiters holds the limiter stats
+type Limiters struct {
+	RateLimit       map[string]int64            `json:"rate_limit"` // decisions by result
+	Concurrency     middleware.ConcurrencyStats `json:"concurrency"`
+	CircuitBreakers map[string]string           `json:"circuit_breakers"` // state by backend
+}
+
+var circuitStates = map[float64]string{0: "closed", 1: "half-open", 2: "open"}
+
+// Handler serves the snapshot
+type Handler struct {
+	connManager        *backend.Manager
+	concurrencyLimiter *middleware.ConcurrencyLimiter
+	startedAt          time.Time
+}
+
+// NewHandler creates the stats handler; startedAt is the start of the process
+func NewHandler(connManager *backend.Manager, concurrencyLimiter *middleware.ConcurrencyLimiter, startedAt time.Time) *Handler {
+	return &Handler{connManager: connManager, concurrencyLimiter: concurrencyLimiter, startedAt: startedAt}
+}
+
+// RegisterAdminRoutes registers /stats behind adminAuth
+func (h *Handler) RegisterAdminRoutes(mux *http.ServeMux, adminAuth func(http.Handler) http.Handler) {
+	mux.Handle("/stats", adminAuth(http.HandlerFunc(h.serveStats)))
+}
+
+func (h *Handler) serveStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+	customRuntime.WriteResponse(w, http.StatusOK, "success", h.Snapshot())
+}
+
+// Snapshot returns the current state of the gateway
+func (h *Handler) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		StartedAt:     h.startedAt.UTC(),
+		UptimeSeconds: int64(time.Since(h.startedAt).Seconds()),
+		Routes:        routes(),
+		Limiters: Limiters{
+			RateLimit:       make(map[string]int64),
+			Concurrency:     h.concurrencyLimiter.Stats(),
+			CircuitBreakers: make(map[string]string),
+		},
+		Connections: h.connManager.Connections(),
+	}
+
+	for _, m := range collect(metrics.HTTPInFlight) {
+		snapshot.InFlight = int64(m.GetGauge().GetValue())
+	}
+	for _, m := range collect(metrics.RateLimitRequests) {
+		snapshot.Limiters.RateLimit[label(m, "result")] += int64(m.GetCounter().GetValue())
+	}
+	for _, m := range collect(metrics.CircuitBreakerState) {
+		snapshot.Limiters.CircuitBreakers[label(m, "backend")] = circuitStates[m.GetGauge().GetValue()]
+	}
+	return snapshot
+}
+
+// routes sums the request counters of each route over merchants, sorted by route
+func routes() []Route {
+	byRoute := make(map[string]*Route)
+	route := func(name string) *Route {
+		if _, ok := byRoute[name]; !ok {
+			byRoute[name] = &Route{Route: name, Codes: make(map[string]int64)}
+		}
+		return byRoute[name]
+	}
+
+	for _, m := range collect(metrics.HTTPRequests) {
+		r := route(label(m, "route"))
+		count := int64(m.GetCounter().GetValue())
+		r.Requests += count
+		r.Codes[label(m, "code")] += count
+	}
+	for _, m := range collect(metrics.HTTPRequestDuration) {
+		h := m.GetHistogram()
+		if h.GetSampleCount() > 0 {
+			route(label(m, "route")).MeanLatencyMS = h.GetSampleSum() / float64(h.GetSampleCount()) * 1000
+		}
+	}
+
+	routes := make([]Route, 0, len(byRoute))
+	for _, r := range byRoute {
+		routes = append(routes, *r)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Route < routes[j].Route })
+	return routes
+}
+
+// collect returns the current values of the series of c
+func collect(c prometheus.Collector) []*dto.Metric {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	var series []*dto.Metric
+	for metric := range ch {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err == nil {
+			series = append(series, m)
+		}
+	}
+	return series
+}
+
+func label(m *dto.Metric, name string) string {
+	for _, pair := range m.GetLabel() {
+		if pair.GetName() == name {
+			return pair.GetValue()
+		}
+	}
+	return ""
+}
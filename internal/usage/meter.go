@@ -0,0 +1,363 @@
+// Package usage meters the requests and bytes of each merchant or API key per day for billing, and
+// exports the completed days to a file or the audit service
+package usage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/audit"
+	"github.com/fekuna/omnipos-gateway/internal/metrics"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// Daily counters are Redis hashes "usage:<day>:<principal>" (fields requests, bytes_in, bytes_out), listed
+// in the set "usage:<day>:principals"; "usage:exported:<day>" marks the days already exported by a replica
+const (
+	dayLayout    = "2006-01-02"
+	keyPrefix    = "usage:"
+	exportedKey  = "usage:exported:"
+	maxQueryDays = 366
+	exportDays   = 7 // completed days looked back at for exports missed while no replica was running
+)
+
+// Usage is the usage of a principal ("merchant:<id>" or "key:<hash>") on a day
+type Usage struct {
+	Principal  string `json:"principal"`
+	MerchantID string `json:"merchant_id,omitempty"`
+	Day        string `json:"day"`
+	Requests   int64  `json:"requests"`
+	BytesIn    int64  `json:"bytes_in"`
+	BytesOut   int64  `json:"bytes_out"`
+}
+
+type counterKey struct {
+	day       string
+	principal string
+}
+
+type counters struct {
+	requests, bytesIn, bytesOut int64
+}
+
+// Meter counts requests in memory and adds the counters up in Redis every cfg.FlushInterval, so
+// metering doesn't cost a Redis round trip per request
+type Meter struct {
+	redisClient *cache.RedisClient
+	jwtHelper   *middleware.JWTHelper
+	forwarder   *audit.Forwarder // for the audit export
+	cfg         config.UsageConfig
+	logger      logger.ZapLogger
+
+	mu      sync.Mutex
+	pending map[counterKey]*counters
+}
+
+// NewMeter creates a usage meter; forwarder is only used by the audit export
+func NewMeter(redisClient *cache.RedisClient, jwtHelper *middleware.JWTHelper, forwarder *audit.Forwarder, cfg config.UsageConfig, log logger.ZapLogger) (*Meter, error) {
+	switch cfg.Export {
+	case "", "file":
+	case "audit":
+		if forwarder == nil {
+			return nil, fmt.Errorf("the audit usage export needs the audit service")
+		}
+	default:
+		return nil, fmt.Errorf("unknown usage export %q", cfg.Export)
+	}
+
+	return &Meter{
+		redisClient: redisClient,
+		jwtHelper:   jwtHelper,
+		forwarder:   forwarder,
+		cfg:         cfg,
+		logger:      log,
+		pending:     make(map[counterKey]*counters),
+	}, nil
+}
+
+// Track counts the requests of merchants and API key callers with their body sizes
+func (m *Meter) Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := m.principal(r)
+		if principal == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := middleware.NewStatusRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		key := counterKey{day: time.Now().UTC().Format(dayLayout), principal: principal}
+		m.mu.Lock()
+		c, ok := m.pending[key]
+		if !ok {
+			c = &counters{}
+			m.pending[key] = c
+		}
+		c.requests++
+		c.bytesIn += max(r.ContentLength, 0)
+		c.bytesOut += rec.Bytes
+		m.mu.Unlock()
+	})
+}
+
+// principal identifies the caller by merchant, or by a hash of its API key so keys never reach Redis
+func (m *Meter) principal(r *http.Request) (principal, merchantID string) {
+	if claims, err := m.jwtHelper.RequestClaims(r); err == nil {
+		return "merchant:" + claims.MerchantID, claims.MerchantID
+	}
+	if key := r.Header.Get(middleware.APIKeyHeader); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "key:" + hex.EncodeToString(sum[:8]), ""
+	}
+	return "", ""
+}
+
+// Run flushes the counters every cfg.FlushInterval and exports the completed days every
+// cfg.ExportInterval until ctx is cancelled, then flushes one last time
+func (m *Meter) Run(ctx context.Context) {
+	flush := time.NewTicker(m.cfg.FlushInterval)
+	defer flush.Stop()
+
+	var exports <-chan time.Time
+	if m.cfg.Export != "" {
+		ticker := time.NewTicker(m.cfg.ExportInterval)
+		defer ticker.Stop()
+		exports = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			m.flush(flushCtx)
+			cancel()
+			return
+		case <-flush.C:
+			m.flush(ctx)
+		case <-exports:
+			m.exportCompletedDays(ctx)
+		}
+	}
+}
+
+// flush adds the buffered counters up in Redis; they're kept for the next flush when Redis fails
+func (m *Meter) flush(ctx context.Context) {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[counterKey]*counters)
+	m.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	pipe := m.redisClient.Client.Pipeline()
+	for key, c := range pending {
+		hash := keyPrefix + key.day + ":" + key.principal
+		principals := keyPrefix + key.day + ":principals"
+		pipe.HIncrBy(ctx, hash, "requests", c.requests)
+		pipe.HIncrBy(ctx, hash, "bytes_in", c.bytesIn)
+		pipe.HIncrBy(ctx, hash, "bytes_out", c.bytesOut)
+		pipe.SAdd(ctx, principals, key.principal)
+		pipe.Expire(ctx, hash, m.cfg.Retention)
+		pipe.Expire(ctx, principals, m.cfg.Retention)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		m.logger.Warn("failed to flush usage counters, retrying on the next flush", zap.Int("counters", len(pending)), zap.Error(err))
+		m.mu.Lock()
+		for key, c := range pending {
+			if current, ok := m.pending[key]; ok {
+				c.requests += current.requests
+				c.bytesIn += current.bytesIn
+				c.bytesOut += current.bytesOut
+			}
+			m.pending[key] = c
+		}
+		m.mu.Unlock()
+	}
+}
+
+// exportCompletedDays exports the days that ended (with a margin for the last flushes of every replica)
+// and weren't exported yet; one replica exports each day
+func (m *Meter) exportCompletedDays(ctx context.Context) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if time.Since(today) < 2*m.cfg.FlushInterval+time.Minute {
+		today = today.AddDate(0, 0, -1)
+	}
+
+	for i := 1; i <= exportDays; i++ {
+		day := today.AddDate(0, 0, -i).Format(dayLayout)
+		claimed, err := m.redisClient.Client.SetNX(ctx, exportedKey+day, time.Now().UTC().Format(time.RFC3339), m.cfg.Retention).Result()
+		if err != nil {
+			m.logger.Warn("failed to claim the usage export", zap.String("day", day), zap.Error(err))
+			return
+		}
+		if !claimed {
+			continue
+		}
+
+		if err := m.export(ctx, day); err != nil {
+			metrics.UsageExports.WithLabelValues("failed").Inc()
+			m.logger.Error("failed to export usage, retrying on the next export", zap.String("day", day), zap.Error(err))
+			m.redisClient.Client.Del(ctx, exportedKey+day)
+			continue
+		}
+	}
+}
+
+func (m *Meter) export(ctx context.Context, day string) error {
+	principals, err := m.redisClient.Client.SMembers(ctx, keyPrefix+day+":principals").Result()
+	if err != nil {
+		return err
+	}
+
+	records := make([]Usage, 0, len(principals))
+	for _, principal := range principals {
+		usage, err := m.read(ctx, principal, day)
+		if err != nil {
+			return err
+		}
+		records = append(records, usage)
+	}
+
+	switch m.cfg.Export {
+	case "file":
+		if err := m.exportFile(records); err != nil {
+			return err
+		}
+	case "audit":
+		for _, usage := range records {
+			m.forwarder.Forward(audit.Record{
+				ActorID:    "gateway",
+				MerchantID: usage.MerchantID,
+				Action:     "usage.daily",
+				Resource:   usage.Principal,
+				Summary:    fmt.Sprintf("%s: %d requests, %d bytes in, %d bytes out", usage.Day, usage.Requests, usage.BytesIn, usage.BytesOut),
+				Result:     "metered",
+				Time:       time.Now().UTC(),
+			})
+		}
+	}
+
+	metrics.UsageExports.WithLabelValues("sent").Add(float64(len(records)))
+	m.logger.Info("usage exported", zap.String("day", day), zap.String("export", m.cfg.Export), zap.Int("principals", len(records)))
+	return nil
+}
+
+// exportFile appends the records as JSON lines
+func (m *Meter) exportFile(records []Usage) error {
+	if err := os.MkdirAll(filepath.Dir(m.cfg.ExportFile), 0o750); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(m.cfg.ExportFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, usage := range records {
+		if err := enc.Encode(usage); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// read returns the usage of principal on day from Redis
+func (m *Meter) read(ctx context.Context, principal, day string) (Usage, error) {
+	usage := Usage{Principal: principal, Day: day}
+	if merchantID, ok := strings.CutPrefix(principal, "merchant:"); ok {
+		usage.MerchantID = merchantID
+	}
+
+	fields, err := m.redisClient.Client.HGetAll(ctx, keyPrefix+day+":"+principal).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return usage, err
+	}
+	usage.Requests, _ = strconv.ParseInt(fields["requests"], 10, 64)
+	usage.BytesIn, _ = strconv.ParseInt(fields["bytes_in"], 10, 64)
+	usage.BytesOut, _ = strconv.ParseInt(fields["bytes_out"], 10, 64)
+	return usage, nil
+}
+
+// RegisterRoutes registers the usage route
+func (m *Meter) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc(m.cfg.Path, m.serveUsage)
+}
+
+// serveUsage returns the daily usage of the caller from ?from= to ?to= (YYYY-MM-DD, the last 30 days by
+// default). Today's counters lag by up to the flush interval.
+func (m *Meter) serveUsage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	principal, _ := m.principal(r)
+	if principal == "" {
+		customRuntime.WriteResponse(w, http.StatusUnauthorized, "missing or invalid authorization header", nil)
+		return
+	}
+
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	from := to.AddDate(0, 0, -29)
+	var err error
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = time.Parse(dayLayout, v); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid to date, expected YYYY-MM-DD", nil)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = time.Parse(dayLayout, v); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid from date, expected YYYY-MM-DD", nil)
+			return
+		}
+	}
+	if from.After(to) || to.Sub(from) >= maxQueryDays*24*time.Hour {
+		customRuntime.WriteResponse(w, http.StatusBadRequest, fmt.Sprintf("the date range must span 1 to %d days", maxQueryDays), nil)
+		return
+	}
+
+	ctx := r.Context()
+	days := []Usage{}
+	total := Usage{Principal: principal, Day: from.Format(dayLayout) + "/" + to.Format(dayLayout)}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		usage, err := m.read(ctx, principal, day.Format(dayLayout))
+		if err != nil {
+			m.logger.Error("failed to read usage", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to read usage", nil)
+			return
+		}
+		total.MerchantID = usage.MerchantID
+		total.Requests += usage.Requests
+		total.BytesIn += usage.BytesIn
+		total.BytesOut += usage.BytesOut
+		if usage.Requests > 0 {
+			days = append(days, usage)
+		}
+	}
+
+	customRuntime.WriteResponse(w, http.StatusOK, "success", map[string]interface{}{
+		"days":  days,
+		"total": total,
+	})
+}
@@ -0,0 +1,660 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-gateway/internal/security"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// eventsStream is the Redis stream of events to deliver; backends can add events to it directly
+	// with a single "event" field holding an OutboundEvent
+	eventsStream  = "webhooks:events"
+	consumerGroup = "gateway"
+	// retriesKey is a Redis sorted set of the pending deliveries, first attempts and retries, scored by
+	// their next attempt (unix ms)
+	retriesKey = "webhooks:retries"
+	// claimIdle is how long an event stays unacknowledged before another replica takes it over, e.g.
+	// after its consumer crashed
+	claimIdle = time.Minute
+
+	// SignatureHeader signs deliveries: "t=<unix>,v1=<hex HMAC-SHA256(secret, "<t>.<body>")>"
+	SignatureHeader = "X-Omnipos-Signature"
+)
+
+// Endpoint is a merchant's webhook subscription
+type Endpoint struct {
+	ID         string    `json:"id"`
+	MerchantID string    `json:"merchant_id"`
+	URL        string    `json:"url"`
+	Events     []string  `json:"events"` // event types, or "*" for all
+	Secret     string    `json:"secret,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// OutboundEvent is an order/payment event delivered to merchant endpoints
+type OutboundEvent struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"` // e.g. "order.created"
+	MerchantID string          `json:"merchant_id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// Delivery is an entry of the delivery log
+type Delivery struct {
+	EventID     string     `json:"event_id"`
+	EventType   string     `json:"event_type"`
+	EndpointID  string     `json:"endpoint_id"`
+	URL         string     `json:"url"`
+	Attempt     int        `json:"attempt"`
+	StatusCode  int        `json:"status_code,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Success     bool       `json:"success"`
+	AttemptedAt time.Time  `json:"attempted_at"`
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty"`
+}
+
+// retryJob is a delivery waiting for its next attempt, the first one included
+type retryJob struct {
+	Event      *OutboundEvent `json:"event"`
+	EndpointID string         `json:"endpoint_id"`
+	Attempt    int            `json:"attempt"` // the upcoming attempt
+}
+
+// Publisher receives every event the dispatcher consumes, e.g. to serve them to polling clients
+type Publisher interface {
+	Publish(ctx context.Context, merchantID, eventType string, event []byte) error
+}
+
+// Dispatcher delivers order/payment events to the webhook URLs registered by merchants. Events are
+// emitted on successful proxied mutations (configured gRPC methods) or added to the Redis stream by
+// backends; deliveries are signed and retried with exponential backoff, and every attempt is logged.
+type Dispatcher struct {
+	redisClient *cache.RedisClient
+	jwtHelper   *middleware.JWTHelper
+	publisher   Publisher
+	cfg         config.OutboundWebhookConfig
+	client      *http.Client
+	consumer    string
+	logger      logger.ZapLogger
+}
+
+// NewDispatcher creates a new webhook dispatcher. Webhooks are only delivered when cfg is enabled;
+// publisher is optional.
+func NewDispatcher(redisClient *cache.RedisClient, jwtHelper *middleware.JWTHelper, publisher Publisher, cfg config.OutboundWebhookConfig, log logger.ZapLogger) *Dispatcher {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateTargets {
+		dialer.Control = denyPrivateAddresses
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	hostname, _ := os.Hostname()
+	return &Dispatcher{
+		redisClient: redisClient,
+		jwtHelper:   jwtHelper,
+		publisher:   publisher,
+		cfg:         cfg,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			// Redirects could point deliveries anywhere
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		consumer: hostname + "-" + randomID(4),
+		logger:   log,
+	}
+}
+
+// ForwardResponse is a grpc-gateway forward response option emitting an event when one of the
+// configured methods succeeds, with the response as event data
+func (d *Dispatcher) ForwardResponse(ctx context.Context, w http.ResponseWriter, resp proto.Message) error {
+	method, ok := runtime.RPCMethod(ctx)
+	if !ok {
+		return nil
+	}
+	eventType, ok := d.cfg.Events[method]
+	if !ok {
+		return nil
+	}
+
+	merchantID := d.merchantFromContext(ctx)
+	if merchantID == "" {
+		return nil
+	}
+
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(resp)
+	if err != nil {
+		d.logger.Error("failed to encode webhook event", zap.String("method", method), zap.Error(err))
+		return nil
+	}
+
+	event := &OutboundEvent{
+		ID:         "evt_" + randomID(12),
+		Type:       eventType,
+		MerchantID: merchantID,
+		CreatedAt:  time.Now().UTC(),
+		Data:       data,
+	}
+	if err := d.Emit(context.WithoutCancel(ctx), event); err != nil {
+		d.logger.Error("failed to emit webhook event", zap.String("type", eventType), zap.Error(err))
+	}
+	return nil
+}
+
+// Emit queues an event for delivery
+func (d *Dispatcher) Emit(ctx context.Context, event *OutboundEvent) error {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return d.redisClient.Client.XAdd(ctx, &redis.XAddArgs{
+		Stream: eventsStream,
+		MaxLen: int64(d.cfg.StreamMaxLen),
+		Approx: true,
+		Values: map[string]interface{}{"event": value},
+	}).Err()
+}
+
+// Run consumes queued events and delivers them until ctx is cancelled. Events are only scheduled here;
+// the attempts are made by a pool of cfg.Workers, so slow endpoints don't hold up other merchants.
+func (d *Dispatcher) Run(ctx context.Context) {
+	err := d.redisClient.Client.XGroupCreateMkStream(ctx, eventsStream, consumerGroup, "$").Err()
+	if err != nil && !strings.Contains(err.Error(), "BUSYGROUP") {
+		d.logger.Error("failed to create webhook consumer group", zap.Error(err))
+	}
+
+	go d.runRetries(ctx)
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= claimIdle/2 {
+			lastClaim = time.Now()
+			d.claimStale(ctx)
+		}
+
+		streams, err := d.redisClient.Client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    consumerGroup,
+			Consumer: d.consumer,
+			Streams:  []string{eventsStream, ">"},
+			Count:    10,
+			Block:    5 * time.Second,
+		}).Result()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+				d.logger.Error("failed to read webhook events", zap.Error(err))
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		for _, stream := range streams {
+			d.consume(ctx, stream.Messages)
+		}
+	}
+}
+
+// claimStale takes over the events left unacknowledged by consumers that went away
+func (d *Dispatcher) claimStale(ctx context.Context) {
+	start := "0-0"
+	for ctx.Err() == nil {
+		messages, next, err := d.redisClient.Client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   eventsStream,
+			Group:    consumerGroup,
+			Consumer: d.consumer,
+			MinIdle:  claimIdle,
+			Start:    start,
+			Count:    100,
+		}).Result()
+		if err != nil {
+			if ctx.Err() == nil {
+				d.logger.Error("failed to claim stale webhook events", zap.Error(err))
+			}
+			return
+		}
+		if len(messages) > 0 {
+			d.logger.Info("claimed stale webhook events", zap.Int("count", len(messages)))
+		}
+		d.consume(ctx, messages)
+		if next == "0-0" {
+			return
+		}
+		start = next
+	}
+}
+
+// consume fans the events out and acknowledges them
+func (d *Dispatcher) consume(ctx context.Context, messages []redis.XMessage) {
+	for _, msg := range messages {
+		d.fanOut(ctx, msg)
+		if err := d.redisClient.Client.XAck(ctx, eventsStream, consumerGroup, msg.ID).Err(); err != nil {
+			d.logger.Warn("failed to ack webhook event", zap.String("id", msg.ID), zap.Error(err))
+		}
+	}
+}
+
+// fanOut publishes the event and schedules its first delivery to every endpoint subscribed to it
+func (d *Dispatcher) fanOut(ctx context.Context, msg redis.XMessage) {
+	raw, _ := msg.Values["event"].(string)
+
+	var event OutboundEvent
+	if err := json.Unmarshal([]byte(raw), &event); err != nil || event.MerchantID == "" || event.Type == "" {
+		d.logger.Warn("ignoring invalid webhook event", zap.String("id", msg.ID), zap.Error(err))
+		return
+	}
+	if event.ID == "" {
+		event.ID = "evt_" + msg.ID
+	}
+
+	if d.publisher != nil {
+		value, _ := json.Marshal(event)
+		if err := d.publisher.Publish(ctx, event.MerchantID, event.Type, value); err != nil {
+			d.logger.Warn("failed to publish event", zap.String("event_id", event.ID), zap.Error(err))
+		}
+	}
+	if !d.cfg.Enabled {
+		return
+	}
+
+	endpoints, err := d.endpoints(ctx, event.MerchantID)
+	if err != nil {
+		d.logger.Error("failed to load webhook endpoints", zap.String("merchant_id", event.MerchantID), zap.Error(err))
+		return
+	}
+
+	for _, endpoint := range endpoints {
+		if slices.Contains(endpoint.Events, "*") || slices.Contains(endpoint.Events, event.Type) {
+			d.schedule(ctx, retryJob{Event: &event, EndpointID: endpoint.ID, Attempt: 1}, time.Now())
+		}
+	}
+}
+
+// schedule queues the next attempt of a delivery
+func (d *Dispatcher) schedule(ctx context.Context, job retryJob, at time.Time) {
+	member, _ := json.Marshal(job)
+	if err := d.redisClient.Client.ZAdd(ctx, retriesKey, redis.Z{Score: float64(at.UnixMilli()), Member: member}).Err(); err != nil {
+		d.logger.Error("failed to schedule webhook delivery", zap.String("event_id", job.Event.ID), zap.Int("attempt", job.Attempt), zap.Error(err))
+	}
+}
+
+// runRetries hands the deliveries that are due to the workers
+func (d *Dispatcher) runRetries(ctx context.Context) {
+	jobs := make(chan retryJob)
+	for range max(d.cfg.Workers, 1) {
+		go func() {
+			for job := range jobs {
+				endpoint, err := d.endpoint(ctx, job.Event.MerchantID, job.EndpointID)
+				if err != nil {
+					// Deleted since the event was scheduled
+					continue
+				}
+				d.attempt(ctx, job.Event, endpoint, job.Attempt)
+			}
+		}()
+	}
+	defer close(jobs)
+
+	const batch = 100
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		members, err := d.redisClient.Client.ZRangeByScore(ctx, retriesKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+			Count: batch,
+		}).Result()
+		if err != nil && ctx.Err() == nil {
+			d.logger.Error("failed to read webhook retries", zap.Error(err))
+		}
+
+		for _, member := range members {
+			// Whoever removes the job owns it, so each attempt is made on one replica only
+			if removed, err := d.redisClient.Client.ZRem(ctx, retriesKey, member).Result(); err != nil || removed == 0 {
+				continue
+			}
+
+			var job retryJob
+			if err := json.Unmarshal([]byte(member), &job); err != nil || job.Event == nil {
+				continue
+			}
+
+			// Waits for a free worker
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// A full batch means more are due, otherwise wait for the next ones
+		if len(members) == batch {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// attempt delivers an event once, logging the attempt and scheduling a retry on failure
+func (d *Dispatcher) attempt(ctx context.Context, event *OutboundEvent, endpoint *Endpoint, attempt int) {
+	delivery := Delivery{
+		EventID:     event.ID,
+		EventType:   event.Type,
+		EndpointID:  endpoint.ID,
+		URL:         endpoint.URL,
+		Attempt:     attempt,
+		AttemptedAt: time.Now().UTC(),
+	}
+
+	statusCode, err := d.post(ctx, event, endpoint)
+	delivery.StatusCode = statusCode
+	delivery.Success = err == nil && statusCode >= 200 && statusCode < 300
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+
+	if !delivery.Success && attempt < d.cfg.MaxAttempts {
+		next := time.Now().Add(d.backoff(attempt)).UTC()
+		delivery.NextRetryAt = &next
+		d.schedule(ctx, retryJob{Event: event, EndpointID: endpoint.ID, Attempt: attempt + 1}, next)
+	}
+
+	if !delivery.Success {
+		d.logger.Warn("webhook delivery failed",
+			zap.String("event_id", event.ID), zap.String("endpoint_id", endpoint.ID),
+			zap.Int("attempt", attempt), zap.Int("status_code", statusCode), zap.Error(err))
+	}
+	d.logDelivery(ctx, event.MerchantID, delivery)
+}
+
+func (d *Dispatcher) post(ctx context.Context, event *OutboundEvent, endpoint *Endpoint) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(endpoint.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "OmniPOS-Webhooks/1.0")
+	req.Header.Set("X-Omnipos-Event", event.Type)
+	req.Header.Set("X-Omnipos-Event-Id", event.ID)
+	req.Header.Set(SignatureHeader, "t="+timestamp+",v1="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	return resp.StatusCode, nil
+}
+
+// backoff doubles the delay after each failed attempt, up to MaxBackoff
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.cfg.InitialBackoff << (attempt - 1)
+	if delay <= 0 || delay > d.cfg.MaxBackoff {
+		return d.cfg.MaxBackoff
+	}
+	return delay
+}
+
+func (d *Dispatcher) logDelivery(ctx context.Context, merchantID string, delivery Delivery) {
+	value, _ := json.Marshal(delivery)
+	key := deliveriesKey(merchantID)
+
+	pipe := d.redisClient.Client.TxPipeline()
+	pipe.LPush(ctx, key, value)
+	pipe.LTrim(ctx, key, 0, int64(d.cfg.DeliveryLogSize-1))
+	if _, err := pipe.Exec(ctx); err != nil {
+		d.logger.Warn("failed to log webhook delivery", zap.Error(err))
+	}
+}
+
+// RegisterRoutes registers the endpoint management and delivery log routes, for authenticated merchants
+func (d *Dispatcher) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc(d.cfg.EndpointsPath, d.serveEndpoints)
+	mux.HandleFunc(d.cfg.DeliveriesPath, d.serveDeliveries)
+}
+
+// serveEndpoints lists (GET), registers (POST {"url", "events"}) and deletes (DELETE ?id=) webhook endpoints.
+// The signing secret is only returned on registration.
+func (d *Dispatcher) serveEndpoints(w http.ResponseWriter, r *http.Request) {
+	merchantID := d.merchantFromRequest(r)
+	if merchantID == "" {
+		middleware.RecordSecurityEvent(r, security.AuthFailure, "webhooks", "missing or invalid authorization header", "")
+		customRuntime.WriteResponse(w, http.StatusUnauthorized, "missing or invalid authorization header", nil)
+		return
+	}
+	ctx := r.Context()
+
+	switch r.Method {
+	case http.MethodGet:
+		endpoints, err := d.endpoints(ctx, merchantID)
+		if err != nil {
+			d.logger.Error("failed to list webhook endpoints", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to list webhook endpoints", nil)
+			return
+		}
+		for _, endpoint := range endpoints {
+			endpoint.Secret = ""
+		}
+		customRuntime.WriteResponse(w, http.StatusOK, "success", endpoints)
+
+	case http.MethodPost:
+		var req struct {
+			URL    string   `json:"url"`
+			Events []string `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid request body", nil)
+			return
+		}
+
+		target, err := url.Parse(req.URL)
+		if err != nil || target.Host == "" || (target.Scheme != "https" && !(d.cfg.AllowInsecureTargets && target.Scheme == "http")) {
+			customRuntime.WriteResponse(w, http.StatusBadRequest, "url must be an https URL", nil)
+			return
+		}
+		if len(req.Events) == 0 {
+			req.Events = []string{"*"}
+		}
+
+		endpoint := &Endpoint{
+			ID:         "we_" + randomID(8),
+			MerchantID: merchantID,
+			URL:        target.String(),
+			Events:     req.Events,
+			Secret:     "whsec_" + randomID(24),
+			CreatedAt:  time.Now().UTC(),
+		}
+		value, _ := json.Marshal(endpoint)
+		if err := d.redisClient.Client.HSet(ctx, endpointsKey(merchantID), endpoint.ID, value).Err(); err != nil {
+			d.logger.Error("failed to register webhook endpoint", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to register webhook endpoint", nil)
+			return
+		}
+		customRuntime.WriteResponse(w, http.StatusOK, "success", endpoint)
+
+	case http.MethodDelete:
+		if err := d.redisClient.Client.HDel(ctx, endpointsKey(merchantID), r.URL.Query().Get("id")).Err(); err != nil {
+			d.logger.Error("failed to delete webhook endpoint", zap.Error(err))
+			customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to delete webhook endpoint", nil)
+			return
+		}
+		customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+	}
+}
+
+// serveDeliveries returns the latest delivery attempts, newest first (?endpoint_id=&event_id=&limit=)
+func (d *Dispatcher) serveDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	merchantID := d.merchantFromRequest(r)
+	if merchantID == "" {
+		middleware.RecordSecurityEvent(r, security.AuthFailure, "webhooks", "missing or invalid authorization header", "")
+		customRuntime.WriteResponse(w, http.StatusUnauthorized, "missing or invalid authorization header", nil)
+		return
+	}
+
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > d.cfg.DeliveryLogSize {
+		limit = 50
+	}
+	endpointID, eventID := r.URL.Query().Get("endpoint_id"), r.URL.Query().Get("event_id")
+
+	values, err := d.redisClient.Client.LRange(r.Context(), deliveriesKey(merchantID), 0, -1).Result()
+	if err != nil {
+		d.logger.Error("failed to read webhook deliveries", zap.Error(err))
+		customRuntime.WriteResponse(w, http.StatusInternalServerError, "failed to read webhook deliveries", nil)
+		return
+	}
+
+	deliveries := make([]Delivery, 0, limit)
+	for _, value := range values {
+		var delivery Delivery
+		if err := json.Unmarshal([]byte(value), &delivery); err != nil {
+			continue
+		}
+		if (endpointID != "" && delivery.EndpointID != endpointID) || (eventID != "" && delivery.EventID != eventID) {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+		if len(deliveries) == limit {
+			break
+		}
+	}
+	customRuntime.WriteResponse(w, http.StatusOK, "success", deliveries)
+}
+
+func (d *Dispatcher) endpoints(ctx context.Context, merchantID string) ([]*Endpoint, error) {
+	values, err := d.redisClient.Client.HGetAll(ctx, endpointsKey(merchantID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoints := make([]*Endpoint, 0, len(values))
+	for _, value := range values {
+		var endpoint Endpoint
+		if err := json.Unmarshal([]byte(value), &endpoint); err != nil {
+			continue
+		}
+		endpoints = append(endpoints, &endpoint)
+	}
+	slices.SortFunc(endpoints, func(a, b *Endpoint) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return endpoints, nil
+}
+
+func (d *Dispatcher) endpoint(ctx context.Context, merchantID, id string) (*Endpoint, error) {
+	value, err := d.redisClient.Client.HGet(ctx, endpointsKey(merchantID), id).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoint Endpoint
+	if err := json.Unmarshal([]byte(value), &endpoint); err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+func (d *Dispatcher) merchantFromRequest(r *http.Request) string {
+	claims, err := d.jwtHelper.RequestClaims(r)
+	if err != nil {
+		return ""
+	}
+	return claims.MerchantID
+}
+
+// merchantFromContext returns the merchant of the token forwarded with a grpc-gateway call
+func (d *Dispatcher) merchantFromContext(ctx context.Context) string {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if claims, err := d.jwtHelper.Claims(ctx, strings.TrimPrefix(auth, "Bearer ")); err == nil {
+			return claims.MerchantID
+		}
+	}
+	return ""
+}
+
+func endpointsKey(merchantID string) string {
+	return "webhooks:endpoints:" + merchantID
+}
+
+func deliveriesKey(merchantID string) string {
+	return "webhooks:deliveries:" + merchantID
+}
+
+func randomID(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(buf)
+}
+
+// denyPrivateAddresses keeps deliveries from reaching the internal network (SSRF)
+func denyPrivateAddresses(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("webhook target %s is not a public address", host)
+	}
+	return nil
+}
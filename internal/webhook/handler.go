@@ -0,0 +1,143 @@
+// Package webhook receives payment-provider notifications over HTTP, verifies their signatures and
+// forwards them to the PaymentService, since providers can't call the gRPC services directly. It also
+// delivers order and payment events to the webhook endpoints registered by merchants.
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fekuna/omnipos-gateway/config"
+	"github.com/fekuna/omnipos-gateway/internal/grpcproxy"
+	"github.com/fekuna/omnipos-gateway/internal/middleware"
+	customRuntime "github.com/fekuna/omnipos-gateway/internal/runtime"
+	"github.com/fekuna/omnipos-gateway/internal/security"
+	"github.com/fekuna/omnipos-pkg/cache"
+	"github.com/fekuna/omnipos-pkg/logger"
+	"go.uber.org/zap"
+)
+
+// Handler serves "<prefix><provider>" webhook endpoints. Each notification is processed once: its
+// event ID is recorded in Redis for the replay window and redeliveries are acknowledged without
+// being forwarded again. Rate limiting is done by middleware.WebhookRateLimiter on the same prefix.
+type Handler struct {
+	proxy       *grpcproxy.Proxy
+	redisClient *cache.RedisClient
+	cfg         config.PaymentWebhookConfig
+	prefix      string
+	providers   map[string]provider
+	logger      logger.ZapLogger
+}
+
+// NewHandler creates the webhook endpoints of the providers with credentials configured
+func NewHandler(proxy *grpcproxy.Proxy, redisClient *cache.RedisClient, cfg config.PaymentWebhookConfig, prefix string, log logger.ZapLogger) *Handler {
+	return &Handler{
+		proxy:       proxy,
+		redisClient: redisClient,
+		cfg:         cfg,
+		prefix:      prefix,
+		providers:   newProviders(cfg),
+		logger:      log,
+	}
+}
+
+// RegisterRoutes registers the webhook endpoints
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc(h.prefix, h.serveWebhook)
+	for name := range h.providers {
+		h.logger.Info("payment webhook enabled", zap.String("provider", name), zap.String("path", h.prefix+name))
+	}
+}
+
+func (h *Handler) serveWebhook(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, h.prefix), "/")
+	p, ok := h.providers[name]
+	if !ok {
+		customRuntime.WriteResponse(w, http.StatusNotFound, "unknown webhook provider", nil)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		customRuntime.WriteResponse(w, http.StatusMethodNotAllowed, "method not allowed", nil)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, h.cfg.MaxBodyBytes+1))
+	if err != nil || int64(len(body)) > h.cfg.MaxBodyBytes {
+		customRuntime.WriteResponse(w, http.StatusRequestEntityTooLarge, "webhook body too large", nil)
+		return
+	}
+
+	if err := p.verify(r, body); err != nil {
+		h.logger.Warn("webhook signature rejected", zap.String("provider", name), zap.Error(err))
+		middleware.RecordSecurityEvent(r, security.SignatureInvalid, "webhook:"+name, err.Error(), "")
+		customRuntime.WriteResponse(w, http.StatusUnauthorized, "invalid webhook signature", nil)
+		return
+	}
+
+	event, err := p.normalize(body)
+	if err != nil || event.EventID == "" {
+		h.logger.Warn("invalid webhook payload", zap.String("provider", name), zap.Error(err))
+		customRuntime.WriteResponse(w, http.StatusBadRequest, "invalid webhook payload", nil)
+		return
+	}
+	event.Provider = name
+	event.Payload = string(body)
+
+	ctx := r.Context()
+	first, err := h.markSeen(ctx, event)
+	if err != nil {
+		// Without replay protection the backend's own idempotency is the only guard; still forward
+		h.logger.Error("webhook replay check failed", zap.Error(err))
+		first = true
+	}
+	if !first {
+		h.logger.Info("duplicate webhook acknowledged", zap.String("provider", name), zap.String("event_id", event.EventID))
+		customRuntime.WriteResponse(w, http.StatusOK, "already processed", nil)
+		return
+	}
+
+	if err := h.forward(ctx, event); err != nil {
+		h.logger.Error("failed to forward webhook", zap.String("provider", name), zap.String("event_id", event.EventID), zap.Error(err))
+		// Let the provider's redelivery go through
+		h.unmarkSeen(ctx, event)
+		customRuntime.WriteResponse(w, http.StatusBadGateway, "failed to process webhook", nil)
+		return
+	}
+
+	h.logger.Info("webhook processed",
+		zap.String("provider", name), zap.String("event_id", event.EventID),
+		zap.String("reference", event.Reference), zap.String("status", event.Status))
+	customRuntime.WriteResponse(w, http.StatusOK, "success", nil)
+}
+
+// forward calls the PaymentService with the normalized event
+func (h *Handler) forward(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx = middleware.WithGatewayCaller(ctx, "webhook:"+event.Provider)
+	_, err = h.proxy.InvokeJSON(ctx, h.cfg.Method, body)
+	return err
+}
+
+// markSeen records the event ID, reporting whether it's the first delivery
+func (h *Handler) markSeen(ctx context.Context, event *Event) (bool, error) {
+	return h.redisClient.Client.SetNX(ctx, seenKey(event), 1, h.cfg.ReplayWindow).Result()
+}
+
+func (h *Handler) unmarkSeen(ctx context.Context, event *Event) {
+	if err := h.redisClient.Client.Del(context.WithoutCancel(ctx), seenKey(event)).Err(); err != nil {
+		h.logger.Warn("failed to clear webhook replay key", zap.Error(err))
+	}
+}
+
+func seenKey(event *Event) string {
+	return "webhook:seen:" + event.Provider + ":" + event.EventID
+}
@@ -0,0 +1,302 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fekuna/omnipos-gateway/config"
+)
+
+// Normalized payment statuses
+const (
+	StatusPending  = "pending"
+	StatusPaid     = "paid"
+	StatusFailed   = "failed"
+	StatusExpired  = "expired"
+	StatusRefunded = "refunded"
+	StatusUnknown  = "unknown"
+)
+
+var (
+	errMissingSignature = errors.New("missing signature")
+	errInvalidSignature = errors.New("invalid signature")
+	errStaleSignature   = errors.New("signature timestamp outside the tolerance")
+)
+
+// Event is a provider notification normalized for the PaymentService
+type Event struct {
+	Provider  string `json:"provider"`
+	EventID   string `json:"event_id"`   // unique per notification, used for replay protection
+	EventType string `json:"event_type"` // provider event, e.g. "settlement", "payment_intent.succeeded"
+	Reference string `json:"reference"`  // our order ID, as sent to the provider
+	Status    string `json:"status"`
+	Amount    string `json:"amount"` // decimal in major units, e.g. "15000.00"
+	Currency  string `json:"currency"`
+	Payload   string `json:"payload"` // raw provider body
+}
+
+// provider verifies and normalizes the notifications of a payment provider
+type provider interface {
+	verify(r *http.Request, body []byte) error
+	normalize(body []byte) (*Event, error)
+}
+
+func newProviders(cfg config.PaymentWebhookConfig) map[string]provider {
+	providers := make(map[string]provider)
+	if cfg.MidtransServerKey != "" {
+		providers["midtrans"] = midtrans{serverKey: cfg.MidtransServerKey}
+	}
+	if cfg.XenditCallbackToken != "" {
+		providers["xendit"] = xendit{callbackToken: cfg.XenditCallbackToken}
+	}
+	if cfg.StripeSigningSecret != "" {
+		providers["stripe"] = stripe{secret: cfg.StripeSigningSecret, tolerance: cfg.StripeTolerance}
+	}
+	return providers
+}
+
+// midtrans signs notifications with signature_key = SHA512(order_id + status_code + gross_amount + server key)
+type midtrans struct {
+	serverKey string
+}
+
+type midtransNotification struct {
+	TransactionID     string `json:"transaction_id"`
+	TransactionStatus string `json:"transaction_status"`
+	FraudStatus       string `json:"fraud_status"`
+	OrderID           string `json:"order_id"`
+	StatusCode        string `json:"status_code"`
+	GrossAmount       string `json:"gross_amount"`
+	Currency          string `json:"currency"`
+	SignatureKey      string `json:"signature_key"`
+}
+
+func (m midtrans) verify(r *http.Request, body []byte) error {
+	var n midtransNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return err
+	}
+	if n.SignatureKey == "" {
+		return errMissingSignature
+	}
+
+	sum := sha512.Sum512([]byte(n.OrderID + n.StatusCode + n.GrossAmount + m.serverKey))
+	if !hmac.Equal([]byte(strings.ToLower(n.SignatureKey)), []byte(hex.EncodeToString(sum[:]))) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+func (m midtrans) normalize(body []byte) (*Event, error) {
+	var n midtransNotification
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, err
+	}
+
+	status := StatusUnknown
+	switch n.TransactionStatus {
+	case "capture":
+		// Card captures flagged by fraud detection still need a manual review
+		status = StatusPaid
+		if n.FraudStatus == "challenge" {
+			status = StatusPending
+		}
+	case "settlement":
+		status = StatusPaid
+	case "pending", "authorize":
+		status = StatusPending
+	case "deny", "cancel", "failure":
+		status = StatusFailed
+	case "expire":
+		status = StatusExpired
+	case "refund", "partial_refund":
+		status = StatusRefunded
+	}
+
+	currency := n.Currency
+	if currency == "" {
+		currency = "IDR"
+	}
+
+	return &Event{
+		EventID:   n.TransactionID + ":" + n.TransactionStatus,
+		EventType: n.TransactionStatus,
+		Reference: n.OrderID,
+		Status:    status,
+		Amount:    n.GrossAmount,
+		Currency:  currency,
+	}, nil
+}
+
+// xendit authenticates callbacks with the account's verification token in X-Callback-Token
+type xendit struct {
+	callbackToken string
+}
+
+type xenditCallback struct {
+	ID         string      `json:"id"`
+	ExternalID string      `json:"external_id"`
+	Status     string      `json:"status"`
+	Amount     json.Number `json:"amount"`
+	PaidAmount json.Number `json:"paid_amount"`
+	Currency   string      `json:"currency"`
+}
+
+func (x xendit) verify(r *http.Request, body []byte) error {
+	token := r.Header.Get("X-Callback-Token")
+	if token == "" {
+		return errMissingSignature
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(x.callbackToken)) != 1 {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+func (x xendit) normalize(body []byte) (*Event, error) {
+	var c xenditCallback
+	if err := json.Unmarshal(body, &c); err != nil {
+		return nil, err
+	}
+
+	status := StatusUnknown
+	switch strings.ToUpper(c.Status) {
+	case "PAID", "SETTLED", "SUCCEEDED":
+		status = StatusPaid
+	case "PENDING":
+		status = StatusPending
+	case "EXPIRED":
+		status = StatusExpired
+	case "FAILED":
+		status = StatusFailed
+	}
+
+	amount := c.PaidAmount
+	if amount == "" {
+		amount = c.Amount
+	}
+
+	return &Event{
+		EventID:   c.ID + ":" + strings.ToUpper(c.Status),
+		EventType: strings.ToLower(c.Status),
+		Reference: c.ExternalID,
+		Status:    status,
+		Amount:    amount.String(),
+		Currency:  c.Currency,
+	}, nil
+}
+
+// stripe signs events with Stripe-Signature: t=<unix>,v1=<hex HMAC-SHA256(secret, "<t>.<body>")>
+type stripe struct {
+	secret    string
+	tolerance time.Duration
+}
+
+type stripeEvent struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object struct {
+			ID       string            `json:"id"`
+			Amount   int64             `json:"amount"`
+			Currency string            `json:"currency"`
+			Metadata map[string]string `json:"metadata"`
+		} `json:"object"`
+	} `json:"data"`
+}
+
+// stripeZeroDecimal are the currencies Stripe amounts are not in cents for
+var stripeZeroDecimal = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true, "krw": true, "mga": true,
+	"pyg": true, "rwf": true, "ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true, "xpf": true,
+}
+
+func (s stripe) verify(r *http.Request, body []byte) error {
+	header := r.Header.Get("Stripe-Signature")
+	if header == "" {
+		return errMissingSignature
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return errInvalidSignature
+	}
+	if age := time.Since(time.Unix(ts, 0)); age > s.tolerance || age < -s.tolerance {
+		return errStaleSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			return nil
+		}
+	}
+	return errInvalidSignature
+}
+
+func (s stripe) normalize(body []byte) (*Event, error) {
+	var e stripeEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, err
+	}
+
+	status := StatusUnknown
+	switch e.Type {
+	case "payment_intent.succeeded", "charge.succeeded", "checkout.session.completed":
+		status = StatusPaid
+	case "payment_intent.processing", "payment_intent.requires_action":
+		status = StatusPending
+	case "payment_intent.payment_failed", "payment_intent.canceled", "charge.failed":
+		status = StatusFailed
+	case "checkout.session.expired":
+		status = StatusExpired
+	case "charge.refunded":
+		status = StatusRefunded
+	}
+
+	obj := e.Data.Object
+	reference := obj.Metadata["order_id"]
+	if reference == "" {
+		reference = obj.ID
+	}
+
+	amount := strconv.FormatInt(obj.Amount, 10)
+	if !stripeZeroDecimal[obj.Currency] {
+		amount = fmt.Sprintf("%d.%02d", obj.Amount/100, obj.Amount%100)
+	}
+
+	return &Event{
+		EventID:   e.ID,
+		EventType: e.Type,
+		Reference: reference,
+		Status:    status,
+		Amount:    amount,
+		Currency:  strings.ToUpper(obj.Currency),
+	}, nil
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMidtrans_Verify(t *testing.T) {
+	m := midtrans{serverKey: "server-key"}
+	sum := sha512.Sum512([]byte("order-1" + "200" + "15000.00" + "server-key"))
+	body := fmt.Sprintf(`{"order_id":"order-1","status_code":"200","gross_amount":"15000.00","transaction_id":"tx-1","transaction_status":"settlement","signature_key":"%s"}`, hex.EncodeToString(sum[:]))
+
+	if err := m.verify(httptest.NewRequest("POST", "/webhooks/midtrans", nil), []byte(body)); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	tampered := strings.Replace(body, "15000.00", "1.00", 1)
+	if err := m.verify(httptest.NewRequest("POST", "/webhooks/midtrans", nil), []byte(tampered)); err != errInvalidSignature {
+		t.Errorf("tampered body: got %v, want errInvalidSignature", err)
+	}
+
+	event, err := m.normalize([]byte(body))
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if event.Status != StatusPaid || event.Reference != "order-1" || event.EventID != "tx-1:settlement" {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestStripe_Verify(t *testing.T) {
+	s := stripe{secret: "whsec_test", tolerance: 5 * time.Minute}
+	body := []byte(`{"id":"evt_1","type":"payment_intent.succeeded","data":{"object":{"id":"pi_1","amount":1999,"currency":"usd","metadata":{"order_id":"order-1"}}}}`)
+
+	sign := func(ts int64) string {
+		mac := hmac.New(sha256.New, []byte("whsec_test"))
+		fmt.Fprintf(mac, "%d.%s", ts, body)
+		return fmt.Sprintf("t=%d,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		want   error
+	}{
+		{"valid", sign(time.Now().Unix()), nil},
+		{"missing", "", errMissingSignature},
+		{"stale", sign(time.Now().Add(-time.Hour).Unix()), errStaleSignature},
+		{"wrong secret", fmt.Sprintf("t=%d,v1=%s", time.Now().Unix(), strings.Repeat("0", 64)), errInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/webhooks/stripe", nil)
+		if tt.header != "" {
+			r.Header.Set("Stripe-Signature", tt.header)
+		}
+		if err := s.verify(r, body); err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	event, err := s.normalize(body)
+	if err != nil {
+		t.Fatalf("normalize: %v", err)
+	}
+	if event.Status != StatusPaid || event.Reference != "order-1" || event.Amount != "19.99" || event.Currency != "USD" {
+		t.Errorf("unexpected event %+v", event)
+	}
+}